@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ansiExt is the filename extension of the ANSI art that is exported from the editor
+const ansiExt = ".ansi"
+
+// EncodeANSI converts the textual representation to an image and writes it to the given io.Writer as ANSI art,
+// with colored half block characters, two rows of pixels per line. Each line ends with a sequence that resets
+// the colors. If truecolor is false, the closest colors in the 256 color palette are used. If script is true,
+// a shell script that prints the ANSI art is written instead, with a comment that says how many columns the
+// terminal needs. The name is the filename of the image, which is only used in the script.
+func EncodeANSI(w io.Writer, mode Mode, size image.Point, text, name string, truecolor, script bool) error {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	lines := halfBlockLines(m, truecolor)
+	if !script {
+		for _, line := range lines {
+			buf.WriteString(line + "\n")
+		}
+		_, err = buf.WriteTo(w)
+		return err
+	}
+	colors := "256 colors"
+	if truecolor {
+		colors = "24-bit colors"
+	}
+	buf.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&buf, "# %s as ANSI art, with %s\n", filepath.Base(name), colors)
+	fmt.Fprintf(&buf, "# The terminal must be at least %d columns wide\n", size.X)
+	for _, line := range lines {
+		// The lines only contain escape sequences, spaces and half blocks, so they can be given to printf as they are
+		buf.WriteString("printf '" + strings.Replace(line, "\x1b", `\033`, -1) + `\n'` + "\n")
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// WriteANSI converts the textual representation to an image and saves it as ANSI art, see EncodeANSI.
+// Filenames that end with .sh are saved as shell scripts. The name is the filename of the image.
+func WriteANSI(mode Mode, size image.Point, text, name, filename string, truecolor bool) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeANSI(w, mode, size, text, name, truecolor, strings.HasSuffix(filename, ".sh"))
+	})
+}
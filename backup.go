@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backupSuffix is added to the filename when copying a file before it is overwritten
+const backupSuffix = "~"
+
+// makeBackup copies the given file to a file ending with backupSuffix before it is overwritten,
+// if backups are enabled and the file exists. If the backup can not be written, for instance
+// because the directory is read-only, the error is kept for the status message and saving continues.
+func (e *Editor) makeBackup(filename string) {
+	e.backupName = ""
+	e.backupErr = nil
+	if !e.backup {
+		return
+	}
+	fi, err := os.Stat(filename)
+	if err != nil || !fi.Mode().IsRegular() {
+		// A new file, no backup is needed
+		return
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = ioutil.WriteFile(filename+backupSuffix, data, fi.Mode().Perm())
+	}
+	if err != nil {
+		e.backupErr = err
+		return
+	}
+	e.backupName = filename + backupSuffix
+}
+
+// SavedMessage returns a status message for when the given file was saved, including the size of the file
+// and the backup, if any
+func (e *Editor) SavedMessage(filename string) string {
+	saved := "Saved " + filename
+	if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() {
+		saved += fmt.Sprintf(", %d bytes", fi.Size())
+	}
+	switch {
+	case e.backupErr != nil:
+		return saved + " (no backup: " + e.backupErr.Error() + ")"
+	case e.backupName != "":
+		return saved + " (backup: " + e.backupName + ")"
+	}
+	return saved
+}
+
+// withExtension returns the filename with the extension of the last path element replaced by ext
+func withExtension(filename, ext string) string {
+	dir, base := filepath.Split(filename)
+	base = strings.TrimSuffix(base, filepath.Ext(base)) + ext
+	if dir == "" {
+		return base
+	}
+	return filepath.Join(dir, base)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errBatchFailed is returned by Batch when some of the files could not be converted
+var errBatchFailed = errors.New("some files could not be converted")
+
+// batchFilename returns the filename in outDir that the given file is converted to, with the extension for "to"
+func batchFilename(filename, outDir, to string) string {
+	base := filepath.Base(filename)
+	return filepath.Join(outDir, strings.TrimSuffix(base, filepath.Ext(base))+"."+to)
+}
+
+// Batch converts all files that match the glob pattern to the format given by "to", like "ico" or "png",
+// and writes them to outDir, which is created if needed. Files that already exist in outDir are skipped,
+// unless force is true. Files that would be saved to the same file in outDir fail. One line is written per file, to errOut for the files that fail and to out for the rest,
+// followed by a summary line to out. A file that can not be converted does not stop the rest, but errBatchFailed
+// is returned at the end. The mode, size, scale and bundle arguments are the same as for Convert.
+func Batch(out, errOut io.Writer, pattern, to, outDir string, mode Mode, size, scale int, bundle, force bool) error {
+	if to == "" {
+		return usageError("-batch needs a format to convert to, like -to ico")
+	}
+	if err := checkOutputFilename("favicon." + to); err != nil {
+		return usageError("can not convert to " + to + ", only to ico, cur, png, favtxt, xbm, ff or icns")
+	}
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return withCode(err, exitUsage)
+	}
+	if len(filenames) == 0 {
+		return withCode(errors.New("no files match "+pattern), exitNotFound)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	// Files that would be converted to the same file, like a.png and a.ico to a.ico, are not converted
+	sources := make(map[string][]string)
+	for _, filename := range filenames {
+		outFilename := batchFilename(filename, outDir, to)
+		sources[outFilename] = append(sources[outFilename], filename)
+	}
+	var converted, skipped, failed int
+	for _, filename := range filenames {
+		outFilename := batchFilename(filename, outDir, to)
+		if n := len(sources[outFilename]); n > 1 {
+			fmt.Fprintf(errOut, "%s: error: %d files would be saved as %s: %s\n", filename, n, outFilename, strings.Join(sources[outFilename], ", "))
+			failed++
+			continue
+		}
+		if _, err := os.Stat(outFilename); err == nil && !force {
+			fmt.Fprintf(out, "%s: skipped, %s already exists\n", filename, outFilename)
+			skipped++
+			continue
+		}
+		if err := Convert(filename, outFilename, mode, size, scale, bundle); err != nil {
+			fmt.Fprintf(errOut, "%s: error: %s\n", filename, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "%s: saved %s\n", filename, outFilename)
+		converted++
+	}
+	fmt.Fprintf(out, "Converted %d of %d files, %d skipped and %d failed\n", converted, len(filenames), skipped, failed)
+	if failed > 0 {
+		return errBatchFailed
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// batchInput writes the given .png images to a new directory, and a broken .png image if broken is true.
+// Returns the directory.
+func batchInput(t *testing.T, names []string, broken bool) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := WriteFavicon(modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), filepath.Join(dir, name), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if broken {
+		if err := ioutil.WriteFile(filepath.Join(dir, "broken.png"), img.PNGMagic, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// checkBatchOutput checks that every line has one of the given prefixes, in order, and that the last line is the summary
+func checkBatchOutput(t *testing.T, name string, output string, prefixes []string, summary string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != len(prefixes)+1 {
+		t.Fatalf("%s: got the output\n%s\nbut wanted %d lines", name, output, len(prefixes)+1)
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("%s: got the line %q, but wanted one that starts with %q", name, lines[i], prefix)
+		}
+	}
+	if lines[len(lines)-1] != summary {
+		t.Errorf("%s: got the summary %q, but wanted %q", name, lines[len(lines)-1], summary)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		in     = batchInput(t, []string{"a.png", "b.png", "c.png"}, true)
+		out    = filepath.Join(t.TempDir(), "icons")
+		buf    bytes.Buffer
+		errBuf bytes.Buffer
+	)
+	a, b, c, broken := filepath.Join(in, "a.png"), filepath.Join(in, "b.png"), filepath.Join(in, "c.png"), filepath.Join(in, "broken.png")
+
+	// A file that can not be converted does not stop the others
+	err := Batch(&buf, &errBuf, filepath.Join(in, "*.png"), "ico", out, modeBlank, 0, 0, false, false)
+	if err != errBatchFailed {
+		t.Errorf("got %v, but wanted errBatchFailed", err)
+	}
+	checkBatchOutput(t, "first", buf.String(), []string{
+		a + ": saved " + filepath.Join(out, "a.ico"),
+		b + ": saved " + filepath.Join(out, "b.ico"),
+		c + ": saved " + filepath.Join(out, "c.ico"),
+	}, "Converted 3 of 4 files, 0 skipped and 1 failed")
+	if !strings.HasPrefix(errBuf.String(), broken+": error: ") || strings.Count(errBuf.String(), "\n") != 1 {
+		t.Errorf("got the errors %q, but wanted one for %s", errBuf.String(), broken)
+	}
+	for _, name := range []string{"a.ico", "b.ico", "c.ico"} {
+		data, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, img.ICOMagic) {
+			t.Errorf("%s is not an .ico image", name)
+		}
+	}
+
+	// The files that already exist are skipped
+	buf.Reset()
+	if err := Batch(&buf, &errBuf, filepath.Join(in, "[abc].png"), "ico", out, modeBlank, 0, 0, false, false); err != nil {
+		t.Error(err)
+	}
+	checkBatchOutput(t, "again", buf.String(), []string{
+		a + ": skipped, " + filepath.Join(out, "a.ico") + " already exists",
+		b + ": skipped, ",
+		c + ": skipped, ",
+	}, "Converted 0 of 3 files, 3 skipped and 0 failed")
+
+	// Unless they are overwritten with -force
+	buf.Reset()
+	if err := Batch(&buf, &errBuf, filepath.Join(in, "[abc].png"), "ico", out, modeBlank, 0, 0, false, true); err != nil {
+		t.Error(err)
+	}
+	checkBatchOutput(t, "force", buf.String(), []string{a + ": saved ", b + ": saved ", c + ": saved "}, "Converted 3 of 3 files, 0 skipped and 0 failed")
+}
+
+func TestBatchSameOutput(t *testing.T) {
+	var (
+		in     = batchInput(t, []string{"a.png", "b.png"}, false)
+		out    = filepath.Join(t.TempDir(), "icons")
+		buf    bytes.Buffer
+		errBuf bytes.Buffer
+	)
+	if err := WriteFavicon(modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), filepath.Join(in, "a.ico"), false); err != nil {
+		t.Fatal(err)
+	}
+	a, b, aICO := filepath.Join(in, "a.png"), filepath.Join(in, "b.png"), filepath.Join(in, "a.ico")
+
+	// a.ico and a.png would both be saved as a.ico, so neither of them is converted
+	if err := Batch(&buf, &errBuf, filepath.Join(in, "*"), "ico", out, modeBlank, 0, 0, false, false); err != errBatchFailed {
+		t.Errorf("got %v, but wanted errBatchFailed", err)
+	}
+	checkBatchOutput(t, "same output", buf.String(), []string{b + ": saved "}, "Converted 1 of 3 files, 0 skipped and 2 failed")
+	collision := "error: 2 files would be saved as " + filepath.Join(out, "a.ico") + ": " + aICO + ", " + a + "\n"
+	if want := aICO + ": " + collision + a + ": " + collision; errBuf.String() != want {
+		t.Errorf("got the errors\n%s\nbut wanted\n%s", errBuf.String(), want)
+	}
+	if exists(filepath.Join(out, "a.ico")) {
+		t.Error("saved a.ico, which two files would be saved as")
+	}
+}
+
+func TestBatchArguments(t *testing.T) {
+	in := batchInput(t, []string{"a.png"}, false)
+	out := filepath.Join(t.TempDir(), "icons")
+	for _, test := range []struct {
+		name    string
+		pattern string
+		to      string
+		code    int
+	}{
+		{"no format", filepath.Join(in, "*.png"), "", exitUsage},
+		{"unknown format", filepath.Join(in, "*.png"), "gif", exitUsage},
+		{"no match", filepath.Join(in, "*.ico"), "png", exitNotFound},
+	} {
+		var buf bytes.Buffer
+		err := Batch(&buf, &buf, test.pattern, test.to, out, modeBlank, 0, 0, false, false)
+		if err == nil || err == errBatchFailed {
+			t.Errorf("%s: got %v, but wanted an error about the arguments", test.name, err)
+		} else if code := exitCode(err); code != test.code {
+			t.Errorf("%s: got the exit code %d, but wanted %d", test.name, code, test.code)
+		}
+		if buf.Len() > 0 {
+			t.Errorf("%s: wrote %q", test.name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import "fmt"
+
+// Buffer is a file that is open, with its own editor and undo history
+type Buffer struct {
+	editor   Editor
+	undo     *Undo
+	filename string
+}
+
+// Buffers are the files that are open, when more than one filename is given.
+// The editor of the current buffer is the one that the main loop uses, so the current buffer in the list
+// is only up to date after switching to another buffer.
+type Buffers struct {
+	list    []Buffer
+	current int
+}
+
+// Add adds an open file to the end of the list
+func (bs *Buffers) Add(e *Editor, undo *Undo, filename string) {
+	bs.list = append(bs.list, Buffer{*e, undo, filename})
+}
+
+// Len returns the number of open files
+func (bs *Buffers) Len() int {
+	return len(bs.list)
+}
+
+// Switch stores the editor, undo history and filename of the current buffer, then copies the editor of the buffer
+// with the given index into e and returns its undo history and filename. The index wraps around at both ends.
+func (bs *Buffers) Switch(index int, e *Editor, undo *Undo, filename string) (*Undo, string) {
+	n := len(bs.list)
+	index = (index%n + n) % n
+	bs.list[bs.current] = Buffer{*e, undo, filename}
+	b := bs.list[index]
+	*e = b.editor
+	bs.current = index
+	e.redraw = true
+	e.redrawCursor = true
+	return b.undo, b.filename
+}
+
+// Modified returns the indexes of the buffers with unsaved changes, where e is the editor of the current buffer
+func (bs *Buffers) Modified(e *Editor) []int {
+	var modified []int
+	for i, b := range bs.list {
+		if (i == bs.current && e.changed) || (i != bs.current && b.editor.changed) {
+			modified = append(modified, i)
+		}
+	}
+	return modified
+}
+
+// Filenames returns the filenames of the buffers with the given indexes, where filename is the current one
+func (bs *Buffers) Filenames(indexes []int, filename string) []string {
+	var filenames []string
+	for _, i := range indexes {
+		if i == bs.current {
+			filenames = append(filenames, filename)
+		} else {
+			filenames = append(filenames, bs.list[i].filename)
+		}
+	}
+	return filenames
+}
+
+// Label returns the number of the current buffer and its filename, like "[2/3] favicon-32.png",
+// or just the filename if only one file is open
+func (bs *Buffers) Label(filename string) string {
+	if len(bs.list) < 2 {
+		return filename
+	}
+	return fmt.Sprintf("[%d/%d] %s", bs.current+1, len(bs.list), filename)
+}
+
+// SetTheme uses the given display profile for the editors of all the buffers,
+// except for the current one, which is set up by the main loop
+func (bs *Buffers) SetTheme(theme Theme) {
+	for i := range bs.list {
+		if i != bs.current {
+			bs.list[i].editor.SetTheme(theme)
+		}
+	}
+}
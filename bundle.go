@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// siteFile is a file that is written by GenerateSite, with the function that encodes it
+type siteFile struct {
+	filename    string
+	description string
+	encode      func(w io.Writer, m image.Image) error
+}
+
+// scaleTo scales the image to a size x size image, with nearest neighbor scaling by a whole number if
+// the image is smaller, so that the pixels stay sharp, or by averaging the pixels if the image is larger.
+func scaleTo(m image.Image, size int) image.Image {
+	if b := m.Bounds(); b.Dx() == size && b.Dy() == size {
+		return m
+	}
+	return scaleInteger(m, size)
+}
+
+// encodePNGSize returns a function that encodes the image as a size x size .png image
+func encodePNGSize(size int) func(w io.Writer, m image.Image) error {
+	return func(w io.Writer, m image.Image) error {
+		return encoder.EncodePNG(w, scaleTo(m, size))
+	}
+}
+
+// siteFiles returns the files that make up a favicon bundle for a web site
+func siteFiles() []siteFile {
+	files := []siteFile{
+		{"favicon.ico", "16x16, 32x32 and 48x48", func(w io.Writer, m image.Image) error {
+			images := make([]image.Image, len(bundleSizes))
+			for i, size := range bundleSizes {
+				images[i] = scaleTo(m, size)
+			}
+			return encoder.EncodeICOAll(w, images, 32)
+		}},
+	}
+	for _, p := range []struct {
+		filename string
+		size     int
+	}{
+		{"favicon-16x16.png", 16},
+		{"favicon-32x32.png", 32},
+		{"apple-touch-icon.png", appleTouchIconSize},
+		{"android-chrome-192x192.png", 192},
+		{"android-chrome-512x512.png", 512},
+	} {
+		files = append(files, siteFile{p.filename, fmt.Sprintf("%dx%d", p.size, p.size), encodePNGSize(p.size)})
+	}
+	files = append(files, siteFile{webmanifestFilename, "web app manifest", func(w io.Writer, m image.Image) error {
+		data, err := encodeWebmanifest([]pngFile{{"android-chrome-192x192.png", 192}, {"android-chrome-512x512.png", 512}})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}})
+	return files
+}
+
+// GenerateSite reads the source image and writes the favicon.ico, .png images and site.webmanifest
+// that a web site needs to the output directory, which is created if needed. Existing files are
+// only overwritten if force is true. The progress and a summary is written to out.
+func GenerateSite(sourceFilename, outDir string, force bool, out io.Writer) error {
+	f, err := os.Open(sourceFilename)
+	if err != nil {
+		return err
+	}
+	format := detectFormat(sourceFilename)
+	m, err := decodeImage(f, format)
+	f.Close()
+	if err != nil {
+		return withCode(decodeError(sourceFilename, err), exitDecode)
+	}
+
+	files := siteFiles()
+
+	// Check all the files before writing any of them
+	if !force {
+		var existing []string
+		for _, file := range files {
+			if filename := filepath.Join(outDir, file.filename); exists(filename) {
+				existing = append(existing, filename)
+			}
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("will not overwrite %s, use -force to overwrite existing files", strings.Join(existing, ", "))
+		}
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+
+	size := m.Bounds().Size()
+	fmt.Fprintf(out, "Read %s (%dx%d)\n", sourceFilename, size.X, size.Y)
+	for _, file := range files {
+		filename := filepath.Join(outDir, file.filename)
+		var buf bytes.Buffer
+		if err := file.encode(&buf, m); err != nil {
+			return withCode(fmt.Errorf("could not encode %s: %s", filename, err), exitEncode)
+		}
+		if err := createFile(filename, func(w io.Writer) error {
+			_, err := buf.WriteTo(w)
+			return err
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %s (%s)\n", filename, file.description)
+	}
+	fmt.Fprintf(out, "Wrote %d files to %s\n", len(files), outDir)
+	return nil
+}
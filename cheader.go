@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cBytesPerLine is the number of bytes per line in the generated C headers
+const cBytesPerLine = 12
+
+// EncodeCHeader writes a C header with the data as a static byte array and a length constant,
+// named after the given name, like favicon_ico and favicon_ico_len, within an include guard like FAVICON_ICO_H.
+// The source is only used in the comment at the top.
+func EncodeCHeader(w io.Writer, name, source string, data []byte) error {
+	guard := strings.ToUpper(name) + "_H"
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "/* Generated by favicon from %s */\n", strings.Replace(source, "*/", "* /", -1))
+	fmt.Fprintf(&buf, "#ifndef %s\n#define %s\n\n", guard, guard)
+	fmt.Fprintf(&buf, "static const unsigned char %s[] = {\n", name)
+	for i, b := range data {
+		if i%cBytesPerLine == 0 {
+			buf.WriteString("  ")
+		}
+		fmt.Fprintf(&buf, "0x%02x", b)
+		if i < len(data)-1 {
+			buf.WriteByte(',')
+		}
+		if i%cBytesPerLine == cBytesPerLine-1 || i == len(data)-1 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteString("};\n\n")
+	fmt.Fprintf(&buf, "static const unsigned int %s_len = %d;\n\n", name, len(data))
+	fmt.Fprintf(&buf, "#endif /* %s */\n", guard)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// PrintCHeader reads an .ico or .png image and writes it to w as a C header with the .ico image, see EncodeCHeader.
+// The array is named after the file, like favicon_ico for favicon.png. If size is not 0, that image is read
+// from .ico files with several images. If bundle is true, the .ico image has all the sizes in bundleSizes.
+func PrintCHeader(filename string, w io.Writer, size int, bundle bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var ico bytes.Buffer
+	if err := ConvertStream(f, &ico, filename, "ico", modeBlank, size, bundle); err != nil {
+		return err
+	}
+	return EncodeCHeader(w, cName(filename)+"_ico", filepath.Base(filename), ico.Bytes())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cArray matches the byte array and the length constant of a C header from EncodeCHeader
+var cArray = regexp.MustCompile(`(?s)static const unsigned char (\w+)\[\] = \{\n(.*?)\};\n\nstatic const unsigned int (\w+)_len = (\d+);`)
+
+// parseCHeader parses the byte array of a C header from EncodeCHeader, and checks the include guard
+// and the length constant
+func parseCHeader(t *testing.T, header string) (string, []byte) {
+	t.Helper()
+	match := cArray.FindStringSubmatch(header)
+	if match == nil {
+		t.Fatalf("there is no byte array and length in\n%s", header)
+	}
+	name := match[1]
+	var data []byte
+	for _, s := range strings.Split(match[2], ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			t.Fatalf("the array has the element %q, which is not a byte", s)
+		}
+		data = append(data, byte(v))
+	}
+	if match[3] != name || match[4] != strconv.Itoa(len(data)) {
+		t.Errorf("the length is %s_len = %s, but wanted %s_len = %d", match[3], match[4], name, len(data))
+	}
+	guard := strings.ToUpper(name) + "_H"
+	if !strings.Contains(header, "#ifndef "+guard+"\n#define "+guard+"\n") || !strings.HasSuffix(header, "#endif /* "+guard+" */\n") {
+		t.Errorf("the include guard %s is missing in\n%s", guard, header)
+	}
+	return name, data
+}
+
+func TestEncodeCHeader(t *testing.T) {
+	for _, data := range [][]byte{{0}, {0x00, 0xff, 0x10}, bytes.Repeat([]byte{0xab}, cBytesPerLine), bytes.Repeat([]byte{1, 2, 3}, 100)} {
+		var buf bytes.Buffer
+		if err := EncodeCHeader(&buf, "favicon_ico", "favicon.png", data); err != nil {
+			t.Fatal(err)
+		}
+		name, got := parseCHeader(t, buf.String())
+		if name != "favicon_ico" || !bytes.Equal(got, data) {
+			t.Errorf("got %s with %x, but wanted favicon_ico with %x", name, got, data)
+		}
+	}
+	// The comment can not be closed by the name of the source file
+	var buf bytes.Buffer
+	if err := EncodeCHeader(&buf, "x_ico", "a*/b.png", []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if comment := strings.SplitN(buf.String(), "\n", 2)[0]; strings.Count(comment, "*/") != 1 {
+		t.Errorf("the comment %q is closed too early", comment)
+	}
+}
+
+func TestPrintCHeader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "my-logo.png")
+	if err := WriteFavicon(modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), filename, true); err != nil {
+		t.Fatal(err)
+	}
+	var header bytes.Buffer
+	if err := PrintCHeader(filename, &header, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	name, got := parseCHeader(t, header.String())
+
+	// The array has the same bytes as the .ico image that the encoder writes
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ico bytes.Buffer
+	if err := ConvertStream(f, &ico, filename, "ico", modeBlank, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if name != "my_logo_ico" || !bytes.Equal(got, ico.Bytes()) {
+		t.Errorf("got %s with %d bytes, but wanted my_logo_ico with the %d bytes of the .ico image", name, len(got), ico.Len())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// dataURIPrefix is the start of a PNG image that is encoded as a data URI
+const dataURIPrefix = "data:image/png;base64,"
+
+// clipboardImage checks if the given clipboard contents is a PNG image,
+// either as raw PNG data or as a data URI, and decodes it if it is.
+func clipboardImage(contents string) (image.Image, bool) {
+	data := []byte(contents)
+	if s := strings.TrimSpace(contents); strings.HasPrefix(s, dataURIPrefix) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, dataURIPrefix))
+		if err != nil {
+			return nil, false
+		}
+		data = decoded
+	}
+	if img.DetectFormat(data) != img.FormatPNG {
+		return nil, false
+	}
+	m, err := img.DecodePNG(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// DataURI encodes the image as a PNG image in a data URI, like "data:image/png;base64,...".
+// Also returns the size of the PNG image, in bytes.
+func (e *Editor) DataURI() (string, int, error) {
+	var buf bytes.Buffer
+	if err := EncodeFavicon(&buf, e.mode, image.Pt(e.width, e.height), e.String(), true); err != nil {
+		return "", 0, err
+	}
+	return dataURIPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), buf.Len(), nil
+}
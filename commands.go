@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/vt100"
+)
+
+// Command is an operation that can be run by typing its name in the command palette
+type Command struct {
+	name string // the name that is typed in, like "invert"
+	key  string // the hotkey that does the same, as returned by tty.String, or an empty string
+	run  func() // runs the command, after the palette has been closed
+}
+
+// Commands is a registry of the commands that can be run from the command palette, sorted by name
+type Commands struct {
+	list []Command
+}
+
+// hotkeyCommands are the names of the operations that already have a hotkey, and the key that runs them
+var hotkeyCommands = []struct{ name, key string }{
+	{"save", "c:19"},
+	{"save-as", "c:15"},
+	{"save-invalid", "c:6"},
+	{"export", "c:0"},
+	{"goto", "c:12"},
+	{"undo", "c:21"},
+	{"quit", "c:17"},
+	{"redraw", "c:27"},
+	{"next-file", "c:9"},
+	{"previous-file", "⇧⇥"},
+	{"status", "c:7"},
+	{"colors", "c:18"},
+	{"copy-data-uri", "c:2"},
+	{"pen", "c:20"},
+	{"shade", "#"},
+	{"pick", "p"},
+	{"plot", "o"},
+	{"line", "l"},
+	{"rectangle", "r"},
+	{"filled-rectangle", "R"},
+	{"mark", "m"},
+	{"copy-block", "y"},
+	{"cut-block", "X"},
+	{"paste-block", "v"},
+	{"graphics", "g"},
+	{"coordinates", "n"},
+	{"literal-transparency", "t"},
+	{"zoom", "z"},
+	{"half-blocks", "h"},
+	{"invert", "i"},
+	{"reload", "L"},
+	{"hotspot", "S"},
+	{"export-png-sizes", "P"},
+	{"export-xpm", "W"},
+	{"copy-html", "H"},
+	{"export-go", "G"},
+	{"brighter", "w"},
+	{"darker", "s"},
+	{"more-contrast", "k"},
+	{"less-contrast", "K"},
+	{"replace", "x"},
+	{"trim", "Y"},
+	{"center", "M"},
+	{"wrap-shift", "J"},
+	{"generate", "N"},
+	{"letter", "O"},
+	{"search", "/"},
+	{"free-cursor", "j"},
+	{"histogram", "u"},
+	{"diff", "Z"},
+	{"reference", "Q"},
+	{"stamp", "V"},
+	{"filter", "U"},
+	{"shade-up", "]"},
+	{"shade-down", "["},
+	{"theme", "I"},
+	{"save-slot", "q"},
+	{"restore-slot", ";"},
+	{"copy-all", "&"},
+}
+
+// Register adds a command with the given name. key is the hotkey that does the same, for showing it
+// in the palette, and may be empty. A command that is registered again with the same name replaces it.
+func (cs *Commands) Register(name, key string, run func()) {
+	for i, command := range cs.list {
+		if command.name == name {
+			cs.list[i] = Command{name, key, run}
+			return
+		}
+	}
+	cs.list = append(cs.list, Command{name, key, run})
+	sort.Slice(cs.list, func(i, j int) bool {
+		return cs.list[i].name < cs.list[j].name
+	})
+}
+
+// RegisterHotkeys registers all the commands in hotkeyCommands, where running a command
+// gives its hotkey to the given function, for handling it as if the key was pressed
+func (cs *Commands) RegisterHotkeys(press func(key string)) {
+	for _, hk := range hotkeyCommands {
+		key := hk.key
+		cs.Register(hk.name, key, func() {
+			press(key)
+		})
+	}
+}
+
+// Matching returns the commands with names that start with the given prefix, sorted by name
+func (cs *Commands) Matching(prefix string) []Command {
+	var matches []Command
+	for _, command := range cs.list {
+		if strings.HasPrefix(command.name, prefix) {
+			matches = append(matches, command)
+		}
+	}
+	return matches
+}
+
+// keyName returns a readable name of a key, as returned by tty.String, like "ctrl-s" for "c:19"
+func keyName(key string) string {
+	switch key {
+	case "c:0":
+		return "ctrl-space"
+	case "c:9":
+		return "tab"
+	case "c:13":
+		return "return"
+	case "c:27":
+		return "esc"
+	case "⇧⇥":
+		return "shift-tab"
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(key, "c:")); err == nil && strings.HasPrefix(key, "c:") && n >= 1 && n <= 26 {
+		return "ctrl-" + string(rune('a'+n-1))
+	}
+	return key
+}
+
+// PromptCommand shows the command palette in the status bar. The name of a command is typed in, and the
+// commands that start with what has been typed are listed. The arrow keys cycle through them, tab completes
+// the name and return chooses the selected command. Returns false if the palette was closed with esc or ctrl-q,
+// or if no command matches.
+func (e *Editor) PromptCommand(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, commands *Commands) (Command, bool) {
+	var (
+		typed string
+		index int
+	)
+	for {
+		matches := commands.Matching(typed)
+		if index >= len(matches) {
+			index = 0
+		}
+		var sb strings.Builder
+		sb.WriteString("Command: " + typed)
+		if len(matches) == 0 {
+			sb.WriteString(" (no match)")
+		}
+		// Show the selected command first, followed by the next ones that fit
+		for i := 0; i < len(matches) && sb.Len() < int(c.W())-8; i++ {
+			match := matches[(index+i)%len(matches)]
+			label := match.name
+			if match.key != "" {
+				label += " (" + keyName(match.key) + ")"
+			}
+			if i == 0 {
+				sb.WriteString("  [" + label + "]")
+			} else {
+				sb.WriteString("  " + label)
+			}
+		}
+		status.ClearAll(c)
+		status.SetMessage(sb.String())
+		status.ShowNoTimeout(c, e)
+		switch key := tty.String(); key {
+		case "→", "↓": // right or down arrow
+			if len(matches) > 0 {
+				index = (index + 1) % len(matches)
+			}
+		case "←", "↑": // left or up arrow
+			if len(matches) > 0 {
+				index = (index - 1 + len(matches)) % len(matches)
+			}
+		case "c:9": // tab, complete the name of the selected command
+			if len(matches) > 0 {
+				typed, index = matches[index].name, 0
+			}
+		case "c:8", "c:127": // ctrl-h or backspace
+			if runes := []rune(typed); len(runes) > 0 {
+				typed, index = string(runes[:len(runes)-1]), 0
+			}
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return Command{}, false
+		case "c:13": // return
+			status.ClearAll(c)
+			if len(matches) == 0 {
+				return Command{}, false
+			}
+			return matches[index], true
+		default:
+			if runes := []rune(key); len(runes) == 1 && unicode.IsPrint(runes[0]) {
+				typed, index = typed+key, 0
+			}
+		}
+	}
+}
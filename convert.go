@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// Convert reads an .ico, .cur, .png, .favtxt, .xbm or .ff image and writes it as an .ico, .cur, .png, .favtxt,
+// .xbm, .ff or .icns image, without using the terminal. If size is not 0, that image is read from .ico and .cur
+// files that contain several images. The hotspot of .cur files is kept. If scale is not 0, larger images are scaled
+// down to fit within scale x scale pixels, like with -scale. If bundle is true, .ico files are written with all the
+// sizes in bundleSizes.
+func Convert(inFilename, outFilename string, mode Mode, size, scale int, bundle bool) error {
+	// The file may be in another format than the extension says
+	inFormat := detectFormat(inFilename)
+	if inFormat == formatUnknown {
+		return withCode(errors.New(inFilename+" must be an .ico, .cur, .png, .favtxt, .xbm or .ff file"), exitDecode)
+	}
+	if err := checkOutputFilename(outFilename); err != nil {
+		return withCode(err, exitUsage)
+	}
+
+	var (
+		imageSize image.Point
+		data      []byte
+		hotspot   image.Point
+		err       error
+	)
+	if inFormat == formatCUR || (inFormat == formatICO && size != 0) {
+		var entries []img.Entry
+		if entries, err = ReadFaviconEntries(inFilename); err != nil {
+			return withCode(err, exitDecode)
+		}
+		index := img.LargestEntry(entries)
+		if size != 0 {
+			if index, err = img.FindEntry(entries, size); err != nil {
+				return withCode(decodeError(inFilename, err), exitUsage)
+			}
+		}
+		hotspot = entries[index].Hotspot
+		mode, imageSize, data, _, err = ReadFaviconEntry(inFilename, entries, index, mode, scale)
+	} else if inFormat == formatFavtxt {
+		mode, imageSize, data, _, err = ReadFavtxt(inFilename, mode, scale)
+	} else {
+		mode, imageSize, data, _, err = ReadImage(inFilename, inFormat, mode, scale)
+	}
+	if err != nil {
+		return withCode(err, exitDecode)
+	}
+	return withCode(writeText(mode, imageSize, string(data), outFilename, hotspot, bundle), exitEncode)
+}
+
+// checkOutputFilename checks that the extension of the filename is one of the image formats that can be written
+func checkOutputFilename(outFilename string) error {
+	outFormat := formatFromExtension(outFilename)
+	if outFormat != formatICO && outFormat != formatCUR && outFormat != formatPNG && outFormat != formatFavtxt && outFormat != formatXBM && outFormat != formatFarbfeld && !isICNS(outFilename) {
+		return errors.New(outFilename + " must be an .ico, .cur, .png, .favtxt, .xbm, .ff or .icns file")
+	}
+	return nil
+}
+
+// WriteImage saves the image in the given mode, as an .ico, .cur, .png, .favtxt, .xbm, .ff or .icns image, depending on the extension
+// of the filename. If bundle is true, .ico files are written with all the sizes in bundleSizes.
+func WriteImage(m image.Image, mode Mode, outFilename string, bundle bool) error {
+	if err := checkOutputFilename(outFilename); err != nil {
+		return withCode(err, exitUsage)
+	}
+	mode, imageSize, data, _, err := imageToText(m, outFilename, true, mode)
+	if err != nil {
+		return withCode(err, exitEncode)
+	}
+	return withCode(writeText(mode, imageSize, string(data), outFilename, image.Point{}, bundle), exitEncode)
+}
+
+// writeText converts the textual representation of an image and saves it in the format that the extension
+// of the filename says, see Convert
+func writeText(mode Mode, imageSize image.Point, text, outFilename string, hotspot image.Point, bundle bool) error {
+	if formatFromExtension(outFilename) == formatCUR {
+		return WriteCursor(mode, imageSize, text, outFilename, hotspot)
+	}
+	if formatFromExtension(outFilename) == formatFavtxt {
+		return WriteFavtxt(mode, imageSize, text, outFilename)
+	}
+	if formatFromExtension(outFilename) == formatXBM {
+		return WriteXBM(mode, imageSize, text, outFilename)
+	}
+	if formatFromExtension(outFilename) == formatFarbfeld {
+		return WriteFarbfeld(mode, imageSize, text, outFilename)
+	}
+	if isICNS(outFilename) {
+		return WriteICNS(mode, imageSize, text, outFilename)
+	}
+	if bundle && strings.HasSuffix(outFilename, ".ico") {
+		return WriteFaviconBundle(mode, imageSize, text, outFilename)
+	}
+	return WriteFavicon(mode, imageSize, text, outFilename, strings.HasSuffix(outFilename, ".png"))
+}
+
+// ConvertStream reads an .ico or .png image from r and writes it to w, without using the terminal.
+// The format of the image that is read is detected by looking at the first bytes.
+// The format that is written is given by "to", which can be "png", "ico", "ff" for farbfeld or blank for the same format.
+// The name is only used in error messages.
+func ConvertStream(r io.Reader, w io.Writer, name, to string, mode Mode, size int, bundle bool) error {
+	if to != "" && to != "png" && to != "ico" && to != "ff" {
+		return usageError("can only convert to png, ico or ff, not " + to)
+	}
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return withCode(err, exitDecode)
+	}
+	im, err := img.DecodeSize(bytes.NewReader(in), size)
+	if err != nil {
+		return withCode(decodeError(name, err), exitDecode)
+	}
+	PNG := img.DetectFormat(in) == img.FormatPNG
+	mode, imageSize, data, _, err := imageToText(im.Image, name, PNG, mode)
+	if err != nil {
+		return withCode(err, exitDecode)
+	}
+	if to == "ff" {
+		return withCode(EncodeFarbfeld(w, mode, imageSize, string(data)), exitEncode)
+	}
+	if to != "" {
+		PNG = to == "png"
+	}
+	if bundle && !PNG {
+		return withCode(EncodeFaviconBundle(w, mode, imageSize, string(data)), exitEncode)
+	}
+	return withCode(EncodeFavicon(w, mode, imageSize, string(data), PNG), exitEncode)
+}
+
+// PrintHalfBlocks reads an .ico or .png image and writes it to w as lines of colored half block characters,
+// where each line shows two rows of pixels. If size is not 0, that image is read from .ico files with several images.
+// If truecolor is false, the closest colors in the 256 color palette are used.
+func PrintHalfBlocks(filename string, w io.Writer, size int, truecolor bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	im, err := img.DecodeSize(f, size)
+	if err != nil {
+		return decodeError(filename, err)
+	}
+	mode, imageSize, data, _, err := imageToText(im.Image, filename, true, modeRGBA)
+	if err != nil {
+		return err
+	}
+	m, err := textToImage(mode, imageSize, string(data))
+	if err != nil {
+		return err
+	}
+	for _, line := range halfBlockLines(m, truecolor) {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportANSI reads an .ico or .png image and saves it as ANSI art, or as a shell script that prints it if the
+// filename ends with .sh, see WriteANSI. If size is not 0, that image is read from .ico files with several images.
+func ExportANSI(filename, ansiFilename string, size int, truecolor bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	im, err := img.DecodeSize(f, size)
+	if err != nil {
+		return withCode(decodeError(filename, err), exitDecode)
+	}
+	mode, imageSize, data, _, err := imageToText(im.Image, filename, true, modeRGBA)
+	if err != nil {
+		return withCode(err, exitDecode)
+	}
+	return withCode(WriteANSI(mode, imageSize, string(data), filename, ansiFilename, truecolor), exitEncode)
+}
+
+// PrintXPM reads an .ico or .png image and writes it to w as an .xpm image, see EncodeXPM.
+// If size is not 0, that image is read from .ico files with several images.
+func PrintXPM(filename string, w io.Writer, size int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	im, err := img.DecodeSize(f, size)
+	if err != nil {
+		return decodeError(filename, err)
+	}
+	mode, imageSize, data, _, err := imageToText(im.Image, filename, true, modeRGBA)
+	if err != nil {
+		return err
+	}
+	return EncodeXPM(w, mode, imageSize, string(data), filename)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+	"github.com/xyproto/vt100"
+)
+
+// EncodeCursor converts the textual representation to an image and writes it to the given io.Writer
+// as a .cur image with the given hotspot, which is the pixel of the cursor that points
+func EncodeCursor(w io.Writer, mode Mode, size image.Point, text string, hotspot image.Point) error {
+	if !mode.CanSave() {
+		return img.ErrCanNotSave
+	}
+	if size.X < 1 || size.Y < 1 || size.X > img.MaxSize || size.Y > img.MaxSize {
+		return fmt.Errorf("can not save a %dx%d image, the maximum size is %dx%d", size.X, size.Y, img.MaxSize, img.MaxSize)
+	}
+	if !hotspot.In(image.Rect(0, 0, size.X, size.Y)) {
+		return fmt.Errorf("the hotspot %d,%d is outside of the %dx%d image", hotspot.X, hotspot.Y, size.X, size.Y)
+	}
+
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+
+	entry, err := encoder.NewEntry(savedImage(mode, m).Saved(), mode.Bits())
+	if err != nil {
+		return err
+	}
+	entry.Cursor, entry.Hotspot = true, hotspot
+	return img.WriteEntries(w, []img.Entry{entry})
+}
+
+// WriteCursor converts the textual representation to an image and saves it as a .cur image, see EncodeCursor
+func WriteCursor(mode Mode, size image.Point, text, filename string, hotspot image.Point) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeCursor(w, mode, size, text, hotspot)
+	})
+}
+
+// readCursor reads the chosen entry of a .cur file, or the first one, together with its hotspot
+func (e *Editor) readCursor(filename string) (Mode, image.Point, []byte, string, error) {
+	entries, index := e.icoEntries, e.icoIndex
+	if len(entries) < 2 {
+		var err error
+		if entries, err = ReadFaviconEntries(filename); err != nil {
+			return modeBlank, image.Point{}, []byte{}, "", err
+		}
+		index = 0
+	}
+	mode, size, data, message, err := ReadFaviconEntry(filename, entries, index, e.mode, e.scale)
+	if err != nil {
+		return mode, size, data, message, err
+	}
+	if len(entries) > 1 {
+		message += fmt.Sprintf(" (entry %d of %d, %dx%d)", index+1, len(entries), size.X, size.Y)
+	}
+	e.hotspot = entries[index].Hotspot
+	if !e.hotspot.In(image.Rect(0, 0, size.X, size.Y)) {
+		// The image was scaled, or the hotspot was outside of the image to begin with
+		e.hotspot = image.Point{}
+	}
+	return mode, size, data, message + fmt.Sprintf(" (hotspot %d,%d)", e.hotspot.X, e.hotspot.Y), nil
+}
+
+// sameKindOfEntries checks if the entries of the file that is being edited can be saved to a file in
+// the given format, which is the case for .ico entries and .ico files, and for .cur entries and .cur files
+func sameKindOfEntries(entries []img.Entry, format Format) bool {
+	return len(entries) > 0 && format.HasEntries() && entries[0].Cursor == (format == formatCUR)
+}
+
+// isHotspot checks if the given pixel is the hotspot of the cursor that is being edited
+func (e *Editor) isHotspot(x, y int) bool {
+	return e.drawMode && e.format == formatCUR && e.hotspot.X == x && e.hotspot.Y == y
+}
+
+// drawHotspot draws the hotspot pixel of a cursor in other colors than the rest of the image,
+// on top of the numlines lines that WriteLines has written, starting with the given line.
+// With NO_COLOR, the hotspot pixel is drawn with + instead.
+func (e *Editor) drawHotspot(c *vt100.Canvas, offset, numlines, cx, cy, zoom int) {
+	x, y := e.hotspot.X, e.hotspot.Y
+	if !e.isHotspot(x, y) || y < offset || (y-offset+1)*zoom > numlines {
+		return
+	}
+	cw := cellWidth(e.mode)
+	for dy := 0; dy < zoom; dy++ {
+		for i := 0; i < cw*zoom; i++ {
+			sx := cx + x*cw*zoom + i
+			if sx >= int(c.W()) {
+				break
+			}
+			if sx < e.pos.left {
+				// Panned out of view
+				continue
+			}
+			if e.noColor {
+				e.writeRune(c, uint(sx), uint(cy+(y-offset)*zoom+dy), e.fg, e.bg, '+')
+				continue
+			}
+			e.writeRune(c, uint(sx), uint(cy+(y-offset)*zoom+dy), vt100.Black, vt100.BackgroundYellow, e.Get(x*cw+i/zoom, y))
+		}
+	}
+}
+
+// parseHotspot parses a hotspot like "3,4" and checks that it is within a width x height image
+func parseHotspot(s string, width, height int) (image.Point, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 {
+		return image.Point{}, fmt.Errorf("%q is not an x,y pair, like 0,0", s)
+	}
+	x, errX := strconv.Atoi(strings.TrimSpace(fields[0]))
+	y, errY := strconv.Atoi(strings.TrimSpace(fields[1]))
+	if errX != nil || errY != nil {
+		return image.Point{}, fmt.Errorf("%q is not an x,y pair, like 0,0", s)
+	}
+	if x < 0 || y < 0 || x >= width || y >= height {
+		return image.Point{}, fmt.Errorf("the hotspot must be from 0,0 to %d,%d", width-1, height-1)
+	}
+	return image.Pt(x, y), nil
+}
+
+// PromptHotspot asks for the hotspot of the cursor in the status bar, starting with the current one.
+// Returns false if the prompt was cancelled or if the hotspot is not valid, in which case an error is shown.
+func (e *Editor) PromptHotspot(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar) bool {
+	text, ok := e.PromptText(c, tty, status, "Hotspot (x,y):", fmt.Sprintf("%d,%d", e.hotspot.X, e.hotspot.Y))
+	if !ok {
+		return false
+	}
+	hotspot, err := parseHotspot(text, e.width, e.height)
+	if err != nil {
+		status.SetErrorMessage(err.Error())
+		status.Show(c, e)
+		return false
+	}
+	if hotspot != e.hotspot {
+		e.hotspot = hotspot
+		e.changed = true
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/xyproto/vt100"
+)
+
+// DiffWithFile reads the image from disk again, in the same way as when loading it, and compares it pixel by
+// pixel with the image that is being edited. The pixels that differ are highlighted until ClearDiff is called.
+// Returns the number of pixels that differ, or an error if the file can not be read or has another size.
+func (e *Editor) DiffWithFile(filename string) (int, error) {
+	if !exists(filename) {
+		return 0, errors.New(filename + " has not been saved yet")
+	}
+	format := e.FileFormat(filename)
+	if format == formatUnknown {
+		return 0, errors.New(filename + " is not an .ico, .cur, .png, .pgm, .bmp or .jpg image")
+	}
+	// Reading a .cur file also reads the hotspot, which should stay as it is
+	hotspot := e.hotspot
+	mode, size, data, _, err := e.readImage(filename, format)
+	e.hotspot = hotspot
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s from disk: %v", filename, err)
+	}
+	if size.X != e.width || size.Y != e.height {
+		return 0, fmt.Errorf("%s is %dx%d on disk, not %dx%d", filename, size.X, size.Y, e.width, e.height)
+	}
+	m, err := textToImage(mode, size, string(data))
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s from disk: %v", filename, err)
+	}
+	// The pixels are compared as they are written in the current mode, so that both are rounded the same way
+	differ := make(map[image.Point]bool)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			pixel, err := e.Pixel(x, y)
+			if err != nil || pixelText(e.mode, pixel) != pixelText(e.mode, m.NRGBAAt(x, y)) {
+				differ[image.Pt(x, y)] = true
+			}
+		}
+	}
+	e.diff = differ
+	return len(differ), nil
+}
+
+// ClearDiff stops highlighting the pixels that differ from the file on disk
+func (e *Editor) ClearDiff() {
+	e.diff = nil
+}
+
+// diffMessage returns the status message after comparing the image with the file on disk, like "7 pixels differ"
+func diffMessage(n int, filename string) string {
+	switch n {
+	case 0:
+		return "No pixels differ from " + filename + " on disk"
+	case 1:
+		return "1 pixel differs from " + filename + " on disk"
+	}
+	return fmt.Sprintf("%d pixels differ from %s on disk", n, filename)
+}
+
+// drawHighlighted draws the given pixels with the search highlight color, like the pixels that differ from the
+// file on disk, on top of the numlines lines that WriteLines has written, starting with the given line
+func (e *Editor) drawHighlighted(c *vt100.Canvas, pixels map[image.Point]bool, offset, numlines, cx, cy, zoom int) {
+	cw := cellWidth(e.mode)
+	for p := range pixels {
+		if p.Y < offset || (p.Y-offset+1)*zoom > numlines || e.isHotspot(p.X, p.Y) {
+			continue
+		}
+		for dy := 0; dy < zoom; dy++ {
+			for i := 0; i < cw*zoom; i++ {
+				sx := cx + p.X*cw*zoom + i
+				if sx >= int(c.W()) {
+					break
+				}
+				if sx < e.pos.left {
+					// Panned out of view
+					continue
+				}
+				e.writeRune(c, uint(sx), uint(cy+(p.Y-offset)*zoom+dy), e.searchFg, e.bg, e.Get(p.X*cw+i/zoom, p.Y))
+			}
+		}
+	}
+}
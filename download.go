@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xyproto/favicon/img"
+	"github.com/xyproto/vt100"
+)
+
+// downloadTimeout is how long a download of an image is allowed to take
+const downloadTimeout = 20 * time.Second
+
+// maxDownloadSize returns how many bytes can be downloaded at most. This is twice the size of an uncompressed
+// RGBA image with the largest size that is decoded, which leaves room for the smaller images of an .ico file.
+func maxDownloadSize() int64 {
+	size := int64(img.MaxDecodeSize())
+	return 2 * 4 * size * size
+}
+
+// isURL checks if the given filename is an http:// or https:// URL
+func isURL(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// Download fetches an .ico or .png image from the given URL. Images that are larger than maxDownloadSize are refused.
+// Returns the image data, a local filename based on the URL (like example.com-favicon.ico) and an error.
+func Download(rawurl string) ([]byte, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("could not download %s: %s", rawurl, resp.Status)
+	}
+	// Read one byte more than is allowed, to find out if the image is too large
+	limit := maxDownloadSize()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > limit {
+		return nil, "", fmt.Errorf("could not download %s: it is larger than %d bytes", rawurl, limit)
+	}
+
+	// Detect the image format by looking at the first bytes
+	var ext string
+	switch img.DetectFormat(data) {
+	case img.FormatPNG:
+		ext = ".png"
+	case img.FormatICO:
+		ext = ".ico"
+	default:
+		return nil, "", errors.New(rawurl + " is not an .ico or a .png image")
+	}
+
+	// Use the host name and the path for the local filename
+	name := strings.Trim(u.Hostname()+"/"+strings.Trim(u.Path, "/"), "/")
+	name = strings.Replace(name, "/", "-", -1)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".ico"), ".png")
+	return data, name + ext, nil
+}
+
+// LoadDownloaded loads image data that has been downloaded, as if it was read from the given filename.
+// The data is marked as changed, since the file has not been saved yet.
+// Returns a warning message (possibly empty) and an error type.
+func (e *Editor) LoadDownloaded(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, data []byte, filename string, preferredSize int) (string, error) {
+	// Load and ChooseEntry read from files, so store the data in a temporary file with the same extension
+	tempDir, err := ioutil.TempDir("", "favicon")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+	tempFilename := filepath.Join(tempDir, "download"+filepath.Ext(filename))
+	if err := ioutil.WriteFile(tempFilename, data, 0644); err != nil {
+		return "", err
+	}
+
+	if e.FileFormat(tempFilename).HasEntries() {
+		if err := e.ChooseEntry(c, tty, status, tempFilename, preferredSize); err != nil {
+			return "", err
+		}
+	}
+	message, err := e.Load(c, tty, tempFilename)
+	if err != nil {
+		return message, err
+	}
+	e.changed = true
+	return message, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+)
+
+func TestDownload(t *testing.T) {
+	if err := img.SetMaxDecodeSize(img.MaxSize); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		img.SetMaxDecodeSize(img.DefaultMaxDecodeSize)
+	})
+	var icon bytes.Buffer
+	if err := EncodeFavicon(&icon, modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), true); err != nil {
+		t.Fatal(err)
+	}
+	large := append(append([]byte{}, img.PNGMagic...), make([]byte, maxDownloadSize())...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/favicon.png":
+			w.Write(icon.Bytes())
+		case "/large.png":
+			w.Write(large)
+		case "/page.html":
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, name, err := Download(server.URL + "/favicon.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, icon.Bytes()) || name != u.Hostname()+"-favicon.png" {
+		t.Errorf("downloaded %d bytes as %s, but wanted %d bytes as %s", len(data), name, icon.Len(), u.Hostname()+"-favicon.png")
+	}
+
+	for _, test := range []struct {
+		path string
+		err  string
+	}{
+		{"/large.png", "is larger than"},
+		{"/page.html", "is not an .ico or a .png image"},
+		{"/missing.png", "404 Not Found"},
+	} {
+		if _, _, err := Download(server.URL + test.path); err == nil {
+			t.Errorf("%s: downloaded it, but wanted an error", test.path)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got the error %q, but wanted one with %q", test.path, err, test.err)
+		}
+	}
+}
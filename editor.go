@@ -4,27 +4,33 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"io/ioutil"
 	"strings"
+	"time"
 	"unicode"
 
+	"github.com/xyproto/favicon/img"
 	"github.com/xyproto/vt100"
 )
 
 const (
 	// Mode "enum"
-	modeBlank = iota
-	modeGray4 // for 4-bit grayscale images
-	modeRGB   // for 8+8+8 bit RGB images
-	modeRGBA  // for 8+8+8+8 bit RGBA images
+	modeBlank   = img.Blank
+	modeGray4   = img.Gray4   // for 4-bit grayscale images
+	modeRGB     = img.RGB     // for 8+8+8 bit RGB images
+	modeRGBA    = img.RGBA    // for 8+8+8+8 bit RGBA images
+	modePalette = img.Palette // for indexed images with at most 16 colors
+	modeMono    = img.Mono    // for 1-bit black and white images
 )
 
-// Mode is a per-filetype mode, like for Markdown
-type Mode int
+// Mode is how the pixels of the image are edited and saved, see img.Mode
+type Mode = img.Mode
 
 // Editor represents the contents and editor settings, but not settings related to the viewport or scrolling
 type Editor struct {
-	lines        map[int][]rune       // the contents of the current document
+	lines        [][]rune             // the contents of the current document, one slice of runes per line
 	changed      bool                 // has the contents changed, since last save?
 	fg           vt100.AttributeColor // default foreground color
 	bg           vt100.AttributeColor // default background color
@@ -36,6 +42,52 @@ type Editor struct {
 	gitColor     vt100.AttributeColor // git commit message color
 	wordWrapAt   int                  // set to 80 or 100 to trigger word wrap when typing to that column
 	mode         Mode                 // a filetype mode, like for git or markdown
+	width        int                  // the image width, in pixels
+	height       int                  // the image height, in pixels
+	icoEntries   []img.Entry          // all entries, if this is an .ico file with more than one image
+	icoIndex     int                  // the index of the .ico entry that is being edited
+	hotspot      image.Point          // the pixel of the cursor that points, for .cur files
+	format       Format               // the real format of the image file, detected from the contents
+	forceFormat  Format               // the format given with -type, or formatUnknown for detecting it
+	scale        int                  // scale loaded images to fit within scale x scale pixels, if not 0
+	pngSizes     []int                // the sizes of the .png images that are exported with P
+	goPackage    string               // the package name of the Go source code that is exported with G
+	goVar        string               // the variable name of the Go source code that is exported with G
+	manifest     bool                 // write site.webmanifest when copying the HTML link tags with H?
+	bundle       bool                 // save .ico files with 16x16, 32x32 and 48x48 images?
+	backup       bool                 // copy files to filename~ before overwriting them?
+	backupName   string               // the backup that was made by the last save, if any
+	backupErr    error                // the reason why the last save could not make a backup, if any
+	postSave     string               // the command that is run after saving, for -post-save, or empty
+	modTime      time.Time            // the modification time of the file when it was loaded or saved
+	fileSize     int64                // the size of the file when it was loaded or saved
+	brush        color.NRGBA          // the color that is used by the drawing tools
+	mark         image.Point          // the pixel that was marked by a drawing tool
+	markTool     rune                 // the key of the drawing tool that set the mark, or 0
+	colors       bool                 // draw the pixels with their real colors?
+	halfBlocks   bool                 // draw a preview of the image with half block characters?
+	noColor      bool                 // is NO_COLOR set?
+	gutter       bool                 // draw the pixel coordinates to the left of and above the image?
+	freeCursor   bool                 // let the cursor leave the pixels of the image in draw mode, for editing the legend?
+	palette      *PaletteBar          // the strip with the 16 shades, above the status bar
+	literalT     bool                 // display transparent pixels as they are stored, instead of as a checker pattern?
+	pen          bool                 // paint with the brush when moving the cursor?
+	brightness   int                  // the brightness adjustments so far, for the status bar
+	contrast     int                  // the contrast adjustments so far, for the status bar
+	shifted      image.Point          // how far the image has been shifted since the last other key, for the status bar
+	wrapShift    bool                 // wrap around when shifting the image, instead of shifting in transparent pixels?
+	reference    *image.NRGBA         // a read-only image that is shown for comparing and tracing, or nil
+	refFile      string               // the filename of the reference image
+	refView      RefView              // how the reference image is shown
+	diff         map[image.Point]bool // the pixels that differ from the file on disk, if they are highlighted
+	searchShade  rune                 // the shade rune, or T, of the pixels that are highlighted, or 0 if there is no search
+	histogram    bool                 // is the histogram shown, until the next key is pressed?
+	theme        Theme                // the display profile, for how the cursor and the shades are drawn
+	dirty        map[int]bool         // the lines that have changed since they were last drawn
+	screen       []screenCell         // the cells that have been written to the canvas, with their colors
+	boldCell     image.Point          // the pixel that was last drawn in bold by drawCursorCell
+	slots        *Slots               // the snapshot slots, for trying out ideas, or nil
+	recorder     *Recorder            // records the operations that change the image, for -record, or nil
 }
 
 // NewEditor takes:
@@ -44,7 +96,6 @@ type Editor struct {
 // * if "insert mode" is enabled (as opposed to "draw mode")
 func NewEditor(fg, bg vt100.AttributeColor, textEditMode bool, scrollSpeed int, searchFg vt100.AttributeColor, mode Mode) *Editor {
 	e := &Editor{}
-	e.lines = make(map[int][]rune)
 	e.fg = fg
 	e.bg = bg
 	e.drawMode = !textEditMode
@@ -54,37 +105,90 @@ func NewEditor(fg, bg vt100.AttributeColor, textEditMode bool, scrollSpeed int,
 	// If the file is not to be highlighted, set word wrap to 99 (0 to disable)
 	e.wordWrapAt = 99
 	e.mode = mode
+	e.brush = color.NRGBA{0xff, 0xff, 0xff, 0xff}
 	return e
 }
 
-// setLightTheme sets a theme suitable for white backgrounds
-func (e *Editor) setLightTheme() {
-	e.fg = vt100.Black
-	e.bg = vt100.Gray
-	e.searchFg = vt100.Red
-	e.gitColor = vt100.Blue
-}
-
-// CopyLines will create a new map[int][]rune struct that is the copy of all the lines in the editor
-func (e *Editor) CopyLines() map[int][]rune {
-	lines2 := make(map[int][]rune)
-	for key, runes := range e.lines {
+// CopyLines will create a new slice of lines that is the copy of all the lines in the editor
+func (e *Editor) CopyLines() [][]rune {
+	lines2 := make([][]rune, len(e.lines))
+	for i, runes := range e.lines {
 		runes2 := make([]rune, len(runes))
 		copy(runes2, runes)
-		lines2[key] = runes2
+		lines2[i] = runes2
 	}
 	return lines2
 }
 
+// hasLine checks if there is a line at the given index
+func (e *Editor) hasLine(n int) bool {
+	return n >= 0 && n < len(e.lines)
+}
+
+// lineRunes returns the runes of the line at the given index, or nil if there is no such line
+func (e *Editor) lineRunes(n int) []rune {
+	if !e.hasLine(n) {
+		return nil
+	}
+	return e.lines[n]
+}
+
+// growTo adds empty lines at the end until there are at least n lines.
+// Returns true if any lines were added.
+func (e *Editor) growTo(n int) bool {
+	if n <= len(e.lines) {
+		return false
+	}
+	for len(e.lines) < n {
+		e.markDirty(len(e.lines))
+		e.lines = append(e.lines, []rune{})
+	}
+	return true
+}
+
+// putLine replaces the line at the given index, adding empty lines before it if needed
+func (e *Editor) putLine(n int, runes []rune) {
+	e.growTo(n + 1)
+	e.lines[n] = runes
+	e.markDirty(n)
+}
+
+// insertLine inserts the given line at the given index, moving the lines from that index and on one line down
+func (e *Editor) insertLine(n int, runes []rune) {
+	e.growTo(n)
+	e.lines = append(e.lines, nil)
+	copy(e.lines[n+1:], e.lines[n:])
+	e.lines[n] = runes
+	// All the lines below have moved
+	e.redraw = true
+}
+
+// removeLine removes the line at the given index, moving the lines after it one line up
+func (e *Editor) removeLine(n int) {
+	if !e.hasLine(n) {
+		return
+	}
+	copy(e.lines[n:], e.lines[n+1:])
+	e.lines[len(e.lines)-1] = nil
+	e.lines = e.lines[:len(e.lines)-1]
+	// All the lines below have moved
+	e.redraw = true
+}
+
+// trimEmptyLinesAfter removes the empty lines at the end, but only the ones after the given index
+func (e *Editor) trimEmptyLinesAfter(n int) {
+	for last := len(e.lines) - 1; last > n && len(e.lines[last]) == 0; last-- {
+		e.lines = e.lines[:last]
+	}
+}
+
 // Set will store a rune in the editor data, at the given data coordinates
 func (e *Editor) Set(x, y int, r rune) {
-	if e.lines == nil {
-		e.lines = make(map[int][]rune)
-	}
-	_, ok := e.lines[y]
-	if !ok {
-		e.lines[y] = make([]rune, 0, x+1)
+	if y < 0 {
+		return
 	}
+	e.growTo(y + 1)
+	e.markDirty(y)
 	if x < int(len([]rune(e.lines[y]))) {
 		e.lines[y][x] = r
 		e.changed = true
@@ -100,13 +204,7 @@ func (e *Editor) Set(x, y int, r rune) {
 
 // Get will retrieve a rune from the editor data, at the given coordinates
 func (e *Editor) Get(x, y int) rune {
-	if e.lines == nil {
-		return ' '
-	}
-	runes, ok := e.lines[y]
-	if !ok {
-		return ' '
-	}
+	runes := e.lineRunes(y)
 	if x >= int(len(runes)) {
 		return ' '
 	}
@@ -120,8 +218,7 @@ func (e *Editor) Changed() bool {
 
 // Line returns the contents of line number N, counting from 0
 func (e *Editor) Line(n int) string {
-	line, ok := e.lines[n]
-	if ok {
+	if line := e.lineRunes(n); line != nil {
 		var sb strings.Builder
 		for _, r := range line {
 			sb.WriteRune(r)
@@ -133,8 +230,7 @@ func (e *Editor) Line(n int) string {
 
 // ScreenLine returns the screen contents of line number N, counting from 0
 func (e *Editor) ScreenLine(n int) string {
-	line, ok := e.lines[n]
-	if ok {
+	if line := e.lineRunes(n); line != nil {
 		var sb strings.Builder
 		for _, r := range line {
 			sb.WriteRune(r)
@@ -185,12 +281,9 @@ func (e *Editor) FirstDataPosition(n int) int {
 // Count the number of instances of the rune r in the line n
 func (e *Editor) Count(r rune, n int) int {
 	var counter int
-	line, ok := e.lines[n]
-	if ok {
-		for _, l := range line {
-			if l == r {
-				counter++
-			}
+	for _, l := range e.lineRunes(n) {
+		if l == r {
+			counter++
 		}
 	}
 	return counter
@@ -198,13 +291,10 @@ func (e *Editor) Count(r rune, n int) int {
 
 // Len returns the number of lines
 func (e *Editor) Len() int {
-	maxy := 0
-	for y := range e.lines {
-		if y > maxy {
-			maxy = y
-		}
+	if len(e.lines) == 0 {
+		return 1
 	}
-	return maxy + 1
+	return len(e.lines)
 }
 
 // String returns the contents of the editor
@@ -218,10 +308,77 @@ func (e *Editor) String() string {
 
 // Clear removes all data from the editor
 func (e *Editor) Clear() {
-	e.lines = make(map[int][]rune)
+	e.lines = nil
+	e.redraw = true
 	e.changed = true
 }
 
+// ChooseEntry reads the directory of an .ico or .cur file and decides which image to edit, when there are several.
+// If preferredSize is not 0, the entry with that width and height is chosen.
+// If not, the user can choose an entry by using the arrow keys and return, starting at the largest one.
+func (e *Editor) ChooseEntry(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, filename string, preferredSize int) error {
+	entries, err := ReadFaviconEntries(filename)
+	if err != nil {
+		// Leave it to Load to report any problems with the file
+		return nil
+	}
+	e.icoEntries, e.icoIndex = nil, 0
+
+	if preferredSize != 0 {
+		index, err := img.FindEntry(entries, preferredSize)
+		if err != nil {
+			return decodeError(filename, err)
+		}
+		if len(entries) > 1 {
+			e.icoEntries, e.icoIndex = entries, index
+		}
+		return nil
+	}
+
+	if len(entries) < 2 {
+		return nil
+	}
+	largest := img.LargestEntry(entries)
+	e.icoEntries, e.icoIndex = entries, largest
+
+	if c == nil || tty == nil || status == nil {
+		// Not interactive, use the largest entry
+		return nil
+	}
+
+	// Let the user choose an entry
+	for {
+		var sb strings.Builder
+		sb.WriteString("Choose an image:")
+		for i, entry := range entries {
+			size := entry.Size()
+			if i == e.icoIndex {
+				sb.WriteString(fmt.Sprintf(" [%dx%d]", size.X, size.Y))
+			} else {
+				sb.WriteString(fmt.Sprintf(" %dx%d", size.X, size.Y))
+			}
+		}
+		status.SetMessage(sb.String())
+		status.ShowNoTimeout(c, e)
+		switch tty.String() {
+		case "←": // left arrow
+			if e.icoIndex > 0 {
+				e.icoIndex--
+			}
+		case "→": // right arrow
+			if e.icoIndex < len(entries)-1 {
+				e.icoIndex++
+			}
+		case "c:27", "c:17": // esc or ctrl-q
+			e.icoIndex = largest
+			fallthrough
+		case "c:13": // return
+			status.ClearAll(c)
+			return nil
+		}
+	}
+}
+
 // Load will try to load a file. The file is assumed to be checked to already exist.
 // Returns a warning message (possibly empty) and an error type
 func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string, error) {
@@ -230,26 +387,16 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 
 	var (
 		mode Mode
+		size image.Point
 		data []byte
 		err  error
 	)
 
 	// TODO: Use a lookup table from file extension to read function and editor settings function
-	// Read the file
-	if strings.HasSuffix(filename, ".ico") {
-		// Try to read the file
-		mode, data, message, err = ReadFavicon(filename, false, false)
-		if err == nil { // no error
-			e.mode = mode
-			e.drawMode = true
-		}
-	} else if strings.HasSuffix(filename, ".png") {
-		// Try to read the file
-		mode, data, message, err = ReadFavicon(filename, false, true)
-		if err == nil { // no error
-			e.mode = mode
-			e.drawMode = true
-		}
+	// Read the file, as the format that the first bytes say it is, which may differ from the extension
+	format := e.FileFormat(filename)
+	if format != formatUnknown {
+		mode, size, data, message, err = e.readImage(filename, format)
 	} else {
 		// Any other file extension
 		data, err = ioutil.ReadFile(filename)
@@ -266,6 +413,26 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 		return message, err
 	}
 
+	if format != formatUnknown {
+		e.mode = mode
+		e.width, e.height = size.X, size.Y
+		e.drawMode = true
+		e.format = format
+		message += mismatchMessage(filename, format)
+	}
+
+	// Show the text chunks of .png images, like the author and the license
+	if format == formatPNG {
+		if entries, err := ReadPNGText(filename); err == nil && len(entries) > 0 {
+			message += " (" + pngTextSummary(entries) + ")"
+		}
+	}
+
+	// Check if the image can be shown in its entirety
+	if w := lineWidth(e.mode, e.width); e.drawMode && c != nil && int(c.W()) < w {
+		message += fmt.Sprintf(" (the terminal needs to be at least %d columns wide to show the whole image)", w)
+	}
+
 	datalines := bytes.Split(data, []byte{'\n'})
 	e.Clear()
 	for y, dataline := range datalines {
@@ -289,21 +456,32 @@ func (e *Editor) Load(c *vt100.Canvas, tty *vt100.TTY, filename string) (string,
 func (e *Editor) PrepareEmpty(c *vt100.Canvas, tty *vt100.TTY, filename string) (Mode, error) {
 	var (
 		mode Mode = modeBlank
+		size image.Point
 		data []byte
 		err  error
 	)
 
 	// Prepare the file
-	if strings.HasSuffix(filename, ".ico") {
+	e.format = e.FileFormat(filename)
+	if e.format.ImportOnly() {
+		return mode, errors.New(filename + " does not exist, and new " + e.format.String() + " images can not be created")
+	}
+	if e.format == formatICO || e.format == formatCUR {
 		// Create empty content
-		mode, data, _, err = ReadFavicon(filename, true, false)
+		mode, size, data, _, err = imageToText(blankImage(), filename, false, e.mode)
 		if err == nil { // no error
+			e.width, e.height = size.X, size.Y
 			e.drawMode = true
 		}
-	} else if strings.HasSuffix(filename, ".png") {
-		// Create empty content
-		mode, data, _, err = ReadFavicon(filename, true, true)
+	} else if e.format == formatPNG || e.format == formatPGM || e.format == formatFavtxt || e.format == formatXBM || e.format == formatFarbfeld {
+		// Create empty content, in black and white for .xbm images, unless another mode is given
+		preferred := e.mode
+		if e.format == formatXBM && preferred == modeBlank {
+			preferred = modeMono
+		}
+		mode, size, data, _, err = imageToText(blankImage(), filename, true, preferred)
 		if err == nil { // no error
+			e.width, e.height = size.X, size.Y
 			e.drawMode = true
 		}
 	}
@@ -333,16 +511,60 @@ func (e *Editor) PrepareEmpty(c *vt100.Canvas, tty *vt100.TTY, filename string)
 
 // Save will try to save a file
 // if asOther is true, .ico files will be saved as .png, and .png files will be saved as .ico
-func (e *Editor) Save(filename *string, asOther bool) error {
+// if force is false, images are only saved if all pixels are valid
+func (e *Editor) Save(filename *string, asOther, force bool) error {
 	stripTrailingSpaces := true
-	if strings.HasSuffix(*filename, ".ico") || strings.HasSuffix(*filename, ".png") {
-		// TODO: Find a way to check if the file was written with "o".
-		//       If it was not, save to a new flename.
-		// Save the image as .ico if this is a .png file and asOther is true
-		// Save the image as .png if this is a .ico file and asOther is true
-		// If asOther is false, save as the same filename
-		// TODO: Find a cleaner API
-		return WriteFavicon(e.mode, e.String(), *filename, asOther)
+	if e.format != formatUnknown {
+		// Refuse to save pixels that would be saved as something else than what was typed in, unless forced
+		if !force {
+			if err := e.ValidatePixels(); err != nil {
+				return err
+			}
+		}
+		// Save the image in the format it was read as, or in the other format, next to it, if asOther is true
+		format, target := e.format, *filename
+		if asOther {
+			format, target = e.format.Other(), e.ExportFilename(*filename)
+		}
+		// Copy the file that is about to be overwritten, if backups are enabled
+		e.makeBackup(target)
+		var (
+			size = image.Pt(e.width, e.height)
+			err  error
+		)
+		if e.bundle && format == formatICO {
+			// Save all the sizes in bundleSizes, scaled from the current image
+			err = WriteFaviconBundle(e.mode, size, e.String(), target)
+		} else if len(e.icoEntries) > 1 && sameKindOfEntries(e.icoEntries, format) && !asOther {
+			// Only replace the entry that is being edited
+			e.icoEntries[e.icoIndex].Hotspot = e.hotspot
+			err = WriteFaviconEntry(e.mode, size, e.String(), target, e.icoEntries, e.icoIndex)
+		} else if format == formatCUR {
+			err = WriteCursor(e.mode, size, e.String(), target, e.hotspot)
+		} else if format == formatPGM {
+			err = WritePGM(e.mode, size, e.String(), target)
+		} else if format == formatFavtxt {
+			err = WriteFavtxt(e.mode, size, e.String(), target)
+		} else if format == formatXBM {
+			err = WriteXBM(e.mode, size, e.String(), target)
+		} else if format == formatFarbfeld {
+			err = WriteFarbfeld(e.mode, size, e.String(), target)
+		} else {
+			err = WriteFavicon(e.mode, size, e.String(), target, format == formatPNG)
+		}
+		// Exporting to the other format does not save the file that is being edited,
+		// unless the extension said it was in the other format to begin with
+		if err == nil && target == *filename {
+			if format != e.format {
+				e.format = format
+				e.icoEntries, e.icoIndex = nil, 0
+			}
+			e.changed = false
+			e.StatFile(*filename)
+			// The file on disk is now the same as the image
+			e.ClearDiff()
+		}
+		return err
 	}
 	var data []byte
 	if stripTrailingSpaces {
@@ -359,15 +581,21 @@ func (e *Editor) Save(filename *string, asOther bool) error {
 	} else {
 		data = []byte(e.String())
 	}
+	// Copy the file that is about to be overwritten, if backups are enabled
+	e.makeBackup(*filename)
 	// Mark the data as "not changed"
 	e.changed = false
 	// Write the data to file
-	return ioutil.WriteFile(*filename, data, 0664)
+	if err := ioutil.WriteFile(*filename, data, 0664); err != nil {
+		return err
+	}
+	e.StatFile(*filename)
+	return nil
 }
 
 // TrimRight will remove whitespace from the end of the given line number
 func (e *Editor) TrimRight(n int) {
-	if _, ok := e.lines[n]; !ok {
+	if !e.hasLine(n) {
 		return
 	}
 	lastIndex := len([]rune(e.lines[n])) - 1
@@ -380,35 +608,194 @@ func (e *Editor) TrimRight(n int) {
 	}
 	// Remove the trailing spaces
 	e.lines[n] = e.lines[n][:(lastIndex + 1)]
+	e.markDirty(n)
 	e.changed = true
 }
 
+// readImage reads the image in the given format and converts it to a textual representation, in the mode of
+// the editor, if possible. For .ico and .cur files, the entry that is being edited is read. For .cur files,
+// the hotspot is also read. Returns the Mode that was used, the image size, the text and a message.
+func (e *Editor) readImage(filename string, format Format) (Mode, image.Point, []byte, string, error) {
+	switch format {
+	case formatICO:
+		// Read the chosen entry, if there are several images in the file
+		if len(e.icoEntries) > 1 {
+			mode, size, data, message, err := ReadFaviconEntry(filename, e.icoEntries, e.icoIndex, e.mode, e.scale)
+			if err == nil {
+				message += fmt.Sprintf(" (entry %d of %d, %dx%d)", e.icoIndex+1, len(e.icoEntries), size.X, size.Y)
+			}
+			return mode, size, data, message, err
+		}
+		return ReadImage(filename, format, e.mode, e.scale)
+	case formatCUR:
+		// Read the hotspot of the chosen entry too
+		return e.readCursor(filename)
+	case formatPNG, formatBMP, formatJPEG, formatPGM, formatXBM, formatFarbfeld:
+		// Read .png images in the mode that fits them, the others as grayscale, or black and white for .xbm files
+		return ReadImage(filename, format, e.mode, e.scale)
+	case formatFavtxt:
+		return ReadFavtxt(filename, e.mode, e.scale)
+	}
+	return modeBlank, image.Point{}, []byte{}, "", errors.New(filename + " is not an .ico, .cur, .png, .pgm, .favtxt, .xbm, .ff, .bmp or .jpg image")
+}
+
 // WriteLines will draw editor lines from "fromline" to and up to "toline" to the canvas, at cx, cy
 func (e *Editor) WriteLines(c *vt100.Canvas, fromline, toline, cx, cy int) error {
-	w := int(c.Width())
 	if fromline >= toline {
 		return errors.New("fromline >= toline in WriteLines")
 	}
 	numlines := toline - fromline
+	if e.drawMode {
+		// The image may be panned, if it is larger than the canvas
+		fromline += e.pos.panY
+	}
 	offset := fromline
+	// Draw the coordinates, then the contents next to them
+	if left, top := e.gutterSize(e.pos.Zoom()); left > 0 {
+		e.drawGutter(c, fromline, numlines, cx, cy)
+		cx += left
+		cy += top
+		numlines -= top
+	}
+	w := int(c.Width()) - cx
+	// The columns that are panned out of view to the left are not drawn, and the overlays are shifted as well
+	panX := e.pos.panX
+	if zoom := e.pos.Zoom(); zoom > 1 {
+		err := e.writeZoomedLines(c, offset, numlines, cx, cy, zoom)
+		e.drawHighlighted(c, e.diff, offset, numlines, cx-panX*zoom, cy, zoom)
+		e.drawHighlighted(c, e.SearchMatches(), offset, numlines, cx-panX*zoom, cy, zoom)
+		e.drawHotspot(c, offset, numlines, cx-panX*zoom, cy, zoom)
+		e.drawCursorCell(c, offset, numlines, cx-panX*zoom, cy, zoom)
+		return err
+	}
 	for y := 0; y < numlines; y++ {
 		counter := 0
-		line := e.Line(y + offset)
+		line := e.displayLine(y + offset)
+		if runes := []rune(line); panX > 0 && panX < len(runes) {
+			line = string(runes[panX:])
+		} else if panX > 0 {
+			line = ""
+		}
 		screenLine := strings.TrimRightFunc(line, unicode.IsSpace)
 		if len([]rune(screenLine)) >= w {
-			screenLine = screenLine[:w]
+			screenLine = string([]rune(screenLine)[:w])
 		}
 		// Output a regular line
-		c.Write(uint(cx+counter), uint(cy+y), e.fg, e.bg, screenLine)
+		e.writeString(c, uint(cx+counter), uint(cy+y), e.fg, e.bg, screenLine)
 		counter += len([]rune(screenLine))
 		// Fill the rest of the line on the canvas with "blanks"
 		for x := counter; x < w; x++ {
-			c.WriteRune(uint(cx+x), uint(cy+y), e.fg, e.bg, ' ')
+			e.writeRune(c, uint(cx+x), uint(cy+y), e.fg, e.bg, ' ')
+		}
+	}
+	e.drawHighlighted(c, e.diff, offset, numlines, cx-panX, cy, 1)
+	e.drawHighlighted(c, e.SearchMatches(), offset, numlines, cx-panX, cy, 1)
+	e.drawHotspot(c, offset, numlines, cx-panX, cy, 1)
+	e.drawCursorCell(c, offset, numlines, cx-panX, cy, 1)
+	return nil
+}
+
+// checkerRune returns the rune that transparent pixels are displayed with at the given pixel,
+// for a light and dark checker pattern
+func checkerRune(x, y int) rune {
+	if (x+y)%2 != 0 {
+		return '▒'
+	}
+	return '░'
+}
+
+// displayLine returns the given line as it should be displayed. In draw mode, transparent pixels are
+// displayed as a light and dark checker pattern, unless literalT is set. The contents are not changed.
+func (e *Editor) displayLine(y int) string {
+	line := e.Line(y)
+	if !e.drawMode || e.literalT || y >= e.height {
+		return line
+	}
+	var (
+		runes = []rune(line)
+		cw    = cellWidth(e.mode)
+	)
+	for x := 0; x < e.width && (x+1)*cw <= len(runes); x++ {
+		cell := runes[x*cw : (x+1)*cw]
+		if pixel, err := parsePixel(e.mode, cell); err != nil || pixel.A != 0 {
+			continue
+		}
+		checker := checkerRune(x, y)
+		for i, r := range cell {
+			// Keep the '|' separators of RGB and RGBA pixels
+			if r == 'T' || r == ' ' {
+				cell[i] = checker
+			}
+		}
+	}
+	return string(runes)
+}
+
+// writeZoomedLines draws numlines screen lines to the canvas, starting with the given line,
+// where each rune is drawn as a zoom x zoom block of cells
+func (e *Editor) writeZoomedLines(c *vt100.Canvas, offset, numlines, cx, cy, zoom int) error {
+	w := int(c.Width()) - cx
+	for y := 0; y < numlines; y++ {
+		line := []rune(strings.TrimRightFunc(e.displayLine(offset+y/zoom), unicode.IsSpace))
+		if panX := e.pos.panX; panX < len(line) {
+			line = line[panX:]
+		} else {
+			line = line[:0]
+		}
+		for x := 0; x < w; x++ {
+			r := ' '
+			if x/zoom < len(line) {
+				r = line[x/zoom]
+			}
+			e.writeRune(c, uint(cx+x), uint(cy+y), e.fg, e.bg, r)
 		}
 	}
 	return nil
 }
 
+// FitZoom drops the zoom factor back to 1 if the zoomed image does not fit on the canvas,
+// with room for the status bar. Returns false if the zoom factor was changed.
+func (e *Editor) FitZoom(c *vt100.Canvas) bool {
+	if e.FitZoomAt(c, e.pos.Zoom()) {
+		return true
+	}
+	e.pos.SetZoom(1)
+	return false
+}
+
+// FitZoomAt checks if the image fits on the canvas when zoomed in by the given factor,
+// with room for the status bar
+func (e *Editor) FitZoomAt(c *vt100.Canvas, zoom int) bool {
+	if zoom <= 1 {
+		return true
+	}
+	left, top := e.gutterSize(zoom)
+	return left+lineWidth(e.mode, e.width)*zoom <= int(c.W()) && top+e.height*zoom <= int(c.H())-1
+}
+
+// ReadOnly checks if the given data position is outside of the image area, in draw mode.
+// The legend, the blank lines around it and the columns to the right of the image can not be edited,
+// except for the colors in the legend of indexed 16 color images.
+func (e *Editor) ReadOnly(x, y int) bool {
+	if e.paletteEditable(x, y) {
+		return false
+	}
+	return e.drawMode && e.height > 0 && (y >= e.height || x >= lineWidth(e.mode, e.width))
+}
+
+// RefuseReadOnly shows a status message and returns true if the cursor is outside of the image area,
+// where the text can not be edited
+func (e *Editor) RefuseReadOnly(c *vt100.Canvas, status *StatusBar) bool {
+	x, _ := e.DataX()
+	if !e.ReadOnly(x, e.DataY()) {
+		return false
+	}
+	status.ClearAll(c)
+	status.SetMessage("Only the pixels can be edited")
+	status.Show(c, e)
+	return true
+}
+
 // DeleteRestOfLine will delete the rest of the line, from the given position
 func (e *Editor) DeleteRestOfLine() {
 	x, err := e.DataX()
@@ -417,65 +804,39 @@ func (e *Editor) DeleteRestOfLine() {
 		return
 	}
 	y := e.DataY()
-	if e.lines == nil {
-		e.lines = make(map[int][]rune)
-	}
-	_, ok := e.lines[y]
-	if !ok {
+	if e.ReadOnly(x, y) {
 		return
 	}
-	if x >= len([]rune(e.lines[y])) {
+	if x >= len(e.lineRunes(y)) {
 		return
 	}
 	e.lines[y] = e.lines[y][:x]
+	e.markDirty(y)
 	e.changed = true
 }
 
 // DeleteLine will delete the given line index
 func (e *Editor) DeleteLine(n int) {
-	endOfDocument := n >= (e.Len() - 1)
-	if endOfDocument {
-		// Just delete this line
-		delete(e.lines, n)
-		return
-	}
-	// Shift all lines after y so that y is overwritten.
-	// Then delete the last item.
-	maxIndex := 0
-	found := false
-	for k := range e.lines {
-		if k > maxIndex {
-			maxIndex = k
-			found = true
-		}
-	}
-	if !found {
-		// This should never happen
+	if e.ReadOnly(0, n) {
 		return
 	}
-	if _, ok := e.lines[maxIndex]; !ok {
-		// The line numbers and the length of e.lines does not match
+	if !e.hasLine(n) {
 		return
 	}
-	// Shift all lines after n one step closer to n, overwriting e.lines[n]
-	for i := n; i <= (maxIndex - 1); i++ {
-		e.lines[i] = e.lines[i+1]
-	}
-	// delete the final item
-	delete(e.lines, maxIndex)
-
+	// Move all lines after n one step closer to n, overwriting e.lines[n]
+	e.removeLine(n)
 	e.changed = true
-
-	// Make sure no lines are nil
-	e.MakeConsistent()
 }
 
 // Delete will delete a character at the given position
 func (e *Editor) Delete() {
 	y := e.DataY()
-	llen := len([]rune(e.lines[y]))
-	if _, ok := e.lines[y]; !ok || llen == 0 || llen == 1 && unicode.IsSpace(e.lines[y][0]) {
-		// All keys in the map that are > y should be shifted -1.
+	if x, _ := e.DataX(); e.ReadOnly(x, y) {
+		return
+	}
+	llen := len(e.lineRunes(y))
+	if llen == 0 || llen == 1 && unicode.IsSpace(e.lines[y][0]) {
+		// All lines that are > y should be moved one line up.
 		// This also overwrites e.lines[y].
 		e.DeleteLine(y)
 		e.changed = true
@@ -485,11 +846,11 @@ func (e *Editor) Delete() {
 	if err != nil || x >= len([]rune(e.lines[y]))-1 {
 		// on the last index, just use every element but x
 		e.lines[y] = e.lines[y][:x]
+		e.markDirty(y)
 		// check if the next line exists
-		if _, ok := e.lines[y+1]; ok {
+		if e.hasLine(y + 1) {
 			// then add the contents of the next line, if available
-			nextLine, ok := e.lines[y+1]
-			if ok && len([]rune(nextLine)) > 0 {
+			if nextLine := e.lines[y+1]; len(nextLine) > 0 {
 				e.lines[y] = append(e.lines[y], nextLine...)
 				// then delete the next line
 				e.DeleteLine(y + 1)
@@ -500,59 +861,37 @@ func (e *Editor) Delete() {
 	}
 	// Delete just this character
 	e.lines[y] = append(e.lines[y][:x], e.lines[y][x+1:]...)
+	e.markDirty(y)
 
 	e.changed = true
-
-	// Make sure no lines are nil
-	e.MakeConsistent()
 }
 
 // Empty will check if the current editor contents are empty or not.
 // If there's only one line left and it is only whitespace, that will be considered empty as well.
 func (e *Editor) Empty() bool {
-	l := len(e.lines)
-	switch l {
+	switch len(e.lines) {
 	case 0:
 		return true
 	case 1:
-		// Check the contents of the 1 remaining line,
-		// without specifying a key.
-		for _, v := range e.lines {
-			if len(strings.TrimSpace(string(v))) == 0 {
-				return true
-			}
-			break
-		}
-		fallthrough
+		// Check the contents of the 1 remaining line
+		return len(strings.TrimSpace(string(e.lines[0]))) == 0
 	default:
 		// > 1 lines
 		return false
 	}
 }
 
-// MakeConsistent creates an empty slice of runes for any empty lines,
-// to make sure that no line number below e.Len() points to a nil map.
-func (e *Editor) MakeConsistent() {
-	// Check if the keys in the map are consistent
-	for i := 0; i < len(e.lines); i++ {
-		if _, found := e.lines[i]; !found {
-			e.lines[i] = make([]rune, 0)
-			e.changed = true
-		}
-	}
-}
-
 // WithinLimit will check if a line is within the word wrap limit,
 // given a Y position.
 func (e *Editor) WithinLimit(y int) bool {
-	return len(e.lines[y]) < e.wordWrapAt
+	return len(e.lineRunes(y)) < e.wordWrapAt
 }
 
 // LastWord will return the last word of a line,
 // given a Y position. Returns an empty string if there is no last word.
 func (e *Editor) LastWord(y int) string {
 	// TODO: Use a faster method
-	words := strings.Fields(strings.TrimSpace(string(e.lines[y])))
+	words := strings.Fields(strings.TrimSpace(string(e.lineRunes(y))))
 	if len(words) > 0 {
 		return words[len(words)-1]
 	}
@@ -568,7 +907,7 @@ func (e *Editor) SplitOvershoot(y int, isSpace bool) ([]rune, []rune) {
 	// Maximum word length to not keep as one word
 	maxDistance := e.wordWrapAt / 2
 	if e.WithinLimit(y) {
-		return e.lines[y], []rune{}
+		return e.lineRunes(y), []rune{}
 	}
 	splitPosition := e.wordWrapAt
 	if isSpace {
@@ -651,39 +990,13 @@ func (e *Editor) WrapAllLinesAt(n, maxOvershoot int) bool {
 func (e *Editor) InsertLineAbove() {
 	y := e.DataY()
 
-	// Create new set of lines
-	lines2 := make(map[int][]rune)
-
-	// For each line in the old map, if at (y-1), insert a blank line
-	// (insert a blank line above)
-	for k, v := range e.lines {
-		if k < (y - 1) {
-			lines2[k] = v
-		} else if k == (y - 1) {
-			lines2[k] = v
-			lines2[k+1] = make([]rune, 0)
-		} else if k > (y - 1) {
-			lines2[k+1] = v
-		}
-	}
-	// Use the new set of lines
-	e.lines = lines2
-
-	// Make sure no lines are nil
-	e.MakeConsistent()
+	// Insert a blank line at y, moving the current line and the lines below it one line down
+	e.insertLine(y, []rune{})
 
 	// Skip trailing newlines after this line
-	for i := len(e.lines); i > y; i-- {
-		if len([]rune(e.lines[i])) == 0 {
-			delete(e.lines, i)
-		} else {
-			break
-		}
-	}
-	e.changed = true
+	e.trimEmptyLinesAfter(y)
 
-	// Make sure no lines are nil
-	e.MakeConsistent()
+	e.changed = true
 }
 
 // InsertLineBelow will attempt to insert a new line below the current position
@@ -693,71 +1006,32 @@ func (e *Editor) InsertLineBelow() {
 
 // InsertLineBelowAt will attempt to insert a new line below the given y position
 func (e *Editor) InsertLineBelowAt(y int) {
-	// Make sure no lines are nil
-	e.MakeConsistent()
-
-	// If we are the the last line, add an empty line at the end and return
-	if y == (len(e.lines) - 1) {
-		e.lines[y+1] = make([]rune, 0)
+	// If we are at or after the last line, add empty lines at the end, up to the line below y, and return
+	if y >= (len(e.lines) - 1) {
+		e.growTo(y + 2)
 		e.changed = true
 		return
 	}
 
-	// Create new set of lines, with room for one more
-	lines2 := make(map[int][]rune, len(e.lines)+1)
-
-	// For each line in the old map, if at y, insert a blank line
-	// (insert a blank line below)
-	for k, v := range e.lines {
-		if k < y {
-			lines2[k] = v
-		} else if k == y {
-			lines2[k] = v
-			lines2[k+1] = make([]rune, 0)
-		} else if k > y {
-			lines2[k+1] = v
-		}
-	}
-	// Use the new set of lines
-	e.lines = lines2
-
-	// Make sure no lines are nil
-	e.MakeConsistent()
+	// Insert a blank line below y, moving the lines below it one line down
+	e.insertLine(y+1, []rune{})
 
 	// Skip trailing newlines after this line
-	for i := len(e.lines); i > y; i-- {
-		if len([]rune(e.lines[i])) == 0 {
-			delete(e.lines, i)
-		} else {
-			break
-		}
-	}
+	e.trimEmptyLinesAfter(y)
 
 	e.changed = true
-
-	// Make sure no lines are nil
-	e.MakeConsistent()
 }
 
-// Insert will insert a rune at the given position, with no word wrap,
-// but MakeConsisten will be called.
+// Insert will insert a rune at the given position, with no word wrap
 func (e *Editor) Insert(r rune) {
 	// Ignore it if the current position is out of bounds
 	x, _ := e.DataX()
 
 	y := e.DataY()
 
-	// If there are no lines, initialize and set the 0th rune to the given one
-	if e.lines == nil {
-		e.lines = make(map[int][]rune)
-		e.lines[0] = []rune{r}
-		return
-	}
-
-	// If the current line is empty, initialize it with a line that is just the given rune
-	_, ok := e.lines[y]
-	if !ok {
-		e.lines[y] = []rune{r}
+	// If the current line is missing, initialize it with a line that is just the given rune
+	if !e.hasLine(y) {
+		e.putLine(y, []rune{r})
 		return
 	}
 	if len([]rune(e.lines[y])) < x {
@@ -774,25 +1048,16 @@ func (e *Editor) Insert(r rune) {
 		newline[i] = e.lines[y][i-1]
 	}
 	e.lines[y] = newline
+	e.markDirty(y)
 
 	e.changed = true
-
-	// Make sure no lines are nil
-	e.MakeConsistent()
 }
 
 // CreateLineIfMissing will create a line at the given Y index, if it's missing
 func (e *Editor) CreateLineIfMissing(n int) {
-	if e.lines == nil {
-		e.lines = make(map[int][]rune)
-	}
-	_, ok := e.lines[n]
-	if !ok {
-		e.lines[n] = make([]rune, 0)
+	if e.growTo(n + 1) {
 		e.changed = true
 	}
-	// Make sure no lines are nil
-	e.MakeConsistent()
 }
 
 // SetColors will set the current editor theme (foreground, background).
@@ -812,6 +1077,7 @@ func (e *Editor) WordCount() int {
 func (e *Editor) SetLine(n int, s string) {
 	e.CreateLineIfMissing(n)
 	e.lines[n] = []rune{}
+	e.markDirty(n)
 	counter := 0
 	// It's important not to use the index value when looping over a string,
 	// unless the byte index is what one's after, as opposed to the rune index.
@@ -833,7 +1099,7 @@ func (e *Editor) SplitLine() bool {
 	y := e.DataY()
 
 	// Get the contents of this line
-	runeLine := e.lines[y]
+	runeLine := e.lineRunes(y)
 	if len(runeLine) < 2 {
 		// Did not split
 		return false
@@ -863,7 +1129,7 @@ func (e *Editor) DataX() (int, error) {
 	found := false
 	dataX := 0
 	runeCounter := 0
-	for range e.lines[dataY] {
+	for range e.lineRunes(dataY) {
 		// When we reached the correct screen position, use i as the data position
 		if screenCounter == e.pos.sx {
 			dataX = runeCounter
@@ -892,7 +1158,7 @@ func (e *Editor) DataY() int {
 // SetRune will set a rune at the current data position
 func (e *Editor) SetRune(r rune) {
 	// Only set a rune if x is within the current line contents
-	if x, err := e.DataX(); err == nil {
+	if x, err := e.DataX(); err == nil && !e.ReadOnly(x, e.DataY()) {
 		e.Set(x, e.DataY(), r)
 	}
 }
@@ -906,9 +1172,9 @@ func (e *Editor) nextLine(y int, c *vt100.Canvas, status *StatusBar) {
 // insertBelow will insert the given rune at the start of the line below,
 // starting a new line if required.
 func (e *Editor) insertBelow(y int, r rune) {
-	if _, ok := e.lines[y+1]; !ok {
+	if !e.hasLine(y + 1) {
 		// If the next line does not exist, create one containing just "r"
-		e.lines[y+1] = []rune{r}
+		e.putLine(y+1, []rune{r})
 	} else if len(e.lines[y+1]) > 0 {
 		// If the next line is non-empty, insert "r" at the start
 		e.lines[y+1] = append([]rune{r}, e.lines[y+1][:]...)
@@ -921,6 +1187,9 @@ func (e *Editor) insertBelow(y int, r rune) {
 // InsertRune will insert a rune at the current data position, with word wrap
 func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 	y := e.DataY()
+	if x, _ := e.DataX(); e.ReadOnly(x, y) {
+		return
+	}
 
 	// If it's not a word-wrap situation, just insert and return
 	if e.wordWrapAt == 0 || e.WithinLimit(y) {
@@ -982,9 +1251,9 @@ func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 		} else {
 			// This would leave the current line empty!
 			// Typing a letter at the end of a line, breaking a word
-			if _, ok := e.lines[y+1]; !ok {
+			if !e.hasLine(y + 1) {
 				// If the next line does not exist, create one containing just "r"
-				e.lines[y+1] = []rune{r}
+				e.putLine(y+1, []rune{r})
 			} else if len(e.lines[y+1]) > 0 {
 				// If the next line is non-empty, insert "r" at the start
 				e.lines[y+1] = append([]rune{r}, e.lines[y+1][:]...)
@@ -994,14 +1263,14 @@ func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 			break
 		}
 		// Insert the last word of the above line on the next line
-		if _, ok := e.lines[y+1]; !ok {
+		if !e.hasLine(y + 1) {
 			// If the next line does not exist, create one containing just "lastWord" + "r"
 			if prevAtSpace {
 				lastpos := len(lastWord) - 1
 				lastWord = append(lastWord[:lastpos], ' ')
 				lastWord = append(lastWord, r)
 			}
-			e.lines[y+1] = lastWord
+			e.putLine(y+1, lastWord)
 		} else if len(e.lines[y+1]) > 0 {
 			// If the next line is non-empty, insert "lastWord" + "r" at the start
 			e.lines[y+1] = append(lastWord, e.lines[y+1][:]...)
@@ -1035,9 +1304,9 @@ func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 		} else {
 			// This would leave the current line empty!
 			// Typing a letter at the end of a line, breaking a word
-			if _, ok := e.lines[y+1]; !ok {
+			if !e.hasLine(y + 1) {
 				// If the next line does not exist, create one containing just "r"
-				e.lines[y+1] = []rune{r}
+				e.putLine(y+1, []rune{r})
 			} else if len(e.lines[y+1]) > 0 {
 				// If the next line is non-empty, insert "r" at the start
 				e.lines[y+1] = append([]rune{r}, e.lines[y+1][:]...)
@@ -1047,14 +1316,14 @@ func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 			break
 		}
 		// Insert the last word of the above line on the next line
-		if _, ok := e.lines[y+1]; !ok {
+		if !e.hasLine(y + 1) {
 			// If the next line does not exist, create one containing just "lastWord" + "r"
 			if prevAtSpace {
 				lastpos := len(lastWord) - 1
 				lastWord = append(lastWord[:lastpos], ' ')
 				lastWord = append(lastWord, r)
 			}
-			e.lines[y+1] = lastWord
+			e.putLine(y+1, lastWord)
 		} else if len(e.lines[y+1]) > 0 {
 			// If the next line is non-empty, insert "lastWord" + "r" at the start
 			e.lines[y+1] = append(lastWord, e.lines[y+1][:]...)
@@ -1066,7 +1335,6 @@ func (e *Editor) InsertRune(c *vt100.Canvas, r rune) {
 		e.Insert(r)
 	}
 	e.TrimRight(y)
-	e.MakeConsistent()
 }
 
 // InsertString will insert a string at the current data position.
@@ -1165,7 +1433,7 @@ func (e *Editor) Next(c *vt100.Canvas) error {
 	// Did we move too far on this line?
 	w := e.wordWrapAt
 	if c != nil {
-		w = int(c.W())
+		w = (int(c.W()) - e.pos.left) / e.pos.Zoom()
 	}
 	if e.pos.sx >= w {
 		// Undo the move
@@ -1188,7 +1456,7 @@ func (e *Editor) Prev(c *vt100.Canvas) error {
 // Right will move the cursor to the right, if possible.
 // It will not move the cursor up or down.
 func (p *Position) Right(c *vt100.Canvas) {
-	lastX := int(c.Width() - 1)
+	lastX := (int(c.Width())-p.left)/p.Zoom() - 1
 	if p.sx < lastX {
 		p.sx++
 	}
@@ -1323,7 +1591,7 @@ func (e *Editor) AfterLineScreenContentsPlusOne() bool {
 // WriteRune writes the current rune to the given canvas
 func (e *Editor) WriteRune(c *vt100.Canvas) {
 	if c != nil {
-		c.WriteRune(uint(e.pos.sx), uint(e.pos.sy), e.fg, e.bg, e.Rune())
+		c.WriteRune(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()), e.fg, e.bg, e.Rune())
 	}
 }
 
@@ -1464,12 +1732,35 @@ func (e *Editor) StatusMessage() string {
 
 // DrawLines will draw a screen full of lines on the given canvas
 func (e *Editor) DrawLines(c *vt100.Canvas, respectOffset, redraw bool) {
+	// Everything is drawn, not only the lines that have changed
+	e.dirty = nil
+	// Show how large the terminal needs to be, instead of a part of the image
+	if msg, small := e.TooSmall(c); small {
+		c.Clear()
+		// Wrap the message at the width of the terminal
+		var lines []string
+		for _, word := range strings.Fields(msg) {
+			if n := len(lines); n > 0 && len(lines[n-1])+1+len(word) <= int(c.W()) {
+				lines[n-1] += " " + word
+			} else {
+				lines = append(lines, word)
+			}
+		}
+		for y, line := range lines {
+			c.Write(0, uint(y), e.fg, e.bg, line)
+		}
+		c.Draw()
+		return
+	}
 	h := int(c.Height())
 	if respectOffset {
 		e.WriteLines(c, e.pos.Offset(), h+e.pos.Offset(), 0, 0)
 	} else {
 		e.WriteLines(c, 0, h, 0, 0)
 	}
+	if e.palette != nil {
+		e.palette.Draw(c)
+	}
 	if redraw {
 		c.Redraw()
 	} else {
@@ -1491,6 +1782,8 @@ func (e *Editor) FullResetRedraw(c *vt100.Canvas, status *StatusBar) *vt100.Canv
 		e.wordWrapAt = int(newC.Width())
 	}
 	e.pos = savePos
+	e.FitZoom(newC)
+	e.pos.SetMargins(e.gutterSize(e.pos.Zoom()))
 	e.redraw = true
 	e.redrawCursor = true
 	return newC
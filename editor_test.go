@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xyproto/vt100"
+)
+
+// newTestEditor returns an editor in text edit mode with the given lines
+func newTestEditor(lines ...string) *Editor {
+	e := NewEditor(vt100.Default, vt100.Default, true, 10, vt100.Default, modeBlank)
+	for _, line := range lines {
+		e.lines = append(e.lines, []rune(line))
+	}
+	return e
+}
+
+// checkLines checks that the editor has the given lines
+func checkLines(t *testing.T, name string, e *Editor, want ...string) {
+	t.Helper()
+	got := make([]string, len(e.lines))
+	for i, line := range e.lines {
+		got[i] = string(line)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s: got the lines %q, but wanted %q", name, got, want)
+	}
+}
+
+func TestDeleteLine(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{"start", 0, []string{"b", "c"}},
+		{"middle", 1, []string{"a", "c"}},
+		{"end", 2, []string{"a", "b"}},
+		{"past the end", 3, []string{"a", "b", "c"}},
+		{"negative", -1, []string{"a", "b", "c"}},
+	} {
+		e := newTestEditor("a", "b", "c")
+		e.DeleteLine(test.n)
+		checkLines(t, test.name, e, test.want...)
+		if changed := test.n >= 0 && test.n < 3; e.changed != changed || e.redraw != changed {
+			t.Errorf("%s: changed is %v and redraw is %v, but wanted %v", test.name, e.changed, e.redraw, changed)
+		}
+	}
+}
+
+func TestInsertLine(t *testing.T) {
+	e := newTestEditor("a", "b")
+	e.insertLine(0, []rune("start"))
+	e.insertLine(2, []rune("middle"))
+	e.insertLine(4, []rune("end"))
+	checkLines(t, "insertLine", e, "start", "a", "middle", "b", "end")
+
+	// Inserting past the end adds empty lines before the inserted line
+	e = newTestEditor("a")
+	e.insertLine(3, []rune("d"))
+	checkLines(t, "insertLine past the end", e, "a", "", "", "d")
+}
+
+func TestInsertLineBelowAt(t *testing.T) {
+	e := newTestEditor("a", "b", "c")
+	e.InsertLineBelowAt(0)
+	checkLines(t, "below the first line", e, "a", "", "b", "c")
+
+	e = newTestEditor("a", "b")
+	e.InsertLineBelowAt(4)
+	checkLines(t, "below a line past the end", e, "a", "b", "", "", "", "")
+	if !e.changed {
+		t.Error("inserting below a line past the end did not change the contents")
+	}
+	for y := 2; y < 6; y++ {
+		if !e.dirty[y] {
+			t.Errorf("line %d was added, but is not marked to be redrawn", y)
+		}
+	}
+}
+
+func TestGrowTo(t *testing.T) {
+	e := newTestEditor("a")
+	if e.growTo(1) {
+		t.Error("growTo added lines to an editor that already had enough")
+	}
+	if !e.growTo(3) {
+		t.Error("growTo did not add any lines")
+	}
+	checkLines(t, "growTo", e, "a", "", "")
+	if e.Line(5) != "" || e.hasLine(5) {
+		t.Error("there is a line past the end")
+	}
+}
+
+func BenchmarkEdit256(b *testing.B) {
+	_, size, text, _, err := imageToText(testImage(256), "test", true, modeRGB)
+	if err != nil {
+		b.Fatal(err)
+	}
+	e := newTestEditor(strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")...)
+	e.drawMode, e.mode, e.width, e.height = true, modeRGB, size.X, size.Y
+	cw := cellWidth(modeRGB)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Paint an opaque pixel on every line, move a line down and up again and read the whole image
+		for y := 0; y < size.Y; y++ {
+			e.Set((1+y%(size.X-1))*cw+1, y, 'f')
+		}
+		e.insertLine(size.Y/2, e.lineRunes(0))
+		e.removeLine(size.Y / 2)
+		if _, err := textToImage(modeRGB, size, e.String()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The exit codes, for scripts. The flag package also exits with exitUsage if a flag is not defined.
+const (
+	exitOK               = 0
+	exitError            = 1  // any other error, like a download that failed
+	exitUsage            = 2  // a missing filename or an invalid flag value
+	exitNotFound         = 3  // a file that does not exist
+	exitDecode           = 4  // an image that can not be read
+	exitEncode           = 5  // an image that can not be written
+	exitValidateErrors   = 6  // -validate found errors
+	exitValidateWarnings = 7  // -validate found warnings, but no errors
+	exitPanic            = 70 // a bug, like EX_SOFTWARE in sysexits.h
+)
+
+// codedError is an error together with the exit code that it should give
+type codedError struct {
+	err  error
+	code int
+}
+
+// Error returns the message of the error
+func (e codedError) Error() string {
+	return e.err.Error()
+}
+
+// withCode returns the error together with the exit code that it should give, or nil if err is nil.
+// Errors that already have an exit code keep it.
+func withCode(err error, code int) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(codedError); ok {
+		return err
+	}
+	return codedError{err, code}
+}
+
+// usageError returns an error with the given message and exitUsage as the exit code
+func usageError(message string) error {
+	return codedError{errors.New(message), exitUsage}
+}
+
+// exitCode returns the exit code for the error: exitNotFound if a file does not exist,
+// the code that was given with withCode, or exitError
+func exitCode(err error) int {
+	code := exitError
+	if ce, ok := err.(codedError); ok {
+		code, err = ce.code, ce.err
+	}
+	if os.IsNotExist(err) {
+		return exitNotFound
+	}
+	return code
+}
+
+// fail prints the error and quits with the exit code for it, for the modes that do not use the terminal
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error: "+err.Error())
+	os.Exit(exitCode(err))
+}
+
+// failUsage prints how the flags should be used and quits with exitUsage
+func failUsage(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(exitUsage)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	_, notFound := os.Open(filepath.Join(t.TempDir(), "missing.ico"))
+	failed := errors.New("could not download the image")
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"error", failed, exitError},
+		{"usage", usageError("no filename is given"), exitUsage},
+		{"not found", notFound, exitNotFound},
+		{"decode", withCode(failed, exitDecode), exitDecode},
+		{"encode", withCode(failed, exitEncode), exitEncode},
+		{"not found with a code", withCode(notFound, exitDecode), exitNotFound},
+		{"usage with a code", withCode(usageError("no filename is given"), exitEncode), exitUsage},
+		{"code with a code", withCode(withCode(failed, exitDecode), exitEncode), exitDecode},
+	}
+	for _, test := range tests {
+		if code := exitCode(test.err); code != test.code {
+			t.Errorf("%s: got exit code %d, but wanted %d", test.name, code, test.code)
+		}
+	}
+	if err := withCode(nil, exitDecode); err != nil {
+		t.Errorf("withCode(nil): got %v, but wanted nil", err)
+	}
+	if err := withCode(failed, exitEncode); err.Error() != failed.Error() {
+		t.Errorf("withCode: got the message %q, but wanted %q", err, failed)
+	}
+}
+
+// writePNG writes a width x height .png image to the given file
+func writePNG(t *testing.T, filename string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateExitCode(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		square = filepath.Join(dir, "square.png")
+		wide   = filepath.Join(dir, "wide.png")
+		broken = filepath.Join(dir, "broken.png")
+	)
+	writePNG(t, square, 16, 16)
+	writePNG(t, wide, 32, 16)
+	if err := ioutil.WriteFile(broken, []byte("\x89PNG\r\n\x1a\nbroken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		code     int
+	}{
+		{"ok", []string{square}, exitOK},
+		{"warning", []string{square, wide}, exitValidateWarnings},
+		{"error", []string{broken}, exitValidateErrors},
+		{"warning and error", []string{wide, broken, square}, exitValidateErrors},
+		{"missing", []string{filepath.Join(dir, "missing.ico")}, exitValidateErrors},
+		{"no matches", []string{filepath.Join(dir, "*.ico")}, exitValidateErrors},
+		{"glob", []string{filepath.Join(dir, "s*.png")}, exitOK},
+	}
+	for _, test := range tests {
+		var out, errOut bytes.Buffer
+		if code := Validate(&out, &errOut, test.patterns, false); code != test.code {
+			t.Errorf("%s: got exit code %d, but wanted %d\n%s", test.name, code, test.code, errOut.String())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// farbfeldMagic is the start of farbfeld images
+var farbfeldMagic = []byte("farbfeld")
+
+// farbfeldHeaderSize is the size of the magic bytes, the width and the height, in bytes
+const farbfeldHeaderSize = 16
+
+// DecodeFarbfeld reads a farbfeld image, which is the magic bytes, the width and the height as 32-bit
+// big-endian numbers, and then 16-bit big-endian red, green, blue and alpha values for each pixel, row by row.
+// The alpha is not premultiplied.
+func DecodeFarbfeld(r io.Reader) (*image.NRGBA64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, farbfeldMagic) {
+		return nil, errors.New("not a farbfeld image, the first bytes must be farbfeld")
+	}
+	if len(data) < farbfeldHeaderSize {
+		return nil, errors.New("the farbfeld image is truncated, the width and height are missing")
+	}
+	width := binary.BigEndian.Uint32(data[8:12])
+	height := binary.BigEndian.Uint32(data[12:16])
+	if width < 1 || height < 1 || width > uint32(img.MaxDecodeSize()) || height > uint32(img.MaxDecodeSize()) {
+		return nil, fmt.Errorf("the width and height of the farbfeld image must be from 1 to %d, not %d and %d", img.MaxDecodeSize(), width, height)
+	}
+	pixels := data[farbfeldHeaderSize:]
+	if count := uint64(width) * uint64(height); uint64(len(pixels)) < count*8 {
+		return nil, fmt.Errorf("the farbfeld image is truncated, it has %d of %d pixels", len(pixels)/8, count)
+	}
+	m := image.NewNRGBA64(image.Rect(0, 0, int(width), int(height)))
+	// The pixels of image.NRGBA64 are stored in the same order, with the same encoding
+	copy(m.Pix, pixels)
+	return m, nil
+}
+
+// EncodeFarbfeld converts the textual representation to an image and writes it to the given io.Writer as a
+// farbfeld image, see DecodeFarbfeld. The 8-bit channels are scaled to 16 bits, so that 0xff becomes 0xffff.
+func EncodeFarbfeld(w io.Writer, mode Mode, size image.Point, text string) error {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(farbfeldMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(size.X))
+	binary.Write(&buf, binary.BigEndian, uint32(size.Y))
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := m.NRGBAAt(x, y)
+			binary.Write(&buf, binary.BigEndian, [4]uint16{uint16(c.R) * 0x101, uint16(c.G) * 0x101, uint16(c.B) * 0x101, uint16(c.A) * 0x101})
+		}
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// WriteFarbfeld converts the textual representation to an image and saves it as a farbfeld image, see EncodeFarbfeld
+func WriteFarbfeld(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeFarbfeld(w, mode, size, text)
+	})
+}
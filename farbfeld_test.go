@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+// ffColors are the pixels of testdata/colors.ff, a 4x4 farbfeld image like png2ff writes it, where the 8-bit
+// channels of the .png image are scaled to 16 bits
+var ffColors = [][]color.NRGBA{
+	{{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}, {0, 0, 0, 0}},
+	{{0xff, 0xff, 0xff, 0xff}, {0, 0, 0, 0xff}, {0x80, 0x40, 0x20, 0xff}, {0x12, 0x34, 0x56, 0x80}},
+	{{0, 0, 0, 0}, {0xaa, 0xbb, 0xcc, 0x40}, {0x7f, 0x7f, 0x7f, 0xff}, {0x01, 0x02, 0x03, 0xff}},
+	{{0xfe, 0xdc, 0xba, 0xff}, {0, 0, 0, 0}, {0x10, 0x20, 0x30, 0xc0}, {0xff, 0xff, 0, 0xff}},
+}
+
+func TestDecodeFarbfeld(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/colors.ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeFarbfeld(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y, row := range ffColors {
+		for x, want := range row {
+			c := m.NRGBA64At(x, y)
+			if got := (color.NRGBA64{uint16(want.R) * 0x101, uint16(want.G) * 0x101, uint16(want.B) * 0x101, uint16(want.A) * 0x101}); c != got {
+				t.Errorf("the pixel at (%d,%d) is %v, but wanted %v", x, y, c, got)
+			}
+		}
+	}
+
+	for name, broken := range map[string][]byte{
+		"empty":        nil,
+		"not farbfeld": []byte("farbfelt\x00\x00\x00\x01\x00\x00\x00\x01"),
+		"no size":      []byte("farbfeld\x00\x00\x00"),
+		"zero width":   []byte("farbfeld\x00\x00\x00\x00\x00\x00\x00\x01"),
+		"too large":    []byte("farbfeld\x00\x01\x00\x00\x00\x01\x00\x00"),
+		"truncated":    data[:len(data)-1],
+	} {
+		if _, err := DecodeFarbfeld(bytes.NewReader(broken)); err == nil {
+			t.Errorf("%s: decoded a broken farbfeld image", name)
+		}
+	}
+}
+
+func TestEncodeFarbfeld(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/colors.ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeFarbfeld(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, size, text, _, err := imageToText(m, "colors.ff", true, modeRGBA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeFarbfeld(&buf, mode, size, string(text)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n%x\nbut wanted\n%x", buf.Bytes(), want)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// favtxtMagic is the start of the header of .favtxt files, like "favicon gray4 16x16"
+const favtxtMagic = "favicon "
+
+// favtxtHeader returns the first line of a .favtxt file, with the mode and the size of the image
+func favtxtHeader(mode Mode, size image.Point) string {
+	return fmt.Sprintf("%s%s %dx%d", favtxtMagic, mode, size.X, size.Y)
+}
+
+// parseFavtxtHeader reads the mode and the size from the first line of a .favtxt file
+func parseFavtxtHeader(line string) (Mode, image.Point, error) {
+	var (
+		name          string
+		width, height int
+	)
+	example := favtxtHeader(modeGray4, image.Pt(blankSize, blankSize))
+	if !strings.HasPrefix(line, favtxtMagic) {
+		return modeBlank, image.Point{}, fmt.Errorf("the first line must be like %q, not %q", example, line)
+	}
+	if n, err := fmt.Sscanf(strings.TrimSpace(line[len(favtxtMagic):]), "%s %dx%d", &name, &width, &height); err != nil || n != 3 {
+		return modeBlank, image.Point{}, fmt.Errorf("the first line must be like %q, not %q", example, line)
+	}
+	mode, err := modeFromName(name)
+	if err != nil {
+		return modeBlank, image.Point{}, err
+	}
+	if width != height {
+		return modeBlank, image.Point{}, fmt.Errorf("the size is %dx%d, but only square images are supported", width, height)
+	}
+	if width < 1 || width > img.MaxSize {
+		return modeBlank, image.Point{}, fmt.Errorf("the size is %dx%d, but it must be from 1x1 to %dx%d", width, height, img.MaxSize, img.MaxSize)
+	}
+	return mode, image.Pt(width, height), nil
+}
+
+// DecodeFavtxt reads a .favtxt file, which has a header line followed by the textual representation of the image,
+// as it is shown in the editor. Rows that are shorter than the image, as when trailing spaces have been removed by
+// another editor, are padded with spaces. The errors start with the name and the line number, like "icon.favtxt:3:".
+// Returns the Mode, the image size and the textual representation, with the legend recreated.
+func DecodeFavtxt(data []byte, name string) (Mode, image.Point, []byte, error) {
+	data = bytes.Replace(data, []byte{'\r', '\n'}, []byte{'\n'}, -1)
+	// The final newline does not start another row
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	mode, size, err := parseFavtxtHeader(lines[0])
+	if err != nil {
+		return modeBlank, image.Point{}, nil, fmt.Errorf("%s:1: %s", name, err)
+	}
+	rows := lines[1:]
+	if len(rows) < size.Y {
+		return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: there are only %d of the %d rows of pixels", name, len(lines), len(rows), size.Y)
+	}
+	rows, legend := rows[:size.Y], rows[size.Y:]
+	if mode == modePalette {
+		// The palette is read from the legend
+		palette, err := parsePaletteLegend(legend)
+		if err != nil {
+			return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: %s", name, size.Y+2, err)
+		}
+		if len(palette) == 0 {
+			return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: the palette is missing below the pixels", name, size.Y+2)
+		}
+		paletteColors = palette
+	}
+	var (
+		width = lineWidth(mode, size.X)
+		cw    = cellWidth(mode)
+	)
+	for y, row := range rows {
+		runes := []rune(strings.TrimRight(row, " "))
+		if len(runes) > width {
+			return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: the row is %d columns wide, but a row of %d pixels is %d columns wide", name, y+2, len(runes), size.X, width)
+		}
+		runes = append(runes, []rune(strings.Repeat(" ", width-len(runes)))...)
+		for x := 0; x < size.X; x++ {
+			cell := runes[x*cw : x*cw+cw]
+			if mode == modeGray4 {
+				if !isShade(cell[0]) {
+					return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: pixel %d,%d has the unknown shade %q", name, y+2, x, y, cell[0])
+				}
+			} else if _, err := parsePixel(mode, cell); err != nil {
+				return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: pixel %d,%d: %s", name, y+2, x, y, err)
+			}
+			if cw == 2 && cell[1] != ' ' {
+				return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: pixel %d,%d is followed by %q instead of a space", name, y+2, x, y, cell[1])
+			}
+		}
+		if mode == modeRGB || mode == modeRGBA {
+			// The final '|' may have been left out
+			if r := runes[width-1]; r != '|' && r != ' ' {
+				return modeBlank, image.Point{}, nil, fmt.Errorf("%s:%d: the row ends with %q instead of '|'", name, y+2, r)
+			}
+			runes[width-1] = '|'
+		}
+		rows[y] = string(runes)
+	}
+	m, err := textToImage(mode, size, strings.Join(rows, "\n"))
+	if err != nil {
+		return modeBlank, image.Point{}, nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return mode, size, pixelsToText(m, mode), nil
+}
+
+// ReadFavtxt reads a .favtxt file and converts it to a textual representation, like ReadImage.
+// If another mode is preferred, or if scale is not 0, the image is converted.
+func ReadFavtxt(filename string, preferred Mode, scale int) (Mode, image.Point, []byte, string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return modeBlank, image.Point{}, []byte{}, "", err
+	}
+	mode, size, text, err := DecodeFavtxt(data, filename)
+	if err != nil {
+		return modeBlank, image.Point{}, []byte{}, "", err
+	}
+	if (preferred == modeBlank || preferred == mode) && (scale == 0 || scale == size.X) {
+		return mode, size, text, "", nil
+	}
+	m, err := textToImage(mode, size, string(text))
+	if err != nil {
+		return modeBlank, image.Point{}, []byte{}, "", err
+	}
+	if preferred == modeBlank {
+		preferred = mode
+	}
+	fitted, scaleMessage := fitImage(m, scale)
+	mode, size, text, message, err := imageToText(fitted, filename, true, preferred)
+	return mode, size, text, scaleMessage + message, err
+}
+
+// EncodeFavtxt writes the textual representation of the image to the given io.Writer as a .favtxt file,
+// with a header line that says the mode and the size, followed by the pixels and the legend
+func EncodeFavtxt(w io.Writer, mode Mode, size image.Point, text string) error {
+	if !mode.CanSave() {
+		return img.ErrCanNotSave
+	}
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(favtxtHeader(mode, size) + "\n")
+	buf.Write(bytes.TrimRight(pixelsToText(m, mode), "\n"))
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// WriteFavtxt saves the textual representation of the image as a .favtxt file, see EncodeFavtxt
+func WriteFavtxt(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeFavtxt(w, mode, size, text)
+	})
+}
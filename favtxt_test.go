@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFavtxtRoundTrip(t *testing.T) {
+	// Other editors may remove the trailing spaces and write CRLF line endings
+	trailingSpace := regexp.MustCompile(` +\n`)
+	for _, mode := range testModes {
+		text := testText(t, mode, 16)
+		var buf bytes.Buffer
+		if err := EncodeFavtxt(&buf, mode, image.Pt(16, 16), text); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if header := favtxtHeader(mode, image.Pt(16, 16)) + "\n"; !strings.HasPrefix(buf.String(), header) {
+			t.Errorf("%s: the file does not start with %q", mode, header)
+		}
+		edited := strings.Replace(trailingSpace.ReplaceAllString(buf.String(), "\n"), "\n", "\r\n", -1)
+		for name, data := range map[string]string{"saved": buf.String(), "edited": edited} {
+			gotMode, size, got, err := DecodeFavtxt([]byte(data), "icon.favtxt")
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, mode, err)
+			}
+			if gotMode != mode || size != image.Pt(16, 16) || string(got) != text {
+				t.Errorf("%s %s: got a %v %s image\n%s\nbut wanted\n%s", name, mode, size, gotMode, got, text)
+			}
+		}
+	}
+}
+
+func TestDecodeFavtxtErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string // the start of the error
+	}{
+		{"magic", "picture gray4 2x2\n_ _ \n_ _ \n", `icon.favtxt:1: the first line must be like "favicon gray4 16x16"`},
+		{"header", "favicon gray4 big\n_ _ \n_ _ \n", `icon.favtxt:1: the first line must be like "favicon gray4 16x16"`},
+		{"mode", "favicon cmyk 2x2\n_ _ \n_ _ \n", `icon.favtxt:1: "cmyk" is not a mode`},
+		{"square", "favicon gray4 2x3\n_ _ \n_ _ \n_ _ \n", "icon.favtxt:1: the size is 2x3, but only square images are supported"},
+		{"size", "favicon gray4 300x300\n", "icon.favtxt:1: the size is 300x300, but it must be from 1x1"},
+		{"rows", "favicon gray4 2x2\n_ _ \n", "icon.favtxt:2: there are only 1 of the 2 rows of pixels"},
+		{"palette entry", "favicon palette 1x1\n_ \n\n_ = #zz0000\n", `icon.favtxt:3: palette entry 0 is "#zz0000"`},
+		{"no palette", "favicon palette 1x1\n_ \n", "icon.favtxt:3: the palette is missing below the pixels"},
+		{"wide row", "favicon gray4 2x2\n_ _ \n_ _ _\n", "icon.favtxt:3: the row is 5 columns wide, but a row of 2 pixels is 4 columns wide"},
+		{"shade", "favicon gray4 2x2\n_ _ \n_ x \n", "icon.favtxt:3: pixel 1,1 has the unknown shade 'x'"},
+		{"color", "favicon rgb 1x1\n|zz0000|\n", "icon.favtxt:2: pixel 0,0: "},
+		{"space", "favicon gray4 1x1\n__\n", "icon.favtxt:2: pixel 0,0 is followed by '_' instead of a space"},
+		{"bar", "favicon rgb 1x1\n|ff0000x\n", "icon.favtxt:2: the row ends with 'x' instead of '|'"},
+	}
+	for _, test := range tests {
+		_, _, _, err := DecodeFavtxt([]byte(test.data), "icon.favtxt")
+		if err == nil {
+			t.Errorf("%s: decoded the broken file %q", test.name, test.data)
+		} else if !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got the error %q, but wanted one that starts with %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/xyproto/vt100"
+)
+
+// errCancelled is returned when the user cancels saving, after being asked
+var errCancelled = errors.New("not saved")
+
+// StatFile records the modification time and size of the file, for detecting if it is changed by
+// another program before it is saved. Returns true if the file can not be written to.
+func (e *Editor) StatFile(filename string) bool {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		e.modTime, e.fileSize = time.Time{}, 0
+		return false
+	}
+	e.modTime, e.fileSize = fi.ModTime(), fi.Size()
+	// Test write, to check if the file can be written or not
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0664)
+	if err != nil {
+		return true
+	}
+	f.Close()
+	return false
+}
+
+// ChangedOnDisk checks if the file has been changed by another program since it was loaded or saved
+func (e *Editor) ChangedOnDisk(filename string) bool {
+	if e.modTime.IsZero() {
+		return false
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		// The file has been removed, saving will create it again
+		return false
+	}
+	return !fi.ModTime().Equal(e.modTime) || fi.Size() != e.fileSize
+}
+
+// Reload loads the file again, for picking up changes that were made by another program.
+// If this is an .ico or .cur file with several images, the image with the same size is chosen, if possible.
+func (e *Editor) Reload(c *vt100.Canvas, tty *vt100.TTY, filename string) (string, error) {
+	if e.FileFormat(filename).HasEntries() {
+		if err := e.ChooseEntry(nil, nil, nil, filename, e.width); err != nil {
+			// There is no longer an image with this size, use the first one
+			if err := e.ChooseEntry(nil, nil, nil, filename, 0); err != nil {
+				return "", err
+			}
+		}
+	}
+	message, err := e.Load(c, tty, filename)
+	if err != nil {
+		return message, err
+	}
+	e.StatFile(filename)
+	return message, nil
+}
+
+// SaveChecked saves the file, like Save, but first asks what to do if the file has been changed
+// by another program since it was loaded or saved. The file can be reloaded (r), overwritten (o)
+// or the save can be cancelled (esc), in which case errCancelled is returned.
+// reloaded is true if the file was reloaded instead of saved.
+func (e *Editor) SaveChecked(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, filename *string, force bool) (reloaded bool, err error) {
+	if e.ChangedOnDisk(*filename) {
+		switch e.Ask(c, tty, status, "Changed by another program. Reload, overwrite or cancel? (r/o/esc)", "r", "o", "c:27") {
+		case "r":
+			_, err := e.Reload(c, tty, *filename)
+			return true, err
+		case "c:27":
+			return false, errCancelled
+		}
+	}
+	return false, e.Save(filename, false, force)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// filterNames are the whole-image filters that can be chosen from the filter menu, in the order they are shown
+var filterNames = []string{"threshold", "posterize", "auto-contrast", "outline", "shadow"}
+
+// isGrayFilter checks if the filter with the given name only works in 16 color grayscale mode
+func isGrayFilter(name string) bool {
+	return name == "threshold" || name == "posterize" || name == "auto-contrast"
+}
+
+// MapShades replaces the shade of every pixel in the image area that is not transparent with the result of f,
+// in 16 color grayscale mode. Returns the number of pixels that were changed.
+func (e *Editor) MapShades(f func(shade byte) byte) int {
+	return e.MapPixels(func(c color.NRGBA) color.NRGBA {
+		return img.ShadeColor(f(c.R / 16))
+	})
+}
+
+// usedShades returns the darkest and the brightest shade of the pixels that are not transparent,
+// and false if all pixels are transparent
+func (e *Editor) usedShades() (byte, byte, bool) {
+	var lo, hi byte = 15, 0
+	found := false
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			c, err := e.Pixel(x, y)
+			if err != nil || c.A == 0 {
+				continue
+			}
+			shade := c.R / 16
+			if shade < lo {
+				lo = shade
+			}
+			if shade > hi {
+				hi = shade
+			}
+			found = true
+		}
+	}
+	return lo, hi, found
+}
+
+// Threshold makes every pixel with a shade below the given level black (0) and the rest white (15).
+// Returns the number of pixels that were changed.
+func (e *Editor) Threshold(level byte) int {
+	return e.MapShades(func(shade byte) byte {
+		if shade < level {
+			return 0
+		}
+		return 15
+	})
+}
+
+// Posterize reduces the shades to the given number of evenly spaced levels, from 2 to 16,
+// where 0 and 15 are always among them. Returns the number of pixels that were changed.
+func (e *Editor) Posterize(levels int) int {
+	steps := levels - 1
+	return e.MapShades(func(shade byte) byte {
+		level := (int(shade)*steps + 7) / 15
+		return byte((level*15 + steps/2) / steps)
+	})
+}
+
+// AutoContrast stretches the shades that are used, so that the darkest one becomes 0 and the brightest one 15.
+// Returns the darkest and the brightest shade before stretching, the number of pixels that were changed,
+// and false if there is nothing to stretch, because the image is blank or only has one shade.
+func (e *Editor) AutoContrast() (byte, byte, int, bool) {
+	lo, hi, found := e.usedShades()
+	if !found || lo == hi {
+		return lo, hi, 0, false
+	}
+	n := e.MapShades(func(shade byte) byte {
+		return byte((int(shade-lo)*15 + int(hi-lo)/2) / int(hi-lo))
+	})
+	return lo, hi, n, true
+}
+
+// filterMessage returns the status message after applying a filter, like "Posterized to 4 levels (12 pixels changed)"
+func filterMessage(description string, n int) string {
+	if n == 1 {
+		return description + " (1 pixel changed)"
+	}
+	return fmt.Sprintf("%s (%d pixels changed)", description, n)
+}
+
+// artMask returns which pixels in the image area are not transparent. Pixels that can not be parsed count as art.
+func (e *Editor) artMask() [][]bool {
+	mask := make([][]bool, e.height)
+	for y := range mask {
+		mask[y] = make([]bool, e.width)
+		for x := range mask[y] {
+			c, err := e.Pixel(x, y)
+			mask[y][x] = err != nil || c.A != 0
+		}
+	}
+	return mask
+}
+
+// Outline draws a 1 pixel outline with the given color around all pixels that are not transparent, by painting
+// the transparent pixels that are next to them, above, below, to the left or to the right. Existing art is never
+// overwritten, so running it again adds another outline around the first one. Returns the number of pixels that were painted.
+func (e *Editor) Outline(c color.NRGBA) int {
+	mask := e.artMask()
+	art := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < e.width && y < e.height && mask[y][x]
+	}
+	painted := 0
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			if mask[y][x] || !(art(x-1, y) || art(x+1, y) || art(x, y-1) || art(x, y+1)) {
+				continue
+			}
+			e.SetPixel(x, y, c)
+			painted++
+		}
+	}
+	return painted
+}
+
+// DropShadow draws a shadow with the given color, 1 pixel below and to the right of all pixels that are not
+// transparent. Only transparent pixels are painted, so existing art is never overwritten, and running it again
+// makes the shadow one pixel longer. Returns the number of pixels that were painted.
+func (e *Editor) DropShadow(c color.NRGBA) int {
+	mask := e.artMask()
+	painted := 0
+	for y := 1; y < e.height; y++ {
+		for x := 1; x < e.width; x++ {
+			if mask[y][x] || !mask[y-1][x-1] {
+				continue
+			}
+			e.SetPixel(x, y, c)
+			painted++
+		}
+	}
+	return painted
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// Format is the file format of an image, which may differ from what the filename extension says
+type Format int
+
+const (
+	formatUnknown Format = iota // not an image
+	formatICO
+	formatPNG
+	formatBMP  // can only be imported
+	formatJPEG // can only be imported
+	formatPGM
+	formatCUR      // an .ico image with a hotspot, for mouse cursors
+	formatFavtxt   // the textual representation, with a header line, see DecodeFavtxt
+	formatXBM      // a 1-bit X bitmap, as C source code
+	formatFarbfeld // 16-bit RGBA, from suckless
+)
+
+// Ext returns the filename extension for the format, including the dot
+func (f Format) Ext() string {
+	switch f {
+	case formatICO:
+		return ".ico"
+	case formatPNG:
+		return ".png"
+	case formatBMP:
+		return ".bmp"
+	case formatJPEG:
+		return ".jpg"
+	case formatPGM:
+		return ".pgm"
+	case formatCUR:
+		return ".cur"
+	case formatFavtxt:
+		return ".favtxt"
+	case formatXBM:
+		return ".xbm"
+	case formatFarbfeld:
+		return ".ff"
+	}
+	return ""
+}
+
+// ImportOnly checks if images in this format can be loaded, but not saved
+func (f Format) ImportOnly() bool {
+	return f == formatBMP || f == formatJPEG
+}
+
+// String returns the name of the format, like "PNG"
+func (f Format) String() string {
+	switch f {
+	case formatICO:
+		return "ICO"
+	case formatPNG:
+		return "PNG"
+	case formatBMP:
+		return "BMP"
+	case formatJPEG:
+		return "JPEG"
+	case formatPGM:
+		return "PGM"
+	case formatCUR:
+		return "CUR"
+	case formatFavtxt:
+		return "FAVTXT"
+	case formatXBM:
+		return "XBM"
+	case formatFarbfeld:
+		return "farbfeld"
+	}
+	return "unknown"
+}
+
+// HasEntries checks if images in this format can contain several images, of different sizes
+func (f Format) HasEntries() bool {
+	return f == formatICO || f == formatCUR
+}
+
+// Other returns the format that ctrl-space exports to, .png for .ico, .cur, .pgm, .favtxt, .xbm and .ff, and .ico for .png
+func (f Format) Other() Format {
+	switch f {
+	case formatICO, formatCUR, formatPGM, formatFavtxt, formatXBM, formatFarbfeld:
+		return formatPNG
+	case formatPNG:
+		return formatICO
+	}
+	return formatUnknown
+}
+
+// formatFromExtension returns the format that the filename extension says the file has
+func formatFromExtension(filename string) Format {
+	switch filepath.Ext(filename) {
+	case ".ico":
+		return formatICO
+	case ".png":
+		return formatPNG
+	case ".bmp":
+		return formatBMP
+	case ".jpg", ".jpeg":
+		return formatJPEG
+	case ".pgm":
+		return formatPGM
+	case ".cur":
+		return formatCUR
+	case ".favtxt":
+		return formatFavtxt
+	case ".xbm":
+		return formatXBM
+	case ".ff":
+		return formatFarbfeld
+	}
+	return formatUnknown
+}
+
+// bmpMagic and jpegMagic are the first bytes of .bmp and .jpg files, see img.DetectFormat for .png, .ico and .cur files
+var (
+	bmpMagic  = []byte("BM")
+	jpegMagic = []byte{0xff, 0xd8, 0xff}
+)
+
+// sniffFormat reads the first bytes of the file and returns the format they belong to,
+// or formatUnknown if the file can not be read or is not an .ico, .cur, .png, .bmp, .jpg, .pgm, .favtxt, .xbm or .ff image.
+// .txt files are also recognized as .favtxt files by their header.
+func sniffFormat(filename string) Format {
+	f, err := os.Open(filename)
+	if err != nil {
+		return formatUnknown
+	}
+	defer f.Close()
+	magic := make([]byte, len(img.PNGMagic))
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	switch img.DetectFormat(magic) {
+	case img.FormatPNG:
+		return formatPNG
+	case img.FormatICO:
+		return formatICO
+	case img.FormatCUR:
+		return formatCUR
+	}
+	switch {
+	case bytes.HasPrefix(magic, bmpMagic):
+		return formatBMP
+	case bytes.HasPrefix(magic, jpegMagic):
+		return formatJPEG
+	case len(magic) > 2 && (bytes.HasPrefix(magic, []byte("P2")) || bytes.HasPrefix(magic, []byte("P5"))) && unicode.IsSpace(rune(magic[2])):
+		return formatPGM
+	case bytes.HasPrefix(magic, []byte(favtxtMagic)):
+		return formatFavtxt
+	case bytes.HasPrefix(magic, xbmMagic):
+		return formatXBM
+	case bytes.HasPrefix(magic, farbfeldMagic):
+		return formatFarbfeld
+	}
+	return formatUnknown
+}
+
+// detectFormat returns the format of the file by looking at the first bytes,
+// or by looking at the filename extension if the file can not be recognized
+func detectFormat(filename string) Format {
+	if format := sniffFormat(filename); format != formatUnknown {
+		return format
+	}
+	return formatFromExtension(filename)
+}
+
+// parseFormat parses the argument to -type, which can be ico, cur, png, pgm, favtxt, xbm, ff or auto.
+// auto returns formatUnknown, which means that the format is detected.
+func parseFormat(s string) (Format, error) {
+	switch s {
+	case "ico":
+		return formatICO, nil
+	case "png":
+		return formatPNG, nil
+	case "pgm":
+		return formatPGM, nil
+	case "cur":
+		return formatCUR, nil
+	case "favtxt":
+		return formatFavtxt, nil
+	case "xbm":
+		return formatXBM, nil
+	case "ff":
+		return formatFarbfeld, nil
+	case "auto", "":
+		return formatUnknown, nil
+	}
+	return formatUnknown, errors.New("the type must be ico, cur, png, pgm, favtxt, xbm, ff or auto, not " + s)
+}
+
+// FileFormat returns the format given with -type, if any.
+// If not, the format is detected from the first bytes of the file, or from the extension if it is a new file.
+func (e *Editor) FileFormat(filename string) Format {
+	if e.forceFormat != formatUnknown {
+		return e.forceFormat
+	}
+	return detectFormat(filename)
+}
+
+// mismatchMessage returns a message about the file being in another format than the extension says, if it is
+func mismatchMessage(filename string, format Format) string {
+	if ext := formatFromExtension(filename); ext == formatUnknown || ext == format || format == formatUnknown {
+		return ""
+	}
+	article := "a"
+	if format == formatICO {
+		article = "an"
+	}
+	return " (" + filepath.Base(filename) + " is actually " + article + " " + format.String() + ")"
+}
+
+// ExportFilename returns the filename that ctrl-space exports to, with the extension of the other format
+func (e *Editor) ExportFilename(filename string) string {
+	return siblingFilename(filename, e.format.Other().Ext())
+}
+
+// siblingFilename returns the filename with the .ico or .png extension replaced by ext.
+// If the filename does not have such an extension, ext is added instead.
+func siblingFilename(filename, ext string) string {
+	if formatFromExtension(filename) == formatUnknown {
+		return filename + ext
+	}
+	return withExtension(filename, ext)
+}
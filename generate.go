@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// generatorNames are the patterns that can be generated, in the order they are shown in the menu
+var generatorNames = []string{"noise", "checkerboard", "hstripes", "vstripes", "radial"}
+
+// random is used by the noise generator. It can be seeded with SetSeed, for reproducible images.
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// SetSeed seeds the random numbers that are used for generating noise, so that the same image is generated each time
+func SetSeed(seed int64) {
+	random = rand.New(rand.NewSource(seed))
+}
+
+// generatorHasPeriod checks if the generator with the given name uses a period, the width of the stripes or squares
+func generatorHasPeriod(name string) bool {
+	return name == "checkerboard" || name == "hstripes" || name == "vstripes"
+}
+
+// GeneratePattern returns a size x size grayscale image with the pattern that has the given name:
+// uniform random noise across the 16 shades, a checkerboard, horizontal or vertical stripes or a radial gradient
+// that is bright in the middle. The period is the width of the squares and the stripes, in pixels.
+func GeneratePattern(name string, size, period int) (*image.NRGBA, error) {
+	if size < 1 || size > img.MaxSize {
+		return nil, fmt.Errorf("can not generate a %dx%d image, the maximum size is %dx%d", size, size, img.MaxSize, img.MaxSize)
+	}
+	if period < 1 {
+		return nil, fmt.Errorf("the period must be at least 1, not %d", period)
+	}
+	var (
+		m = image.NewNRGBA(image.Rect(0, 0, size, size))
+		// The middle of the image and the distance from there to the corners, for the radial gradient
+		middle      = float64(size-1) / 2
+		maxDistance = math.Hypot(middle, middle)
+	)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var shade byte
+			switch name {
+			case "noise":
+				shade = byte(random.Intn(16))
+			case "checkerboard":
+				if (x/period+y/period)%2 == 0 {
+					shade = 15
+				}
+			case "hstripes":
+				if (y/period)%2 == 0 {
+					shade = 15
+				}
+			case "vstripes":
+				if (x/period)%2 == 0 {
+					shade = 15
+				}
+			case "radial":
+				shade = 15
+				if maxDistance > 0 {
+					shade = byte(math.Round(15 * (1 - math.Hypot(float64(x)-middle, float64(y)-middle)/maxDistance)))
+				}
+			default:
+				return nil, fmt.Errorf("no pattern named %q, only %s", name, strings.Join(generatorNames, ", "))
+			}
+			m.SetNRGBA(x, y, img.ShadeColor(shade))
+		}
+	}
+	return m, nil
+}
+
+// Generate fills the image area with the pattern that has the given name, see GeneratePattern
+func (e *Editor) Generate(name string, period int) error {
+	m, err := GeneratePattern(name, e.width, period)
+	if err != nil {
+		return err
+	}
+	return e.ReplaceImage(m, name)
+}
@@ -1,12 +1,17 @@
 module github.com/xyproto/favicon
 
-go 1.10
+go 1.18
 
 require (
 	github.com/atotto/clipboard v0.1.4
 	github.com/biessek/golang-ico v0.0.0-20180326222316-d348d9ea4670
-	github.com/jsummers/gobmp v0.0.0-20151104160322-e2ba15ffa76e // indirect
+	github.com/jsummers/gobmp v0.0.0-20151104160322-e2ba15ffa76e
 	github.com/xyproto/syntax v1.7.3
 	github.com/xyproto/vt100 v1.9.2
+)
+
+require (
+	github.com/pkg/term v1.1.0 // indirect
+	github.com/sourcegraph/annotate v0.0.0-20160123013949-f4cad6c6324d // indirect
 	golang.org/x/sys v0.0.0-20210611083646-a4fc73990273 // indirect
 )
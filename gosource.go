@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode"
+)
+
+// goBytesPerLine is the number of bytes per line in the generated Go source code
+const goBytesPerLine = 12
+
+// isGoIdentifier checks if the given string can be used as a Go package or variable name
+func isGoIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeGoSource writes Go source code that declares a byte slice with the given data, in the given package.
+// The description is used in the comment for the variable. The generated code is formatted with go/format.
+func EncodeGoSource(w io.Writer, packageName, varName, description string, data []byte) error {
+	if !isGoIdentifier(packageName) {
+		return fmt.Errorf("%q is not a valid Go package name", packageName)
+	}
+	if !isGoIdentifier(varName) {
+		return fmt.Errorf("%q is not a valid Go variable name", varName)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by favicon; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", packageName)
+	fmt.Fprintf(&buf, "// %s is %s\n", varName, description)
+	fmt.Fprintf(&buf, "var %s = []byte{\n", varName)
+	for i, b := range data {
+		if i%goBytesPerLine == 0 {
+			buf.WriteByte('\t')
+		}
+		fmt.Fprintf(&buf, "0x%02x,", b)
+		if i%goBytesPerLine == goBytesPerLine-1 || i == len(data)-1 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteString("}\n")
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(source)
+	return err
+}
+
+// goSourceFilename returns the filename of the Go source code that is exported, like favicon_ico.go for favicon.ico
+func goSourceFilename(filename string) string {
+	return siblingFilename(filename, "_ico.go")
+}
+
+// WriteGoSource encodes the image as an .ico image, with all the bundle sizes if -bundle is given,
+// and saves it as Go source code next to the file, with the package and variable name given by
+// -gopackage and -govar. Returns the filename that was written.
+func (e *Editor) WriteGoSource(filename string) (string, error) {
+	var (
+		ico  bytes.Buffer
+		size = image.Pt(e.width, e.height)
+		err  error
+	)
+	if e.bundle {
+		err = EncodeFaviconBundle(&ico, e.mode, size, e.String())
+	} else {
+		err = EncodeFavicon(&ico, e.mode, size, e.String(), false)
+	}
+	if err != nil {
+		return "", err
+	}
+	var source bytes.Buffer
+	description := fmt.Sprintf("a %dx%d .ico image", e.width, e.height)
+	if err := EncodeGoSource(&source, e.goPackage, e.goVar, description, ico.Bytes()); err != nil {
+		return "", err
+	}
+	goFilename := goSourceFilename(filename)
+	return goFilename, createFile(goFilename, func(w io.Writer) error {
+		_, err := source.WriteTo(w)
+		return err
+	})
+}
+
+// PrintGoSource reads an .ico or .png image and writes it to w as Go source code with the .ico image, see EncodeGoSource.
+// If size is not 0, that image is read from .ico files with several images. If bundle is true, the .ico image
+// has all the sizes in bundleSizes.
+func PrintGoSource(filename string, w io.Writer, packageName, varName string, size int, bundle bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var ico bytes.Buffer
+	if err := ConvertStream(f, &ico, filename, "ico", modeBlank, size, bundle); err != nil {
+		return err
+	}
+	return EncodeGoSource(w, packageName, varName, "the "+filepath.Base(filename)+" image, as an .ico image", ico.Bytes())
+}
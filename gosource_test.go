@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseGoSource parses Go source code from EncodeGoSource, and returns the package name, the name of the
+// variable and the bytes in the byte slice
+func parseGoSource(t *testing.T, source []byte) (string, string, []byte) {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "favicon_ico.go", source, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("the generated code can not be parsed: %v\n%s", err, source)
+	}
+	if len(f.Decls) != 1 {
+		t.Fatalf("there are %d declarations, but wanted 1", len(f.Decls))
+	}
+	decl, ok := f.Decls[0].(*ast.GenDecl)
+	if !ok || decl.Tok != token.VAR || len(decl.Specs) != 1 {
+		t.Fatalf("the declaration is not a single var")
+	}
+	spec := decl.Specs[0].(*ast.ValueSpec)
+	if len(spec.Names) != 1 || len(spec.Values) != 1 {
+		t.Fatalf("the var declares %d names with %d values, but wanted 1", len(spec.Names), len(spec.Values))
+	}
+	lit, ok := spec.Values[0].(*ast.CompositeLit)
+	if !ok {
+		t.Fatalf("the value is not a composite literal")
+	}
+	if typ, ok := lit.Type.(*ast.ArrayType); !ok || typ.Len != nil || typ.Elt.(*ast.Ident).Name != "byte" {
+		t.Fatalf("the value is not a []byte")
+	}
+	var data []byte
+	for _, elt := range lit.Elts {
+		b, ok := elt.(*ast.BasicLit)
+		if !ok || b.Kind != token.INT {
+			t.Fatalf("the element %v is not an integer", elt)
+		}
+		v, err := strconv.ParseUint(b.Value, 0, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, byte(v))
+	}
+	if !strings.HasPrefix(string(source), "// Code generated by favicon; DO NOT EDIT.\n") {
+		t.Error("the generated code does not start with a Code generated comment")
+	}
+	if doc := decl.Doc.Text(); !strings.HasPrefix(doc, spec.Names[0].Name+" is ") {
+		t.Errorf("the variable has the comment %q", doc)
+	}
+	return f.Name.Name, spec.Names[0].Name, data
+}
+
+func TestEncodeGoSource(t *testing.T) {
+	var ico bytes.Buffer
+	if err := EncodeFavicon(&ico, modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{ico.Bytes(), {}, {0}, bytes.Repeat([]byte{0xff}, goBytesPerLine)} {
+		var buf bytes.Buffer
+		if err := EncodeGoSource(&buf, "assets", "Favicon", "a 16x16 .ico image", data); err != nil {
+			t.Fatal(err)
+		}
+		pkg, name, got := parseGoSource(t, buf.Bytes())
+		if pkg != "assets" || name != "Favicon" {
+			t.Errorf("got package %s and variable %s, but wanted assets and Favicon", pkg, name)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("got %d bytes that differ from the %d bytes that were encoded", len(got), len(data))
+		}
+	}
+}
+
+func TestEncodeGoSourceNames(t *testing.T) {
+	for _, names := range [][2]string{{"", "Favicon"}, {"assets", ""}, {"2d", "Favicon"}, {"assets", "fav-icon"}, {"main", "ico.data"}} {
+		if err := EncodeGoSource(&bytes.Buffer{}, names[0], names[1], "an image", []byte{1}); err == nil {
+			t.Errorf("accepted the package name %q and the variable name %q", names[0], names[1])
+		}
+	}
+	if err := EncodeGoSource(&bytes.Buffer{}, "_assets", "favicon2", "an image", []byte{1}); err != nil {
+		t.Error(err)
+	}
+}
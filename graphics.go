@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// graphicsProtocol is a way of showing bitmaps in a terminal
+type graphicsProtocol int
+
+const (
+	graphicsNone graphicsProtocol = iota
+	graphicsKitty
+	graphicsSixel
+)
+
+// sixelSize is the approximate width and height of the sixel preview, in pixels
+const sixelSize = 128
+
+// detectGraphics tries to find a graphics protocol that the terminal supports, by looking at environment variables.
+// $FAVICON_GRAPHICS can be set to "kitty", "sixel" or "none" to override the detection.
+func detectGraphics() graphicsProtocol {
+	switch strings.ToLower(os.Getenv("FAVICON_GRAPHICS")) {
+	case "kitty":
+		return graphicsKitty
+	case "sixel":
+		return graphicsSixel
+	case "none":
+		return graphicsNone
+	}
+	term := os.Getenv("TERM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", strings.Contains(term, "kitty"), os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return graphicsKitty
+	case strings.HasPrefix(term, "foot"), strings.HasPrefix(term, "mlterm"), strings.HasPrefix(term, "yaft"), strings.Contains(term, "sixel"):
+		return graphicsSixel
+	}
+	return graphicsNone
+}
+
+// kittyImage returns the escape sequences for showing a PNG image with the kitty graphics protocol,
+// scaled to the given number of columns and rows, without moving the cursor
+func kittyImage(pngData []byte, cols, rows int) string {
+	var sb strings.Builder
+	encoded := base64.StdEncoding.EncodeToString(pngData)
+	// The data is sent in chunks of at most 4096 bytes
+	for i := 0; i < len(encoded); i += 4096 {
+		end := i + 4096
+		more := 1
+		if end >= len(encoded) {
+			end = len(encoded)
+			more = 0
+		}
+		if i == 0 {
+			sb.WriteString(fmt.Sprintf("\x1b_Ga=T,f=100,q=2,C=1,c=%d,r=%d,m=%d;%s\x1b\\", cols, rows, more, encoded[i:end]))
+		} else {
+			sb.WriteString(fmt.Sprintf("\x1b_Gm=%d;%s\x1b\\", more, encoded[i:end]))
+		}
+	}
+	return sb.String()
+}
+
+// kittyDelete returns the escape sequence for removing all images that are shown with the kitty graphics protocol
+func kittyDelete() string {
+	return "\x1b_Ga=d,q=2\x1b\\"
+}
+
+// sixelImage returns the escape sequences for showing an image as sixels, where each pixel is scaled up
+// to scale x scale sixel pixels. Transparent pixels are not drawn.
+func sixelImage(m image.Image, scale int) string {
+	var (
+		bounds  = m.Bounds()
+		width   = bounds.Dx() * scale
+		height  = bounds.Dy() * scale
+		palette = make(map[color.NRGBA]int)
+		colors  []color.NRGBA
+		indices = make([]int, width*height) // -1 is transparent
+		sb      strings.Builder
+	)
+
+	// Find the palette index of each sixel pixel, using the 6x6x6 color cube if there are too many colors
+	quantize := false
+	for pass := 0; pass < 2; pass++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				c := color.NRGBAModel.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+				index := -1
+				if c.A != 0 {
+					c.A = 0xff
+					if quantize {
+						c.R, c.G, c.B = c.R/51*51, c.G/51*51, c.B/51*51
+					}
+					i, ok := palette[c]
+					if !ok {
+						i = len(colors)
+						palette[c] = i
+						colors = append(colors, c)
+					}
+					index = i
+				}
+				for sy := 0; sy < scale; sy++ {
+					for sx := 0; sx < scale; sx++ {
+						indices[(y*scale+sy)*width+x*scale+sx] = index
+					}
+				}
+			}
+		}
+		if len(colors) <= 256 {
+			break
+		}
+		quantize = true
+		palette = make(map[color.NRGBA]int)
+		colors = colors[:0]
+	}
+
+	// Start the sixel image, where pixels that are not drawn are left transparent
+	sb.WriteString("\x1bP0;1q")
+	sb.WriteString(fmt.Sprintf("\"1;1;%d;%d", width, height))
+	for i, c := range colors {
+		sb.WriteString(fmt.Sprintf("#%d;2;%d;%d;%d", i, int(c.R)*100/255, int(c.G)*100/255, int(c.B)*100/255))
+	}
+
+	// Each band is 6 pixels high
+	for band := 0; band < height; band += 6 {
+		for i := range colors {
+			var (
+				line  bytes.Buffer
+				used  bool
+				prev  byte
+				count int
+			)
+			flush := func() {
+				if count > 3 {
+					line.WriteString(fmt.Sprintf("!%d%c", count, prev))
+				} else {
+					for j := 0; j < count; j++ {
+						line.WriteByte(prev)
+					}
+				}
+			}
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if indices[(band+dy)*width+x] == i {
+						bits |= 1 << uint(dy)
+						used = true
+					}
+				}
+				ch := 63 + bits
+				if count > 0 && ch == prev {
+					count++
+					continue
+				}
+				flush()
+				prev, count = ch, 1
+			}
+			flush()
+			if used {
+				sb.WriteString(fmt.Sprintf("#%d", i))
+				sb.Write(line.Bytes())
+				sb.WriteString("$")
+			}
+		}
+		sb.WriteString("-")
+	}
+	sb.WriteString("\x1b\\")
+	return sb.String()
+}
+
+// ShowGraphics shows the image as a bitmap in the top right corner of the terminal,
+// by using the kitty graphics protocol or sixels. The canvas is not changed, so pressing
+// esc for a full redraw removes the bitmap again.
+func (e *Editor) ShowGraphics(c *vt100.Canvas) error {
+	protocol := detectGraphics()
+	if protocol == graphicsNone {
+		return errors.New("this terminal does not seem to support kitty graphics or sixels (FAVICON_GRAPHICS can be set to kitty or sixel)")
+	}
+	m, err := textToImage(e.mode, image.Pt(e.width, e.height), e.String())
+	if err != nil {
+		return err
+	}
+
+	var (
+		sb   strings.Builder
+		rows = int(c.H()) / 2
+	)
+	if rows > 16 {
+		rows = 16
+	}
+	cols := rows * 2 // terminal cells are usually twice as high as they are wide
+
+	// Save the cursor position and move to the top right corner
+	sb.WriteString("\x1b7")
+	sb.WriteString(fmt.Sprintf("\x1b[1;%dH", int(c.W())-cols+1))
+	switch protocol {
+	case graphicsKitty:
+		var buf bytes.Buffer
+		if err := encoder.EncodePNG(&buf, m); err != nil {
+			return err
+		}
+		sb.WriteString(kittyDelete())
+		sb.WriteString(kittyImage(buf.Bytes(), cols, rows))
+	case graphicsSixel:
+		scale := sixelSize / e.width
+		if scale < 1 {
+			scale = 1
+		}
+		sb.WriteString(sixelImage(m, scale))
+	}
+	// Restore the cursor position
+	sb.WriteString("\x1b8")
+	fmt.Print(sb.String())
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/xyproto/vt100"
+)
+
+// gutterColor is the color of the coordinates in the gutter
+var gutterColor = vt100.DarkGray
+
+// gutterSize returns the number of columns to the left of the image and the number of rows above the image
+// that are used for the coordinates, when the image is zoomed in by the given factor.
+// Returns 0, 0 if the gutter is not shown.
+func (e *Editor) gutterSize(zoom int) (int, int) {
+	if !e.drawMode || !e.gutter || e.width == 0 || e.height == 0 {
+		return 0, 0
+	}
+	left := len(strconv.Itoa(e.height-1)) + 1
+	// Use one row per digit if the numbers are wider than the pixels
+	top := 1
+	if digits := len(strconv.Itoa(e.width - 1)); cellWidth(e.mode)*zoom <= digits {
+		top = digits
+	}
+	return left, top
+}
+
+// SetGutter shows or hides the pixel coordinates to the left of and above the image
+func (e *Editor) SetGutter(gutter bool) {
+	e.gutter = gutter
+	e.pos.SetMargins(e.gutterSize(e.pos.Zoom()))
+	e.KeepCursorInImage()
+}
+
+// KeepCursorInImage moves the cursor to the closest pixel within the image area, if the cursor is confined to
+// the pixels or if the gutter is shown
+func (e *Editor) KeepCursorInImage() {
+	if !e.drawMode || (!e.gutter && e.freeCursor) || e.width == 0 || e.height == 0 {
+		return
+	}
+	if lastX := e.width*cellWidth(e.mode) - 1; e.pos.sx > lastX {
+		e.pos.sx = lastX
+	}
+	if e.pos.sy >= e.height {
+		e.pos.sy = e.height - 1
+	}
+	// The space after the rune of each pixel is not a pixel of its own
+	if e.CursorConfined() && cellWidth(e.mode) == 2 {
+		e.pos.sx -= e.pos.sx % 2
+	}
+}
+
+// drawGutter draws the x coordinates above the image and the y coordinates to the left of the image,
+// for numlines screen lines starting at cx, cy, where the first line of the image is fromline.
+// If the image has been panned, or is larger than the canvas, the sides that are out of view are
+// marked with <, >, ^ and v.
+func (e *Editor) drawGutter(c *vt100.Canvas, fromline, numlines, cx, cy int) {
+	var (
+		w         = int(c.W())
+		zoom      = e.pos.Zoom()
+		cw        = cellWidth(e.mode) * zoom
+		panX      = e.pos.panX * zoom
+		left, top = e.gutterSize(zoom)
+		digits    = len(strconv.Itoa(e.width - 1))
+	)
+	// The x coordinates, with one row per digit if the numbers are wider than the pixels
+	for row := 0; row < top && row < numlines; row++ {
+		for x := cx; x < w; x++ {
+			e.writeRune(c, uint(x), uint(cy+row), gutterColor, e.bg, ' ')
+		}
+		for x := panX / cw; x < e.width; x++ {
+			label := strconv.Itoa(x)
+			if top > 1 {
+				label = string(fmt.Sprintf("%*d", digits, x)[row])
+			}
+			e.writeString(c, uint(cx+left+x*cw-panX), uint(cy+row), gutterColor, e.bg, label)
+		}
+	}
+	// The y coordinates, on the first screen line of each row of pixels
+	for y := 0; y < numlines-top; y++ {
+		label := ""
+		if dataY := fromline + y/zoom; y%zoom == 0 && dataY < e.height {
+			label = strconv.Itoa(dataY)
+		}
+		e.writeString(c, uint(cx), uint(cy+top+y), gutterColor, e.bg, fmt.Sprintf("%*s ", left-1, label))
+	}
+	// Mark the sides of the image that are out of view
+	clippedLeft, clippedRight, clippedAbove, clippedBelow := e.Clipped(c)
+	if clippedLeft {
+		e.writeRune(c, uint(cx), uint(cy), gutterColor, e.bg, '<')
+	}
+	if clippedRight {
+		e.writeRune(c, uint(w-1), uint(cy), gutterColor, e.bg, '>')
+	}
+	if clippedAbove && top < numlines {
+		e.writeRune(c, uint(cx+left-1), uint(cy+top), gutterColor, e.bg, '^')
+	}
+	if _, rows := e.ViewSize(c); clippedBelow && top+rows*zoom <= numlines {
+		e.writeRune(c, uint(cx+left-1), uint(cy+top+rows*zoom-1), gutterColor, e.bg, 'v')
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xyproto/vt100"
+)
+
+// postSaveTimeout is how long the post-save command may run before it is stopped
+const postSaveTimeout = 30 * time.Second
+
+// shellQuote quotes the given string for sh, so that filenames with spaces and quotes are passed as they are
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// postSaveCommand returns the post-save command with {} replaced by the quoted filename.
+// If there is no {}, the quoted filename is added at the end.
+func postSaveCommand(command, filename string) string {
+	if !strings.Contains(command, "{}") {
+		return command + " " + shellQuote(filename)
+	}
+	return strings.Replace(command, "{}", shellQuote(filename), -1)
+}
+
+// RunPostSave runs the command that is given with -post-save or FAVICON_POST_SAVE on the given file, which has
+// just been saved. The output is captured instead of being written to the terminal. Returns the exit status and
+// the first line of the output, like "exit status 0: ** Processing: favicon.png", and false if the command failed
+// or timed out. Returns an empty string and true if there is no post-save command.
+func (e *Editor) RunPostSave(filename string) (string, bool) {
+	if e.postSave == "" {
+		return "", true
+	}
+	var (
+		output bytes.Buffer
+		cmd    = exec.Command("sh", "-c", postSaveCommand(e.postSave, filename))
+	)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Run the command in a process group of its own, so that it can be stopped together with what it starts
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err.Error(), false
+	}
+	timer := time.AfterFunc(postSaveTimeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	err := cmd.Wait()
+	// The timer has already fired if it can not be stopped
+	timedOut := !timer.Stop()
+	result := "exit status 0"
+	switch {
+	case timedOut:
+		result = "stopped after " + postSaveTimeout.String()
+	case err != nil:
+		result = err.Error()
+	}
+	if line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(output.String()), "\n", 2)[0]); line != "" {
+		result += ": " + line
+	}
+	return result, err == nil && !timedOut
+}
+
+// ShowSaved shows the given message from SavedMessage in the status bar, after the given file has been saved,
+// and runs the post-save command on the file, if there is one. How the command went is added to the message,
+// which is shown as an error if the command failed. The file counts as saved either way.
+func (e *Editor) ShowSaved(c *vt100.Canvas, status *StatusBar, message, filename string) {
+	status.ClearAll(c)
+	if e.postSave == "" {
+		status.SetMessage(message)
+		status.Show(c, e)
+		return
+	}
+	status.SetMessage(message + " (running the post-save command)")
+	status.ShowNoTimeout(c, e)
+	result, ok := e.RunPostSave(filename)
+	status.ClearAll(c)
+	if ok {
+		status.SetMessage(message + " (post-save: " + result + ")")
+	} else {
+		status.SetErrorMessage(message + " (post-save: " + result + ")")
+	}
+	status.Show(c, e)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// webmanifestFilename is the filename of the web app manifest, which is written next to the image
+	webmanifestFilename = "site.webmanifest"
+
+	// appleTouchIconSize is the size of the .png image that is used as the icon on iOS home screens
+	appleTouchIconSize = 180
+)
+
+// pngFile is a .png image next to the image that is being edited, with its width and height in pixels
+type pngFile struct {
+	filename string
+	size     int
+}
+
+// existingICO returns the .ico image for the given file, which is either the file itself
+// or the .ico image that was exported with ctrl-space. Returns "" if there is no such file.
+func existingICO(filename string) string {
+	if detectFormat(filename) == formatICO {
+		return filename
+	}
+	if icoFilename := siblingFilename(filename, ".ico"); exists(icoFilename) && detectFormat(icoFilename) == formatICO {
+		return icoFilename
+	}
+	return ""
+}
+
+// existingPNGs returns the .png images for the given file: the file itself, if it is a .png image,
+// and the images that were exported with P, for the given sizes. Only files that exist are returned,
+// and the exported image with the same size as the file itself is left out.
+func existingPNGs(filename string, sizes []int) []pngFile {
+	var pngs []pngFile
+	if detectFormat(filename) == formatPNG {
+		if f, err := os.Open(filename); err == nil {
+			config, err := png.DecodeConfig(f)
+			f.Close()
+			if err == nil && config.Width == config.Height {
+				pngs = append(pngs, pngFile{filename, config.Width})
+			}
+		}
+	}
+	for _, size := range sizes {
+		// Only link to one image per size
+		if len(pngs) > 0 && pngs[0].size == size {
+			continue
+		}
+		if pngFilename := pngSizeFilename(filename, size); exists(pngFilename) {
+			pngs = append(pngs, pngFile{pngFilename, size})
+		}
+	}
+	return pngs
+}
+
+// FaviconHTML returns the HTML link tags for the .ico and .png images that exist for the given file,
+// see existingICO and existingPNGs. If manifest is true, a link to the web app manifest is included.
+func FaviconHTML(filename string, sizes []int, manifest bool) (string, error) {
+	var sb strings.Builder
+	if icoFilename := existingICO(filename); icoFilename != "" {
+		fmt.Fprintf(&sb, "<link rel=\"icon\" href=\"%s\" sizes=\"any\">\n", filepath.Base(icoFilename))
+	}
+	for _, p := range existingPNGs(filename, sizes) {
+		if p.size == appleTouchIconSize {
+			fmt.Fprintf(&sb, "<link rel=\"apple-touch-icon\" sizes=\"%dx%d\" href=\"%s\">\n", p.size, p.size, filepath.Base(p.filename))
+			continue
+		}
+		fmt.Fprintf(&sb, "<link rel=\"icon\" type=\"image/png\" sizes=\"%dx%d\" href=\"%s\">\n", p.size, p.size, filepath.Base(p.filename))
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("found no .ico or .png images for %s, save or export them first", filepath.Base(filename))
+	}
+	if manifest {
+		fmt.Fprintf(&sb, "<link rel=\"manifest\" href=\"%s\">\n", webmanifestFilename)
+	}
+	return sb.String(), nil
+}
+
+// webmanifestIcon is an icon in a web app manifest
+type webmanifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// WriteWebmanifest writes a minimal web app manifest next to the given file, with the .png images
+// that exist for it, see existingPNGs. Returns the filename that was written.
+func WriteWebmanifest(filename string, sizes []int) (string, error) {
+	var pngs []pngFile
+	for _, p := range existingPNGs(filename, sizes) {
+		pngs = append(pngs, pngFile{filepath.Base(p.filename), p.size})
+	}
+	data, err := encodeWebmanifest(pngs)
+	if err != nil {
+		return "", err
+	}
+	manifestFilename := filepath.Join(filepath.Dir(filename), webmanifestFilename)
+	return manifestFilename, ioutil.WriteFile(manifestFilename, data, 0664)
+}
+
+// encodeWebmanifest returns a minimal web app manifest, as JSON, with the given .png images as the icons
+func encodeWebmanifest(pngs []pngFile) ([]byte, error) {
+	var manifest struct {
+		Icons []webmanifestIcon `json:"icons"`
+	}
+	manifest.Icons = []webmanifestIcon{}
+	for _, p := range pngs {
+		manifest.Icons = append(manifest.Icons, webmanifestIcon{p.filename, fmt.Sprintf("%dx%d", p.size, p.size), "image/png"})
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// htmlForFile returns the HTML link tags for the given file, see FaviconHTML, for the comma separated sizes
+// of the exported .png images. If manifest is true, the web app manifest is written as well.
+func htmlForFile(filename, sizes string, manifest bool) (string, error) {
+	pngSizes, err := parseSizes(sizes)
+	if err != nil {
+		return "", err
+	}
+	html, err := FaviconHTML(filename, pngSizes, manifest)
+	if err != nil {
+		return "", err
+	}
+	if manifest {
+		if _, err := WriteWebmanifest(filename, pngSizes); err != nil {
+			return "", err
+		}
+	}
+	return html, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"path/filepath"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// icnsExt is the filename extension of macOS icon files, which can be exported to, but not edited
+const icnsExt = ".icns"
+
+// icnsType is the type of an element in an .icns file, with the width and height of its .png image
+type icnsType struct {
+	name string
+	size int
+}
+
+// icnsTypes are the elements that are written to .icns files, all with .png images.
+// Several types have the same size, since the @2x types are for high resolution screens.
+var icnsTypes = []icnsType{
+	{"ic04", 16},
+	{"ic05", 32},
+	{"ic07", 128},
+	{"ic08", 256},
+	{"ic09", 512},
+	{"ic10", 1024}, // 512x512@2x
+	{"ic11", 32},   // 16x16@2x
+	{"ic12", 64},   // 32x32@2x
+	{"ic13", 256},  // 128x128@2x
+	{"ic14", 512},  // 256x256@2x
+}
+
+// isICNS checks if the filename has the .icns extension
+func isICNS(filename string) bool {
+	return filepath.Ext(filename) == icnsExt
+}
+
+// writeICNSElement writes the type and the length of an element, including the 8 byte header, followed by the data
+func writeICNSElement(buf *bytes.Buffer, name string, data []byte) {
+	buf.WriteString(name)
+	binary.Write(buf, binary.BigEndian, uint32(8+len(data)))
+	buf.Write(data)
+}
+
+// EncodeICNSImage writes the image as an .icns file with one .png element per type in icnsTypes,
+// scaled with nearest neighbor scaling, and a table of contents at the start.
+func EncodeICNSImage(w io.Writer, m image.Image) error {
+	// Encode each size once, since several types have the same size
+	pngData := make(map[int][]byte)
+	for _, t := range icnsTypes {
+		if _, ok := pngData[t.size]; ok {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := encoder.EncodePNG(&buf, img.ScaleNearest(m, t.size)); err != nil {
+			return err
+		}
+		pngData[t.size] = buf.Bytes()
+	}
+
+	// The table of contents lists the type and the length of each element that follows it
+	var toc bytes.Buffer
+	for _, t := range icnsTypes {
+		toc.WriteString(t.name)
+		binary.Write(&toc, binary.BigEndian, uint32(8+len(pngData[t.size])))
+	}
+	var elements bytes.Buffer
+	writeICNSElement(&elements, "TOC ", toc.Bytes())
+	for _, t := range icnsTypes {
+		writeICNSElement(&elements, t.name, pngData[t.size])
+	}
+
+	// The header is the magic bytes and the length of the file, including the header
+	var buf bytes.Buffer
+	writeICNSElement(&buf, "icns", elements.Bytes())
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// EncodeICNS converts the textual representation to an image and writes it as an .icns file, see EncodeICNSImage
+func EncodeICNS(w io.Writer, mode Mode, size image.Point, text string) error {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	return EncodeICNSImage(w, m)
+}
+
+// WriteICNS converts the textual representation to an image and saves it as an .icns file, see EncodeICNSImage
+func WriteICNS(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeICNS(w, mode, size, text)
+	})
+}
+
+// ExportICNS saves the image as an .icns file, without changing which file is being edited.
+// Images with invalid pixels are not exported.
+func (e *Editor) ExportICNS(filename string) error {
+	if err := e.ValidatePixels(); err != nil {
+		return err
+	}
+	e.makeBackup(filename)
+	return WriteICNS(e.mode, image.Pt(e.width, e.height), e.String(), filename)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// icnsElement is an element of an .icns file, read by parseICNS
+type icnsElement struct {
+	name string
+	data []byte
+}
+
+// parseICNS reads the elements of an .icns file, and checks the magic bytes and the lengths
+func parseICNS(t *testing.T, data []byte) []icnsElement {
+	t.Helper()
+	if len(data) < 8 || string(data[:4]) != "icns" {
+		t.Fatal("the icns magic bytes are missing")
+	}
+	if length := binary.BigEndian.Uint32(data[4:8]); int(length) != len(data) {
+		t.Fatalf("the header says that the file is %d bytes, but it is %d", length, len(data))
+	}
+	var elements []icnsElement
+	for rest := data[8:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			t.Fatalf("%d bytes are left, which is too short for an element", len(rest))
+		}
+		length := int(binary.BigEndian.Uint32(rest[4:8]))
+		if length < 8 || length > len(rest) {
+			t.Fatalf("the %q element is %d bytes, but %d are left", rest[:4], length, len(rest))
+		}
+		elements = append(elements, icnsElement{string(rest[:4]), rest[8:length]})
+		rest = rest[length:]
+	}
+	return elements
+}
+
+func TestEncodeICNSImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeICNSImage(&buf, testImage(16)); err != nil {
+		t.Fatal(err)
+	}
+	elements := parseICNS(t, buf.Bytes())
+
+	// The table of contents is first, followed by the elements in the order it lists them
+	wantNames := []string{"TOC ", "ic04", "ic05", "ic07", "ic08", "ic09", "ic10", "ic11", "ic12", "ic13", "ic14"}
+	wantSizes := map[string]int{"ic04": 16, "ic05": 32, "ic07": 128, "ic08": 256, "ic09": 512, "ic10": 1024, "ic11": 32, "ic12": 64, "ic13": 256, "ic14": 512}
+	if len(elements) != len(wantNames) {
+		t.Fatalf("there are %d elements, but wanted %d", len(elements), len(wantNames))
+	}
+	toc := elements[0].data
+	if len(toc) != 8*(len(wantNames)-1) {
+		t.Fatalf("the table of contents is %d bytes, but wanted %d", len(toc), 8*(len(wantNames)-1))
+	}
+	length := 8 + 8 + len(toc)
+	for i, element := range elements {
+		if element.name != wantNames[i] {
+			t.Errorf("element %d is %q, but wanted %q", i, element.name, wantNames[i])
+		}
+		if i == 0 {
+			continue
+		}
+		length += 8 + len(element.data)
+		entry := toc[8*(i-1) : 8*i]
+		if string(entry[:4]) != element.name || int(binary.BigEndian.Uint32(entry[4:])) != 8+len(element.data) {
+			t.Errorf("the table of contents lists %q with %d bytes, but element %d is %q with %d bytes", entry[:4], binary.BigEndian.Uint32(entry[4:]), i, element.name, 8+len(element.data))
+		}
+		config, err := png.DecodeConfig(bytes.NewReader(element.data))
+		if err != nil {
+			t.Errorf("the %s element is not a .png image: %v", element.name, err)
+			continue
+		}
+		if size := wantSizes[element.name]; config.Width != size || config.Height != size {
+			t.Errorf("the %s element is %dx%d, but wanted %dx%d", element.name, config.Width, config.Height, size, size)
+		}
+	}
+	if length != buf.Len() {
+		t.Errorf("the elements add up to %d bytes, but the file is %d", length, buf.Len())
+	}
+
+	// Elements with the same size have the same .png image
+	same := map[string]string{"ic05": "ic11", "ic08": "ic13", "ic09": "ic14"}
+	data := make(map[string][]byte)
+	for _, element := range elements {
+		data[element.name] = element.data
+	}
+	for a, b := range same {
+		if !bytes.Equal(data[a], data[b]) {
+			t.Errorf("the %s and %s elements have different .png images", a, b)
+		}
+	}
+
+	// The largest element has the pixels of the image, scaled up
+	m, err := png.Decode(bytes.NewReader(data["ic10"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.NRGBAModel.Convert(m.At(1023, 1023)), testImage(16).At(15, 15); got != want {
+		t.Errorf("the lower right pixel is %v, but wanted %v", got, want)
+	}
+	if _, _, _, a := m.At(0, 0).RGBA(); a != 0 {
+		t.Error("the upper left pixel is not transparent")
+	}
+}
@@ -1,327 +1,733 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
+	"image/jpeg"
 	"image/png"
 	"io"
-	"math"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 
-	ico "github.com/biessek/golang-ico"
+	"github.com/jsummers/gobmp"
+	"github.com/xyproto/favicon/img"
 )
 
-var (
-	// 4-bit, 16-color grayscale grading by runes
-	// This map has room for improvement.
-	// I wanted it to
-	// - Not contain regular letters, to avoid confusion when someone typed in the lowercase/uppercase version of it
-	// - Make it easy to type the 0 and 15 value on most keyboard layouts
-	// - Not contain '?' or '#'
-	// - Have visible 0 values (not ' ')
-	// _,.'-~+:*<=!%{$@
-	lookupRunes = map[rune]byte{
-		'_':  0,
-		',':  1,
-		'.':  2,
-		'\'': 3,
-		'-':  4,
-		'~':  5,
-		'+':  6,
-		':':  7,
-		'*':  8,
-		'<':  9,
-		'=':  10,
-		'!':  11,
-		'%':  12,
-		'{':  15,
-		'$':  13,
-		'@':  14,
+// blankSize is the width and height of new images, in pixels
+const blankSize = 16
+
+// toolKeys are the keys that are used for drawing tools, which can not be used as shades
+const toolKeys = "lrRiwskKx][pomyXvghntz#LPWGHSUJYMNOVQZju/?Iq;&"
+
+// SetRunes replaces the 16 runes that are used for the shades in 16 color grayscale mode,
+// from the darkest shade to the brightest one, for instance "_,.'-~+:*<=!%$@{", see img.SetRunes.
+// The keys that are used for drawing tools can not be used.
+func SetRunes(alphabet string) error {
+	for _, r := range alphabet {
+		if strings.ContainsRune(toolKeys, r) {
+			return fmt.Errorf("the shade runes %q can not contain %c, which is used for a drawing tool", alphabet, r)
+		}
 	}
-)
+	return img.SetRunes(alphabet)
+}
 
-// ReadFavicon will try to load an ICO or PNG image into a "\n" separated []byte slice.
-// Returns a Mode (representing: 16 color grayscale, rgb or rgba), the textual representation and an error.
-// If blank is true, the textual representation of a blank 16 color grayscale image will be returned.
-// May return a warning/message string as well.
-// If PNG is true, tries to read a PNG image instead
-func ReadFavicon(filename string, blank, PNG bool) (Mode, []byte, string, error) {
-	var (
-		mode    Mode = modeBlank
-		m       image.Image
-		bounds  image.Rectangle
-		buf     bytes.Buffer
-		message string
-	)
+// cellWidth returns the number of text columns that are used for each pixel, in the given mode
+func cellWidth(mode Mode) int {
+	switch mode {
+	case modeRGB:
+		return 7 // "|rrggbb"
+	case modeRGBA:
+		return 9 // "|rrggbbaa"
+	default:
+		return 2 // a rune and a space
+	}
+}
 
-	if blank {
-		// Create the textual representation of a blank image (16x16, all gray)
-		tm := image.NewNRGBA(image.Rect(0, 0, 16, 16))
-		bounds = tm.Bounds()
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				tm.Set(x, y, color.NRGBA{127, 127, 127, 255})
-			}
+// lineWidth returns the number of text columns that are used for a row of the given number of pixels
+func lineWidth(mode Mode, width int) int {
+	if mode == modeRGB || mode == modeRGBA {
+		// The final '|'
+		return width*cellWidth(mode) + 1
+	}
+	return width * cellWidth(mode)
+}
+
+// pixelText returns the textual representation of a single pixel, in the given mode
+func pixelText(mode Mode, c color.Color) string {
+	switch mode {
+	case modeRGB:
+		// 8+8+8 bit RGB
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if nc.A == 0 {
+			return "|      " // transparent
+		}
+		return fmt.Sprintf("|%02x%02x%02x", nc.R, nc.G, nc.B)
+	case modeRGBA:
+		// 8+8+8+8 bit RGBA
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if nc.A == 0 {
+			return "|        " // transparent
+		}
+		return fmt.Sprintf("|%02x%02x%02x%02x", nc.R, nc.G, nc.B, nc.A)
+	case modePalette:
+		// The rune of the closest color in the palette
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		index := img.NearestIndex(paletteColors, nc)
+		if nc.A == 0 || index == -1 {
+			return "T " // transparent
+		}
+		return string(lookupLetters()[byte(index)]) + " "
+	case modeMono:
+		// 1-bit black and white, depending on the threshold
+		shade, opaque := img.MonoShade(c)
+		if !opaque {
+			return "T " // transparent
+		}
+		return string(monoRune(shade)) + " "
+	default:
+		// 4-bit grayscale
+		luma16, opaque := img.GrayShade(c)
+		if !opaque {
+			return "T " // transparent
+		} else if luma16 == 0 {
+			return "  " // black
+		}
+		// a grayscale pixel, and a space to make the proportions look better
+		return string(lookupLetters()[luma16]) + " "
+	}
+}
+
+// parsePixel interprets the textual representation of a single pixel, in the given mode.
+// cell is the text that starts at the pixel position, and may be shorter than cellWidth(mode).
+func parsePixel(mode Mode, cell []rune) (color.NRGBA, error) {
+	switch mode {
+	case modeRGB:
+		s := strings.TrimRight(string(cell), " ")
+		if len(s) > 0 && s[0] == '|' {
+			s = s[1:]
+		}
+		if s == "" {
+			// A transparent pixel
+			return color.NRGBA{0, 0, 0, 0}, nil
 		}
-		m = tm
-	} else {
-		// Read the file
-		reader, err := os.Open(filename)
+		if len(s) != 6 {
+			return color.NRGBA{}, fmt.Errorf("%q is not a pixel on the form |rrggbb", string(cell))
+		}
+		v, err := strconv.ParseUint(s, 16, 32)
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("%q is not a pixel on the form |rrggbb", string(cell))
+		}
+		return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+	case modeRGBA:
+		s := strings.TrimRight(string(cell), " ")
+		if len(s) > 0 && s[0] == '|' {
+			s = s[1:]
+		}
+		if s == "" {
+			// A transparent pixel
+			return color.NRGBA{0, 0, 0, 0}, nil
+		}
+		if len(s) != 8 {
+			return color.NRGBA{}, fmt.Errorf("%q is not a pixel on the form |rrggbbaa", string(cell))
+		}
+		v, err := strconv.ParseUint(s, 16, 32)
 		if err != nil {
-			return mode, []byte{}, "", err
+			return color.NRGBA{}, fmt.Errorf("%q is not a pixel on the form |rrggbbaa", string(cell))
 		}
-		defer reader.Close()
+		return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+	case modePalette:
+		if len(cell) == 0 || cell[0] == 'T' {
+			// A transparent pixel
+			return color.NRGBA{0, 0, 0, 0}, nil
+		}
+		index, ok := img.RuneShade(cell[0])
+		if !ok || int(index) >= len(paletteColors) {
+			return color.NRGBA{}, fmt.Errorf("%q is not in the palette", string(cell[0]))
+		}
+		return paletteColors[index], nil
+	case modeMono:
+		switch {
+		case len(cell) == 0 || cell[0] == 'T':
+			// A transparent pixel
+			return color.NRGBA{0, 0, 0, 0}, nil
+		case cell[0] == monoBlack:
+			return img.MonoColors[0], nil
+		case cell[0] == monoWhite:
+			return img.MonoColors[1], nil
+		}
+		return color.NRGBA{}, fmt.Errorf("%q is neither black (%c) nor white (%c)", string(cell[0]), monoBlack, monoWhite)
+	default:
+		if len(cell) == 0 {
+			// A white transparent pixel
+			return color.NRGBA{0xff, 0xff, 0xff, 0}, nil
+		}
+		if cell[0] == 'T' { // transparent
+			// A black transparent pixel
+			return color.NRGBA{0, 0, 0, 0}, nil
+		}
+		shade, _ := img.RuneShade(cell[0])
+		return img.ShadeColor(shade), nil
+	}
+}
 
-		if PNG {
-			// Decode the image
-			pngImage, err := png.Decode(reader)
-			if err != nil {
-				return mode, []byte{}, "", err
-			}
-			m = pngImage
+// isShade checks if the given rune is a valid pixel in 16 color grayscale mode
+func isShade(r rune) bool {
+	_, ok := img.RuneShade(r)
+	return ok || r == 'T' || r == ' '
+}
+
+// lookupLetters returns a lookup table from the 16 grayscale shades to their runes, see img.ShadeRune
+func lookupLetters() map[byte]rune {
+	lookupLetters := make(map[byte]rune)
+	for shade := byte(0); shade < 16; shade++ {
+		lookupLetters[shade] = img.ShadeRune(shade)
+	}
+	return lookupLetters
+}
+
+// blankImage returns a new blankSize x blankSize image, where all pixels are gray
+func blankImage() image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, blankSize, blankSize))
+	for y := 0; y < blankSize; y++ {
+		for x := 0; x < blankSize; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{127, 127, 127, 255})
+		}
+	}
+	return m
+}
+
+// ReadImage reads an image and converts it to a textual representation.
+// Returns a Mode (representing: 16 color grayscale, rgb or rgba), the image size in pixels,
+// the textual representation, a warning/message string and an error.
+// The largest image is read from .ico and .cur files, see ReadFaviconEntry for reading another one.
+// If preferred is not modeBlank, that mode is used instead of detecting the mode from the image contents.
+// .bmp, .jpg, .pgm, .xbm and .ff images are converted to 16 color grayscale, or to black and white for .xbm
+// images, unless another mode is preferred.
+// If scale is not 0, the image is scaled to fit within a scale x scale image, unless it already has that size.
+func ReadImage(filename string, format Format, preferred Mode, scale int) (Mode, image.Point, []byte, string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return modeBlank, image.Point{}, []byte{}, "", err
+	}
+	defer f.Close()
+
+	var m image.Image
+	switch format {
+	case formatICO, formatCUR, formatPNG:
+		im, err := img.Decode(f)
+		if err != nil {
+			return modeBlank, image.Point{}, []byte{}, "", decodeError(filename, err)
+		}
+		m = im.Image
+	case formatBMP, formatJPEG, formatPGM, formatXBM, formatFarbfeld:
+		if m, err = decodeImage(f, format); err != nil {
+			return modeBlank, image.Point{}, []byte{}, "", decodeError(filename, err)
+		}
+		if preferred == modeBlank && format == formatXBM {
+			preferred = modeMono
+		} else if preferred == modeBlank {
+			preferred = modeGray4
+		}
+	default:
+		return modeBlank, image.Point{}, []byte{}, "", errors.New(filename + " is not an .ico, .cur, .png, .bmp, .jpg, .pgm, .xbm or .ff image")
+	}
+
+	m, scaleMessage := fitImage(m, scale)
+	mode, size, data, message, err := imageToText(m, filename, format != formatICO && format != formatCUR, preferred)
+	return mode, size, data, scaleMessage + message, err
+}
+
+// decodeImage decodes an image in the given format. For .ico and .cur images with several images, the largest one
+// is returned, like with img.Decode.
+func decodeImage(r io.Reader, format Format) (image.Image, error) {
+	switch format {
+	case formatICO, formatCUR, formatPNG:
+		m, err := img.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return m.Image, nil
+	case formatBMP:
+		return decodeChecked(r, gobmp.Decode, gobmp.DecodeConfig)
+	case formatJPEG:
+		return decodeChecked(r, jpeg.Decode, jpeg.DecodeConfig)
+	case formatPGM:
+		return DecodePGM(r)
+	case formatXBM:
+		return DecodeXBM(r)
+	case formatFarbfeld:
+		return DecodeFarbfeld(r)
+	}
+	return nil, errors.New("not an .ico, .cur, .png, .bmp, .jpg, .pgm, .xbm or .ff image")
+}
+
+// decodeChecked decodes an image with the given decoder, after checking the size that decodeConfig reads from
+// the header with img.CheckDecodeSize, so that a file that says that it has a huge image is not decoded
+func decodeChecked(r io.Reader, decode func(io.Reader) (image.Image, error), decodeConfig func(io.Reader) (image.Config, error)) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := img.CheckDecodeSize(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+	return decode(bytes.NewReader(data))
+}
+
+// decodeError returns an error that says which file could not be loaded, and why in plain words,
+// followed by the error from the decoder, like "can not load favicon.ico, the file is truncated (unexpected EOF)"
+func decodeError(filename string, err error) error {
+	cause := err
+	if entryErr, ok := err.(img.EntryError); ok {
+		cause = entryErr.Err
+	}
+	var explanation string
+	switch cause.(type) {
+	case *img.SizeError:
+		return fmt.Errorf("can not load %s, %s", filename, err)
+	case *img.TooLargeError:
+		return fmt.Errorf("can not load %s, %s, -max-load-size can be used for loading larger images", filename, err)
+	case png.FormatError, jpeg.FormatError, gobmp.FormatError:
+		explanation = "the file is damaged, or it is not an image in the format it seems to be"
+	case png.UnsupportedError, jpeg.UnsupportedError, gobmp.UnsupportedError:
+		explanation = "the image uses a feature that is not supported"
+	default:
+		if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+			explanation = "the file is truncated"
 		} else {
-			// Decode the image
-			icoImage, err := ico.Decode(reader)
-			if err != nil {
-				return mode, []byte{}, "", err
-			}
-			m = icoImage
+			explanation = "the image data is damaged"
 		}
 	}
+	return fmt.Errorf("can not load %s, %s (%s)", filename, explanation, err)
+}
+
+// ReadFaviconEntries reads all the entries of an .ico file, without decoding them
+func ReadFaviconEntries(filename string) ([]img.Entry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := img.ReadEntries(f)
+	if err != nil {
+		return nil, decodeError(filename, err)
+	}
+	return entries, nil
+}
+
+// ReadFaviconEntry is like ReadImage, but converts the given entry of an .ico file
+// (as returned by ReadFaviconEntries) to a textual representation.
+func ReadFaviconEntry(filename string, entries []img.Entry, index int, preferred Mode, scale int) (Mode, image.Point, []byte, string, error) {
+	if index < 0 || index >= len(entries) {
+		return modeBlank, image.Point{}, []byte{}, "", fmt.Errorf("%s has no entry number %d", filename, index+1)
+	}
+	m, err := entries[index].Decode()
+	if err != nil {
+		return modeBlank, image.Point{}, []byte{}, "", decodeError(filename, err)
+	}
+	m, scaleMessage := fitImage(m, scale)
+	mode, size, data, message, err := imageToText(m, filename, false, preferred)
+	return mode, size, data, scaleMessage + message, err
+}
+
+// imageToText converts an image to a textual representation.
+// Returns the Mode that was used, the image size in pixels, the textual representation,
+// a warning/message string and an error.
+// The filename is only used in error messages, and PNG is only used for deciding on the warning message.
+func imageToText(m image.Image, filename string, PNG bool, preferred Mode) (Mode, image.Point, []byte, string, error) {
+	var (
+		mode    Mode = modeBlank
+		message string
+	)
 
 	// Check the size of the image
-	// TODO: Consider lifting this restriction
-	if m.Bounds() != image.Rect(0, 0, 16, 16) {
-		return mode, []byte{}, "", errors.New("can not load " + filename + ", the size is not 16x16")
+	size := m.Bounds().Size()
+	if size.X != size.Y {
+		return mode, image.Point{}, []byte{}, "", fmt.Errorf("can not load %s, the size is %dx%d, but only square images are supported", filename, size.X, size.Y)
+	}
+	if size.X < 1 || size.X > img.MaxSize {
+		return mode, image.Point{}, []byte{}, "", fmt.Errorf("can not load %s, the size is %dx%d, but the maximum size is %dx%d", filename, size.X, size.Y, img.MaxSize, img.MaxSize)
 	}
 
-	lookupLetters := make(map[byte]rune)
-	for key, value := range lookupRunes {
-		lookupLetters[value] = key
+	// Decide which mode to use
+	mode = preferred
+	if mode == modeBlank {
+		mode = img.DetectMode(m)
 	}
 
-	if m.ColorModel() != color.GrayModel {
+	if mode == modeGray4 && m.ColorModel() != color.GrayModel {
 		// Warning message
 		if PNG {
 			message = " (will be saved as grayscale)"
 		} else {
 			message = " (will be saved as 16 color grayscale)"
 		}
+	} else if mode == modeMono && !img.IsMonochrome(m) {
+		message = fmt.Sprintf(" (will be saved as black and white, with the threshold %d)", img.MonoThreshold())
+	} else if mode == modeRGB && preferred == modeRGB && img.DetectMode(m) == modeRGBA {
+		message = " (will be saved without partial transparency)"
+	}
+
+	if mode == modePalette {
+		// Find the palette, leaving room for a transparent color in .png images
+		maxColors := img.MaxPaletteColors
+		if img.HasTransparency(m) {
+			maxColors--
+		}
+		var colorCount int
+		paletteColors, colorCount = img.MedianCut(m, maxColors)
+		if colorCount > len(paletteColors) {
+			message = fmt.Sprintf(" (reduced from %d to %d colors)", colorCount, len(paletteColors))
+		}
+		if img.DetectMode(m) == modeRGBA {
+			message += " (will be saved without partial transparency)"
+		}
 	}
 
-	var hasTransparentPixels bool
+	return mode, size, pixelsToText(m, mode), message, nil
+}
 
-	// Convert the image to a textual representation
-	bounds = m.Bounds()
+// pixelsToText converts the image to a textual representation in the given mode, with the legend below the pixels.
+// In indexed 16 color mode, the pixels get the closest colors in paletteColors.
+func pixelsToText(m image.Image, mode Mode) []byte {
+	var (
+		buf                  bytes.Buffer
+		hasTransparentPixels bool
+		bounds               = m.Bounds()
+	)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			r, g, b, a := m.At(x, y).RGBA()
-			// Found a luma formula here: https://riptutorial.com/go/example/31693/convert-color-image-to-grayscale
-			luma := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) * (255.0 / 65535)
-
-			// luma16 is 0..15
-			luma16 := int(math.Round(luma) / 16.0)
-			if luma16 > 15 {
-				luma16 = 15
-			}
-
-			mode = modeGray4 // 4-bit grayscale, 16 different color values
-
-			if mode == modeGray4 {
-				// 4-bit grayscale
-				if a == 0 {
-					buf.WriteString("T ") // transparent
-					hasTransparentPixels = true
-				} else if luma16 == 0 {
-					buf.WriteString("  ") // black
-				} else {
-					// a grayscale pixel
-					buf.WriteRune(lookupLetters[byte(luma16)])
-					buf.Write([]byte{' '}) // Add a space, to make the proportions look better
-				}
-			} else if mode == modeRGB {
-				// 8+8+8 bit RGB
-				if r+g+b+a == 0 {
-					buf.WriteString("|      ")
-				} else {
-					buf.WriteString(strings.Replace(fmt.Sprintf("|%2x%2x%2x", r/256, g/256, b/256), " ", "0", -1))
-				}
-			} else if mode == modeRGBA {
-				// 8+8+8+8 bit RGBA
-				if r+g+b+a == 0 {
-					buf.WriteString("|        ")
-				} else {
-					buf.WriteString(strings.Replace(fmt.Sprintf("|%2x%2x%2x%2x", r/256, g/256, b/256, a/256), " ", "0", -1))
-				}
+			c := m.At(x, y)
+			if _, _, _, a := c.RGBA(); a == 0 {
+				hasTransparentPixels = true
 			}
+			buf.WriteString(pixelText(mode, c))
 		}
-		if mode != modeGray4 {
-			buf.Write([]byte{'|', '\n'})
+		if mode == modeRGB || mode == modeRGBA {
+			buf.WriteByte('|')
 		}
-		// The blank lines are for the proportions to look right
 		buf.WriteString("\n")
-		if mode == modeRGB {
-			buf.WriteString("\n\n")
-		}
-		if mode == modeRGBA {
-			buf.WriteString("\n")
-		}
+	}
+	if mode == modePalette {
+		buf.WriteString(paletteLegend(hasTransparentPixels))
+	}
+	if mode == modeMono {
+		buf.WriteString(monoLegend(hasTransparentPixels))
 	}
 	if mode == modeGray4 {
 		// Legend
+		lookupLetters := lookupLetters()
 		buf.WriteString("\n")
 		for i := byte(0); i < byte(16); i++ {
 			buf.WriteString(fmt.Sprintf("%2d = %c\n", i, lookupLetters[i]))
 		}
 		if hasTransparentPixels {
-			buf.WriteString(" T = transparent, will be saved as black\n")
+			buf.WriteString(" T = transparent\n")
 		}
 	}
-	return mode, buf.Bytes(), message, nil
+	return buf.Bytes()
 }
 
-// WriteFavicon converts the textual representation to an .ico image
-// If asOther is true, .png images are written as .ico and the other way around
-func WriteFavicon(mode Mode, text, filename string, asOther bool) error {
-	if mode != modeGray4 {
-		return errors.New("saving .ico files is only implemented for 4-bit grayscale images")
-	}
-
+// textToImage converts the textual representation of an image to an image.NRGBA
+func textToImage(mode Mode, size image.Point, text string) (*image.NRGBA, error) {
 	var (
 		// Create a new image
-		width  = 16
-		height = 16
-		m      = image.NewRGBA(image.Rect(0, 0, width, height))
+		width  = size.X
+		height = size.Y
+		m      = image.NewNRGBA(image.Rect(0, 0, width, height))
 
 		// These are used in the loops below
-		x, y      int
-		line      string
-		intensity byte
-		r         rune
-		runes     []rune
+		x, y  int
+		line  string
+		cw    = cellWidth(mode)
+		runes []rune
+		cell  []rune
 	)
 
+	// Read the palette from the legend, which may have been edited
+	lines := strings.Split(text, "\n")
+	if mode == modePalette && len(lines) > height {
+		palette, err := parsePaletteLegend(lines[height:])
+		if err != nil {
+			return nil, err
+		}
+		paletteColors = palette
+	}
+
 	// Draw the pixels
-	for y, line = range strings.Split(text, "\n") {
-		if y >= 16 { // max 16x16 pixels
-			break
+	for y = 0; y < height; y++ {
+		line = ""
+		if y < len(lines) {
+			line = lines[y]
 		}
 		runes = []rune(line)
-		for x = 0; x < 16; x++ { // max 16x16 pixels
-			if (x * 2) < len(runes) {
-				r = runes[x*2]
-				if r == 'T' { // transparent
-					// Draw a black transparent pixel
-					m.Set(x, y, color.RGBA{0, 0, 0, 0})
-				} else {
-					intensity = lookupRunes[r]*16 + 15 // from 0..15 to 15..255
-					// Draw pixel to image
-					m.Set(x, y, color.RGBA{intensity, intensity, intensity, 0xff})
+		for x = 0; x < width; x++ {
+			cell = []rune{}
+			if (x * cw) < len(runes) {
+				cell = runes[x*cw:]
+				if len(cell) > cw {
+					cell = cell[:cw]
 				}
-			} else {
-				// Draw a white transparent pixel
-				m.Set(x, y, color.RGBA{0xff, 0xff, 0xff, 0})
 			}
+			c, err := parsePixel(mode, cell)
+			if err != nil {
+				return nil, fmt.Errorf("pixel %d,%d: %s", x, y, err)
+			}
+			m.SetNRGBA(x, y, c)
 		}
 	}
+	return m, nil
+}
 
-	if asOther && strings.HasSuffix(filename, ".ico") {
-		filename = strings.Replace(filename, ".ico", ".png", 1)
-		// Create a new file
-		f, err := os.Create(filename)
-		if err != nil {
-			return err
-		}
-		// Encode the image as a .png image
-		return png.Encode(f, m)
-	} else if !asOther && strings.HasSuffix(filename, ".png") {
-		// Create a new file
-		f, err := os.Create(filename)
-		if err != nil {
-			return err
-		}
-		return png.Encode(f, m)
-	} else if asOther && strings.HasSuffix(filename, ".png") {
-		filename = strings.Replace(filename, ".png", ".ico", 1)
+// EncodeFavicon converts the textual representation to an image and writes it
+// to the given io.Writer, as a .png image if PNG is true or as an .ico image if not.
+func EncodeFavicon(w io.Writer, mode Mode, size image.Point, text string, PNG bool) error {
+	if !mode.CanSave() {
+		return img.ErrCanNotSave
+	}
+	if size.X < 1 || size.Y < 1 || size.X > img.MaxSize || size.Y > img.MaxSize {
+		return fmt.Errorf("can not save a %dx%d image, the maximum size is %dx%d", size.X, size.Y, img.MaxSize, img.MaxSize)
 	}
 
-	// Create a new file
-	f, err := os.Create(filename)
+	m, err := textToImage(mode, size, text)
 	if err != nil {
 		return err
 	}
 
-	// Encode the image as an .ico image
-	//return ico.Encode(f, m)
-	return EncodeGrayscale4bit(f, m) // Sadly, this does not seem to support transparency
+	if PNG {
+		return encoder.EncodePNG(w, savedImage(mode, m))
+	}
+	return encoder.EncodeICO(w, savedImage(mode, m))
+}
+
+// savedImage returns the image together with the mode that it is saved in, and the palette of the editor
+func savedImage(mode Mode, m image.Image) *img.Image {
+	return &img.Image{Image: m, Mode: mode, Palette: paletteColors}
+}
+
+// WriteFavicon converts the textual representation to an image and saves it,
+// as a .png image if PNG is true or as an .ico image if not.
+// The size is the width and height of the image, in pixels.
+func WriteFavicon(mode Mode, size image.Point, text, filename string, PNG bool) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeFavicon(w, mode, size, text, PNG)
+	})
 }
 
-// This is from github.com/biessek/golang-ico, only to be able to use private structs
-type head struct {
-	Zero   uint16
-	Type   uint16
-	Number uint16
+// createFile creates or truncates the given file, calls write with it and then closes it.
+// The error from closing the file is returned if writing went well, since the data may not be flushed.
+func createFile(filename string, write func(w io.Writer) error) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return write(f)
 }
 
-// This is from github.com/biessek/golang-ico, only to be able to use private structs
-type direntry struct {
-	Width   byte
-	Height  byte
-	Palette byte
-	_       byte
-	Plane   uint16
-	Bits    uint16
-	Size    uint32
-	Offset  uint32
+// writeEncoded calls encode with a buffer, and then writes what was encoded to the given file.
+// The file is only created if encoding went well, so that it is left as it is if the text is not valid.
+func writeEncoded(filename string, encode func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := encode(&buf); err != nil {
+		return err
+	}
+	return createFile(filename, func(w io.Writer) error {
+		_, err := buf.WriteTo(w)
+		return err
+	})
 }
 
-// EncodeGrayscale4bit is a modified version of the function from github.com/biessek/golang-ico, only to be able to save 4-bit .ico images
-func EncodeGrayscale4bit(w io.Writer, im image.Image) error {
-	b := im.Bounds()
-	m := image.NewGray(b)
-	draw.Draw(m, b, im, b.Min, draw.Src)
-	header := head{
-		0,
-		1,
-		1,
-	}
-	entry := direntry{
-		Plane:  1,
-		Bits:   4, // was: 32
-		Offset: 22,
-	}
-	pngbuffer := new(bytes.Buffer)
-	pngwriter := bufio.NewWriter(pngbuffer)
-	err := png.Encode(pngwriter, m)
+// WriteFaviconEntry converts the textual representation to an image and saves it as
+// the given entry of a multi-entry .ico file, keeping the other entries as they are.
+func WriteFaviconEntry(mode Mode, size image.Point, text, filename string, entries []img.Entry, index int) error {
+	if !mode.CanSave() {
+		return img.ErrCanNotSave
+	}
+	if index < 0 || index >= len(entries) {
+		return fmt.Errorf("%s has no entry number %d", filename, index+1)
+	}
+
+	m, err := textToImage(mode, size, text)
 	if err != nil {
 		return err
 	}
-	err = pngwriter.Flush()
+
+	entry, err := encoder.NewEntry(savedImage(mode, m).Saved(), mode.Bits())
 	if err != nil {
 		return err
 	}
-	entry.Size = uint32(len(pngbuffer.Bytes()))
+
+	// Keep the hotspot, if this is a .cur file
+	entry.Cursor, entry.Hotspot = entries[index].Cursor, entries[index].Hotspot
+
+	// Replace the entry, but leave the given slice as it is
+	newEntries := make([]img.Entry, len(entries))
+	copy(newEntries, entries)
+	newEntries[index] = entry
+
+	return createFile(filename, func(w io.Writer) error {
+		return img.WriteEntries(w, newEntries)
+	})
+}
+
+// bundleSizes are the sizes of the images that are written by WriteFaviconBundle
+var bundleSizes = []int{16, 32, 48}
+
+// scaleArea scales the given image so that it fits within a size x size image, by averaging the pixels
+// that are covered by each new pixel. Images that are not square are centered, with transparent pixels around.
+func scaleArea(m image.Image, size int) *image.NRGBA {
+	var (
+		bounds  = m.Bounds()
+		w, h    = bounds.Dx(), bounds.Dy()
+		longest = w
+	)
+	if h > longest {
+		longest = h
+	}
+	// The size of the scaled image within the size x size image, keeping the aspect ratio
+	sw, sh := w*size/longest, h*size/longest
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	left, top := (size-sw)/2, (size-sh)/2
+	scaled := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < sh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/sh, bounds.Min.Y+(y+1)*h/sh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < sw; x++ {
+			x0, x1 := bounds.Min.X+x*w/sw, bounds.Min.X+(x+1)*w/sw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			// Average the premultiplied colors, so that transparent pixels do not darken the result
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := m.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			scaled.Set(left+x, top+y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return scaled
+}
+
+// fitImage scales the image to fit within a size x size image with scaleArea, if size is not 0 and the image
+// does not already have that size. Returns the image and a message about the original size, if it was scaled.
+func fitImage(m image.Image, size int) (image.Image, string) {
+	if original := m.Bounds().Size(); size != 0 && (original.X != size || original.Y != size) {
+		return scaleArea(m, size), fmt.Sprintf(" (scaled from %dx%d)", original.X, original.Y)
+	}
+	return m, ""
+}
+
+// defaultPNGSizes are the sizes of the .png images that are exported with P, unless -sizes is given
+var defaultPNGSizes = []int{32, 48, 64, 180}
+
+// parseSizes parses a comma separated list of image sizes, like "32,48,64"
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 1 || size > 1024 {
+			return nil, fmt.Errorf("%q is not a size from 1 to 1024", field)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// scaleInteger scales the given image up by the largest whole number that makes it fit within a size x size image,
+// using nearest neighbor scaling, so that the pixels stay sharp. The scaled image is centered, with transparent
+// pixels around it if size is not a multiple of the image size. Images that are larger than size are scaled with scaleArea.
+func scaleInteger(m image.Image, size int) *image.NRGBA {
 	bounds := m.Bounds()
-	entry.Width = uint8(bounds.Dx())
-	entry.Height = uint8(bounds.Dy())
-	bb := new(bytes.Buffer)
-	var e error
-	if e = binary.Write(bb, binary.LittleEndian, header); e != nil {
-		return e
+	factor := size / bounds.Dx()
+	if f := size / bounds.Dy(); f < factor {
+		factor = f
+	}
+	if factor < 1 {
+		return scaleArea(m, size)
+	}
+	var (
+		left   = (size - bounds.Dx()*factor) / 2
+		top    = (size - bounds.Dy()*factor) / 2
+		scaled = image.NewNRGBA(image.Rect(0, 0, size, size))
+	)
+	for y := 0; y < bounds.Dy()*factor; y++ {
+		for x := 0; x < bounds.Dx()*factor; x++ {
+			scaled.Set(left+x, top+y, m.At(bounds.Min.X+x/factor, bounds.Min.Y+y/factor))
+		}
+	}
+	return scaled
+}
+
+// pngSizeFilename returns the filename of the .png image with the given size, like favicon-32.png for favicon.ico
+func pngSizeFilename(filename string, size int) string {
+	return siblingFilename(filename, fmt.Sprintf("-%d.png", size))
+}
+
+// WritePNGSizes converts the textual representation to an image and writes one .png image per size in sizes,
+// scaled with scaleInteger and named after the given filename, like favicon-32.png.
+// Returns the filenames that were written.
+func WritePNGSizes(mode Mode, size image.Point, text, filename string, sizes []int) ([]string, error) {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, pngSize := range sizes {
+		pngFilename := pngSizeFilename(filename, pngSize)
+		scaled := scaleInteger(m, pngSize)
+		if err := createFile(pngFilename, func(w io.Writer) error {
+			return encoder.EncodePNG(w, scaled)
+		}); err != nil {
+			return written, err
+		}
+		written = append(written, pngFilename)
 	}
-	if e = binary.Write(bb, binary.LittleEndian, entry); e != nil {
-		return e
+	return written, nil
+}
+
+// WriteFaviconBundle converts the textual representation to an image and saves it as an .ico file
+// with one entry per size in bundleSizes, by scaling the image with nearest neighbor scaling.
+func WriteFaviconBundle(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeFaviconBundle(w, mode, size, text)
+	})
+}
+
+// EncodeFaviconBundle is like WriteFaviconBundle, but writes the .ico image to the given io.Writer
+func EncodeFaviconBundle(w io.Writer, mode Mode, size image.Point, text string) error {
+	if !mode.CanSave() {
+		return img.ErrCanNotSave
 	}
-	if _, e = w.Write(bb.Bytes()); e != nil {
-		return e
+
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
 	}
-	_, e = w.Write(pngbuffer.Bytes())
-	return e
+
+	return encoder.EncodeICOSizes(w, savedImage(mode, m), bundleSizes)
 }
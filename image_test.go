@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// testModes are the modes that images can be saved in
+var testModes = []Mode{modeGray4, modeRGB, modeRGBA, modePalette, modeMono}
+
+// testImage returns a size x size image with colors, partially transparent pixels and a transparent column
+func testImage(size int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 1; x < size; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / size), uint8(y * 255 / size), 0x80, uint8(0x80 + x*0x7f/size)})
+		}
+	}
+	return m
+}
+
+// testText returns the textual representation of testImage in the given mode
+func testText(t *testing.T, mode Mode, size int) string {
+	t.Helper()
+	_, _, text, _, err := imageToText(testImage(size), "test", true, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+// decodeText decodes the .ico or .png image and returns its textual representation in the given mode
+func decodeText(t *testing.T, data []byte, mode Mode) string {
+	t.Helper()
+	m, err := img.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, text, _, err := imageToText(m.Image, "test", true, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(text)
+}
+
+func TestEncodeFavicon(t *testing.T) {
+	for _, mode := range testModes {
+		for _, PNG := range []bool{true, false} {
+			name := fmt.Sprintf("%s ico", mode)
+			if PNG {
+				name = fmt.Sprintf("%s png", mode)
+			}
+			text := testText(t, mode, 16)
+			var buf bytes.Buffer
+			if err := EncodeFavicon(&buf, mode, image.Pt(16, 16), text, PNG); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got := bytes.HasPrefix(buf.Bytes(), img.PNGMagic); got != PNG {
+				t.Errorf("%s: wrote a .png image: %v", name, got)
+			}
+			if got := decodeText(t, buf.Bytes(), mode); got != text {
+				t.Errorf("%s: got\n%s\nbut wanted\n%s", name, got, text)
+			}
+		}
+	}
+}
+
+func TestWriteFavicon(t *testing.T) {
+	dir := t.TempDir()
+	for _, mode := range testModes {
+		for _, ext := range []string{".png", ".ico"} {
+			filename := filepath.Join(dir, mode.String()+ext)
+			text := testText(t, mode, 32)
+			if err := WriteFavicon(mode, image.Pt(32, 32), text, filename, ext == ".png"); err != nil {
+				t.Fatal(err)
+			}
+			format := formatICO
+			if ext == ".png" {
+				format = formatPNG
+			}
+			gotMode, size, got, _, err := ReadImage(filename, format, mode, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMode != mode || size != image.Pt(32, 32) || string(got) != text {
+				t.Errorf("%s: got a %v %s image\n%s\nbut wanted a (32,32) %s image\n%s", filename, size, gotMode, got, mode, text)
+			}
+		}
+	}
+}
+
+func TestWriteFaviconInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "favicon.ico")
+	if err := WriteFavicon(modeRGB, image.Pt(2, 2), "xxxxxx|\n", filename, false); err == nil {
+		t.Fatal("saved an image with invalid pixels")
+	}
+	if exists(filename) {
+		t.Error("created a file for an image with invalid pixels")
+	}
+	if err := EncodeFavicon(&bytes.Buffer{}, modeBlank, image.Pt(16, 16), "", true); err != img.ErrCanNotSave {
+		t.Errorf("got %v, but wanted ErrCanNotSave", err)
+	}
+	if err := EncodeFavicon(&bytes.Buffer{}, modeRGBA, image.Pt(img.MaxSize+1, 1), "", true); err == nil {
+		t.Errorf("saved an image that is larger than %d", img.MaxSize)
+	}
+}
+
+func TestWriteFaviconBundleInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "favicon.ico")
+	if err := WriteFaviconBundle(modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), filename); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFaviconBundle(modeRGB, image.Pt(2, 2), "xxxxxx|\n", filename); err == nil {
+		t.Fatal("saved an image with invalid pixels")
+	}
+	after, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("the existing image was changed by an image with invalid pixels")
+	}
+}
+
+func TestEncodeDeterministic(t *testing.T) {
+	text := testText(t, modeRGBA, 48)
+	encoders := map[string]func(w *bytes.Buffer) error{
+		"png": func(w *bytes.Buffer) error {
+			return EncodeFavicon(w, modeRGBA, image.Pt(48, 48), text, true)
+		},
+		"bundle": func(w *bytes.Buffer) error {
+			return EncodeFaviconBundle(w, modeRGBA, image.Pt(48, 48), text)
+		},
+	}
+	for name, encode := range encoders {
+		var first, second bytes.Buffer
+		if err := encode(&first); err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(&second); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Errorf("%s: encoding the same image twice gave different bytes", name)
+		}
+	}
+
+	// The entries are sorted by size, so the order of the images does not matter
+	images := []image.Image{testImage(48), testImage(16), testImage(32)}
+	var first, second bytes.Buffer
+	if err := encoder.EncodeICOAll(&first, images, 32); err != nil {
+		t.Fatal(err)
+	}
+	images[0], images[1], images[2] = images[1], images[2], images[0]
+	if err := encoder.EncodeICOAll(&second, images, 32); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("encoding the same images in another order gave different bytes")
+	}
+	entries, err := img.ReadEntries(&first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{16, 32, 48} {
+		if size := entries[i].Size(); size != image.Pt(want, want) {
+			t.Errorf("entry %d is %v, but wanted %dx%d", i+1, size, want, want)
+		}
+	}
+}
+
+func TestReadLargestEntry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "favicon.ico")
+	var buf bytes.Buffer
+	if err := encoder.EncodeICOAll(&buf, []image.Image{testImage(32), testImage(48), testImage(16)}, 32); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The image that is edited and the image that is converted or used as a reference is the same one
+	_, size, _, _, err := ReadImage(filename, formatICO, modeRGBA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != image.Pt(48, 48) {
+		t.Errorf("ReadImage read the %v image, but wanted the largest one", size)
+	}
+	m, err := decodeImage(bytes.NewReader(buf.Bytes()), formatICO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := m.Bounds().Size(); size != image.Pt(48, 48) {
+		t.Errorf("decodeImage read the %v image, but wanted the largest one", size)
+	}
+}
+
+func TestWritePNGSizes(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		filename = filepath.Join(dir, "favicon.ico")
+		text     = testText(t, modeRGBA, 16)
+		original = testImage(16)
+		sizes    = []int{16, 32, 48, 180, 8}
+	)
+	written, err := WritePNGSizes(modeRGBA, image.Pt(16, 16), text, filename, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(sizes) {
+		t.Fatalf("wrote %v, but wanted %d files", written, len(sizes))
+	}
+	for i, size := range sizes {
+		if want := filepath.Join(dir, fmt.Sprintf("favicon-%d.png", size)); written[i] != want {
+			t.Errorf("wrote %s, but wanted %s", written[i], want)
+		}
+		data, err := ioutil.ReadFile(written[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Bounds().Size(); got != image.Pt(size, size) {
+			t.Errorf("%s is %v, but wanted %dx%d", written[i], got, size, size)
+		}
+		if size < 16 {
+			// The transparent column is averaged with its neighbours when scaling down
+			if a := color.NRGBAModel.Convert(m.At(0, 0)).(color.NRGBA).A; a == 0xff {
+				t.Errorf("the top left pixel in %s is opaque", written[i])
+			}
+			continue
+		}
+		// Each pixel becomes factor x factor pixels, centered, with the partial transparency kept
+		factor := size / 16
+		offset := (size - 16*factor) / 2
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				var want color.NRGBA
+				if ox, oy := (x-offset)/factor, (y-offset)/factor; x >= offset && y >= offset && ox < 16 && oy < 16 {
+					want = original.NRGBAAt(ox, oy)
+				}
+				got := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+				if got != want && (got.A != 0 || want.A != 0) {
+					t.Fatalf("the pixel at (%d,%d) in %s is %v, but wanted %v", x, y, written[i], got, want)
+				}
+			}
+		}
+	}
+}
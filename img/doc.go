@@ -0,0 +1,44 @@
+// Package img reads and writes favicons: .ico, .cur and .png images, in the modes that the favicon editor
+// uses, like 16 shades of gray, 16 colors or black and white. This is the image I/O of the favicon editor,
+// without the editor and the textual representation of the pixels.
+//
+// An Image is an image.Image together with the Mode that it is saved in. Decode reads an image and finds the
+// mode with DetectMode, while New can be used for choosing a mode:
+//
+//	f, err := os.Open("logo.png")
+//	if err != nil {
+//		return err
+//	}
+//	defer f.Close()
+//	m, err := img.Decode(f)
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Println(m.Mode, m.Size()) // like "rgba (32,32)"
+//
+// Save it as a 4-bit grayscale .ico image, where the pixels get the closest of the 16 shades:
+//
+//	err = img.EncodeICO(w, img.New(m, img.Gray4))
+//
+// Or as a .png image with at most 16 colors, the best compression and a text chunk with the author:
+//
+//	enc := img.Encoder{CompressionLevel: png.BestCompression, Text: []img.Text{{Key: "Author", Value: "Alex"}}}
+//	err = enc.EncodePNG(w, img.New(m, img.Palette))
+//
+// Or as an .ico image with 16x16, 32x32 and 48x48 entries:
+//
+//	err = enc.EncodeICOSizes(w, m, []int{16, 32, 48})
+//
+// Decode reads the largest image of an .ico file with several images. Use DecodeSize to read the image with
+// a given size, or FindEntry and LargestEntry to find it among the entries from ReadEntries. ReadEntries and WriteEntries read and write the entries of .ico
+// and .cur files without decoding them, for instance for replacing one entry while keeping the others.
+// DetectFormat tells .png, .ico and .cur files apart by their first bytes, which are PNGMagic, ICOMagic and CURMagic.
+//
+// The runes that the favicon editor uses for the 16 shades can be changed with SetRunes, and looked up with
+// RuneShade and ShadeRune. SetMonoThreshold changes which shades become white in Mono mode.
+//
+// Images that are larger than MaxDecodeSize are not decoded, so that a damaged file that says that it has a huge
+// image can not use up the memory. The size is read from the header first, and can be raised with SetMaxDecodeSize.
+// DecodePNG and CheckDecodeSize can be used for the same check in other decoders. Errors from decoding an entry
+// are EntryErrors, which say which entry failed and at which byte in the file.
+package img
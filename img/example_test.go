@@ -0,0 +1,98 @@
+package img_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// logo returns a 32x32 image with a colored square on a transparent background
+func logo() image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 8; y < 24; y++ {
+		for x := 8; x < 24; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{0xff, uint8(x * 8), 0x40, 0xff})
+		}
+	}
+	return m
+}
+
+func ExampleDecode() {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo()); err != nil {
+		log.Fatal(err)
+	}
+	m, err := img.Decode(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(m.Mode, m.Size())
+	// Output: rgb (32,32)
+}
+
+func ExampleEncodeICO() {
+	var buf bytes.Buffer
+	if err := img.EncodeICO(&buf, img.New(logo(), img.Gray4)); err != nil {
+		log.Fatal(err)
+	}
+	m, err := img.Decode(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(m.Mode, m.Size(), img.HasTransparency(m))
+	// Output: gray4 (32,32) true
+}
+
+func ExampleEncoder_EncodePNG() {
+	enc := img.Encoder{CompressionLevel: png.BestCompression, Text: []img.Text{{Key: "Author", Value: "Alex"}}}
+	var buf bytes.Buffer
+	if err := enc.EncodePNG(&buf, img.New(logo(), img.Palette)); err != nil {
+		log.Fatal(err)
+	}
+	text, err := img.ReadText(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %s\n", text[0].Key, text[0].Value)
+	// Output: Author: Alex
+}
+
+func ExampleEncoder_EncodeICOSizes() {
+	var (
+		enc img.Encoder
+		buf bytes.Buffer
+	)
+	if err := enc.EncodeICOSizes(&buf, logo(), []int{16, 32, 48}); err != nil {
+		log.Fatal(err)
+	}
+	entries, err := img.ReadEntries(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		fmt.Println(entry.Size(), entry.Dir.Bits)
+	}
+	m, err := img.DecodeSize(&buf, 48)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(m.Size())
+	// Output:
+	// (16,16) 32
+	// (32,32) 32
+	// (48,48) 32
+	// (48,48)
+}
+
+func ExampleShadeRune() {
+	for _, shade := range []byte{0, 7, 15} {
+		fmt.Printf("%c", img.ShadeRune(shade))
+	}
+	fmt.Println()
+	// Output: _:{
+}
@@ -0,0 +1,167 @@
+package img_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"runtime"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// maxFuzzAlloc is the most memory that decoding one input may allocate. The largest image that is decoded is
+// 1024x1024 pixels, so this leaves room for the copies that are made while decoding, but not for an image
+// with the size that a damaged header says.
+const maxFuzzAlloc = 256 << 20
+
+// pngWithSize returns the start of a .png image where the IHDR chunk says that it is width x height pixels,
+// followed by an IDAT chunk that is far too short for that size
+func pngWithSize(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := func(typ string, data []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(typ)
+		buf.Write(data)
+		binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8 bits per channel, RGBA
+	chunk("IHDR", ihdr)
+	chunk("IDAT", []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01})
+	chunk("IEND", nil)
+	return buf.Bytes()
+}
+
+// icoWithEntry returns an .ico file with a single entry with the given data
+func icoWithEntry(data []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, img.Header{Type: img.TypeIcon, Number: 1})
+	binary.Write(&buf, binary.LittleEndian, img.DirEntry{Plane: 1, Bits: 32, Size: uint32(len(data)), Offset: 22})
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// bmpWithSize returns a BMP encoded .ico entry where the BITMAPINFOHEADER says that it is width x height pixels,
+// without any pixel data
+func bmpWithSize(width, height int32) []byte {
+	data := make([]byte, 40)
+	binary.LittleEndian.PutUint32(data[0:4], 40)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(width))
+	binary.LittleEndian.PutUint32(data[8:12], uint32(height*2))
+	binary.LittleEndian.PutUint16(data[12:14], 1)
+	binary.LittleEndian.PutUint16(data[14:16], 32)
+	return data
+}
+
+// seedImages returns small valid .png and .ico images, for starting the fuzzing from
+func seedImages(t testing.TB) [][]byte {
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 16), uint8(y * 16), 0x80, uint8((x + y) * 8)})
+		}
+	}
+	var seeds [][]byte
+	for _, mode := range []img.Mode{img.Gray4, img.RGBA, img.Palette, img.Mono} {
+		var pngBuf, icoBuf bytes.Buffer
+		if err := img.EncodePNG(&pngBuf, img.New(m, mode)); err != nil {
+			t.Fatal(err)
+		}
+		if err := img.EncodeICO(&icoBuf, img.New(m, mode)); err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, pngBuf.Bytes(), icoBuf.Bytes())
+	}
+	var sizesBuf bytes.Buffer
+	var enc img.Encoder
+	if err := enc.EncodeICOSizes(&sizesBuf, img.New(m, img.RGB), []int{16, 32, 256}); err != nil {
+		t.Fatal(err)
+	}
+	return append(seeds, sizesBuf.Bytes())
+}
+
+// hugeImages are files where the header says that the image is much larger than MaxDecodeSize
+var hugeImages = map[string][]byte{
+	"png":          pngWithSize(50000, 50000),
+	"png entry":    icoWithEntry(pngWithSize(60000, 1)),
+	"bmp entry":    icoWithEntry(bmpWithSize(1, 70000)),
+	"top-down bmp": icoWithEntry(bmpWithSize(70000, -70000)),
+}
+
+// decodeAll decodes data with Decode, and with ReadEntries and Entry.Decode if it is an .ico or .cur file,
+// and checks the size of the images that could be decoded. Returns the errors from decoding the entries and
+// the error from Decode.
+func decodeAll(t *testing.T, data []byte) ([]error, error) {
+	checkSize := func(m image.Image) {
+		if size := m.Bounds().Size(); size.X > img.MaxDecodeSize() || size.Y > img.MaxDecodeSize() {
+			t.Errorf("decoded a %dx%d image, which is larger than %d", size.X, size.Y, img.MaxDecodeSize())
+		}
+	}
+	m, decodeErr := img.Decode(bytes.NewReader(data))
+	if decodeErr == nil {
+		checkSize(m)
+	}
+	entries, err := img.ReadEntries(bytes.NewReader(data))
+	if err != nil {
+		return nil, decodeErr
+	}
+	var entryErrs []error
+	for _, entry := range entries {
+		m, err := entry.Decode()
+		if err != nil {
+			entryErrs = append(entryErrs, err)
+			continue
+		}
+		checkSize(m)
+	}
+	return entryErrs, decodeErr
+}
+
+// allocated returns the number of bytes that f allocates
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	for name, data := range hugeImages {
+		var (
+			decodeErr error
+			entryErrs []error
+		)
+		if n := allocated(func() { entryErrs, decodeErr = decodeAll(t, data) }); n > maxFuzzAlloc {
+			t.Errorf("%s: decoding allocated %d bytes", name, n)
+		}
+		for _, err := range append([]error{decodeErr}, entryErrs...) {
+			var tooLarge *img.TooLargeError
+			if !errors.As(err, &tooLarge) {
+				t.Errorf("%s: got %v, but wanted a *img.TooLargeError", name, err)
+			}
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, data := range seedImages(f) {
+		f.Add(data)
+	}
+	for _, data := range hugeImages {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Decoding must not panic or allocate the size that a damaged header says
+		if n := allocated(func() { decodeAll(t, data) }); n > maxFuzzAlloc {
+			t.Errorf("decoding %d bytes allocated %d bytes", len(data), n)
+		}
+	})
+}
@@ -0,0 +1,582 @@
+package img
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	ico "github.com/biessek/golang-ico"
+)
+
+// Header is the header of .ico and .cur files.
+// This is from github.com/biessek/golang-ico, only to be able to use private structs.
+type Header struct {
+	Zero   uint16
+	Type   uint16
+	Number uint16
+}
+
+// DirEntry is an entry in the directory of .ico and .cur files, after the header.
+// This is from github.com/biessek/golang-ico, only to be able to use private structs.
+type DirEntry struct {
+	Width   byte
+	Height  byte
+	Palette byte
+	_       byte
+	Plane   uint16
+	Bits    uint16
+	Size    uint32
+	Offset  uint32
+}
+
+// bitmapInfoHeader is the BITMAPINFOHEADER that starts a BMP encoded .ico entry
+type bitmapInfoHeader struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+}
+
+// biRGB and biBitfields are the compression methods in the BITMAPINFOHEADER that decodeBMP can read
+const (
+	biRGB       = 0
+	biBitfields = 3
+)
+
+// TypeIcon and TypeCursor are the types in the header of .ico and .cur files
+const (
+	TypeIcon   = 1
+	TypeCursor = 2
+)
+
+// pngEntrySize is the width and height from which .ico entries are stored as PNG instead of as BMP
+const pngEntrySize = 256
+
+// EncodeICOAll writes an .ico image with one entry per given image, with the given number of bits per pixel (1, 4 or 32).
+// The entries are sorted from the smallest to the largest image, so that the order and the offsets of the entries
+// do not depend on the order of the given images.
+func (enc *Encoder) EncodeICOAll(w io.Writer, images []image.Image, bits uint16) error {
+	if len(images) == 0 {
+		return errors.New("no images to encode")
+	}
+	entries := make([]Entry, len(images))
+	for i, m := range images {
+		entry, err := enc.NewEntry(m, bits)
+		if err != nil {
+			return err
+		}
+		entries[i] = entry
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Size(), entries[j].Size()
+		return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+	})
+	return WriteEntries(w, entries)
+}
+
+// encodeICO writes an .ico image with a single entry, with the given number of bits per pixel (1, 4 or 32).
+// Images smaller than 256x256 are stored as BMP, larger images are stored as PNG.
+// 32-bit images with partially transparent pixels are also stored as PNG, since many ICO readers
+// (including github.com/biessek/golang-ico) premultiply the alpha channel of BMP entries.
+func (enc *Encoder) encodeICO(w io.Writer, m image.Image, bits uint16) error {
+	entry, err := enc.NewEntry(m, bits)
+	if err != nil {
+		return err
+	}
+	return WriteEntries(w, []Entry{entry})
+}
+
+// NewEntry encodes the given image as an .ico entry, with the given number of bits per pixel (1, 4 or 32)
+func (enc *Encoder) NewEntry(m image.Image, bits uint16) (Entry, error) {
+	entry := DirEntry{
+		Plane: 1,
+		Bits:  bits,
+	}
+	bounds := m.Bounds()
+	var (
+		data []byte
+		err  error
+	)
+	if bounds.Dx() >= pngEntrySize || bounds.Dy() >= pngEntrySize || (bits == 32 && HasPartialAlpha(m)) {
+		data, err = enc.encodePNGEntry(m, bits)
+	} else {
+		data, err = encodeBMPEntry(m, bits)
+		if bits == 1 || bits == 4 {
+			entry.Palette = 1 << bits
+		}
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Size = uint32(len(data))
+	entry.Width = uint8(bounds.Dx())
+	entry.Height = uint8(bounds.Dy())
+	return Entry{Dir: entry, Data: data}, nil
+}
+
+// encodePNGEntry returns the PNG encoded data for an .ico entry.
+// 4-bit entries are saved as grayscale, unless the image has a palette, like 1-bit images, or transparent
+// pixels, which grayscale .png images can not have.
+func (enc *Encoder) encodePNGEntry(im image.Image, bits uint16) ([]byte, error) {
+	m := im
+	if _, paletted := im.(*image.Paletted); bits == 4 && !paletted && !HasTransparency(im) {
+		b := im.Bounds()
+		gm := image.NewGray(b)
+		draw.Draw(gm, b, im, b.Min, draw.Src)
+		m = gm
+	}
+	pngbuffer := new(bytes.Buffer)
+	pngwriter := bufio.NewWriter(pngbuffer)
+	// Some .ico readers can not read interlaced .png entries, so -png-interlace is not used here
+	pngEncoder := png.Encoder{CompressionLevel: enc.CompressionLevel}
+	if err := pngEncoder.Encode(pngwriter, m); err != nil {
+		return nil, err
+	}
+	if err := pngwriter.Flush(); err != nil {
+		return nil, err
+	}
+	return pngbuffer.Bytes(), nil
+}
+
+// encodeBMPEntry returns the BMP encoded data for an .ico entry: a BITMAPINFOHEADER with a doubled height,
+// a palette (only for 1 and 4 bits per pixel), the XOR pixel data and the AND mask.
+// The palette is the one of the image, if it has one with at most 16 colors, or 16 grays if not.
+// 1-bit images are black and white, and transparent pixels are black with the bit set in the AND mask.
+// Fully transparent pixels are set in the AND mask.
+func encodeBMPEntry(m image.Image, bits uint16) ([]byte, error) {
+	pm, paletted := m.(*image.Paletted)
+	if paletted && len(pm.Palette) > 16 {
+		paletted = false
+	}
+	var (
+		bounds    = m.Bounds()
+		width     = bounds.Dx()
+		height    = bounds.Dy()
+		xorStride = (width*int(bits) + 31) / 32 * 4
+		andStride = (width + 31) / 32 * 4
+		xorData   = make([]byte, xorStride*height)
+		andData   = make([]byte, andStride*height)
+		header    = bitmapInfoHeader{
+			Size:      40,
+			Width:     int32(width),
+			Height:    int32(height * 2), // the XOR data and the AND mask
+			Planes:    1,
+			BitCount:  bits,
+			SizeImage: uint32(len(xorData) + len(andData)),
+		}
+		buf bytes.Buffer
+	)
+
+	// The rows are stored bottom-up
+	for y := 0; y < height; y++ {
+		row := height - 1 - y
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			if c.A == 0 {
+				// Transparent, set the bit in the AND mask and leave the pixel black
+				andData[row*andStride+x/8] |= 0x80 >> uint(x%8)
+				if bits != 4 {
+					continue
+				}
+			}
+			switch bits {
+			case 1:
+				// White pixels have the bit set
+				if color.GrayModel.Convert(c).(color.Gray).Y >= 0x80 {
+					xorData[row*xorStride+x/8] |= 0x80 >> uint(x%8)
+				}
+			case 4:
+				// From 0..255 to a palette index from 0..15
+				index := color.GrayModel.Convert(c).(color.Gray).Y / 16
+				if paletted {
+					index = pm.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)
+				}
+				if c.A == 0 {
+					index = 0
+				}
+				if x%2 == 0 {
+					xorData[row*xorStride+x/2] |= index << 4
+				} else {
+					xorData[row*xorStride+x/2] |= index
+				}
+			default:
+				// BGRA
+				i := row*xorStride + x*4
+				xorData[i] = c.B
+				xorData[i+1] = c.G
+				xorData[i+2] = c.R
+				xorData[i+3] = c.A
+			}
+		}
+	}
+
+	if bits == 1 || bits == 4 {
+		header.ClrUsed = 1 << bits
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	if bits == 4 && paletted {
+		// The palette of the image, as BGR0, followed by black for the unused entries
+		for i := 0; i < 16; i++ {
+			var c color.NRGBA
+			if i < len(pm.Palette) {
+				c = color.NRGBAModel.Convert(pm.Palette[i]).(color.NRGBA)
+			}
+			buf.Write([]byte{c.B, c.G, c.R, 0})
+		}
+	} else if bits == 1 {
+		// Black and white
+		buf.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0})
+	} else if bits == 4 {
+		// The palette, with the same intensities that are used when drawing the pixels (15..255)
+		for i := 0; i < 16; i++ {
+			intensity := byte(i*16 + 15)
+			buf.Write([]byte{intensity, intensity, intensity, 0})
+		}
+	}
+	buf.Write(xorData)
+	buf.Write(andData)
+	return buf.Bytes(), nil
+}
+
+// Entry is a single image in an .ico file: the directory entry and the encoded PNG or BMP data.
+// Entries from .cur files also have a hotspot, which is stored in the Plane and Bits fields of the directory entry.
+type Entry struct {
+	Dir     DirEntry
+	Data    []byte
+	Cursor  bool        // is this an entry in a .cur file?
+	Hotspot image.Point // the pixel of the cursor that points, for .cur files
+	number  int         // the number of the entry in the file, from 1, or 0 if it was not read from a file
+}
+
+// Size returns the width and height of the entry, in pixels (0 in the directory entry means 256)
+func (e Entry) Size() image.Point {
+	w, h := int(e.Dir.Width), int(e.Dir.Height)
+	if w == 0 {
+		w = 256
+	}
+	if h == 0 {
+		h = 256
+	}
+	return image.Pt(w, h)
+}
+
+// Decode decodes the image data of this entry. Entries that github.com/biessek/golang-ico can not decode,
+// like 8-bit BMP entries, and 256x256 BMP entries, which it reads as fully transparent, are decoded with
+// image/png or with decodeBMPEntry instead. The errors say which entry failed, and at which byte in the file.
+func (e Entry) Decode() (image.Image, error) {
+	if err := e.checkSize(); err != nil {
+		return nil, err
+	}
+	if size := e.Size(); size.X < 256 && size.Y < 256 {
+		if m, err := e.decodeICO(); err == nil {
+			return m, nil
+		}
+	}
+	if bytes.HasPrefix(e.Data, PNGMagic) {
+		m, err := DecodePNG(bytes.NewReader(e.Data))
+		if err != nil {
+			return nil, e.errorf(0, "the PNG data can not be decoded: %s", err)
+		}
+		return m, nil
+	}
+	return e.decodeBMP()
+}
+
+// checkSize checks the size in the PNG or BMP header of the entry with CheckDecodeSize, before it is decoded
+func (e Entry) checkSize() error {
+	var width, height int
+	if bytes.HasPrefix(e.Data, PNGMagic) {
+		config, err := png.DecodeConfig(bytes.NewReader(e.Data))
+		if err != nil {
+			return e.errorf(0, "the PNG header can not be decoded: %s", err)
+		}
+		width, height = config.Width, config.Height
+	} else if len(e.Data) >= 12 {
+		// The height is doubled, for the AND mask, and is negative if the rows are stored top-down
+		width = int(int32(binary.LittleEndian.Uint32(e.Data[4:8])))
+		height = int(int32(binary.LittleEndian.Uint32(e.Data[8:12]))) / 2
+		if height < 0 {
+			height = -height
+		}
+	}
+	if err := CheckDecodeSize(width, height); err != nil {
+		return EntryError{e.number, int64(e.Dir.Offset), err}
+	}
+	return nil
+}
+
+// decodeICO decodes the image data of this entry with github.com/biessek/golang-ico,
+// which panics instead of returning an error for some truncated entries
+func (e Entry) decodeICO() (m image.Image, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	// github.com/biessek/golang-ico can only decode .ico files
+	e.Cursor = false
+	var buf bytes.Buffer
+	if err := WriteEntries(&buf, []Entry{e}); err != nil {
+		return nil, err
+	}
+	return ico.Decode(&buf)
+}
+
+// EntryError is an error from decoding an entry, with the number of the entry and the byte in the file where
+// the problem is
+type EntryError struct {
+	Number int   // the number of the entry in the file, from 1, or 0 if it was not read from a file
+	Offset int64 // the position in the file
+	Err    error
+}
+
+// Error returns the message of the error, followed by which entry failed and at which byte
+func (e EntryError) Error() string {
+	if e.Number > 0 {
+		return fmt.Sprintf("%s (entry %d, at byte %d)", e.Err, e.Number, e.Offset)
+	}
+	return fmt.Sprintf("%s (at byte %d)", e.Err, e.Offset)
+}
+
+// Unwrap returns the error from decoding the entry, like a *TooLargeError
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+// errorf returns an EntryError, where offset is the position within the data of the entry
+func (e Entry) errorf(offset int, format string, a ...interface{}) error {
+	return EntryError{e.number, int64(e.Dir.Offset) + int64(offset), fmt.Errorf(format, a...)}
+}
+
+// decodeBMP decodes a BMP encoded entry: a BITMAPINFOHEADER with a doubled height, a palette for 1, 4 and 8
+// bits per pixel, the XOR pixel data and the AND mask, where a set bit is a transparent pixel. 32-bit entries
+// use their alpha channel instead, unless it is 0 for all pixels, like in icons from before Windows XP.
+// The directory entry is not used, since the reserved byte and the number of colors are often wrong.
+func (e Entry) decodeBMP() (image.Image, error) {
+	var (
+		data   = e.Data
+		header bitmapInfoHeader
+	)
+	if len(data) < 40 {
+		return nil, e.errorf(0, "the BMP header is truncated, there are %d of 40 bytes", len(data))
+	}
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, &header)
+	if header.Size < 40 || int64(header.Size) > int64(len(data)) {
+		return nil, e.errorf(0, "the BMP header size is %d bytes, but it must be from 40 to %d", header.Size, len(data))
+	}
+	var (
+		width  = int(header.Width)
+		height = int(header.Height) / 2 // the XOR data and the AND mask
+		bits   = int(header.BitCount)
+	)
+	if width < 1 || height < 1 {
+		return nil, e.errorf(4, "the BMP header says the image is %dx%d, but it must be at least 1x1", header.Width, header.Height/2)
+	}
+	switch bits {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, e.errorf(14, "%d bits per pixel is not supported, only 1, 4, 8, 24 or 32", bits)
+	}
+	offset := int(header.Size)
+	switch {
+	case header.Compression == biBitfields && bits == 32:
+		// The bit masks are assumed to be the usual ones for BGRA, and follow a BITMAPINFOHEADER
+		if header.Size == 40 {
+			offset += 12
+		}
+	case header.Compression != biRGB:
+		return nil, e.errorf(16, "the BMP data is compressed with method %d, which is not supported", header.Compression)
+	}
+
+	// The palette, as BGR0
+	var palette []color.NRGBA
+	if bits <= 8 {
+		count := int(header.ClrUsed)
+		if count == 0 || count > 1<<uint(bits) {
+			count = 1 << uint(bits)
+		}
+		if offset+count*4 > len(data) {
+			return nil, e.errorf(offset, "the palette is truncated, %d colors need %d bytes, but there are %d", count, count*4, len(data)-offset)
+		}
+		palette = make([]color.NRGBA, count)
+		for i := range palette {
+			p := data[offset+i*4:]
+			palette[i] = color.NRGBA{p[2], p[1], p[0], 0xff}
+		}
+		offset += count * 4
+	}
+
+	var (
+		xorStride = (width*bits + 31) / 32 * 4
+		andStride = (width + 31) / 32 * 4
+		xorStart  = offset
+		andStart  = xorStart + xorStride*height
+		hasMask   = andStart+andStride*height <= len(data)
+	)
+	if andStart > len(data) {
+		return nil, e.errorf(xorStart, "the pixel data is truncated, %d rows need %d bytes, but there are %d", height, xorStride*height, len(data)-xorStart)
+	}
+	if !hasMask && bits != 32 {
+		return nil, e.errorf(andStart, "the AND mask is truncated, %d rows need %d bytes, but there are %d", height, andStride*height, len(data)-andStart)
+	}
+
+	// The rows are stored bottom-up
+	var (
+		m        = image.NewNRGBA(image.Rect(0, 0, width, height))
+		useAlpha bool
+	)
+	for y := 0; y < height; y++ {
+		row := data[xorStart+(height-1-y)*xorStride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			switch bits {
+			case 24, 32:
+				i := x * bits / 8
+				c = color.NRGBA{row[i+2], row[i+1], row[i], 0xff}
+				if bits == 32 {
+					c.A = row[i+3]
+					useAlpha = useAlpha || c.A != 0
+				}
+			default:
+				// 1, 4 or 8 bits per pixel, the leftmost pixel in the highest bits
+				shift := uint(8 - bits - x*bits%8)
+				index := int(row[x*bits/8]>>shift) & (1<<uint(bits) - 1)
+				c = color.NRGBA{0, 0, 0, 0xff}
+				if index < len(palette) {
+					c = palette[index]
+				}
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	if bits == 32 && useAlpha {
+		return m, nil
+	}
+
+	// Apply the AND mask, or make 32-bit entries without a mask and without an alpha channel opaque
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*m.Stride + x*4
+			if !hasMask {
+				m.Pix[i+3] = 0xff
+				continue
+			}
+			mask := data[andStart+(height-1-y)*andStride+x/8]
+			if mask&(0x80>>uint(x%8)) != 0 {
+				m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3] = 0, 0, 0, 0
+			} else {
+				m.Pix[i+3] = 0xff
+			}
+		}
+	}
+	return m, nil
+}
+
+// ReadEntries reads the directory and the data of all entries in an .ico or .cur file, without decoding them
+func ReadEntries(r io.Reader) ([]Entry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var header Header
+	br := bytes.NewReader(data)
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("the header is truncated, there are %d of 6 bytes", len(data))
+	}
+	if header.Zero != 0 || (header.Type != TypeIcon && header.Type != TypeCursor) {
+		return nil, errors.New("not an .ico or .cur file")
+	}
+	if header.Number == 0 {
+		return nil, errors.New("the .ico file has no images")
+	}
+	dirEnd := 6 + 16*int64(header.Number)
+	if dirEnd > int64(len(data)) {
+		return nil, fmt.Errorf("the file is truncated, the directory of %d entries ends at byte %d, but the file is %d bytes", header.Number, dirEnd, len(data))
+	}
+	entries := make([]Entry, header.Number)
+	for i := range entries {
+		if err := binary.Read(br, binary.LittleEndian, &entries[i].Dir); err != nil {
+			return nil, fmt.Errorf("the directory entry of entry %d, at byte %d, is truncated", i+1, 6+16*i)
+		}
+		entries[i].number = i + 1
+	}
+	for i := range entries {
+		// Check that the entry is within the file before slicing the data
+		start, size := int64(entries[i].Dir.Offset), int64(entries[i].Dir.Size)
+		switch {
+		case start < dirEnd:
+			return nil, fmt.Errorf("entry %d starts at byte %d, within the directory, which ends at byte %d", i+1, start, dirEnd)
+		case start+size > int64(len(data)):
+			return nil, fmt.Errorf("entry %d is at bytes %d to %d, past the end of the file, which is %d bytes", i+1, start, start+size, len(data))
+		}
+		entries[i].Data = data[start : start+size]
+		if header.Type == TypeCursor {
+			// Move the hotspot out of the directory entry, so that it can be decoded like an .ico entry
+			entries[i].Cursor = true
+			entries[i].Hotspot = image.Pt(int(entries[i].Dir.Plane), int(entries[i].Dir.Bits))
+			entries[i].Dir.Plane, entries[i].Dir.Bits = 1, EntryBits(entries[i].Data)
+		}
+	}
+	return entries, nil
+}
+
+// WriteEntries writes an .ico file with the given entries, placing the image data right after the directory.
+// If the entries are cursor entries, a .cur file is written instead, with the hotspots in the directory.
+func WriteEntries(w io.Writer, entries []Entry) error {
+	header := Header{
+		0,
+		TypeIcon,
+		uint16(len(entries)),
+	}
+	if len(entries) > 0 && entries[0].Cursor {
+		header.Type = TypeCursor
+	}
+	bb := new(bytes.Buffer)
+	if err := binary.Write(bb, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	offset := uint32(6 + 16*len(entries))
+	for _, e := range entries {
+		e.Dir.Size = uint32(len(e.Data))
+		e.Dir.Offset = offset
+		offset += e.Dir.Size
+		if header.Type == TypeCursor {
+			e.Dir.Plane, e.Dir.Bits = uint16(e.Hotspot.X), uint16(e.Hotspot.Y)
+		}
+		if err := binary.Write(bb, binary.LittleEndian, e.Dir); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		bb.Write(e.Data)
+	}
+	_, err := w.Write(bb.Bytes())
+	return err
+}
+
+// EntryBits returns the number of bits per pixel of the encoded data of an .ico entry,
+// from the BITMAPINFOHEADER of BMP entries, or 32 for PNG entries
+func EntryBits(data []byte) uint16 {
+	if bytes.HasPrefix(data, PNGMagic) || len(data) < 16 {
+		return 32
+	}
+	return binary.LittleEndian.Uint16(data[14:16])
+}
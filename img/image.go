@@ -0,0 +1,378 @@
+package img
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// PNGMagic, ICOMagic and CURMagic are the first bytes of .png, .ico and .cur files
+var (
+	PNGMagic = []byte("\x89PNG\r\n\x1a\n")
+	ICOMagic = []byte{0, 0, 1, 0}
+	CURMagic = []byte{0, 0, 2, 0}
+)
+
+// Format is the format of an image, as detected from the first bytes by DetectFormat
+type Format int
+
+const (
+	// Format "enum"
+	FormatUnknown Format = iota // not a .png, .ico or .cur image
+	FormatPNG
+	FormatICO
+	FormatCUR
+)
+
+// DetectFormat returns the format that the first bytes of an image say it is, or FormatUnknown.
+// The first 8 bytes are needed for recognizing .png images.
+func DetectFormat(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, PNGMagic):
+		return FormatPNG
+	case bytes.HasPrefix(data, ICOMagic):
+		return FormatICO
+	case bytes.HasPrefix(data, CURMagic):
+		return FormatCUR
+	}
+	return FormatUnknown
+}
+
+// maxDecodeSize is the largest width and height of images that are decoded, so that a damaged file that says
+// that it has a huge image can not make the decoder allocate a huge buffer. Can be changed with SetMaxDecodeSize.
+var maxDecodeSize = DefaultMaxDecodeSize
+
+// TooLargeError is returned when an image is larger than MaxDecodeSize, before it is decoded
+type TooLargeError struct {
+	Width, Height int // the size of the image, from the header of the file
+	Max           int // the largest width and height that is decoded
+}
+
+// Error returns a message with the size of the image and the largest size that is decoded
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("the image is %dx%d, but images larger than %dx%d are not decoded", e.Width, e.Height, e.Max, e.Max)
+}
+
+// SetMaxDecodeSize sets the largest width and height of images that are decoded, in pixels.
+// It can not be smaller than MaxSize, the largest size that can be saved.
+func SetMaxDecodeSize(size int) error {
+	if size < MaxSize {
+		return fmt.Errorf("the largest size of images that are decoded must be at least %d, not %d", MaxSize, size)
+	}
+	maxDecodeSize = size
+	return nil
+}
+
+// MaxDecodeSize returns the largest width and height of images that are decoded, in pixels
+func MaxDecodeSize() int {
+	return maxDecodeSize
+}
+
+// CheckDecodeSize returns a *TooLargeError if an image with the given width and height is larger than
+// MaxDecodeSize, for decoders that can check the size before allocating the pixels
+func CheckDecodeSize(width, height int) error {
+	if width > maxDecodeSize || height > maxDecodeSize {
+		return &TooLargeError{width, height, maxDecodeSize}
+	}
+	return nil
+}
+
+// DecodePNG decodes a .png image, like png.Decode, but checks the size in the IHDR chunk with CheckDecodeSize first
+func DecodePNG(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckDecodeSize(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// Image is an image together with the mode that it is edited and saved in
+type Image struct {
+	image.Image               // the pixels, as they were decoded or drawn
+	Mode        Mode          // the mode that the image is saved in
+	Palette     []color.NRGBA // the colors in Palette mode, see MedianCut
+}
+
+// New returns an Image with the pixels of m, which is saved in the given mode, or in the mode that DetectMode
+// finds if mode is Blank. In Palette mode, the palette is found with MedianCut, leaving room for a transparent color.
+func New(m image.Image, mode Mode) *Image {
+	if mode == Blank {
+		mode = DetectMode(m)
+	}
+	im := &Image{Image: m, Mode: mode}
+	if mode == Palette {
+		maxColors := MaxPaletteColors
+		if HasTransparency(m) {
+			maxColors--
+		}
+		im.Palette, _ = MedianCut(m, maxColors)
+	}
+	return im
+}
+
+// Size returns the width and height of the image, in pixels
+func (m *Image) Size() image.Point {
+	return m.Bounds().Size()
+}
+
+// check checks that the image can be saved in its mode, and that it is not too large
+func (m *Image) check() error {
+	if !m.Mode.CanSave() {
+		return ErrCanNotSave
+	}
+	if size := m.Size(); size.X < 1 || size.Y < 1 || size.X > MaxSize || size.Y > MaxSize {
+		return fmt.Errorf("can not save a %dx%d image, the maximum size is %dx%d", size.X, size.Y, MaxSize, MaxSize)
+	}
+	return nil
+}
+
+// Saved returns the pixels as they are saved in the mode of the image. In Gray4 mode, the pixels get the
+// closest of the 16 shades, and in RGB mode, pixels that are not transparent become opaque. In Palette mode,
+// the pixels get the closest colors in the palette, and in Mono mode they become black or white, depending on
+// the threshold, see SetMonoThreshold. Both give an *image.Paletted, with a transparent color at the end of the
+// palette if there are transparent pixels. Images in RGBA mode are returned as they are.
+func (m *Image) Saved() image.Image {
+	switch m.Mode {
+	case Gray4, RGB:
+		var (
+			bounds = m.Bounds()
+			nm     = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				c := color.NRGBAModel.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+				if c.A != 0 && m.Mode == Gray4 {
+					shade, _ := GrayShade(c)
+					c = ShadeColor(shade)
+				} else if c.A != 0 {
+					c.A = 0xff
+				}
+				nm.SetNRGBA(x, y, c)
+			}
+		}
+		return nm
+	case Palette:
+		return m.paletted(func(c color.NRGBA) int {
+			return NearestIndex(m.Palette, c)
+		}, m.Palette)
+	case Mono:
+		return m.paletted(func(c color.NRGBA) int {
+			shade, _ := MonoShade(c)
+			return int(shade)
+		}, MonoColors)
+	}
+	return m.Image
+}
+
+// paletted converts the image to an image with the given palette, where index returns the palette index
+// of an opaque color, and a transparent color at the end of the palette, if there are transparent pixels
+func (m *Image) paletted(index func(c color.NRGBA) int, colors []color.NRGBA) *image.Paletted {
+	var (
+		bounds      = m.Bounds()
+		palette     = make(color.Palette, len(colors))
+		transparent = -1
+	)
+	for i, c := range colors {
+		palette[i] = c
+	}
+	pm := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBAModel.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			i := -1
+			if c.A != 0 {
+				i = index(c)
+			}
+			if i == -1 {
+				if transparent == -1 {
+					pm.Palette = append(pm.Palette, color.NRGBA{0, 0, 0, 0})
+					transparent = len(pm.Palette) - 1
+				}
+				i = transparent
+			}
+			pm.SetColorIndex(x, y, uint8(i))
+		}
+	}
+	return pm
+}
+
+// Decode reads a .png, .ico or .cur image, where the format is detected from the first bytes.
+// The largest image is read from .ico and .cur files with several images, see DecodeSize.
+// The mode is found with DetectMode.
+func Decode(r io.Reader) (*Image, error) {
+	return DecodeSize(r, 0)
+}
+
+// DecodeSize is like Decode, but reads the image with the given width and height from .ico and .cur files
+// with several images, or the largest one if size is 0
+func DecodeSize(r io.Reader, size int) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var m image.Image
+	switch DetectFormat(data) {
+	case FormatPNG:
+		m, err = DecodePNG(bytes.NewReader(data))
+	case FormatICO, FormatCUR:
+		m, err = decodeEntrySize(data, size)
+	default:
+		err = errors.New("not an .ico, .cur or .png image")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return New(m, Blank), nil
+}
+
+// decodeEntrySize decodes the entry of an .ico or .cur file with the given width and height,
+// or the largest entry if size is 0
+func decodeEntrySize(data []byte, size int) (image.Image, error) {
+	entries, err := ReadEntries(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return entries[LargestEntry(entries)].Decode()
+	}
+	index, err := FindEntry(entries, size)
+	if err != nil {
+		return nil, err
+	}
+	return entries[index].Decode()
+}
+
+// SizeError is returned when an .ico or .cur file has no image with the width and height that was asked for
+type SizeError struct {
+	Size  int           // the width and height that was asked for
+	Sizes []image.Point // the sizes of the images in the file
+}
+
+// Error returns a message with the size that was asked for and the sizes of the images in the file
+func (e *SizeError) Error() string {
+	sizes := make([]string, len(e.Sizes))
+	for i, size := range e.Sizes {
+		sizes[i] = fmt.Sprintf("%dx%d", size.X, size.Y)
+	}
+	return fmt.Sprintf("there is no %dx%d image, only %s", e.Size, e.Size, strings.Join(sizes, ", "))
+}
+
+// FindEntry returns the index of the entry with the given width and height, or a *SizeError if there is none
+func FindEntry(entries []Entry, size int) (int, error) {
+	var sizes []image.Point
+	for i, entry := range entries {
+		entrySize := entry.Size()
+		if entrySize.X == size && entrySize.Y == size {
+			return i, nil
+		}
+		sizes = append(sizes, entrySize)
+	}
+	return -1, &SizeError{size, sizes}
+}
+
+// LargestEntry returns the index of the entry with the largest width, or the first of them if several have
+// the same width. This is the entry that is read when no size is asked for.
+func LargestEntry(entries []Entry) int {
+	largest := 0
+	for i, entry := range entries {
+		if entry.Size().X > entries[largest].Size().X {
+			largest = i
+		}
+	}
+	return largest
+}
+
+// Encoder has the settings for writing .ico and .png images. The zero value uses the default compression level,
+// and writes .png images without interlacing and without text chunks, like png.Encode.
+type Encoder struct {
+	// CompressionLevel is the compression level of .png images and of .png encoded .ico entries
+	CompressionLevel png.CompressionLevel
+
+	// Interlace is true if .png images are written with Adam7 interlacing, which image/png can not do.
+	// Some .ico readers can not read interlaced .png entries, so .ico entries are never interlaced.
+	Interlace bool
+
+	// Text are the keywords and texts that are added to .png images as text chunks, but not to .ico entries
+	Text []Text
+}
+
+// defaultEncoder is the Encoder that is used by EncodePNG, EncodeICO and the other functions that are not methods
+var defaultEncoder Encoder
+
+// EncodePNG writes the image as a .png image, with the default settings of Encoder
+func EncodePNG(w io.Writer, m image.Image) error {
+	return defaultEncoder.EncodePNG(w, m)
+}
+
+// EncodeICO writes the image as an .ico image, with the default settings of Encoder
+func EncodeICO(w io.Writer, m image.Image) error {
+	return defaultEncoder.EncodeICO(w, m)
+}
+
+// EncodeICO writes the image to w as an .ico image with a single entry. If m is an *Image, it is saved in its mode,
+// see Saved and Mode.Bits, and if not, it is saved with 32 bits per pixel.
+func (enc *Encoder) EncodeICO(w io.Writer, m image.Image) error {
+	bits := uint16(32)
+	if im, ok := m.(*Image); ok {
+		if err := im.check(); err != nil {
+			return err
+		}
+		m, bits = im.Saved(), im.Mode.Bits()
+	}
+	return enc.encodeICO(w, m, bits)
+}
+
+// EncodeICOSizes writes the image to w as an .ico image with one entry per given size, which is scaled with
+// ScaleNearest, unless it already has that size. If m is an *Image, the entries are saved in its mode, like
+// with EncodeICO.
+func (enc *Encoder) EncodeICOSizes(w io.Writer, m image.Image, sizes []int) error {
+	var (
+		im, isImage = m.(*Image)
+		images      = make([]image.Image, len(sizes))
+		bits        = uint16(32)
+	)
+	if isImage {
+		if !im.Mode.CanSave() {
+			return ErrCanNotSave
+		}
+		m, bits = im.Image, im.Mode.Bits()
+	}
+	for i, size := range sizes {
+		scaled := m
+		if original := m.Bounds().Size(); original.X != size || original.Y != size {
+			scaled = ScaleNearest(m, size)
+		}
+		if isImage {
+			scaled = (&Image{scaled, im.Mode, im.Palette}).Saved()
+		}
+		images[i] = scaled
+	}
+	return enc.EncodeICOAll(w, images, bits)
+}
+
+// ScaleNearest scales the given image to a size x size image, using nearest neighbor scaling
+func ScaleNearest(m image.Image, size int) *image.NRGBA {
+	bounds := m.Bounds()
+	scaled := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/size
+			sy := bounds.Min.Y + y*bounds.Dy()/size
+			scaled.Set(x, y, m.At(sx, sy))
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,207 @@
+package img_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// modes are the modes that images can be saved in
+var modes = []img.Mode{img.Gray4, img.RGB, img.RGBA, img.Palette, img.Mono}
+
+// testImage returns a size x size image with colors, partially transparent pixels and a transparent column
+func testImage(size int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 1; x < size; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 255 / size), uint8(y * 255 / size), 0x80, uint8(0x80 + x*0x7f/size)})
+		}
+	}
+	return m
+}
+
+// samePixels checks that the two images have the same size and pixels. Transparent pixels are the same
+// regardless of their color.
+func samePixels(t *testing.T, name string, got, want image.Image) {
+	t.Helper()
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("%s: the image is %v, but wanted %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	gb, wb := got.Bounds(), want.Bounds()
+	for y := 0; y < gb.Dy(); y++ {
+		for x := 0; x < gb.Dx(); x++ {
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			w := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			if g != w && (g.A != 0 || w.A != 0) {
+				t.Fatalf("%s: the pixel at (%d,%d) is %v, but wanted %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	encoders := map[string]func(io.Writer, image.Image) error{
+		"png":            img.EncodePNG,
+		"ico":            img.EncodeICO,
+		"interlaced png": (&img.Encoder{Interlace: true}).EncodePNG,
+	}
+	for _, mode := range modes {
+		for _, size := range []int{16, 256} {
+			im := img.New(testImage(size), mode)
+			for format, encode := range encoders {
+				name := fmt.Sprintf("%dx%d %s %s", size, size, mode, format)
+				var buf bytes.Buffer
+				if err := encode(&buf, im); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				m, err := img.Decode(&buf)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				samePixels(t, name, m, im.Saved())
+			}
+		}
+	}
+}
+
+func TestRoundTripSizes(t *testing.T) {
+	sizes := []int{16, 32, 48}
+	for _, mode := range modes {
+		var (
+			buf bytes.Buffer
+			enc img.Encoder
+			im  = img.New(testImage(48), mode)
+		)
+		if err := enc.EncodeICOSizes(&buf, im, sizes); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		data := buf.Bytes()
+		for _, size := range sizes {
+			m, err := img.DecodeSize(bytes.NewReader(data), size)
+			if err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+			want := img.ScaleNearest(im.Image, size)
+			if size == 48 {
+				want = testImage(48)
+			}
+			samePixels(t, mode.String(), m, (&img.Image{Image: want, Mode: mode, Palette: im.Palette}).Saved())
+		}
+		if _, err := img.DecodeSize(bytes.NewReader(data), 64); err == nil {
+			t.Errorf("%s: decoded a 64x64 image that is not in the file", mode)
+		}
+		// Without a size, the largest image is read
+		if m, err := img.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		} else if m.Size() != image.Pt(48, 48) {
+			t.Errorf("%s: decoded the %v image, but wanted the largest one", mode, m.Size())
+		}
+	}
+}
+
+func TestRoundTripEntries(t *testing.T) {
+	var buf bytes.Buffer
+	if err := img.EncodeICO(&buf, img.New(testImage(32), img.Mono)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := img.ReadEntries(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Turn the .ico file into a .cur file, and read it back
+	entries[0].Cursor, entries[0].Hotspot = true, image.Pt(3, 5)
+	buf.Reset()
+	if err := img.WriteEntries(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := img.ReadEntries(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cursor) != 1 || !cursor[0].Cursor || cursor[0].Hotspot != image.Pt(3, 5) {
+		t.Fatalf("got %+v, but wanted a cursor entry with the hotspot at (3,5)", cursor)
+	}
+	if !bytes.Equal(cursor[0].Data, entries[0].Data) || cursor[0].Dir.Bits != 1 {
+		t.Errorf("the cursor entry has %d bits per pixel and %d bytes, but wanted 1 and %d", cursor[0].Dir.Bits, len(cursor[0].Data), len(entries[0].Data))
+	}
+	m, err := img.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePixels(t, "cur", m, img.New(testImage(32), img.Mono).Saved())
+}
+
+func TestEncodeCanNotSave(t *testing.T) {
+	im := &img.Image{Image: testImage(16), Mode: img.Blank}
+	if err := img.EncodePNG(io.Discard, im); !errors.Is(err, img.ErrCanNotSave) {
+		t.Errorf("EncodePNG: got %v, but wanted ErrCanNotSave", err)
+	}
+	if err := img.EncodeICO(io.Discard, im); !errors.Is(err, img.ErrCanNotSave) {
+		t.Errorf("EncodeICO: got %v, but wanted ErrCanNotSave", err)
+	}
+	if err := img.EncodeICO(io.Discard, img.New(testImage(img.MaxSize+1), img.RGBA)); err == nil {
+		t.Errorf("EncodeICO: saved an image that is larger than %d", img.MaxSize)
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	enc := img.Encoder{CompressionLevel: png.BestCompression, Text: []img.Text{{Key: "Author", Value: "Alex"}}}
+	var buf bytes.Buffer
+	if err := enc.EncodePNG(&buf, img.New(testImage(16), img.RGBA)); err != nil {
+		t.Fatal(err)
+	}
+	text, err := img.ReadText(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(text) != 1 || text[0] != enc.Text[0] {
+		t.Errorf("got %v, but wanted %v", text, enc.Text)
+	}
+	m, err := img.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePixels(t, "png with text", m, testImage(16))
+}
+
+func TestDetectFormat(t *testing.T) {
+	var ico, cur, pngData bytes.Buffer
+	if err := img.EncodeICO(&ico, img.New(testImage(16), img.RGBA)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := img.ReadEntries(bytes.NewReader(ico.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0].Cursor = true
+	if err := img.WriteEntries(&cur, entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := img.EncodePNG(&pngData, img.New(testImage(16), img.RGBA)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		format img.Format
+	}{
+		{"png", pngData.Bytes(), img.FormatPNG},
+		{"ico", ico.Bytes(), img.FormatICO},
+		{"cur", cur.Bytes(), img.FormatCUR},
+		{"short png", img.PNGMagic[:4], img.FormatUnknown},
+		{"bmp", []byte("BM\x00\x00\x00\x00"), img.FormatUnknown},
+		{"empty", nil, img.FormatUnknown},
+	}
+	for _, test := range tests {
+		if format := img.DetectFormat(test.data); format != test.format {
+			t.Errorf("%s: got format %d, but wanted %d", test.name, format, test.format)
+		}
+	}
+}
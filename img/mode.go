@@ -0,0 +1,122 @@
+package img
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+const (
+	// MaxSize is the largest width and height of an image that can be edited and saved, in pixels
+	MaxSize = 256
+
+	// MaxPaletteColors is the largest number of colors in Palette mode
+	MaxPaletteColors = 16
+
+	// DefaultMaxDecodeSize is the largest width and height of images that are decoded, unless it is changed
+	// with SetMaxDecodeSize
+	DefaultMaxDecodeSize = 1024
+)
+
+// Mode is how the pixels of an image are edited and saved
+type Mode int
+
+const (
+	// Mode "enum"
+	Blank   Mode = iota // no mode, for detecting the mode from the pixels with DetectMode
+	Gray4               // for 4-bit grayscale images
+	RGB                 // for 8+8+8 bit RGB images
+	RGBA                // for 8+8+8+8 bit RGBA images
+	Palette             // for indexed images with at most 16 colors
+	Mono                // for 1-bit black and white images
+)
+
+// ErrCanNotSave is returned when saving an image in a mode that can not be saved
+var ErrCanNotSave = errors.New("saving is only implemented for 4-bit grayscale, 16 color palette, 1-bit monochrome, RGB and RGBA images")
+
+// String returns the name of the mode
+func (mode Mode) String() string {
+	switch mode {
+	case Gray4:
+		return "gray4"
+	case RGB:
+		return "rgb"
+	case RGBA:
+		return "rgba"
+	case Palette:
+		return "palette"
+	case Mono:
+		return "mono"
+	default:
+		return "blank"
+	}
+}
+
+// CanSave checks if images in the given mode can be saved
+func (mode Mode) CanSave() bool {
+	return mode == Gray4 || mode == RGB || mode == RGBA || mode == Palette || mode == Mono
+}
+
+// Bits returns the number of bits per pixel of the BMP encoded .ico entries in the given mode.
+// Grayscale images and images with a palette are saved with 4 bits per pixel, monochrome images with 1
+// and the rest with 32.
+func (mode Mode) Bits() uint16 {
+	switch mode {
+	case Gray4, Palette:
+		return 4
+	case Mono:
+		return 1
+	}
+	return 32
+}
+
+// DetectMode finds the mode that is needed to represent the given image
+func DetectMode(m image.Image) Mode {
+	var colored bool
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A != 0 && c.A != 0xff {
+				// Partially transparent pixels are only kept in RGBA mode
+				return RGBA
+			}
+			if c.A != 0 && (c.R != c.G || c.G != c.B) {
+				colored = true
+			}
+		}
+	}
+	if colored {
+		return RGB
+	}
+	if IsMonochrome(m) {
+		return Mono
+	}
+	return Gray4
+}
+
+// HasTransparency checks if the given image has pixels that are fully transparent
+func HasTransparency(m image.Image) bool {
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPartialAlpha checks if the given image has pixels that are neither fully opaque nor fully transparent
+func HasPartialAlpha(m image.Image) bool {
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0 && a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package img
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// colorCount is a color and the number of pixels that have it
+type colorCount struct {
+	c color.NRGBA
+	n int
+}
+
+// channel returns the red, green or blue value of the color, for i 0, 1 or 2
+func (cc colorCount) channel(i int) uint8 {
+	switch i {
+	case 0:
+		return cc.c.R
+	case 1:
+		return cc.c.G
+	}
+	return cc.c.B
+}
+
+// widestChannel returns the color channel with the largest range in the box, and the range
+func widestChannel(box []colorCount) (int, int) {
+	widest, widestRange := 0, -1
+	for i := 0; i < 3; i++ {
+		lo, hi := 255, 0
+		for _, cc := range box {
+			v := int(cc.channel(i))
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > widestRange {
+			widest, widestRange = i, hi-lo
+		}
+	}
+	return widest, widestRange
+}
+
+// luma returns the perceived brightness of the color, for sorting the palette from dark to bright
+func luma(c color.NRGBA) int {
+	return 299*int(c.R) + 587*int(c.G) + 114*int(c.B)
+}
+
+// rgbValue returns the color as a single number, for sorting colors in a predictable order
+func rgbValue(c color.NRGBA) int {
+	return int(c.R)<<16 | int(c.G)<<8 | int(c.B)
+}
+
+// MedianCut finds a palette of at most max colors for the opaque pixels in the image, by using median cut
+// quantization: the colors are split in two at the median of the widest color channel, until there are max
+// groups, and the average color of each group is used. The palette is sorted from dark to bright.
+// Also returns the number of different colors in the image.
+func MedianCut(m image.Image, max int) ([]color.NRGBA, int) {
+	counts := make(map[color.NRGBA]int)
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			c.A = 0xff
+			counts[c]++
+		}
+	}
+	all := make([]colorCount, 0, len(counts))
+	for c, n := range counts {
+		all = append(all, colorCount{c, n})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return rgbValue(all[i].c) < rgbValue(all[j].c)
+	})
+
+	boxes := [][]colorCount{all}
+	if len(all) == 0 {
+		boxes = nil
+	}
+	for len(boxes) < max {
+		// Split the box with the widest color channel
+		chosen, channel, widestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if ch, r := widestChannel(box); r > widestRange {
+				chosen, channel, widestRange = i, ch, r
+			}
+		}
+		if chosen == -1 {
+			break
+		}
+		box := boxes[chosen]
+		sort.Slice(box, func(i, j int) bool {
+			if a, b := box[i].channel(channel), box[j].channel(channel); a != b {
+				return a < b
+			}
+			return rgbValue(box[i].c) < rgbValue(box[j].c)
+		})
+		// Split at the median pixel, keeping at least one color on each side
+		total := 0
+		for _, cc := range box {
+			total += cc.n
+		}
+		split, sum := 1, 0
+		for i, cc := range box[:len(box)-1] {
+			sum += cc.n
+			split = i + 1
+			if sum*2 >= total {
+				break
+			}
+		}
+		boxes = append(boxes, box[split:])
+		boxes[chosen] = box[:split]
+	}
+
+	palette := make([]color.NRGBA, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, b, n int
+		for _, cc := range box {
+			r += int(cc.c.R) * cc.n
+			g += int(cc.c.G) * cc.n
+			b += int(cc.c.B) * cc.n
+			n += cc.n
+		}
+		palette = append(palette, color.NRGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((b + n/2) / n), 0xff})
+	}
+	sort.Slice(palette, func(i, j int) bool {
+		if a, b := luma(palette[i]), luma(palette[j]); a != b {
+			return a < b
+		}
+		return rgbValue(palette[i]) < rgbValue(palette[j])
+	})
+	return palette, len(all)
+}
+
+// NearestIndex returns the index of the color in the palette that is closest to the given color,
+// or -1 if the palette is empty
+func NearestIndex(palette []color.NRGBA, c color.NRGBA) int {
+	nearest, nearestDistance := -1, 0
+	for i, pc := range palette {
+		dr, dg, db := int(c.R)-int(pc.R), int(c.G)-int(pc.G), int(c.B)-int(pc.B)
+		if distance := dr*dr + dg*dg + db*db; nearest == -1 || distance < nearestDistance {
+			nearest, nearestDistance = i, distance
+		}
+	}
+	return nearest
+}
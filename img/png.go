@@ -0,0 +1,322 @@
+package img
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+// Text is a keyword and a text from a tEXt, zTXt or iTXt chunk of a .png image, like Author and Alex
+type Text struct {
+	Key   string
+	Value string
+}
+
+// zlibLevel returns the zlib compression level for the given .png compression level
+func zlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	}
+	return zlib.DefaultCompression
+}
+
+// EncodePNG writes the image to w as a .png image, with the compression level of the encoder, or interlaced
+// if Interlace is true. The text chunks in Text are added. If m is an *Image, it is saved in its mode, see Saved.
+func (enc *Encoder) EncodePNG(w io.Writer, m image.Image) error {
+	if im, ok := m.(*Image); ok {
+		if err := im.check(); err != nil {
+			return err
+		}
+		m = im.Saved()
+	}
+	var buf bytes.Buffer
+	if enc.Interlace {
+		if err := encodeInterlacedPNG(&buf, m, enc.CompressionLevel); err != nil {
+			return err
+		}
+	} else if err := (&png.Encoder{CompressionLevel: enc.CompressionLevel}).Encode(&buf, m); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if len(enc.Text) > 0 {
+		var err error
+		if data, err = addPNGText(data, enc.Text); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// adam7Passes are the first column and row and the distance between the columns and rows of the
+// pixels in each of the seven passes of Adam7 interlacing
+var adam7Passes = []struct{ x, y, dx, dy int }{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// writePNGChunk writes a .png chunk with the given type and data, with the length first and the CRC last
+func writePNGChunk(buf *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	buf.WriteString(chunkType)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// encodeInterlacedPNG writes the image to w as an Adam7 interlaced .png image, which image/png can not do.
+// Images with a palette keep it and grayscale images stay grayscale, with 8 bits per pixel, while the rest
+// are written as 8-bit RGBA. The rows are not filtered, so the images are a little larger than with png.Encode.
+func encodeInterlacedPNG(w io.Writer, m image.Image, level png.CompressionLevel) error {
+	var (
+		b         = m.Bounds()
+		colorType byte
+		pixel     func(x, y int) []byte
+		pm, _     = m.(*image.Paletted)
+		gm, _     = m.(*image.Gray)
+		buf       bytes.Buffer
+	)
+	if pm != nil && len(pm.Palette) > 256 {
+		pm = nil
+	}
+	switch {
+	case pm != nil:
+		colorType = 3
+		pixel = func(x, y int) []byte { return []byte{pm.ColorIndexAt(x, y)} }
+	case gm != nil:
+		colorType = 0
+		pixel = func(x, y int) []byte { return []byte{gm.GrayAt(x, y).Y} }
+	default:
+		colorType = 6
+		pixel = func(x, y int) []byte {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			return []byte{c.R, c.G, c.B, c.A}
+		}
+	}
+
+	buf.Write(PNGMagic)
+	var ihdr bytes.Buffer
+	binary.Write(&ihdr, binary.BigEndian, uint32(b.Dx()))
+	binary.Write(&ihdr, binary.BigEndian, uint32(b.Dy()))
+	// 8 bits per sample, deflate compression, adaptive filtering and Adam7 interlacing
+	ihdr.Write([]byte{8, colorType, 0, 0, 1})
+	writePNGChunk(&buf, "IHDR", ihdr.Bytes())
+
+	if pm != nil {
+		var plte, trns []byte
+		opaque := true
+		for _, c := range pm.Palette {
+			nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+			plte = append(plte, nc.R, nc.G, nc.B)
+			trns = append(trns, nc.A)
+			if nc.A != 0xff {
+				opaque = false
+			}
+		}
+		writePNGChunk(&buf, "PLTE", plte)
+		if !opaque {
+			writePNGChunk(&buf, "tRNS", trns)
+		}
+	}
+
+	var idat bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&idat, zlibLevel(level))
+	if err != nil {
+		return err
+	}
+	for _, p := range adam7Passes {
+		// Passes without pixels, for small images, are left out
+		for y := b.Min.Y + p.y; y < b.Max.Y; y += p.dy {
+			if p.x >= b.Dx() {
+				break
+			}
+			row := []byte{0} // no filter
+			for x := b.Min.X + p.x; x < b.Max.X; x += p.dx {
+				row = append(row, pixel(x, y)...)
+			}
+			zw.Write(row)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	writePNGChunk(&buf, "IDAT", idat.Bytes())
+	writePNGChunk(&buf, "IEND", nil)
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// pngChunk is a chunk of a .png image, like IHDR or tEXt, without the length and the CRC
+type pngChunk struct {
+	name string
+	data []byte
+}
+
+// readPNGChunks splits a .png image into chunks, up to and including IEND, and checks the CRC of each chunk
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, PNGMagic) {
+		return nil, errors.New("not a .png image")
+	}
+	var chunks []pngChunk
+	for pos := len(PNGMagic); ; {
+		if len(data)-pos < 12 {
+			return nil, errors.New("the .png image is truncated, there is no IEND chunk")
+		}
+		length := binary.BigEndian.Uint32(data[pos:])
+		if uint64(length) > uint64(len(data)-pos-12) {
+			return nil, fmt.Errorf("the .png image is truncated, the %q chunk is %d bytes long", data[pos+4:pos+8], length)
+		}
+		var (
+			name = string(data[pos+4 : pos+8])
+			body = data[pos+8 : pos+8+int(length)]
+			crc  = binary.BigEndian.Uint32(data[pos+8+int(length):])
+		)
+		if crc32.ChecksumIEEE(data[pos+4:pos+8+int(length)]) != crc {
+			return nil, fmt.Errorf("the CRC of the %q chunk in the .png image is wrong", name)
+		}
+		chunks = append(chunks, pngChunk{name, body})
+		if name == "IEND" {
+			return chunks, nil
+		}
+		pos += 12 + int(length)
+	}
+}
+
+// writePNGChunks writes a .png image with the given chunks, with new lengths and CRCs
+func writePNGChunks(chunks []pngChunk) []byte {
+	var buf bytes.Buffer
+	buf.Write(PNGMagic)
+	for _, chunk := range chunks {
+		writePNGChunk(&buf, chunk.name, chunk.data)
+	}
+	return buf.Bytes()
+}
+
+// textChunk returns a tEXt chunk with the keyword and the text, or an iTXt chunk if the text is not ASCII,
+// since tEXt chunks are Latin-1 and iTXt chunks are UTF-8
+func textChunk(entry Text) pngChunk {
+	for _, r := range entry.Value {
+		if r > '~' {
+			// Uncompressed, without a language tag and a translated keyword
+			return pngChunk{"iTXt", []byte(entry.Key + "\x00\x00\x00\x00\x00" + entry.Value)}
+		}
+	}
+	return pngChunk{"tEXt", []byte(entry.Key + "\x00" + entry.Value)}
+}
+
+// addPNGText adds text chunks with the given keywords and texts to a .png image, right after the IHDR chunk
+func addPNGText(data []byte, entries []Text) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var withText []pngChunk
+	for _, chunk := range chunks {
+		withText = append(withText, chunk)
+		if chunk.name == "IHDR" {
+			for _, entry := range entries {
+				withText = append(withText, textChunk(entry))
+			}
+		}
+	}
+	return writePNGChunks(withText), nil
+}
+
+// latin1 converts Latin-1 encoded text, as in tEXt and zTXt chunks, to UTF-8
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// inflate decompresses the zlib compressed text of zTXt and iTXt chunks
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// pngTextEntries returns the keywords and texts of the tEXt, zTXt and iTXt chunks, in the order they appear.
+// Chunks that can not be read are skipped.
+func pngTextEntries(chunks []pngChunk) []Text {
+	var entries []Text
+	for _, chunk := range chunks {
+		i := bytes.IndexByte(chunk.data, 0)
+		if i < 1 {
+			continue
+		}
+		key, rest := string(chunk.data[:i]), chunk.data[i+1:]
+		switch chunk.name {
+		case "tEXt":
+			entries = append(entries, Text{key, latin1(rest)})
+		case "zTXt":
+			// The compression method, which is always 0, is followed by the compressed text
+			if len(rest) < 1 {
+				continue
+			}
+			text, err := inflate(rest[1:])
+			if err != nil {
+				continue
+			}
+			entries = append(entries, Text{key, latin1(text)})
+		case "iTXt":
+			// The compression flag and method are followed by the language tag and the translated keyword
+			if len(rest) < 2 {
+				continue
+			}
+			compressed := rest[0] == 1
+			fields := bytes.SplitN(rest[2:], []byte{0}, 3)
+			if len(fields) != 3 {
+				continue
+			}
+			text := fields[2]
+			if compressed {
+				var err error
+				if text, err = inflate(text); err != nil {
+					continue
+				}
+			}
+			entries = append(entries, Text{key, string(text)})
+		}
+	}
+	return entries
+}
+
+// ReadText reads the keywords and texts of the text chunks of a .png image, in the order they appear
+func ReadText(r io.Reader) ([]Text, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	return pngTextEntries(chunks), nil
+}
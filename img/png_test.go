@@ -0,0 +1,151 @@
+package img
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// parseChunks splits a .png image into the names and the data of its chunks, without using readPNGChunks,
+// and checks the length and the CRC of every chunk and that nothing follows the IEND chunk
+func parseChunks(t *testing.T, data []byte) ([]string, [][]byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, PNGMagic) {
+		t.Fatal("the .png signature is missing")
+	}
+	var (
+		names  []string
+		bodies [][]byte
+	)
+	for rest := data[len(PNGMagic):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			t.Fatalf("%d bytes are left after the %s chunk, which is too short for a chunk", len(rest), names[len(names)-1])
+		}
+		length := int(binary.BigEndian.Uint32(rest))
+		if length > len(rest)-12 {
+			t.Fatalf("the %q chunk is %d bytes, but only %d are left", rest[4:8], length, len(rest)-12)
+		}
+		name, body := string(rest[4:8]), rest[8:8+length]
+		if crc := binary.BigEndian.Uint32(rest[8+length:]); crc != crc32.ChecksumIEEE(rest[4:8+length]) {
+			t.Errorf("the CRC of the %s chunk is wrong", name)
+		}
+		names, bodies = append(names, name), append(bodies, body)
+		rest = rest[12+length:]
+		if name == "IEND" && len(rest) > 0 {
+			t.Errorf("there are %d bytes after the IEND chunk", len(rest))
+		}
+	}
+	return names, bodies
+}
+
+// withMetadata returns a .png image with a gAMA chunk and tEXt, zTXt and iTXt chunks, some before and some
+// after the IDAT chunk
+func withMetadata(t *testing.T, m image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("Made with a pencil"))
+	zw.Close()
+	gamma := make([]byte, 4)
+	binary.BigEndian.PutUint32(gamma, 45455)
+	chunks, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spliced []pngChunk
+	for _, chunk := range chunks {
+		switch chunk.name {
+		case "IDAT":
+			spliced = append(spliced, pngChunk{"gAMA", gamma}, pngChunk{"tEXt", []byte("Title\x00Old title")}, chunk)
+		case "IEND":
+			spliced = append(spliced,
+				pngChunk{"zTXt", append([]byte("Comment\x00\x00"), compressed.Bytes()...)},
+				pngChunk{"iTXt", []byte("Author\x00\x00\x00en\x00Author\x00Åse")},
+				chunk)
+		default:
+			spliced = append(spliced, chunk)
+		}
+	}
+	return writePNGChunks(spliced)
+}
+
+func TestAddPNGText(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 7)
+	}
+	data := withMetadata(t, m)
+	oldNames, oldBodies := parseChunks(t, data)
+
+	entries := []Text{{"Software", "fed"}, {"Description", "Blåbær"}}
+	spliced, err := addPNGText(data, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, bodies := parseChunks(t, spliced)
+
+	// The new chunks come right after IHDR, and the other chunks are kept in the same order, with the same data
+	wantNames := append([]string{"IHDR", "tEXt", "iTXt"}, oldNames[1:]...)
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("got the chunks %v, but wanted %v", names, wantNames)
+	}
+	if !bytes.Equal(bodies[0], oldBodies[0]) {
+		t.Error("the IHDR chunk was changed")
+	}
+	for i := range oldBodies[1:] {
+		if !bytes.Equal(bodies[3+i], oldBodies[1+i]) {
+			t.Errorf("the %s chunk was changed", names[3+i])
+		}
+	}
+
+	wantText := append(entries, Text{"Title", "Old title"}, Text{"Comment", "Made with a pencil"}, Text{"Author", "Åse"})
+	text, err := ReadText(bytes.NewReader(spliced))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(text, wantText) {
+		t.Errorf("got the text %q, but wanted %q", text, wantText)
+	}
+
+	// The pixels are the same
+	decoded, err := png.Decode(bytes.NewReader(spliced))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if got, want := color.NRGBAModel.Convert(decoded.At(x, y)), m.NRGBAAt(x, y); got != want {
+				t.Fatalf("the pixel at (%d,%d) is %v, but wanted %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestAddPNGTextBroken(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	wrongCRC := append([]byte{}, data...)
+	wrongCRC[len(PNGMagic)+8+13] ^= 0xff // the first byte of the CRC of IHDR
+	for name, broken := range map[string][]byte{
+		"not png":   []byte("GIF89a"),
+		"truncated": data[:len(data)-12],
+		"wrong CRC": wrongCRC,
+	} {
+		if _, err := addPNGText(broken, []Text{{"Title", "x"}}); err == nil {
+			t.Errorf("%s: added text to a broken .png image", name)
+		}
+	}
+}
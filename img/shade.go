@@ -0,0 +1,159 @@
+package img
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"unicode"
+)
+
+// DefaultRunes are the runes for the 16 grayscale shades, from the darkest shade to the brightest one
+const DefaultRunes = "_,.'-~+:*<=!%$@{"
+
+// DefaultMonoThreshold is the grayscale shade, from 0 to 15, from which pixels become white in Mono mode
+const DefaultMonoThreshold = 8
+
+var (
+	// 4-bit, 16-color grayscale grading by runes
+	// This map has room for improvement.
+	// I wanted it to
+	// - Not contain regular letters, to avoid confusion when someone typed in the lowercase/uppercase version of it
+	// - Make it easy to type the 0 and 15 value on most keyboard layouts
+	// - Not contain '?' or '#'
+	// - Have visible 0 values (not ' ')
+	// _,.'-~+:*<=!%{$@
+	// The table can be replaced with SetRunes.
+	lookupRunes = map[rune]byte{
+		'_':  0,
+		',':  1,
+		'.':  2,
+		'\'': 3,
+		'-':  4,
+		'~':  5,
+		'+':  6,
+		':':  7,
+		'*':  8,
+		'<':  9,
+		'=':  10,
+		'!':  11,
+		'%':  12,
+		'{':  15,
+		'$':  13,
+		'@':  14,
+	}
+
+	// monoThreshold is the grayscale shade, from 0 to 15, from which pixels become white when
+	// an image is converted to monochrome. Darker pixels become black. Can be changed with SetMonoThreshold.
+	monoThreshold byte = DefaultMonoThreshold
+
+	// MonoColors are the two colors in Mono mode, black and white
+	MonoColors = []color.NRGBA{{0, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0xff}}
+)
+
+// SetRunes replaces the 16 runes that are used for the grayscale shades, from the darkest shade to the
+// brightest one, for instance "_,.'-~+:*<=!%$@{". T is used for transparent pixels, and can not be used.
+func SetRunes(alphabet string) error {
+	runes := []rune(alphabet)
+	if len(runes) != 16 {
+		return fmt.Errorf("the shade runes %q must be exactly 16 runes, not %d", alphabet, len(runes))
+	}
+	table := make(map[rune]byte, 16)
+	for i, r := range runes {
+		switch {
+		case r == 'T':
+			return fmt.Errorf("the shade runes %q can not contain T, which is used for transparent pixels", alphabet)
+		case !unicode.IsPrint(r) || unicode.IsSpace(r):
+			return fmt.Errorf("the shade runes %q can only contain printable runes and no spaces", alphabet)
+		}
+		if _, found := table[r]; found {
+			return fmt.Errorf("the shade runes %q contain %c more than once", alphabet, r)
+		}
+		table[r] = byte(i)
+	}
+	lookupRunes = table
+	return nil
+}
+
+// RuneShade returns the grayscale shade, from 0 to 15, of the given rune, and false if it is not a shade rune
+func RuneShade(r rune) (byte, bool) {
+	shade, ok := lookupRunes[r]
+	return shade, ok
+}
+
+// ShadeRune returns the rune for the given grayscale shade, from 0 to 15, or 0 if there is none
+func ShadeRune(shade byte) rune {
+	for r, s := range lookupRunes {
+		if s == shade {
+			return r
+		}
+	}
+	return 0
+}
+
+// GrayShade returns the grayscale shade of the given color, from 0 to 15,
+// and false if the color is transparent
+func GrayShade(c color.Color) (byte, bool) {
+	r, g, b, a := c.RGBA()
+	// Found a luma formula here: https://riptutorial.com/go/example/31693/convert-color-image-to-grayscale
+	luma := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) * (255.0 / 65535)
+
+	// luma16 is 0..15
+	luma16 := int(math.Round(luma) / 16.0)
+	if luma16 > 15 {
+		luma16 = 15
+	}
+	return byte(luma16), a != 0
+}
+
+// ShadeColor returns the color that the given grayscale shade, from 0 to 15, is drawn and saved with
+func ShadeColor(shade byte) color.NRGBA {
+	intensity := shade*16 + 15 // from 0..15 to 15..255
+	return color.NRGBA{intensity, intensity, intensity, 0xff}
+}
+
+// SetMonoThreshold sets the grayscale shade, from 0 to 15, from which pixels become white in Mono mode
+func SetMonoThreshold(threshold int) error {
+	if threshold < 0 || threshold > 15 {
+		return fmt.Errorf("the threshold must be a shade from 0 to 15, not %d", threshold)
+	}
+	monoThreshold = byte(threshold)
+	return nil
+}
+
+// MonoThreshold returns the grayscale shade, from 0 to 15, from which pixels become white in Mono mode
+func MonoThreshold() byte {
+	return monoThreshold
+}
+
+// MonoShade returns 1 if the given color is white in Mono mode and 0 if it is black,
+// and false if the color is transparent
+func MonoShade(c color.Color) (byte, bool) {
+	shade, opaque := GrayShade(c)
+	if shade >= monoThreshold {
+		return 1, opaque
+	}
+	return 0, opaque
+}
+
+// IsMonochrome checks if all the pixels that are not transparent are either pure black or pure white,
+// and that the image has both, so that it can be edited in Mono mode without losing anything
+func IsMonochrome(m image.Image) bool {
+	var black, white bool
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			switch color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA) {
+			case MonoColors[0]:
+				black = true
+			case MonoColors[1]:
+				white = true
+			default:
+				if _, _, _, a := m.At(x, y).RGBA(); a != 0 {
+					return false
+				}
+			}
+		}
+	}
+	return black && white
+}
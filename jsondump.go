@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// PixelDump is an image as JSON, with one number per pixel, for -json and -from-json. The pixels are rows of
+// numbers, from the top. The numbers depend on the mode, and -1 is a transparent pixel:
+//
+//	gray4:   the grayscale shade, from 0 to 15
+//	mono:    0 for black and 1 for white
+//	palette: the index of the color in the palette, where the palette is a list of colors like "#rrggbb"
+//	rgb:     the color as 0xrrggbb
+//	rgba:    the color as 0xrrggbbaa
+type PixelDump struct {
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Mode    string    `json:"mode"`
+	Palette []string  `json:"palette,omitempty"`
+	Pixels  [][]int64 `json:"pixels"`
+}
+
+// NewPixelDump converts the textual representation of an image to a pixel dump
+func NewPixelDump(mode Mode, size image.Point, text string) (PixelDump, error) {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return PixelDump{}, err
+	}
+	d := PixelDump{Width: size.X, Height: size.Y, Mode: mode.String(), Pixels: make([][]int64, size.Y)}
+	if mode == modePalette {
+		// textToImage has read the palette from the legend
+		for _, c := range paletteColors {
+			d.Palette = append(d.Palette, fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+		}
+	}
+	for y := range d.Pixels {
+		d.Pixels[y] = make([]int64, size.X)
+		for x := range d.Pixels[y] {
+			d.Pixels[y][x] = pixelNumber(mode, m.NRGBAAt(x, y))
+		}
+	}
+	return d, nil
+}
+
+// pixelNumber returns the number for the given color in a pixel dump, see PixelDump
+func pixelNumber(mode Mode, c color.NRGBA) int64 {
+	if c.A == 0 {
+		return -1
+	}
+	switch mode {
+	case modeRGB:
+		return int64(c.R)<<16 | int64(c.G)<<8 | int64(c.B)
+	case modeRGBA:
+		return int64(c.R)<<24 | int64(c.G)<<16 | int64(c.B)<<8 | int64(c.A)
+	case modePalette:
+		return int64(img.NearestIndex(paletteColors, c))
+	case modeMono:
+		shade, _ := img.MonoShade(c)
+		return int64(shade)
+	default:
+		shade, _ := img.GrayShade(c)
+		return int64(shade)
+	}
+}
+
+// maxPixelNumber returns the largest number that a pixel can have in a pixel dump, in the given mode
+func maxPixelNumber(mode Mode, paletteSize int) int64 {
+	switch mode {
+	case modeRGB:
+		return 0xffffff
+	case modeRGBA:
+		return 0xffffffff
+	case modePalette:
+		return int64(paletteSize) - 1
+	case modeMono:
+		return 1
+	default:
+		return 15
+	}
+}
+
+// pixelColor returns the color for the given number in a pixel dump, see PixelDump
+func pixelColor(mode Mode, n int64, palette []color.NRGBA) color.NRGBA {
+	if n == -1 {
+		return color.NRGBA{0, 0, 0, 0}
+	}
+	switch mode {
+	case modeRGB:
+		return color.NRGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 0xff}
+	case modeRGBA:
+		return color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}
+	case modePalette:
+		return palette[n]
+	case modeMono:
+		return img.MonoColors[n]
+	default:
+		return img.ShadeColor(byte(n))
+	}
+}
+
+// Encode writes the pixel dump to w as JSON, with one row of pixels per line
+func (d PixelDump) Encode(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\n  \"width\": %d,\n  \"height\": %d,\n  \"mode\": %q,\n", d.Width, d.Height, d.Mode)
+	if len(d.Palette) > 0 {
+		colors := make([]string, len(d.Palette))
+		for i, s := range d.Palette {
+			colors[i] = strconv.Quote(s)
+		}
+		buf.WriteString("  \"palette\": [" + strings.Join(colors, ", ") + "],\n")
+	}
+	buf.WriteString("  \"pixels\": [\n")
+	for y, row := range d.Pixels {
+		numbers := make([]string, len(row))
+		for x, n := range row {
+			numbers[x] = strconv.FormatInt(n, 10)
+		}
+		buf.WriteString("    [" + strings.Join(numbers, ", ") + "]")
+		if y < len(d.Pixels)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("  ]\n}\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Text checks the pixel dump and converts it to a textual representation of the image. The errors say
+// where the problem is, like "pixel 3,1 is 16, but must be from -1 to 15 in gray4 mode".
+func (d PixelDump) Text() (Mode, image.Point, string, error) {
+	mode, err := modeFromName(d.Mode)
+	if err != nil {
+		return modeBlank, image.Point{}, "", err
+	}
+	if d.Width != d.Height {
+		return modeBlank, image.Point{}, "", fmt.Errorf("the size is %dx%d, but only square images are supported", d.Width, d.Height)
+	}
+	if d.Width < 1 || d.Width > img.MaxSize {
+		return modeBlank, image.Point{}, "", fmt.Errorf("the size is %dx%d, but it must be from 1x1 to %dx%d", d.Width, d.Height, img.MaxSize, img.MaxSize)
+	}
+	var palette []color.NRGBA
+	if mode == modePalette {
+		if len(d.Palette) == 0 || len(d.Palette) > img.MaxPaletteColors {
+			return modeBlank, image.Point{}, "", fmt.Errorf("the palette has %d colors, but must have from 1 to %d", len(d.Palette), img.MaxPaletteColors)
+		}
+		for i, s := range d.Palette {
+			c, err := parseOpColor(s)
+			if err != nil || len(s) != len("#rrggbb") {
+				return modeBlank, image.Point{}, "", fmt.Errorf("palette entry %d is %q, which is not a color on the form #rrggbb", i, s)
+			}
+			palette = append(palette, c)
+		}
+	} else if len(d.Palette) > 0 {
+		return modeBlank, image.Point{}, "", fmt.Errorf("only images in palette mode have a palette, not images in %s mode", mode)
+	}
+	if len(d.Pixels) != d.Height {
+		return modeBlank, image.Point{}, "", fmt.Errorf("there are %d rows of pixels, but the height is %d", len(d.Pixels), d.Height)
+	}
+	var (
+		m   = image.NewNRGBA(image.Rect(0, 0, d.Width, d.Height))
+		max = maxPixelNumber(mode, len(palette))
+	)
+	for y, row := range d.Pixels {
+		if len(row) != d.Width {
+			return modeBlank, image.Point{}, "", fmt.Errorf("row %d has %d pixels, but the width is %d", y, len(row), d.Width)
+		}
+		for x, n := range row {
+			if n < -1 || n > max {
+				return modeBlank, image.Point{}, "", fmt.Errorf("pixel %d,%d is %d, but must be from -1 to %d in %s mode", x, y, n, max, mode)
+			}
+			m.SetNRGBA(x, y, pixelColor(mode, n, palette))
+		}
+	}
+	if mode == modePalette {
+		// Keep the colors in the order of the pixel dump
+		paletteColors = palette
+	}
+	return mode, image.Pt(d.Width, d.Height), string(pixelsToText(m, mode)), nil
+}
+
+// jsonError adds the line and column of the problem to errors from decoding JSON, when they are known
+func jsonError(filename string, data []byte, err error) error {
+	var offset int64 = -1
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		offset = err.Offset
+	case *json.UnmarshalTypeError:
+		offset = err.Offset
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return fmt.Errorf("%s is not a pixel dump: %s", filename, err)
+	}
+	// The offset is just after the byte where the problem was found
+	before := data[:offset]
+	if offset > 0 {
+		before = data[:offset-1]
+	}
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("%s:%d:%d: %s", filename, line, column, err)
+}
+
+// ReadPixelDump reads a pixel dump from a JSON file and converts it to a textual representation of the image
+func ReadPixelDump(filename string) (Mode, image.Point, string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return modeBlank, image.Point{}, "", err
+	}
+	var d PixelDump
+	if err := json.Unmarshal(data, &d); err != nil {
+		return modeBlank, image.Point{}, "", jsonError(filename, data, err)
+	}
+	mode, size, text, err := d.Text()
+	if err != nil {
+		return modeBlank, image.Point{}, "", fmt.Errorf("%s: %s", filename, err)
+	}
+	return mode, size, text, nil
+}
+
+// PrintJSON reads an .ico or .png image and writes it to w as a pixel dump, see PixelDump.
+// If size is not 0, that image is read from .ico files with several images.
+func PrintJSON(filename string, w io.Writer, mode Mode, size int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	im, err := img.DecodeSize(f, size)
+	if err != nil {
+		return decodeError(filename, err)
+	}
+	mode, imageSize, data, _, err := imageToText(im.Image, filename, true, mode)
+	if err != nil {
+		return err
+	}
+	d, err := NewPixelDump(mode, imageSize, string(data))
+	if err != nil {
+		return err
+	}
+	return d.Encode(w)
+}
+
+// ConvertFromJSON reads a pixel dump from a JSON file and writes it as an .ico, .cur, .png or .icns image,
+// depending on the extension of the output filename. If bundle is true, .ico files are written with all the
+// sizes in bundleSizes.
+func ConvertFromJSON(jsonFilename, outFilename string, bundle bool) error {
+	if err := checkOutputFilename(outFilename); err != nil {
+		return withCode(err, exitUsage)
+	}
+	mode, size, text, err := ReadPixelDump(jsonFilename)
+	if err != nil {
+		return withCode(err, exitDecode)
+	}
+	return withCode(writeText(mode, size, text, outFilename, image.Point{}, bundle), exitEncode)
+}
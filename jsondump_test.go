@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPixelDumpRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.json")
+	for _, mode := range testModes {
+		text := testText(t, mode, 16)
+		d, err := NewPixelDump(mode, image.Pt(16, 16), text)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		var buf bytes.Buffer
+		if err := d.Encode(&buf); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gotMode, size, got, err := ReadPixelDump(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if gotMode != mode || size != image.Pt(16, 16) || got != text {
+			t.Errorf("%s: got a %v %s image\n%s\nbut wanted\n%s", mode, size, gotMode, got, text)
+		}
+	}
+}
+
+func TestReadPixelDumpErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.json")
+	tests := []struct {
+		name string
+		dump string
+		err  string // the error, after the filename
+	}{
+		{"short row", `{"width": 2, "height": 2, "mode": "gray4", "pixels": [[0, 1], [2]]}`, ": row 1 has 1 pixels, but the width is 2"},
+		{"long row", `{"width": 2, "height": 2, "mode": "rgb", "pixels": [[0, 1, 2], [2, 3]]}`, ": row 0 has 3 pixels, but the width is 2"},
+		{"missing row", `{"width": 2, "height": 2, "mode": "gray4", "pixels": [[0, 1]]}`, ": there are 1 rows of pixels, but the height is 2"},
+		{"gray4", `{"width": 2, "height": 2, "mode": "gray4", "pixels": [[0, 16], [0, 0]]}`, ": pixel 1,0 is 16, but must be from -1 to 15 in gray4 mode"},
+		{"rgb", `{"width": 2, "height": 2, "mode": "rgb", "pixels": [[0, 0], [-2, 0]]}`, ": pixel 0,1 is -2, but must be from -1 to 16777215 in rgb mode"},
+		{"mono", `{"width": 2, "height": 2, "mode": "mono", "pixels": [[0, 1], [1, 2]]}`, ": pixel 1,1 is 2, but must be from -1 to 1 in mono mode"},
+		{"palette", `{"width": 1, "height": 1, "mode": "palette", "palette": ["#000000", "#ffffff"], "pixels": [[2]]}`, ": pixel 0,0 is 2, but must be from -1 to 1 in palette mode"},
+		{"syntax", "{\n  \"width\": 2,\n  \"height\": 2 x\n}", ":3:15: invalid character 'x' after object key:value pair"},
+		{"type", "{\n  \"width\": 2,\n  \"height\": 2,\n  \"mode\": 4\n}", ":4:11: json: cannot unmarshal number into Go struct field PixelDump.mode of type string"},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filename, []byte(test.dump), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, _, _, err := ReadPixelDump(filename)
+		if err == nil {
+			t.Errorf("%s: read the broken pixel dump %q", test.name, test.dump)
+		} else if got := err.Error(); got != filename+test.err {
+			t.Errorf("%s: got the error %q, but wanted %q", test.name, strings.TrimPrefix(got, filename), test.err)
+		}
+	}
+}
@@ -4,46 +4,134 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 	"unicode"
 
 	"github.com/atotto/clipboard"
+	"github.com/xyproto/favicon/img"
 	"github.com/xyproto/vt100"
 )
 
 const version = "favicon 1.0.0"
 
+// undoSize is how many undo snapshots are kept
+const undoSize = 8192
+
 func main() {
 	var (
-		// Color scheme for the "text edit" mode
-		defaultEditorForeground      = vt100.LightGreen
-		defaultEditorBackground      = vt100.BackgroundDefault
-		defaultStatusForeground      = vt100.White
-		defaultStatusBackground      = vt100.BackgroundBlack
-		defaultStatusErrorForeground = vt100.LightRed
-		defaultStatusErrorBackground = vt100.BackgroundDefault
-		defaultEditorSearchHighlight = vt100.LightMagenta
-
-		versionFlag = flag.Bool("version", false, "show version information")
-		helpFlag    = flag.Bool("help", false, "show simple help")
+		versionFlag      = flag.Bool("version", false, "show version information")
+		helpFlag         = flag.Bool("help", false, "show simple help")
+		rgbFlag          = flag.Bool("rgb", false, "edit the image as 8+8+8 bit RGB")
+		rgbaFlag         = flag.Bool("rgba", false, "edit the image as 8+8+8+8 bit RGBA")
+		grayFlag         = flag.Bool("gray", false, "edit the image as 16 color grayscale")
+		paletteFlag      = flag.Bool("palette", false, "edit the image with a palette of at most 16 colors")
+		monoFlag         = flag.Bool("mono", false, "edit the image as 1-bit black and white")
+		thresholdFlag    = flag.Int("threshold", img.DefaultMonoThreshold, "the grayscale shade from 0 to 15 from which pixels become white, for -mono")
+		bundleFlag       = flag.Bool("bundle", false, "save .ico files with 16x16, 32x32 and 48x48 images")
+		outFlag          = flag.String("out", "", "write all the favicons a web site needs to this directory, for -bundle, or the image file for -letter, -apply and -from-json, then quit")
+		forceFlag        = flag.Bool("force", false, "overwrite existing files when writing favicons with -bundle and -out")
+		convertFlag      = flag.Bool("convert", false, "convert the first image to the second image, then quit")
+		stdinFlag        = flag.Bool("stdin", false, "read the image from stdin and write it to stdout, then quit")
+		stdoutFlag       = flag.Bool("stdout", false, "write the image to stdout, then quit")
+		toFlag           = flag.String("to", "", "the image format to write to stdout (png, ico or ff), or to convert to with -batch")
+		watchFlag        = flag.String("watch", "", "convert this image to the -to file or format each time it changes, until ctrl-c is pressed")
+		batchFlag        = flag.String("batch", "", "convert all files that match this glob pattern to the -to format, in the -out-dir directory, then quit")
+		outDirFlag       = flag.String("out-dir", ".", "the directory to write the converted files to, for -batch")
+		downloadOnlyFlag = flag.Bool("download-only", false, "download the image from the given URL, save it and quit")
+		backupFlag       = flag.Bool("backup", false, "copy files to filename~ before overwriting them")
+		runesFlag        = flag.String("runes", "", "the 16 runes that are used for the shades in grayscale mode, from dark to bright")
+		icnsFlag         = flag.Bool("icns", false, "save the image as an .icns file for macOS next to it, then quit")
+		xpmFlag          = flag.Bool("xpm", false, "print the image as a grayscale .xpm image, then quit")
+		xbmFlag          = flag.Bool("xbm", false, "print the image as a black and white .xbm image, then quit")
+		goFlag           = flag.Bool("go", false, "print the image as Go source code with an .ico image, then quit")
+		goPackageFlag    = flag.String("gopackage", "main", "the package name of the exported Go source code")
+		goVarFlag        = flag.String("govar", "FaviconICO", "the variable name of the exported Go source code")
+		cHeaderFlag      = flag.Bool("c-header", false, "print a C header with the image as an .ico image, then quit")
+		generateFlag     = flag.String("generate", "", "save a new image with a pattern: noise, checkerboard, hstripes, vstripes or radial, then quit")
+		periodFlag       = flag.Int("period", 2, "the width of the squares and stripes, for -generate")
+		seedFlag         = flag.Int64("seed", 0, "the seed for the random noise, for reproducible images (the default is to use the time)")
+		letterFlag       = flag.String("letter", "", "save a new image with this letter from the built-in 8x8 font, to the -out file, then quit")
+		boldFlag         = flag.Bool("bold", false, "make the letter bold, for -letter")
+		stampsFlag       = flag.String("stamps", "", "load more stamps for V from the .txt files in this directory")
+		refFlag          = flag.String("ref", "", "show this image to the right of the image that is edited, for comparing and tracing")
+		htmlFlag         = flag.Bool("html", false, "print the HTML link tags for the .ico and .png images that have been saved, then quit")
+		manifestFlag     = flag.Bool("manifest", false, "also write a site.webmanifest file and link to it, for -html and H")
+		ansiFlag         = flag.Bool("ansi", false, "print the image with colored half block characters, then quit")
+		exportANSIFlag   = flag.String("export-ansi", "", "save the image as ANSI art to this file, or as a shell script if it ends with .sh, then quit")
+		truecolorFlag    = flag.Bool("truecolor", false, "use 24-bit colors for -ansi and -export-ansi, instead of the 256 color palette")
+		scaleFlag        = flag.Bool("scale", false, "scale larger images down to 16x16 when loading them")
+		maxLoadSizeFlag  = flag.Int("max-load-size", img.DefaultMaxDecodeSize, "the largest width and height of images that are loaded, for -scale")
+		sizesFlag        = flag.String("sizes", "32,48,64,180", "the sizes of the .png images that are exported with P, separated by commas")
+		typeFlag         = flag.String("type", "auto", "the image format of the file: ico, cur, png, pgm, favtxt, xbm, ff or auto for detecting it")
+		sizeFlag         = flag.Int("size", 0, "edit the image with this width and height, for .ico files with several images")
+		themeFlag        = flag.String("theme", "auto", "the display profile: dark, light, high-contrast, colorblind or auto for detecting a light background from $COLORFGBG")
+		postSaveFlag     = flag.String("post-save", "", "run this command after saving, where {} is replaced by the filename, like \"optipng {}\"")
+		serveFlag        = flag.String("serve", "", "serve a web page with a live preview of the image at this address, like :8080")
+		recordFlag       = flag.String("record", "", "record the operations that change the image to this operations script")
+		applyFlag        = flag.String("apply", "", "replay this operations script on the -in image and write the result to the -out file, then quit")
+		inFlag           = flag.String("in", "", "the image to replay the operations on, for -apply")
+		jsonFlag         = flag.Bool("json", false, "print the image as JSON, with one number per pixel, then quit")
+		fromJSONFlag     = flag.String("from-json", "", "write the image in this JSON pixel dump to the -out file, then quit")
+		pngCompressFlag  = flag.String("png-compression", "default", "the compression level of .png images: none, fast, default or best")
+		pngInterlaceFlag = flag.Bool("png-interlace", false, "write .png images with Adam7 interlacing")
+		validateFlag     = flag.Bool("validate", false, "check that the given .ico and .png files are well-formed favicons, then quit")
+		requireAlphaFlag = flag.Bool("require-alpha", false, "also check that some pixels are transparent, for -validate")
+		quietFlag        = flag.Bool("quiet", false, "only print errors and warnings, not which files have been saved, when not using the terminal")
 
 		statusDuration = 2700 * time.Millisecond
 
-		copyLine   string // for the cut/copy/paste functionality
-		statusMode bool   // if information should be shown at the bottom
+		metaFlags metaList // the keywords and texts for -meta, which can be given several times
+
+		copyLine   string          // for the cut/copy/paste functionality
+		copyBlock  [][]color.NRGBA // for the block cut/copy/paste functionality
+		statusMode bool            // if information should be shown at the bottom
 
 		clearOnQuit bool // clear the terminal when quitting, or not
 
 		mode Mode // an "enum"/int signalling if this file should be in git mode, markdown mode etc
 	)
 
+	flag.Var(&metaFlags, "meta", "add a text chunk like \"Author=Alex\" to the .png images that are written, can be given several times")
 	flag.Parse()
 
+	// The messages about which files have been saved are not printed, with -quiet
+	var info io.Writer = os.Stdout
+	if *quietFlag {
+		info = ioutil.Discard
+	}
+
+	// Images that are larger than 16x16 are scaled down when loading them, with -scale
+	scale := 0
+	if *scaleFlag {
+		scale = blankSize
+	}
+
+	// If no mode is given, it is detected from the image contents when loading
+	if *rgbaFlag {
+		mode = modeRGBA
+	} else if *rgbFlag {
+		mode = modeRGB
+	} else if *paletteFlag {
+		mode = modePalette
+	} else if *monoFlag {
+		mode = modeMono
+	} else if *grayFlag || *scaleFlag {
+		// Images that are scaled down are edited as grayscale, unless another mode is given
+		mode = modeGray4
+	}
+
 	if *versionFlag {
 		fmt.Println(version)
 		return
@@ -54,162 +142,758 @@ func main() {
 		fmt.Print(`
 Hotkeys
 
-ctrl-q     to quit
+ctrl-q     to quit, asking to save any changes first (press ctrl-q twice to quit without saving)
 ctrl-s     to save
+ctrl-o     to save as another .ico, .cur or .png file, with tab completion of the filename,
+           or to export an .icns file for macOS if the filename ends with .icns
+ctrl-f     to save even if some pixels are invalid (unknown shades are saved as black)
 ctrl-a     go to start of line, then start of text and then the previous line
 ctrl-e     go to end of line and then the next line
 ctrl-p     to scroll up 10 lines
-ctrl-n     to scroll down 10 lines or go to the next match if a search is active
+ctrl-n     to scroll down 10 lines or go to the next match if a search is active, wrapping around at the end
 ctrl-k     to delete characters to the end of the line, then delete the line
-ctrl-g     to toggle filename/line/column/unicode/word count status display
+ctrl-g     to toggle a status display with the filename, the x and y of the pixel, its shade and value,
+           if it is transparent and how many pixels are opaque (line/column/word count outside of the image)
 ctrl-d     to delete a single character
 ctrl-x     to cut the current line
 ctrl-c     to copy the current line
-ctrl-v     to paste the current line
-ctrl-u     to undo
-ctrl-l     to jump to a specific line
+ctrl-v     to paste the current line, or replace the image with a PNG image from the clipboard,
+           where several lines overwrite the rows of the image from the row of the cursor and down
+ctrl-u     to undo, where pixels typed less than two seconds apart are undone together
+ctrl-z     to suspend (fg continues)
+tab        to switch to the next file, when more than one file is given, shift-tab for the previous one
+ctrl-l     to jump to a specific line, or to a pixel, like 11,6, when editing an image
 esc        to redraw the screen and clear the last search
+ctrl-r     to toggle drawing the pixels with their real colors (transparent pixels as a checkerboard)
+ctrl-b     to copy the image to the clipboard as a data:image/png;base64 URI
+ctrl-w     to erase the previous pixel by making it transparent, like backspace
+ctrl-t     to toggle the pen, which paints with the brush at each pixel the cursor moves to
 ctrl-space to export to the other image format
 ctrl-~     to save and quit + clear the terminal
+I          to switch to the next display profile: dark, light, high-contrast or colorblind
+q          to save a copy of the image in a snapshot slot, followed by a digit from 0 to 9
+;          to replace the image with the copy in a snapshot slot, followed by a digit
+&          to copy all the rows of pixels to the clipboard as text, without the legend
+?          to open the command palette, for running an operation by name, like "invert" or "save",
+           or "export-ansi" for saving the image as ANSI art next to the file, or "png-text" for
+           showing the text chunks of a .png image
+
+Drawing tools, using the brush (the color of the pixel that was picked or typed in last)
+
+p          to pick the color under the cursor as the brush (transparent is the eraser)
+#          followed by a number from 0 to 15 (and return, for 1) to select a shade from the palette as the brush
+o          to stamp the brush at the cursor
+l          to mark the start of a line, then again to draw the line
+r          to mark a corner of a rectangle, then again to draw it, or R to fill it
+m          to mark a corner of a block, then y to copy or X to cut it (from the mark to the cursor)
+v          to paste the block at the cursor
+g          to show the image as a bitmap in the top right corner (kitty graphics or sixels), esc removes it
+n          to toggle the pixel coordinates to the left of and above the image
+t          to toggle between showing transparent pixels as a checker pattern or as they are stored
+z          to zoom in to 2x or 3x, or back to 1x
+h          to toggle a preview of the image with colored half block characters
+i          to invert the image
+L          to load the file again, discarding the undo history
+S          to set the hotspot of a .cur cursor, the pixel that points (shown in yellow)
+P          to export .png images in the sizes given by -sizes, like favicon-32.png
+W          to export a grayscale .xpm image, for C source code
+H          to copy the HTML link tags for the saved .ico and .png images (and write site.webmanifest, with -manifest)
+G          to export Go source code with the image as an .ico image, like favicon_ico.go
+w / s      to make the image one step brighter or darker
+k / K      to increase or decrease the contrast of the image by one step
+x          to replace all pixels of one shade or color with another
+Y          to trim the image to the pixels that are not transparent or black, scaled up and centered
+M          to move the pixels that are not transparent or black to the middle of the image
+shift+arrow  to shift the image one pixel, shifting in transparent pixels at the edge
+J          to toggle between shifting in transparent pixels and wrapping around, for shift+arrow
+N          to fill the image with a pattern: noise, checkerboard, hstripes, vstripes or radial
+O          to draw a letter from the built-in 8x8 font with the brush, scaled up and centered, regular or bold
+/          to search for a shade rune, or T for transparent pixels, and highlight the pixels that match
+j          to let the cursor move freely, for editing the legend, or to keep it on the pixels again
+u          to show a histogram of the 16 grayscale levels and the min, max and mean level, until the next key
+Z          to highlight the pixels that differ from the file on disk, or to stop highlighting them
+Q          to show a reference image to the right of the image, then as onion skin in the transparent pixels,
+           then to hide it (it is never saved)
+V          to stamp a shape with the brush at the cursor: heart, star, arrow, check, cross, rss or one from -stamps
+U          to apply a filter: threshold, posterize or auto-contrast (for grayscale images),
+           or add a 1 pixel outline or drop shadow around the pixels that are not transparent
+] / [      to make the pixel under the cursor one shade brighter or darker
+mouse      click to move the cursor, drag to paint with the brush and use the wheel to scroll
+
+Flags
+
+-rgb       edit the image as 8+8+8 bit RGB, with a "|rrggbb" hex triplet per pixel
+-rgba      edit the image as 8+8+8+8 bit RGBA, with a "|rrggbbaa" hex quadruplet per pixel
+-gray      edit the image as 16 color grayscale, with one rune per pixel
+-palette   edit the image with a palette of at most 16 colors, with one rune per pixel
+           and an editable legend with the colors, like "_ = #rrggbb"
+-mono      edit the image as 1-bit black and white, with _ for black and @ for white
+-threshold N  the grayscale shade from 0 to 15 from which pixels become white, for -mono (the default is 8)
+-theme NAME  the display profile: dark, light, high-contrast, colorblind or auto (the default is to
+           detect a light or dark background from $COLORFGBG, and use dark if it is not set)
+-type TYPE the image format of the file: ico, cur, png, pgm, favtxt, xbm, ff or auto (the default is to detect it from the contents)
+-scale     scale the image down to 16x16 grayscale when loading it, averaging the pixels, also for -convert and -batch
+-max-load-size N  the largest width and height of images that are loaded, for -scale (the default is 1024).
+           Larger images are not decoded, so that a damaged file can not use up the memory
+-sizes LIST  the sizes of the .png images that are exported with P (the default is 32,48,64,180)
+-size N    edit the NxN image, for .ico files that contain several images
+-runes RUNES  use these 16 runes for the grayscale shades, from dark to bright (the default is _,.'-~+:*<=!%$@{)
+-convert   convert an image and quit, for example: -convert favicon.png favicon.ico
+-stdin     read an image from stdin and write it to stdout, for example: -stdin -to png
+-stdout    write the given image to stdout instead of editing it
+-to FORMAT the image format to write to stdout: png, ico or ff for farbfeld (the default is the same format)
+-watch FILE  convert the image to the -to file or format each time it changes, until ctrl-c is pressed,
+           for example: -watch logo.png -to favicon.ico
+-batch PATTERN  convert all files that match the glob pattern to the -to format and quit, continuing
+           past files that fail, for example: -batch '*.png' -to ico -out-dir icons
+-out-dir DIR  the directory to write the converted files to, for -batch (the default is the current directory)
+-download-only  download the image from the given URL, save it and quit
+-icns      save the image as an .icns file for macOS, like favicon.icns for favicon.png, and quit
+-xpm       print the image as a grayscale .xpm image and quit
+-xbm       print the image as a black and white .xbm image and quit, with -threshold for the shade
+           from which pixels become white
+-generate NAME  save a new image with a pattern and quit: noise, checkerboard, hstripes, vstripes or radial,
+           for example: -generate noise -seed 42 noise.ico
+-period N  the width of the squares and stripes in pixels, for -generate (the default is 2)
+-seed N    the seed for the random noise, for reproducible images
+-letter X  save a new image with a white letter from the built-in 8x8 font and quit,
+           for example: -letter G -out g.ico
+-bold      make the letter bold, for -letter
+-record FILE  record the changes to the image as a JSON operations script, for replaying them with -apply
+-apply FILE  replay an operations script on the -in image, write the -out file and quit,
+           for example: -apply ops.json -in blank.png -out favicon.ico
+-in FILE   the image to replay the operations on, for -apply (a new blank image if it does not exist)
+-json      print the image as JSON, with one number per pixel, like 0 to 15 for grayscale and -1 for
+           transparent, and quit
+-from-json FILE  write the image in a JSON pixel dump to the -out file and quit,
+           for example: -from-json dump.json -out favicon.ico
+-ref FILE  show this image in a dim color to the right of the image that is edited, for comparing and tracing
+-stamps DIR  load more stamps for V from the .txt files in DIR, with one row of runes per line,
+           where . and space are transparent and all other runes are painted with the brush
+-html      print the HTML link tags for the saved .ico and .png images and quit
+-validate  check that the given .ico and .png files and glob patterns are well-formed favicons with
+           the usual sizes, print one line per finding and quit, with exit code 0 if all files are ok,
+           7 if there are warnings and 6 if there are errors, for example: -validate 'static/*.ico'
+-require-alpha  also check that some pixels are transparent, for -validate
+-manifest  also write site.webmanifest next to the image and link to it, for -html and H
+-c-header  print a C header with the image as an .ico image and quit
+-go        print Go source code with the image as an .ico image and quit
+-gopackage NAME  the package name of the exported Go source code (the default is main)
+-govar NAME  the variable name of the exported Go source code (the default is FaviconICO)
+-ansi      print the image with colored half block characters and quit
+-export-ansi FILE  save the image as ANSI art for cat, or as a shell script if FILE ends with .sh, and quit,
+           for example: -export-ansi favicon.ansi favicon.ico
+-truecolor use 24-bit colors for -ansi and -export-ansi, instead of the 256 color palette
+-png-compression LEVEL  the compression level of .png images: none, fast, default or best
+           (the default is default)
+-png-interlace  write .png images with Adam7 interlacing, so that browsers can show them while loading
+-meta KEY=TEXT  add a text chunk to the .png images that are written, like -meta "Author=Alex",
+           can be given several times
+-backup    copy files to filename~ before overwriting them (or set FAVICON_BACKUP=1)
+-serve ADDR  serve a web page with a live preview of the image and with it as the favicon, like -serve :8080
+-post-save COMMAND  run COMMAND after saving, where {} is the filename, like -post-save 'optipng -quiet {}',
+           and show the exit status and the first line of the output (or set FAVICON_POST_SAVE)
+-bundle    save .ico files with 16x16, 32x32 and 48x48 images, scaled from the edited image
+-out DIR   with -bundle, write favicon.ico, .png images and site.webmanifest to DIR and quit,
+           for example: -bundle -out static logo.png
+-out FILE  with -letter, -apply and -from-json, the image file to write
+-force     overwrite existing files in the -out directory, or in the -out-dir directory for -batch
+-quiet     only print errors and warnings when not using the terminal, not which files have been saved
+
+Images with partial transparency are edited as RGBA, other color images as RGB,
+pure black and white images as monochrome and the rest as grayscale, by default.
+Blank RGB and RGBA pixels are transparent.
+
+When an .ico file contains several images and -size is not given, the image to
+edit can be chosen with the arrow keys and return. Saving only replaces that image.
+
+Only the pixels can be edited. The legend below grayscale images is read-only.
+With -palette, the #rrggbb colors in the legend can be edited, which recolors
+all the pixels that use them.
 
 Set NO_COLOR=1 to disable colors.
+Set COLORFGBG to the foreground and background colors, like 0;15, to use the colors for a light background.
+Set NO_TITLE=1 to leave the terminal title as it is.
+Set FAVICON_RUNES to use other runes for the grayscale shades, like -runes.
+Set FAVICON_STAMPS to a directory with more stamps, like -stamps.
+Set FAVICON_POST_SAVE to a command that is run after saving, like -post-save.
+
+Exit codes: 0 for success, 1 for other errors, 2 for usage errors, 3 if a file does not exist,
+4 if an image can not be read, 5 if an image can not be written, 6 if -validate found errors
+and 7 if -validate only found warnings.
 
 `)
 		return
 	}
 
-	filename := flag.Arg(0)
-	if filename == "" {
-		fmt.Fprintln(os.Stderr, "Need a filename.")
-		os.Exit(1)
+	// Use other runes for the shades in 16 color grayscale mode, if configured
+	runes := os.Getenv("FAVICON_RUNES")
+	if *runesFlag != "" {
+		runes = *runesFlag
+	}
+	if runes != "" {
+		if err := SetRunes(runes); err != nil {
+			fail(withCode(err, exitUsage))
+		}
+	}
+
+	// Load more stamps, if configured
+	stampDir := os.Getenv("FAVICON_STAMPS")
+	if *stampsFlag != "" {
+		stampDir = *stampsFlag
+	}
+	if stampDir != "" {
+		if err := LoadStamps(stampDir); err != nil {
+			fail(err)
+		}
+	}
+
+	// Use the display profile that is given, or the colors for a light or dark background
+	theme, err := parseTheme(*themeFlag)
+	if err != nil {
+		fail(withCode(err, exitUsage))
+	}
+
+	if err := img.SetMonoThreshold(*thresholdFlag); err != nil {
+		fail(withCode(err, exitUsage))
+	}
+
+	if err := img.SetMaxDecodeSize(*maxLoadSizeFlag); err != nil {
+		fail(withCode(err, exitUsage))
+	}
+
+	// Use the same .png settings for all images that are written, also when saving from the editor
+	encoder.CompressionLevel, err = parsePNGCompression(*pngCompressFlag)
+	if err != nil {
+		fail(withCode(err, exitUsage))
+	}
+	encoder.Interlace = *pngInterlaceFlag
+	if encoder.Text, err = parsePNGMeta(metaFlags); err != nil {
+		fail(withCode(err, exitUsage))
+	}
+
+	// Use the same random noise each time, if a seed is given
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			SetSeed(*seedFlag)
+		}
+	})
+
+	// Save a new image with a pattern, without using the terminal
+	if *generateFlag != "" {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		size := *sizeFlag
+		if size == 0 {
+			size = blankSize
+		}
+		m, err := GeneratePattern(*generateFlag, size, *periodFlag)
+		if err != nil {
+			fail(withCode(err, exitUsage))
+		}
+		if err := WriteImage(m, mode, flag.Arg(0), *bundleFlag); err != nil {
+			fail(withCode(err, exitEncode))
+		}
+		fmt.Fprintln(info, "Saved "+flag.Arg(0))
+		return
+	}
+
+	// Save a new image with a white letter on a transparent background, without using the terminal
+	if *letterFlag != "" {
+		filename := *outFlag
+		if filename == "" {
+			filename = flag.Arg(0)
+		}
+		if filename == "" {
+			failUsage("Need a filename, for example: -letter G -out g.ico")
+		}
+		letter, err := ParseLetter(*letterFlag)
+		if err != nil {
+			fail(withCode(err, exitUsage))
+		}
+		size := *sizeFlag
+		if size == 0 {
+			size = blankSize
+		}
+		m, err := RenderLetter(letter, size, *boldFlag, color.NRGBA{0xff, 0xff, 0xff, 0xff})
+		if err != nil {
+			fail(withCode(err, exitUsage))
+		}
+		if err := WriteImage(m, mode, filename, *bundleFlag); err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(info, "Saved "+filename)
+		return
+	}
+
+	// Replay an operations script that was recorded with -record, without using the terminal
+	if *applyFlag != "" {
+		if *inFlag == "" || *outFlag == "" {
+			failUsage("Need an image to start from and a file to write, for example: -apply ops.json -in blank.png -out favicon.ico")
+		}
+		if err := ApplyOps(*applyFlag, *inFlag, *outFlag, mode, *sizeFlag); err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(info, "Saved "+*outFlag)
+		return
+	}
+
+	// Write the image in a JSON pixel dump, as written by -json, without using the terminal
+	if *fromJSONFlag != "" {
+		if *outFlag == "" {
+			failUsage("Need a file to write, for example: -from-json dump.json -out favicon.ico")
+		}
+		if err := ConvertFromJSON(*fromJSONFlag, *outFlag, *bundleFlag); err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(info, "Saved "+*outFlag)
+		return
+	}
+
+	// Convert between .ico and .png without using the terminal
+	if *convertFlag {
+		if flag.NArg() != 2 {
+			failUsage("Need an input and an output filename.")
+		}
+		if err := Convert(flag.Arg(0), flag.Arg(1), mode, *sizeFlag, scale, *bundleFlag); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	// Convert the image each time it changes, until ctrl-c is pressed, without using the terminal
+	if *watchFlag != "" {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		if err := Watch(info, os.Stderr, *watchFlag, *toFlag, mode, *sizeFlag, scale, *bundleFlag, stop); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	// Convert all files that match a glob pattern, without using the terminal, and continue past files that fail
+	if *batchFlag != "" {
+		if err := Batch(info, os.Stderr, *batchFlag, *toFlag, *outDirFlag, mode, *sizeFlag, scale, *bundleFlag, *forceFlag); err == errBatchFailed {
+			// The files that failed have been reported
+			os.Exit(exitError)
+		} else if err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	// Write all the favicons a web site needs, without using the terminal
+	if *outFlag != "" {
+		if !*bundleFlag {
+			failUsage("-out is used together with -bundle, for example: -bundle -out static logo.png")
+		}
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := GenerateSite(flag.Arg(0), *outFlag, *forceFlag, info); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	// Download an image and quit, without using the terminal
+	if *downloadOnlyFlag {
+		if !isURL(flag.Arg(0)) {
+			failUsage("Need an http:// or https:// URL.")
+		}
+		data, localFilename, err := Download(flag.Arg(0))
+		if err == nil {
+			err = ioutil.WriteFile(localFilename, data, 0644)
+		}
+		if err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(info, "Saved "+localFilename)
+		return
+	}
+
+	// Check the given files and glob patterns, and exit with a code that says if there were warnings or errors
+	if *validateFlag {
+		if flag.NArg() == 0 {
+			failUsage("Need a filename, for example: -validate static/favicon.ico")
+		}
+		os.Exit(Validate(info, os.Stderr, flag.Args(), *requireAlphaFlag))
+	}
+
+	// Print the HTML link tags for the images that have been saved, and write the web app manifest if asked to
+	if *htmlFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		html, err := htmlForFile(flag.Arg(0), *sizesFlag, *manifestFlag)
+		if err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		fmt.Print(html)
+		return
+	}
+
+	// Print the image as a C header
+	if *cHeaderFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := PrintCHeader(flag.Arg(0), os.Stdout, *sizeFlag, *bundleFlag); err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		return
+	}
+
+	// Print the image as Go source code
+	if *goFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := PrintGoSource(flag.Arg(0), os.Stdout, *goPackageFlag, *goVarFlag, *sizeFlag, *bundleFlag); err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		return
+	}
+
+	// Save the image as an .icns file next to it
+	if *icnsFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		icnsFilename := siblingFilename(flag.Arg(0), icnsExt)
+		if err := Convert(flag.Arg(0), icnsFilename, mode, *sizeFlag, 0, false); err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(info, "Saved "+icnsFilename)
+		return
+	}
+
+	// Print the image as an .xpm image
+	if *xpmFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := PrintXPM(flag.Arg(0), os.Stdout, *sizeFlag); err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		return
+	}
+
+	// Print the image as an .xbm image
+	if *xbmFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := PrintXBM(flag.Arg(0), os.Stdout, *sizeFlag); err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		return
+	}
+
+	// Print the image with colored half block characters
+	if *ansiFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := PrintHalfBlocks(flag.Arg(0), os.Stdout, *sizeFlag, *truecolorFlag || hasTruecolor()); err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		return
+	}
+
+	// Save the image as ANSI art, for showing it with cat
+	if *exportANSIFlag != "" {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename, for example: -export-ansi favicon.ansi favicon.ico")
+		}
+		if err := ExportANSI(flag.Arg(0), *exportANSIFlag, *sizeFlag, *truecolorFlag); err != nil {
+			fail(err)
+		}
+		fmt.Fprintln(info, "Saved "+*exportANSIFlag)
+		return
+	}
+
+	// Print the image as JSON, with one number per pixel
+	if *jsonFlag {
+		if flag.Arg(0) == "" {
+			failUsage("Need a filename.")
+		}
+		if err := PrintJSON(flag.Arg(0), os.Stdout, mode, *sizeFlag); err != nil {
+			fail(withCode(err, exitDecode))
+		}
+		return
+	}
+
+	// Read from stdin or from a file, and write to stdout, without using the terminal
+	if *stdinFlag || *stdoutFlag {
+		var (
+			r    io.Reader = os.Stdin
+			name           = "stdin"
+		)
+		if !*stdinFlag {
+			name = flag.Arg(0)
+			if name == "" {
+				failUsage("Need a filename.")
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				fail(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := ConvertStream(r, os.Stdout, name, *toFlag, mode, *sizeFlag, *bundleFlag); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	filenames := flag.Args()
+	if len(filenames) == 0 {
+		failUsage("Need a filename.")
 	}
 
 	// If the filename ends with "." and the file does not exist, assume this was an attempt at tab-completion gone wrong.
 	// If there are multiple files that exist that start with the given filename, open the one first in the alphabet (.cpp before .o)
-	if strings.HasSuffix(filename, ".") && !exists(filename) {
-		// Glob
-		matches, err := filepath.Glob(filename + "*")
-		if err == nil && len(matches) > 0 { // no error and at least 1 match
-			sort.Strings(matches)
-			filename = matches[0]
+	for i, filename := range filenames {
+		if strings.HasSuffix(filename, ".") && !exists(filename) {
+			// Glob
+			matches, err := filepath.Glob(filename + "*")
+			if err == nil && len(matches) > 0 { // no error and at least 1 match
+				sort.Strings(matches)
+				filenames[i] = matches[0]
+			}
 		}
 	}
 
-	baseFilename := filepath.Base(filename)
-
 	// Initialize the terminal
 	tty, err := vt100.NewTTY()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "error: "+err.Error())
-		os.Exit(1)
+		fail(err)
 	}
 	defer tty.Close()
 	vt100.Init()
 
-	// Check that the file is an .ico or .png image
-	if !strings.HasSuffix(filename, ".png") && !strings.HasSuffix(filename, ".ico") {
-		quitError(tty, errors.New(filename+" must be an .ico or a .png file"))
+	// Restore the terminal before printing the panic message, instead of leaving it in raw mode
+	defer func() {
+		if r := recover(); r != nil {
+			restoreTerminal(tty)
+			fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", r, debug.Stack())
+			os.Exit(exitPanic)
+		}
+	}()
+	SetUpSignalHandler(tty)
+
+	forceFormat, err := parseFormat(*typeFlag)
+	if err != nil {
+		quitError(tty, err, exitUsage)
+	}
+	pngSizes, err := parseSizes(*sizesFlag)
+	if err != nil {
+		quitError(tty, err, exitUsage)
 	}
 
 	// Create a Canvas for drawing onto the terminal
 	c := vt100.NewCanvas()
 	c.ShowCursor()
 
-	// scroll 10 lines at a time, no word wrap
-	e := NewEditor(defaultEditorForeground, defaultEditorBackground, true, 10, defaultEditorSearchHighlight, mode)
+	// newEditor creates an editor with the settings from the flags and the environment, for each file
+	newEditor := func() *Editor {
+		// scroll 10 lines at a time, no word wrap
+		e := NewEditor(theme.fg, theme.bg, true, 10, theme.searchFg, mode)
 
-	// Adjust the word wrap if the terminal is too narrow
-	w := int(c.Width())
-	if w < e.wordWrapAt {
-		e.wordWrapAt = w
-	}
+		e.bundle = *bundleFlag
+		e.forceFormat = forceFormat
+		e.goPackage, e.goVar = *goPackageFlag, *goVarFlag
+		e.manifest = *manifestFlag
+		e.scale = scale
+		e.pngSizes = pngSizes
+		e.backup = *backupFlag || os.Getenv("FAVICON_BACKUP") == "1"
+		e.postSave = os.Getenv("FAVICON_POST_SAVE")
+		if *postSaveFlag != "" {
+			e.postSave = *postSaveFlag
+		}
+
+		// Adjust the word wrap if the terminal is too narrow
+		w := int(c.Width())
+		if w < e.wordWrapAt {
+			e.wordWrapAt = w
+		}
 
-	// Use a theme for light backgrounds if XTERM_VERSION is set,
-	// because $COLORFGBG is "15;0" even though the background is white.
-	xterm := os.Getenv("XTERM_VERSION") != ""
-	if xterm {
-		e.setLightTheme()
+		// Use the display profile that is given with -theme, or the one for a light background if detected from $COLORFGBG
+		e.SetTheme(theme)
+		return e
 	}
 
-	e.respectNoColorEnvironmentVariable()
+	e := newEditor()
 
-	status := NewStatusBar(defaultStatusForeground, defaultStatusBackground, defaultStatusErrorForeground, defaultStatusErrorBackground, e, statusDuration)
+	status := NewStatusBar(theme.statusFg, theme.statusBg, theme.statusErrFg, theme.statusErrBg, e, statusDuration)
+	e.palette = NewPaletteBar(theme.paletteFg, theme.statusBg, theme.brushFg, e)
 	status.respectNoColorEnvironmentVariable()
 
-	// Load a file, or a prepare an empty version of the file (without saving it until the user saves it)
-	var (
-		statusMessage  string
-		warningMessage string
-	)
+	// openFile loads a file into the given editor, or prepares an empty version of the file (without saving it until
+	// the user saves it). Returns the filename that the image will be saved as, and a status message.
+	openFile := func(e *Editor, filename string) (string, string) {
+		// Download the image if a URL is given, and use a local filename for saving it
+		var (
+			downloadURL  string
+			downloadData []byte
+			err          error
+		)
+		if isURL(filename) {
+			downloadURL = filename
+			downloadData, filename, err = Download(downloadURL)
+			if err != nil {
+				quitError(tty, err, exitError)
+			}
+		}
 
-	// We wish to redraw the canvas and reposition the cursor
-	e.redraw = true
-	e.redrawCursor = true
+		// Check that the file is an .ico or .png image, by looking at the contents or the extension, unless -type is given
+		if forceFormat == formatUnknown && downloadURL == "" && detectFormat(filename) == formatUnknown {
+			quitError(tty, errors.New(filename+" is not an .ico, .cur, .png, .pgm, .favtxt, .xbm, .ff, .bmp or .jpg image (use -type ico, cur, png, pgm, favtxt, xbm or ff for new images)"), exitDecode)
+		}
 
-	// Use os.Stat to check if the file exists, and load the file if it does
-	if fileInfo, err := os.Stat(filename); err == nil {
+		var (
+			statusMessage  string
+			warningMessage string
+		)
 
-		// TODO: Enter file-rename mode when opening a directory?
-		// Check if this is a directory
-		if fileInfo.IsDir() {
-			quitError(tty, errors.New(filename+" is a directory"))
-		}
+		// We wish to redraw the canvas and reposition the cursor
+		e.redraw = true
+		e.redrawCursor = true
 
-		warningMessage, err = e.Load(c, tty, filename)
-		if err != nil {
-			quitError(tty, err)
-		}
+		// Use os.Stat to check if the file exists, and load the file if it does
+		if downloadURL != "" {
+			warningMessage, err = e.LoadDownloaded(c, tty, status, downloadData, filename, *sizeFlag)
+			if err != nil {
+				quitError(tty, err, exitDecode)
+			}
+			statusMessage = "Downloaded " + downloadURL + " (ctrl-s saves it as " + filename + ")" + warningMessage
+		} else if fileInfo, err := os.Stat(filename); err == nil {
 
-		if !e.Empty() {
-			statusMessage = "Loaded " + filename + warningMessage
-		} else {
-			statusMessage = "Loaded empty file: " + filename + warningMessage
-		}
+			// TODO: Enter file-rename mode when opening a directory?
+			// Check if this is a directory
+			if fileInfo.IsDir() {
+				quitError(tty, errors.New(filename+" is a directory"), exitUsage)
+			}
 
-		// Test write, to check if the file can be written or not
-		testfile, err := os.OpenFile(filename, os.O_WRONLY, 0664)
-		if err != nil {
-			// can not open the file for writing
-			statusMessage += " (read only)"
-			// set the color to red when in read-only mode
-			e.fg = vt100.Red
-			// do a full reset and redraw
-			c = e.FullResetRedraw(c, status)
-			// draw the editor lines again
-			e.DrawLines(c, false, true)
-			e.redraw = false
-		}
-		testfile.Close()
-	} else {
-		newMode, err := e.PrepareEmpty(c, tty, filename)
-		if err != nil {
-			quitError(tty, err)
-		}
+			// Choose which image to edit, if this is an .ico or .cur file with several images
+			if e.FileFormat(filename).HasEntries() {
+				if err := e.ChooseEntry(c, tty, status, filename, *sizeFlag); err != nil {
+					quitError(tty, err, exitDecode)
+				}
+			}
 
-		statusMessage = "New " + filename
+			warningMessage, err = e.Load(c, tty, filename)
+			if err != nil {
+				quitError(tty, err, exitDecode)
+			}
 
-		// For .ico and .png
-		if newMode != modeBlank {
-			e.mode = newMode
-		}
+			if !e.Empty() {
+				statusMessage = "Loaded " + filename + warningMessage
+			} else {
+				statusMessage = "Loaded empty file: " + filename + warningMessage
+			}
 
-		// Test save, to check if the file can be created and written, or not
-		if err := e.Save(&filename, false); err != nil {
-			// Check if the new file can be saved before the user starts working on the file.
-			quitError(tty, err)
+			// .bmp and .jpg images can not be saved, so they are saved as .png images next to them
+			if e.format.ImportOnly() {
+				filename = withExtension(filename, ".png")
+				e.format = formatPNG
+				e.changed = true
+				statusMessage += " (ctrl-s saves it as " + filepath.Base(filename) + ")"
+			}
+
+			// Check if the file can be written, and record the modification time and size
+			if e.StatFile(filename) {
+				// can not open the file for writing
+				statusMessage += " (read only)"
+				// set the color to red when in read-only mode
+				e.fg = vt100.Red
+				// do a full reset and redraw
+				c = e.FullResetRedraw(c, status)
+				// draw the editor lines again
+				e.DrawLines(c, false, true)
+				e.redraw = false
+			}
 		} else {
-			// Creating a new empty file worked out fine, don't save it until the user saves it
-			if os.Remove(filename) != nil {
-				// This should never happen
-				quitError(tty, errors.New("could not remove an empty file that was just created: "+filename))
+			newMode, err := e.PrepareEmpty(c, tty, filename)
+			if err != nil {
+				quitError(tty, err, exitUsage)
+			}
+
+			statusMessage = "New " + filename
+
+			// For .ico and .png
+			if newMode != modeBlank {
+				e.mode = newMode
+			}
+
+			// Test save, to check if the file can be created and written, or not
+			if err := e.Save(&filename, false, false); err != nil {
+				// Check if the new file can be saved before the user starts working on the file.
+				quitError(tty, err, exitEncode)
+			} else {
+				// Creating a new empty file worked out fine, don't save it until the user saves it
+				if os.Remove(filename) != nil {
+					// This should never happen
+					quitError(tty, errors.New("could not remove an empty file that was just created: "+filename), exitError)
+				}
 			}
 		}
+		return filename, statusMessage
 	}
 
+	filename, statusMessage := openFile(e, filenames[0])
+
 	// The editing mode is decided at this point
 
+	// Show a reference image next to the image, if given
+	if *refFlag != "" {
+		if err := e.LoadReference(*refFlag); err != nil {
+			quitError(tty, err, exitDecode)
+		}
+		statusMessage += " (" + e.RefStatus() + ")"
+	}
+
+	// Record the operations that change the image, if asked to. Only the first file is recorded.
+	if *recordFlag != "" {
+		if !e.drawMode {
+			quitError(tty, errors.New("only images can be recorded with -record, not "+filename), exitUsage)
+		}
+		if err := e.StartRecording(*recordFlag); err != nil {
+			quitError(tty, err, exitError)
+		}
+	}
+
+	// Serve a web page with a live preview of the image, if asked to
+	var preview *PreviewServer
+	if *serveFlag != "" {
+		if preview, err = NewPreviewServer(*serveFlag); err != nil {
+			quitError(tty, err, exitError)
+		}
+		defer preview.Close()
+		preview.Update(e, filename)
+		statusMessage += " (preview at " + preview.URL() + ")"
+	}
+
 	// Undo buffer with room for 8192 actions
-	undo := NewUndo(8192)
+	undo := NewUndo(undoSize)
+
+	// Open the other files in buffers of their own, with tab and shift-tab for switching between them
+	buffers := &Buffers{}
+	buffers.Add(e, undo, filename)
+	for _, otherFilename := range filenames[1:] {
+		other := newEditor()
+		other.palette = e.palette
+		otherFilename, _ = openFile(other, otherFilename)
+		buffers.Add(other, NewUndo(undoSize), otherFilename)
+	}
+	if buffers.Len() > 1 {
+		// The other files may have been drawn while loading them
+		c = e.FullResetRedraw(c, status)
+		statusMessage = buffers.Label(filename) + ": " + statusMessage
+	}
 
 	// Resize handler
 	SetUpResizeHandler(c, e, status, tty)
@@ -225,6 +909,9 @@ Set NO_COLOR=1 to disable colors.
 	status.SetMessage(statusMessage)
 	status.Show(c, e)
 
+	// Draw the reference image after the canvas, since drawing the canvas covers the whole terminal
+	e.DrawOverlays(c)
+
 	if e.redrawCursor {
 		x := e.pos.ScreenX()
 		y := e.pos.ScreenY()
@@ -235,42 +922,165 @@ Set NO_COLOR=1 to disable colors.
 	}
 
 	var (
-		quit        bool
-		previousKey string
+		quit          bool
+		previousKey   string
+		adjustedPixel image.Point // the last pixel that was adjusted with [ or ]
+		painting      bool        // is the mouse being dragged to paint with the brush?
 	)
 
+	// Click to move the cursor, and drag to paint with the brush
+	keys := NewKeyReader(tty)
+	EnableMouse()
+
+	// The operations that can be run by name from the command palette, with ?.
+	// The ones that have a hotkey are run by handling the key as if it was pressed.
+	commands := &Commands{}
+	commands.RegisterHotkeys(keys.Queue)
+	commands.Register("export-ansi", "", func() {
+		if !e.drawMode {
+			return
+		}
+		status.ClearAll(c)
+		ansiFilename := siblingFilename(filename, ansiExt)
+		if err := WriteANSI(e.mode, image.Pt(e.width, e.height), e.String(), filename, ansiFilename, *truecolorFlag || hasTruecolor()); err != nil {
+			status.SetErrorMessage(err.Error())
+			status.Show(c, e)
+			return
+		}
+		status.SetMessage("Saved " + filepath.Base(ansiFilename) + ", for showing with cat")
+		status.Show(c, e)
+	})
+	commands.Register("png-text", "", func() {
+		status.ClearAll(c)
+		// Read the text chunks from the file, since they are not part of what is edited
+		entries, err := ReadPNGText(filename)
+		switch {
+		case e.format != formatPNG:
+			status.SetErrorMessage(filepath.Base(filename) + " is not a .png image")
+		case err != nil:
+			status.SetErrorMessage(err.Error())
+		case len(entries) == 0:
+			status.SetMessage(filepath.Base(filename) + " has no text chunks")
+		default:
+			status.SetMessage(pngTextSummary(entries))
+		}
+		status.Show(c, e)
+	})
+
+	// Show the filename in the terminal title
+	SetTitle(titleText(filename, e.changed))
+
 	for !quit {
-		key := tty.String()
+		key, mouse := keys.Read()
+		// The histogram is only shown until the next key is pressed
+		if e.histogram && (key != "" || mouse != nil) {
+			e.histogram = false
+			e.redraw = true
+		}
+		if mouse != nil {
+			switch {
+			case mouse.button == mouseWheelUp:
+				e.redraw = e.ScrollUp(c, status, e.pos.scrollSpeed)
+			case mouse.button == mouseWheelDown:
+				e.redraw = e.ScrollDown(c, status, e.pos.scrollSpeed)
+			case mouse.release:
+				painting = false
+			case mouse.button == mouseLeft:
+				from, fromInside := e.CursorPixel()
+				if !e.MoveToScreenPosition(c, mouse.x, mouse.y) || !mouse.drag || !e.drawMode {
+					break
+				}
+				to, inside := e.CursorPixel()
+				if !inside {
+					break
+				}
+				if !fromInside {
+					from = to
+				}
+				// One undo snapshot per stroke
+				if !painting {
+					undo.Snapshot(e)
+					painting = true
+				}
+				// Draw a line, in case the mouse was moved past several pixels
+				if _, err := e.Do(Op{Op: "line", At: opXY(from), To: opXY(to), Color: formatOpColor(e.brush)}); err != nil {
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+				}
+				e.redraw = true
+			}
+		}
 		switch key {
 		case "c:17": // ctrl-q, quit
-			quit = true
-		case "c:0": // ctrl-space, build source code to executable, word wrap, convert to PDF or write to PNG, depending on the mode
-			if strings.HasSuffix(baseFilename, ".ico") {
-				// Save .ico as .png
-				err := e.Save(&filename, true)
-				if err != nil {
-					statusMessage = err.Error()
-					status.ClearAll(c)
-					status.SetMessage(statusMessage)
-					status.Show(c, e)
-				} else {
-					status.ClearAll(c)
-					status.SetMessage("Saved " + strings.Replace(baseFilename, ".ico", ".png", 1))
-					status.Show(c, e)
+			modified := buffers.Modified(e)
+			if len(modified) == 0 {
+				quit = true
+				break
+			}
+			// Ask before quitting without saving. Pressing ctrl-q again quits without saving.
+			switch e.Ask(c, tty, status, "Save changes to "+strings.Join(buffers.Filenames(modified, filename), ", ")+"? (y/n/esc)", "y", "Y", "n", "N", "c:27", "c:17") {
+			case "y", "Y":
+				// Save all the files with unsaved changes, and stay at the first one that can not be saved
+				quit = true
+				for _, i := range modified {
+					if i != buffers.current {
+						undo, filename = buffers.Switch(i, e, undo, filename)
+					}
+					reloaded, err := e.SaveChecked(c, tty, status, &filename, false)
+					if reloaded {
+						undo = NewUndo(undoSize)
+						e.redraw = true
+					}
+					if err == nil && !reloaded {
+						// There is no status bar to show the outcome of the post-save command in, when quitting
+						e.RunPostSave(filename)
+						err = e.RecordSave(filename)
+					}
+					if err != nil {
+						status.SetMessage(err.Error())
+						status.Show(c, e)
+						quit = false
+						break
+					}
+					if reloaded {
+						quit = false
+						break
+					}
 				}
-				break // from case
-			} else if strings.HasSuffix(baseFilename, ".png") {
-				// Save .png as .ico
-				err := e.Save(&filename, true)
+			case "n", "N", "c:17":
+				quit = true
+			}
+		case "c:9", "⇧⇥": // tab or shift-tab, switch to the next or previous file
+			if buffers.Len() < 2 {
+				break
+			}
+			index := buffers.current + 1
+			if key == "⇧⇥" {
+				index = buffers.current - 1
+			}
+			undo, filename = buffers.Switch(index, e, undo, filename)
+			// Start with a fresh canvas, since the images may have different sizes and overlays
+			c = e.FullResetRedraw(c, status)
+			e.DrawLines(c, false, true)
+			e.redraw = false
+			label := buffers.Label(filename)
+			if e.changed {
+				label += " (modified)"
+			}
+			status.SetMessage(label)
+			status.Show(c, e)
+		case "c:0": // ctrl-space, build source code to executable, word wrap, convert to PDF or write to PNG, depending on the mode
+			if e.format != formatUnknown {
+				// Save .ico as .png or .png as .ico, next to the file that is being edited
+				exportFilename := filepath.Base(e.ExportFilename(filename))
+				err := e.Save(&filename, true, false)
 				if err != nil {
 					statusMessage = err.Error()
 					status.ClearAll(c)
 					status.SetMessage(statusMessage)
 					status.Show(c, e)
 				} else {
-					status.ClearAll(c)
-					status.SetMessage("Saved " + strings.Replace(baseFilename, ".png", ".ico", 1))
-					status.Show(c, e)
+					e.ShowSaved(c, status, e.SavedMessage(exportFilename), e.ExportFilename(filename))
 				}
 				break // from case
 			}
@@ -282,20 +1092,369 @@ Set NO_COLOR=1 to disable colors.
 			status.Show(c, e)
 		case "←": // left arrow
 			// Draw mode
-			e.pos.Left()
+			if e.CursorConfined() {
+				e.StepPixel(-1, 0)
+			} else {
+				e.pos.Left()
+			}
 			e.redrawCursor = true
+			e.PenDown()
 		case "→": // right arrow
 			// Draw mode
-			e.pos.Right(c)
+			if e.CursorConfined() {
+				e.StepPixel(1, 0)
+			} else {
+				e.pos.Right(c)
+			}
 			e.redrawCursor = true
+			e.PenDown()
 		case "↑": // up arrow
 			// Move the screen cursor
-			e.pos.Up()
+			if e.CursorConfined() {
+				e.StepPixel(0, -1)
+			} else {
+				e.pos.Up()
+			}
 			e.redrawCursor = true
+			e.PenDown()
 		case "↓": // down arrow
-			e.pos.Down(c)
+			if e.CursorConfined() {
+				e.StepPixel(0, 1)
+			} else {
+				e.pos.Down(c)
+			}
+			e.redrawCursor = true
+			e.PenDown()
+		case "?": // open the command palette, for running an operation by name
+			command, ok := e.PromptCommand(c, tty, status, commands)
+			if !ok {
+				break
+			}
+			command.run()
+		case "I": // switch to the next display profile
+			if e.noColor {
+				status.ClearAll(c)
+				status.SetMessage("Colors are disabled by NO_COLOR")
+				status.Show(c, e)
+				break
+			}
+			theme = nextTheme(theme)
+			e.SetTheme(theme)
+			status.SetTheme(theme)
+			e.palette.SetTheme(theme)
+			buffers.SetTheme(theme)
+			// Start with a fresh canvas, to draw everything with the new colors
+			c = e.FullResetRedraw(c, status)
+			e.DrawLines(c, true, true)
+			e.redraw = false
+			status.SetMessage("Display profile: " + theme.name)
+			status.Show(c, e)
+		case "c:18": // ctrl-r, toggle drawing the pixels with their real colors
+			if !e.drawMode {
+				break
+			}
+			if e.noColor {
+				status.ClearAll(c)
+				status.SetMessage("Colors are disabled by NO_COLOR")
+				status.Show(c, e)
+				break
+			}
+			e.colors = !e.colors
+			// Start with a fresh canvas, to remove the colors that were drawn on top of it
+			c = e.FullResetRedraw(c, status)
+		case "g": // show the image as a bitmap, if the terminal supports kitty graphics or sixels
+			if !e.drawMode {
+				break
+			}
+			if err := e.ShowGraphics(c); err != nil {
+				status.ClearAll(c)
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+			}
+		case "#": // select one of the 16 shades as the brush, by typing its number
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			status.SetMessage("Shade number (0-15):")
+			status.ShowNoTimeout(c, e)
+			digits := ""
+			for done := false; !done && len(digits) < 2; {
+				numkey, _ := keys.Read()
+				switch {
+				case len(numkey) == 1 && numkey[0] >= '0' && numkey[0] <= '9':
+					digits += numkey
+					status.SetMessage("Shade number (0-15): " + digits)
+					status.ShowNoTimeout(c, e)
+					// Only 1 can be followed by a second digit
+					done = digits != "1"
+				case numkey == "c:13": // return
+					done = true
+				case numkey == "c:27" || numkey == "c:17": // esc or ctrl-q
+					digits = ""
+					done = true
+				}
+			}
+			status.ClearAll(c)
+			shade, err := strconv.Atoi(digits)
+			if digits == "" {
+				break
+			}
+			brush, ok := e.ShadeColor(byte(shade))
+			if err != nil || shade > 15 || !ok {
+				status.SetErrorMessage("No shade number " + digits)
+				status.Show(c, e)
+				break
+			}
+			e.brush = brush
+			status.SetMessage(e.BrushStatus())
+			status.Show(c, e)
+		case "L": // load the file again, for picking up changes that were made by another program
+			if !e.drawMode {
+				break
+			}
+			if e.changed && !e.Confirm(c, tty, status, "Discard the changes and reload "+filename+"?") {
+				break
+			}
+			message, err := e.Reload(c, tty, filename)
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			// The undo snapshots are for the previous contents
+			undo = NewUndo(undoSize)
+			e.redraw = true
+			status.SetMessage("Reloaded " + filename + message)
+			status.Show(c, e)
+		case "S": // set the hotspot of the cursor, for .cur files
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if e.format != formatCUR {
+				status.SetErrorMessage("Only .cur files have a hotspot (ctrl-o can save the image as a .cur file)")
+				status.Show(c, e)
+				break
+			}
+			if !e.PromptHotspot(c, tty, status) {
+				break
+			}
+			e.redraw = true
+			status.SetMessage(fmt.Sprintf("The hotspot is now %d,%d", e.hotspot.X, e.hotspot.Y))
+			status.Show(c, e)
+		case "P": // export .png images in several sizes, scaled up with nearest neighbor scaling
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if err := e.ValidatePixels(); err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			written, err := WritePNGSizes(e.mode, image.Pt(e.width, e.height), e.String(), filename, e.pngSizes)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			if len(written) == 1 {
+				status.SetMessage("Saved " + filepath.Base(written[0]))
+			} else {
+				status.SetMessage(fmt.Sprintf("Saved %d images, from %s to %s", len(written), filepath.Base(written[0]), filepath.Base(written[len(written)-1])))
+			}
+			status.Show(c, e)
+		case "H": // copy the HTML link tags for the images that have been saved to the clipboard
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			html, err := FaviconHTML(filename, e.pngSizes, e.manifest)
+			if err == nil && e.manifest {
+				_, err = WriteWebmanifest(filename, e.pngSizes)
+			}
+			if err == nil {
+				err = clipboard.WriteAll(html)
+			}
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			status.SetMessage(fmt.Sprintf("Copied %d HTML link tags", strings.Count(html, "\n")))
+			status.Show(c, e)
+		case "G": // export to Go source code with the image as an .ico image, for embedding it
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if err := e.ValidatePixels(); err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			goFilename, err := e.WriteGoSource(filename)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			status.SetMessage("Saved " + filepath.Base(goFilename))
+			status.Show(c, e)
+		case "W": // export to a grayscale .xpm image, for C source code
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if err := e.ValidatePixels(); err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			xpmFilename := siblingFilename(filename, ".xpm")
+			if err := WriteXPM(e.mode, image.Pt(e.width, e.height), e.String(), xpmFilename); err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			status.SetMessage("Saved " + filepath.Base(xpmFilename))
+			status.Show(c, e)
+		case "n": // toggle the pixel coordinates to the left of and above the image
+			if !e.drawMode {
+				break
+			}
+			e.SetGutter(!e.gutter)
+			e.redraw = true
 			e.redrawCursor = true
+		case "t": // toggle between a checker pattern and the literal text for transparent pixels
+			if !e.drawMode {
+				break
+			}
+			e.literalT = !e.literalT
+			e.redraw = true
+		case "z": // zoom in, from 1x to 2x to 3x and then back to 1x
+			if !e.drawMode {
+				break
+			}
+			e.pos.SetZoom(e.pos.Zoom()%3 + 1)
+			// Start with a fresh canvas, to draw the pixels at the new size
+			c = e.FullResetRedraw(c, status)
+			if e.pos.Zoom() == 1 && !e.FitZoomAt(c, 2) {
+				// The error message is shown after the redraw
+				status.SetErrorMessage("The terminal is too small for zooming in")
+			}
+		case "h": // toggle a preview of the image with half block characters, in the top right corner
+			if !e.drawMode {
+				break
+			}
+			if e.noColor {
+				status.ClearAll(c)
+				status.SetMessage("Colors are disabled by NO_COLOR")
+				status.Show(c, e)
+				break
+			}
+			e.halfBlocks = !e.halfBlocks
+			// Start with a fresh canvas, to remove the preview
+			c = e.FullResetRedraw(c, status)
+		case "c:2": // ctrl-b, copy the image to the clipboard as a data URI
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			uri, n, err := e.DataURI()
+			if err == nil {
+				err = clipboard.WriteAll(uri)
+			}
+			if err != nil {
+				status.SetErrorMessage("Could not copy the image: " + err.Error())
+				status.Show(c, e)
+				break
+			}
+			status.SetMessage(fmt.Sprintf("Copied a data URI with a %d byte PNG image", n))
+			status.Show(c, e)
+		case "q": // save a copy of the image in a snapshot slot, chosen with a digit
+			if !e.drawMode {
+				break
+			}
+			n, ok := e.PromptSlot(c, status, keys, "Save to slot")
+			if !ok {
+				break
+			}
+			e.SaveSlot(n)
+			status.SetMessage("Saved slot " + strconv.Itoa(n) + " (in use: " + e.SlotsInUse() + ")")
+			status.Show(c, e)
+		case ";": // replace the image with the copy in a snapshot slot, chosen with a digit
+			if !e.drawMode {
+				break
+			}
+			n, ok := e.PromptSlot(c, status, keys, "Restore slot")
+			if !ok {
+				break
+			}
+			if !e.SlotUsed(n) {
+				status.SetErrorMessage("Slot " + strconv.Itoa(n) + " is empty (in use: " + e.SlotsInUse() + ")")
+				status.Show(c, e)
+				break
+			}
+			undo.Snapshot(e)
+			e.RestoreSlot(n)
+			status.SetMessage("Restored slot " + strconv.Itoa(n) + ", ctrl-u undoes it")
+			status.Show(c, e)
+		case "&": // copy all the rows of pixels as text, without the legend
+			if !e.drawMode {
+				break
+			}
+			copyLine = e.PixelRows()
+			status.ClearAll(c)
+			if clipboard.WriteAll(copyLine) != nil {
+				// The rows can still be pasted with ctrl-v
+				status.SetMessage(fmt.Sprintf("Copied %d rows (the clipboard is not available, paste with ctrl-v)", e.height))
+			} else {
+				status.SetMessage(fmt.Sprintf("Copied %d rows", e.height))
+			}
+			status.Show(c, e)
+		case "c:20": // ctrl-t, toggle the pen
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if e.pen {
+				e.pen = false
+				status.SetMessage("Pen off")
+			} else {
+				// The whole stroke is undone in one step
+				undo.Snapshot(e)
+				e.pen = true
+				e.PenDown()
+				status.SetMessage("Pen on, moving the cursor paints with the brush")
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "c:23": // ctrl-w, erase the previous pixel by making it transparent
+			if !e.drawMode {
+				break
+			}
+			undo.SnapshotPixel(e)
+			if e.Erase() {
+				e.redrawCursor = true
+				e.redraw = true
+			}
 		case "c:14": // ctrl-n, scroll down or jump to next match
+			if e.drawMode && e.searchShade != 0 {
+				status.ClearAll(c)
+				if wrapped, found := e.NextMatch(); !found {
+					status.SetMessage("No pixels match " + string(e.searchShade))
+				} else if p, _ := e.CursorPixel(); wrapped {
+					status.SetMessage(fmt.Sprintf("Pixel %d,%d (wrapped)", p.X, p.Y))
+				} else {
+					status.SetMessage(fmt.Sprintf("Pixel %d,%d", p.X, p.Y))
+				}
+				status.Show(c, e)
+				e.redrawCursor = true
+				break
+			}
 			// Scroll down
 			e.redraw = e.ScrollDown(c, status, e.pos.scrollSpeed)
 			// If e.redraw is false, the end of file is reached
@@ -309,8 +1468,18 @@ Set NO_COLOR=1 to disable colors.
 			e.redraw = e.ScrollUp(c, status, e.pos.scrollSpeed)
 			e.redrawCursor = true
 		case "c:27": // esc, clear search term, reset, clean and redraw
+			// Remove any kitty graphics preview
+			if detectGraphics() == graphicsKitty {
+				fmt.Print(kittyDelete())
+			}
+			// Also forget any mark that was set by a drawing tool, and the search
+			e.markTool = 0
+			e.ClearSearch()
 			c = e.FullResetRedraw(c, status)
 		case " ": // space
+			if e.RefuseReadOnly(c, status) {
+				break
+			}
 			undo.Snapshot(e)
 			// Place a space
 			e.SetRune(' ')
@@ -325,7 +1494,10 @@ Set NO_COLOR=1 to disable colors.
 			e.pos.Down(c)
 			e.redraw = true
 		case "c:8", "c:127": // ctrl-h or backspace
-			undo.Snapshot(e)
+			if e.RefuseReadOnly(c, status) {
+				break
+			}
+			undo.SnapshotPixel(e)
 			// Move back
 			e.Prev(c)
 			// Type a blank
@@ -334,6 +1506,13 @@ Set NO_COLOR=1 to disable colors.
 			e.redrawCursor = true
 			e.redraw = true
 		case "c:1", "c:25": // ctrl-a, home (or ctrl-y for scrolling up in the st terminal)
+			// Go to the first pixel of the row, if the cursor is confined to the pixels
+			if e.CursorConfined() {
+				e.pos.sx = 0
+				e.redrawCursor = true
+				e.PenDown()
+				break
+			}
 			// First check if we just moved to this line with the arrow keys
 			justMovedUpOrDown := previousKey == "↓" || previousKey == "↑"
 			// If at an empty line, go up one line
@@ -356,6 +1535,13 @@ Set NO_COLOR=1 to disable colors.
 			e.redrawCursor = true
 			e.SaveX(true)
 		case "c:5": // ctrl-e, end
+			// Go to the last pixel of the row, if the cursor is confined to the pixels
+			if e.CursorConfined() {
+				e.pos.sx = (e.width - 1) * cellWidth(e.mode)
+				e.redrawCursor = true
+				e.PenDown()
+				break
+			}
 			// First check if we just moved to this line with the arrow keys
 			justMovedUpOrDown := previousKey == "↓" || previousKey == "↑"
 			// If we didn't just move here, and are at the end of the line,
@@ -367,35 +1553,97 @@ Set NO_COLOR=1 to disable colors.
 			} else {
 				e.End()
 			}
-			e.redrawCursor = true
-			e.SaveX(true)
-		case "c:4": // ctrl-d, delete
-			undo.Snapshot(e)
-			if e.Empty() {
-				status.SetMessage("Empty")
+			e.redrawCursor = true
+			e.SaveX(true)
+		case "c:4": // ctrl-d, delete
+			if e.RefuseReadOnly(c, status) {
+				break
+			}
+			undo.Snapshot(e)
+			if e.Empty() {
+				status.SetMessage("Empty")
+				status.Show(c, e)
+			} else {
+				e.Delete()
+				e.redraw = true
+			}
+			e.redrawCursor = true
+		case "c:15": // ctrl-o, save as
+			newFilename, ok := e.PromptFilename(c, tty, status, "Save as:", filename)
+			if !ok || newFilename == "" {
+				break
+			}
+			if _, err := os.Stat(newFilename); err == nil && newFilename != filename && !e.Confirm(c, tty, status, newFilename+" already exists. Overwrite it?") {
+				break
+			}
+			// .icns files can only be exported to, so keep editing the same file
+			if e.drawMode && isICNS(newFilename) {
+				if err := e.ExportICNS(newFilename); err != nil {
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+					break
+				}
+				status.SetMessage("Saved " + filepath.Base(newFilename))
+				status.Show(c, e)
+				break
+			}
+			// Save in the format that the new extension says, or in the same format if there is no such extension
+			oldFormat := e.format
+			if format := formatFromExtension(newFilename); e.drawMode && format != formatUnknown {
+				e.format = format
+			}
+			if err := e.Save(&newFilename, false, false); err != nil {
+				e.format = oldFormat
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			// Save to the new file from now on
+			filename = newFilename
+			if err := e.RecordSave(filename); err != nil {
+				status.SetErrorMessage(err.Error())
 				status.Show(c, e)
-			} else {
-				e.Delete()
-				e.redraw = true
+				break
 			}
-			e.redrawCursor = true
+			e.ShowSaved(c, status, e.SavedMessage(filename), filename)
 		case "c:30": // ctrl-~, save and quit + clear the terminal
 			clearOnQuit = true
 			quit = true
 			fallthrough
-		case "c:19": // ctrl-s, save
+		case "c:19", "c:6": // ctrl-s, save, or ctrl-f, save even if some pixels are invalid
 			status.ClearAll(c)
-			// Save the file
-			if err := e.Save(&filename, false); err != nil {
+			// Save the file, or reload it if it was changed by another program and the user wants to
+			reloaded, err := e.SaveChecked(c, tty, status, &filename, key == "c:6")
+			if err == nil && !reloaded {
+				err = e.RecordSave(filename)
+			}
+			if reloaded {
+				undo = NewUndo(undoSize)
+				e.redraw = true
+			}
+			if err != nil || reloaded {
+				// Don't quit if the file was not saved
+				quit = false
+				clearOnQuit = false
+			}
+			if err != nil {
 				status.SetMessage(err.Error())
 				status.Show(c, e)
+			} else if reloaded {
+				status.SetMessage("Reloaded " + filename)
+				status.Show(c, e)
 			} else {
 				// Status message
-				status.SetMessage("Saved " + filename)
-				status.Show(c, e)
+				e.ShowSaved(c, status, e.SavedMessage(filename), filename)
 				c.Draw()
 			}
-		case "c:21", "c:26": // ctrl-u or ctrl-z, undo (ctrl-z may background the application)
+		case "c:26": // ctrl-z, suspend
+			Suspend(tty)
+			// Start with a fresh canvas, since the terminal may have been resized or used by other programs
+			c = e.FullResetRedraw(c, status)
+			e.DrawLines(c, true, false)
+			e.DrawOverlays(c)
+		case "c:21": // ctrl-u, undo
 			if err := undo.Restore(e); err == nil {
 				//c.Draw()
 				x := e.pos.ScreenX()
@@ -407,9 +1655,13 @@ Set NO_COLOR=1 to disable colors.
 				status.SetMessage("No more to undo")
 				status.Show(c, e)
 			}
-		case "c:12": // ctrl-l, go to line number
+		case "c:12": // ctrl-l, go to line number, or to pixel coordinates in draw mode
+			prompt := "Go to line number:"
+			if e.drawMode {
+				prompt = "Go to pixel (x,y):"
+			}
 			status.ClearAll(c)
-			status.SetMessage("Go to line number:")
+			status.SetMessage(prompt)
 			status.ShowNoTimeout(c, e)
 			lns := ""
 			doneCollectingDigits := false
@@ -418,12 +1670,18 @@ Set NO_COLOR=1 to disable colors.
 				switch numkey {
 				case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9": // 0 .. 9
 					lns += numkey // string('0' + (numkey - 48))
-					status.SetMessage("Go to line number: " + lns)
+					status.SetMessage(prompt + " " + lns)
 					status.ShowNoTimeout(c, e)
+				case ",": // between the x and y coordinates
+					if e.drawMode && !strings.Contains(lns, ",") {
+						lns += numkey
+						status.SetMessage(prompt + " " + lns)
+						status.ShowNoTimeout(c, e)
+					}
 				case "c:8", "c:127": // ctrl-h or backspace
 					if len(lns) > 0 {
 						lns = lns[:len(lns)-1]
-						status.SetMessage("Go to line number: " + lns)
+						status.SetMessage(prompt + " " + lns)
 						status.ShowNoTimeout(c, e)
 					}
 				case "c:27", "c:17": // esc or ctrl-q
@@ -434,13 +1692,24 @@ Set NO_COLOR=1 to disable colors.
 				}
 			}
 			status.ClearAll(c)
-			if lns != "" {
+			if lns != "" && e.drawMode {
+				p, err := e.ParsePixelCoordinates(lns)
+				if err != nil {
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+				} else {
+					e.GoToPixel(p)
+				}
+			} else if lns != "" {
 				if ln, err := strconv.Atoi(lns); err == nil { // no error
 					e.redraw = e.GoToLineNumber(ln, c, status, true)
 				}
 			}
 			e.redrawCursor = true
 		case "c:11": // ctrl-k, delete to end of line
+			if e.RefuseReadOnly(c, status) {
+				break
+			}
 			undo.Snapshot(e)
 			if e.Empty() {
 				status.SetMessage("Empty")
@@ -451,7 +1720,15 @@ Set NO_COLOR=1 to disable colors.
 				e.redraw = true
 			}
 			e.redrawCursor = true
+		case "c:7": // ctrl-g, toggle the status display with the pixel under the cursor, or the line, column and word count
+			statusMode = !statusMode
+			if !statusMode {
+				status.ClearAll(c)
+			}
 		case "c:24": // ctrl-x, cut line
+			if e.RefuseReadOnly(c, status) {
+				break
+			}
 			undo.Snapshot(e)
 			y := e.DataY()
 			copyLine = e.Line(y)
@@ -470,9 +1747,44 @@ Set NO_COLOR=1 to disable colors.
 			e.redrawCursor = true
 			e.redraw = true
 		case "c:22": // ctrl-v, paste
-			undo.Snapshot(e)
 			// Try fetching the line from the clipboard first
 			lines, err := clipboard.ReadAll()
+			// Check if the clipboard contains a PNG image, or a data URI with a PNG image
+			if m, ok := clipboardImage(lines); err == nil && ok && e.drawMode {
+				if e.changed && !e.Confirm(c, tty, status, "Replace the image with the image from the clipboard?") {
+					break
+				}
+				undo.Snapshot(e)
+				if err := e.ReplaceImage(m, "the clipboard image"); err != nil {
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+					break
+				}
+				status.SetMessage("Pasted the image from the clipboard")
+				status.Show(c, e)
+				e.redraw = true
+				break
+			}
+			if e.RefuseReadOnly(c, status) {
+				break
+			}
+			// Several lines overwrite the rows of the image, from the row of the cursor and down.
+			// The rows that were copied with & are kept in copyLine, if the clipboard is not available.
+			rows := lines
+			if err != nil {
+				rows = copyLine
+			}
+			if e.drawMode && strings.Contains(strings.TrimRight(rows, "\r\n"), "\n") {
+				undo.Snapshot(e)
+				n := e.PasteRows(rows)
+				status.ClearAll(c)
+				status.SetMessage(fmt.Sprintf("Pasted %d rows", n))
+				status.Show(c, e)
+				e.redrawCursor = true
+				e.redraw = true
+				break
+			}
+			undo.Snapshot(e)
 			if err == nil { // no error
 				if strings.Contains(lines, "\n") {
 					copyLine = strings.SplitN(lines, "\n", 2)[0]
@@ -492,18 +1804,523 @@ Set NO_COLOR=1 to disable colors.
 			// Prepare to redraw the text
 			e.redrawCursor = true
 			e.redraw = true
+		case "l": // draw a line from the marked pixel to the pixel under the cursor
+			if !e.drawMode {
+				break
+			}
+			p, _ := e.CursorPixel()
+			status.ClearAll(c)
+			if e.markTool != 'l' {
+				e.mark, e.markTool = p, 'l'
+				status.SetMessage(e.MarkStatus() + " (press l again to draw it)")
+				status.ShowNoTimeout(c, e)
+				break
+			}
+			undo.Snapshot(e)
+			if _, err := e.Do(Op{Op: "line", At: opXY(e.mark), To: opXY(p), Color: formatOpColor(e.brush)}); err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(e.MarkStatus())
+			}
+			status.Show(c, e)
+			e.markTool = 0
+			e.redraw = true
+		case "r", "R": // draw a rectangle (r) or a filled rectangle (R) from the marked pixel to the pixel under the cursor
+			if !e.drawMode {
+				break
+			}
+			p, _ := e.CursorPixel()
+			status.ClearAll(c)
+			if e.markTool != 'r' && e.markTool != 'R' {
+				e.mark, e.markTool = p, []rune(key)[0]
+				status.SetMessage(e.MarkStatus() + " (press r again to draw it, or R to fill it)")
+				status.ShowNoTimeout(c, e)
+				break
+			}
+			undo.Snapshot(e)
+			op := Op{Op: "rectangle", At: opXY(e.mark), To: opXY(p), Color: formatOpColor(e.brush)}
+			if key == "R" {
+				op.Op = "filled-rectangle"
+			}
+			if _, err := e.Do(op); err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(e.MarkStatus())
+			}
+			status.Show(c, e)
+			e.markTool = 0
+			e.redraw = true
+		case "i": // invert the image
+			if !e.drawMode {
+				break
+			}
+			undo.Snapshot(e)
+			message, err := e.Do(Op{Op: "invert"})
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "w", "s": // make the image brighter (w) or darker (s)
+			if !e.drawMode {
+				break
+			}
+			undo.Snapshot(e)
+			steps := 1
+			if key == "s" {
+				steps = -1
+			}
+			_, err := e.Do(Op{Op: "brighten", N: steps})
+			e.brightness += steps
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(fmt.Sprintf("brightness %+d", e.brightness))
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "k", "K": // increase (k) or decrease (K) the contrast of the image
+			if !e.drawMode {
+				break
+			}
+			undo.Snapshot(e)
+			steps := 1
+			if key == "K" {
+				steps = -1
+			}
+			_, err := e.Do(Op{Op: "contrast", N: steps})
+			e.contrast += steps
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(fmt.Sprintf("contrast %+d", e.contrast))
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "⇧←", "⇧→", "⇧↑", "⇧↓": // shift the image one pixel with shift and the arrow keys
+			if !e.drawMode {
+				break
+			}
+			if !strings.HasPrefix(previousKey, "⇧") && previousKey != "J" {
+				e.shifted = image.Point{}
+			}
+			var delta image.Point
+			switch key {
+			case "⇧←":
+				delta.X = -1
+			case "⇧→":
+				delta.X = 1
+			case "⇧↑":
+				delta.Y = -1
+			case "⇧↓":
+				delta.Y = 1
+			}
+			undo.Snapshot(e)
+			_, err := e.Do(Op{Op: "shift", By: opXY(delta), Wrap: e.wrapShift})
+			e.shifted = e.shifted.Add(delta)
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(fmt.Sprintf("shifted %+d,%+d", e.shifted.X, e.shifted.Y) + e.ShiftStatus())
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "Y", "M": // trim the image to the artwork (Y) or move the artwork to the middle of the image (M)
+			if !e.drawMode {
+				break
+			}
+			undo.Snapshot(e)
+			op := Op{Op: "trim"}
+			if key == "M" {
+				op.Op = "center"
+			}
+			message, err := e.Do(op)
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+				e.redraw = true
+			}
+			status.Show(c, e)
+		case "J": // toggle between wrapping around and shifting in transparent pixels, when shifting the image
+			if !e.drawMode {
+				break
+			}
+			e.wrapShift = !e.wrapShift
+			status.ClearAll(c)
+			status.SetMessage("Shifting the image" + e.ShiftStatus())
+			status.Show(c, e)
+		case "x": // replace all pixels of one color with another color
+			if !e.drawMode {
+				break
+			}
+			from, ok := e.PromptPixel(c, tty, status, "Replace:")
+			if !ok {
+				break
+			}
+			label := strings.TrimRight(pixelText(e.mode, from), " ")
+			if label == "" {
+				label = " " // black, in grayscale mode
+			}
+			to, ok := e.PromptPixel(c, tty, status, fmt.Sprintf("Replace %q with:", label))
+			if !ok {
+				break
+			}
+			undo.Snapshot(e)
+			message, err := e.Do(Op{Op: "replace", Color: formatOpColor(from), With: formatOpColor(to)})
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "N": // fill the image with a pattern, chosen from a menu
+			if !e.drawMode {
+				break
+			}
+			name, ok := e.ChooseFromMenu(c, tty, status, "Generate:", generatorNames)
+			if !ok {
+				break
+			}
+			period := 1
+			if generatorHasPeriod(name) {
+				if period, ok = e.PromptNumber(c, tty, status, "Period in pixels:", 2, 1, e.width); !ok {
+					break
+				}
+			}
+			undo.Snapshot(e)
+			message, err := e.Do(Op{Op: "generate", Name: name, N: period})
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+				e.redraw = true
+			}
+			status.Show(c, e)
+		case "O": // draw a letter from the built-in font with the brush
+			if !e.drawMode {
+				break
+			}
+			text, ok := e.PromptText(c, tty, status, "Letter:", "")
+			if !ok {
+				break
+			}
+			letter, err := ParseLetter(text)
+			if err != nil {
+				status.ClearAll(c)
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			style, ok := e.ChooseFromMenu(c, tty, status, "Style:", letterStyles)
+			if !ok {
+				break
+			}
+			undo.Snapshot(e)
+			message, err := e.Do(Op{Op: "letter", Letter: string(letter), Bold: style == "bold", Color: formatOpColor(e.brush)})
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+				e.redraw = true
+			}
+			status.Show(c, e)
+		case "/": // search for a shade, and highlight the pixels that match
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			status.SetMessage("Search for shade (or T):")
+			status.ShowNoTimeout(c, e)
+			shadeKey := tty.String()
+			status.ClearAll(c)
+			if shadeKey == "c:27" || shadeKey == "c:17" || len([]rune(shadeKey)) != 1 { // esc, ctrl-q or not a rune
+				e.ClearSearch()
+				e.redraw = true
+				break
+			}
+			if err := e.SetSearch([]rune(shadeKey)[0]); err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			e.DrawLines(c, false, true)
+			e.redraw = false
+			status.SetMessage(e.searchMessage())
+			status.Show(c, e)
+		case "j": // let the cursor move freely, for editing the legend, or confine it to the pixels again
+			if !e.drawMode {
+				break
+			}
+			e.freeCursor = !e.freeCursor
+			status.ClearAll(c)
+			if e.freeCursor {
+				status.SetMessage("The cursor moves freely (j to keep it on the pixels)")
+			} else {
+				e.KeepCursorInImage()
+				e.redrawCursor = true
+				status.SetMessage("The cursor is kept on the pixels (j to let it move freely)")
+			}
+			status.Show(c, e)
+		case "u": // show a histogram of the grayscale levels, and statistics in the status bar
+			if !e.drawMode {
+				break
+			}
+			summary := e.Histogram().Summary()
+			if !e.HistogramFits(c) {
+				summary = "No room for the histogram, " + summary
+			}
+			status.ClearAll(c)
+			status.SetMessage(summary)
+			status.Show(c, e)
+			e.histogram = true
+		case "Z": // highlight the pixels that differ from the file on disk, or stop highlighting them
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if e.diff != nil {
+				e.ClearDiff()
+				status.SetMessage("Not highlighting the pixels that differ from " + filename)
+			} else if n, err := e.DiffWithFile(filename); err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(diffMessage(n, filename))
+			}
+			// Draw the highlighted pixels before the status message, so that it is not drawn over
+			e.DrawLines(c, true, false)
+			e.redraw = false
+			status.Show(c, e)
+		case "Q": // show a reference image beside the image, then as onion skin, then hide it
+			if !e.drawMode {
+				break
+			}
+			if e.refView == refHidden {
+				refFile, ok := e.PromptFilename(c, tty, status, "Reference image:", e.refFile)
+				if !ok {
+					break
+				}
+				status.ClearAll(c)
+				if err := e.LoadReference(refFile); err != nil {
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+					break
+				}
+			} else if e.refView == refBeside && !e.noColor {
+				e.refView = refOnion
+			} else {
+				// Onion skin can not be told apart from the image without colors
+				e.refView = refHidden
+			}
+			// Start with a fresh canvas, to remove the reference image from where it was
+			c = e.FullResetRedraw(c, status)
+			e.DrawLines(c, false, true)
+			e.redraw = false
+			status.SetMessage(e.RefStatus())
+			status.Show(c, e)
+		case "V": // stamp a pre-drawn shape with the brush, chosen from a menu
+			if !e.drawMode {
+				break
+			}
+			p, inside := e.CursorPixel()
+			if !inside {
+				break
+			}
+			name, ok := e.ChooseFromMenu(c, tty, status, "Stamp:", stampNames())
+			if !ok {
+				break
+			}
+			undo.Snapshot(e)
+			message, err := e.Do(Op{Op: "stamp", Name: name, At: opXY(p), Color: formatOpColor(e.brush)})
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "U": // apply a filter to the whole image, chosen from a menu
+			if !e.drawMode {
+				break
+			}
+			name, ok := e.ChooseFromMenu(c, tty, status, "Filter:", filterNames)
+			if !ok {
+				break
+			}
+			if isGrayFilter(name) && e.mode != modeGray4 {
+				status.ClearAll(c)
+				status.SetMessage("Only for 16 color grayscale images")
+				status.Show(c, e)
+				break
+			}
+			op := Op{Op: "filter", Name: name}
+			switch name {
+			case "threshold":
+				op.N, ok = e.PromptNumber(c, tty, status, "Threshold (0-15):", 8, 0, 15)
+			case "posterize":
+				op.N, ok = e.PromptNumber(c, tty, status, "Posterize to levels (2-16):", 4, 2, 16)
+			case "outline", "shadow":
+				prompt := "Outline shade or color:"
+				if name == "shadow" {
+					prompt = "Shadow shade or color:"
+				}
+				var outline color.NRGBA
+				outline, ok = e.PromptPixel(c, tty, status, prompt)
+				op.Color = formatOpColor(outline)
+			}
+			if !ok {
+				// The prompt was cancelled
+				break
+			}
+			undo.Snapshot(e)
+			message, err := e.Do(op)
+			status.ClearAll(c)
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+			} else {
+				status.SetMessage(message)
+			}
+			status.Show(c, e)
+			e.redraw = true
+		case "]", "[": // make the pixel under the cursor one shade brighter (]) or darker ([), since + - and < are shades
+			if !e.drawMode {
+				break
+			}
+			p, inside := e.CursorPixel()
+			if !inside {
+				break
+			}
+			if e.mode != modeGray4 {
+				status.ClearAll(c)
+				status.SetMessage("Only for 16 color grayscale images")
+				status.Show(c, e)
+				break
+			}
+			// Consecutive adjustments of the same pixel are undone in one step
+			if (previousKey != "]" && previousKey != "[") || p != adjustedPixel {
+				undo.Snapshot(e)
+			}
+			steps := 1
+			if key == "[" {
+				steps = -1
+			}
+			if _, err := e.Do(Op{Op: "shade", At: opXY(p), N: steps}); err != nil {
+				status.ClearAll(c)
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				break
+			}
+			adjustedPixel = p
+			e.redraw = true
+		case "p": // pick the color under the cursor as the brush
+			if !e.drawMode || !e.SetBrushFromCursor() {
+				break
+			}
+			status.ClearAll(c)
+			status.SetMessage(e.BrushStatus())
+			status.Show(c, e)
+		case "o": // stamp the brush at the pixel under the cursor
+			if !e.drawMode {
+				break
+			}
+			if p, inside := e.CursorPixel(); inside {
+				undo.SnapshotPixel(e)
+				if _, err := e.Do(Op{Op: "set", At: opXY(p), Color: formatOpColor(e.brush)}); err != nil {
+					status.ClearAll(c)
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+				}
+			}
+		case "m": // mark a corner of a block of pixels, for copying or cutting
+			if !e.drawMode {
+				break
+			}
+			p, _ := e.CursorPixel()
+			e.mark, e.markTool = p, 'm'
+			status.ClearAll(c)
+			status.SetMessage(e.MarkStatus() + " (press y to copy or X to cut)")
+			status.ShowNoTimeout(c, e)
+		case "y", "X": // copy (y) or cut (X) the block of pixels from the mark to the cursor
+			if !e.drawMode {
+				break
+			}
+			status.ClearAll(c)
+			if e.markTool != 'm' {
+				status.SetMessage("Press m to mark a corner of the block first")
+				status.Show(c, e)
+				break
+			}
+			p, _ := e.CursorPixel()
+			msg := e.MarkStatus()
+			// The block is copied before it is cut
+			copyBlock = e.CopyBlock(e.mark, p, false)
+			if key == "X" {
+				undo.Snapshot(e)
+				if _, err := e.Do(Op{Op: "cut-block", At: opXY(e.mark), To: opXY(p)}); err != nil {
+					status.SetErrorMessage(err.Error())
+					status.Show(c, e)
+					break
+				}
+				msg = "Cut " + strings.ToLower(msg[:1]) + msg[1:]
+				e.redraw = true
+			} else {
+				msg = "Copied " + strings.ToLower(msg[:1]) + msg[1:]
+			}
+			e.markTool = 0
+			status.SetMessage(msg)
+			status.Show(c, e)
+		case "v": // paste the block of pixels at the cursor
+			if !e.drawMode {
+				break
+			}
+			if len(copyBlock) == 0 {
+				status.ClearAll(c)
+				status.SetMessage("Nothing to paste, use m and y or X first")
+				status.Show(c, e)
+				break
+			}
+			p, _ := e.CursorPixel()
+			pixels := make([][]string, len(copyBlock))
+			for y, row := range copyBlock {
+				pixels[y] = make([]string, len(row))
+				for x, pc := range row {
+					pixels[y][x] = formatOpColor(pc)
+				}
+			}
+			undo.Snapshot(e)
+			if _, err := e.Do(Op{Op: "paste-block", At: opXY(p), Pixels: pixels}); err != nil {
+				status.ClearAll(c)
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+			}
+			e.redraw = true
 		default:
+			if len([]rune(key)) > 0 && unicode.IsGraphic([]rune(key)[0]) && e.RefuseReadOnly(c, status) {
+				break
+			}
 			if len([]rune(key)) > 0 && unicode.IsLetter([]rune(key)[0]) { // letter
-				undo.Snapshot(e)
+				undo.SnapshotPixel(e)
 				// Type the letter that was pressed
 				if len([]rune(key)) > 0 {
 					// Replace this letter.
 					e.SetRune([]rune(key)[0])
 					e.WriteRune(c)
-					e.redraw = true
+					e.SetBrushFromCursor()
 				}
 			} else if len([]rune(key)) > 0 && unicode.IsGraphic([]rune(key)[0]) { // any other key that can be drawn
-				undo.Snapshot(e)
+				undo.SnapshotPixel(e)
 
 				// Place *something*
 				r := []rune(key)[0]
@@ -522,22 +2339,63 @@ Set NO_COLOR=1 to disable colors.
 
 				e.SetRune([]rune(key)[0])
 				e.WriteRune(c)
+				e.SetBrushFromCursor()
 				e.redrawCursor = true
-				e.redraw = true
 			}
 		}
 		previousKey = key
+		// The cursor can not be placed on the coordinates
+		e.KeepCursorInImage()
+		// Show an indicator while the pen is active (undo may also turn it off)
+		if e.pen {
+			status.SetIndicator("PEN")
+		} else if status.indicator != "" {
+			status.SetIndicator("")
+			e.redraw = true
+		}
+		// Use the colors that have been typed into the legend, in indexed 16 color mode
+		e.UpdatePalette()
+		// Record the changes that were made without the drawing tools, like typing, if -record is given
+		if err := e.RecordChanges(); err != nil {
+			status.SetErrorMessage(err.Error())
+			status.Show(c, e)
+		}
+		// Let the preview page show the changes, if -serve is given
+		if preview != nil {
+			preview.Update(e, filename)
+		}
+		// Draw the pixel under the cursor in bold where it is now, with the high contrast display profile
+		if e.theme.boldCursor && (e.pos.ScreenX() != previousX || e.pos.ScreenY() != previousY) {
+			e.markDirty(e.boldCell.Y)
+			if p, inside := e.CursorPixel(); inside {
+				e.markDirty(p.Y)
+			}
+		}
+		// Scroll the image so that the cursor is in view, if the image is larger than the canvas
+		e.PanToCursor(c)
 		// Redraw, if needed
 		if e.redraw {
 			// Draw the editor lines on the canvas, respecting the offset
 			e.DrawLines(c, true, false)
 			e.redraw = false
-		} else if e.Changed() {
-			c.Draw()
+		} else {
+			// Only write the lines that have changed, if any
+			e.DrawChanged(c)
+		}
+		if status.DrawIndicator(c) {
+			e.redrawCursor = true
+		}
+		// Show if there are unsaved changes in the terminal title, and the new filename after saving as another file
+		SetTitle(titleText(filename, e.changed))
+		// Draw the real colors, the half block preview and the reference image, on top of the canvas
+		if e.DrawOverlays(c) {
+			e.redrawCursor = true
 		}
 		// Drawing status messages should come after redrawing, but before cursor positioning
-		if statusMode {
-			status.ShowLineColWordCount(c, e, filename)
+		if statusMode && e.drawMode {
+			status.ShowPixelPosition(c, e, buffers.Label(filename))
+		} else if statusMode {
+			status.ShowLineColWordCount(c, e, buffers.Label(filename))
 		} else if status.isError {
 			// Show the status message
 			status.Show(c, e)
@@ -545,6 +2403,12 @@ Set NO_COLOR=1 to disable colors.
 		// Position the cursor
 		x := e.pos.ScreenX()
 		y := e.pos.ScreenY()
+		// Show the area from the mark to the cursor, when a drawing tool has set a mark
+		if e.markTool != 0 && (x != previousX || y != previousY) {
+			status.SetMessage(e.MarkStatus())
+			status.ShowNoTimeout(c, e)
+			e.redrawCursor = true
+		}
 		if e.redrawCursor || x != previousX || y != previousY {
 			vt100.SetXY(uint(x), uint(y))
 			e.redrawCursor = false
@@ -553,6 +2417,9 @@ Set NO_COLOR=1 to disable colors.
 		previousY = y
 	}
 
+	DisableMouse()
+	RestoreTitle()
+
 	// Clear all status bar messages
 	status.ClearAll(c)
 
@@ -0,0 +1,35 @@
+package main
+
+import "fmt"
+
+const (
+	// monoBlack and monoWhite are the runes for black and white pixels in 1-bit monochrome mode
+	monoBlack = '_'
+	monoWhite = '@'
+)
+
+// monoRune returns the rune for black (0) or white (1) in monochrome mode
+func monoRune(shade byte) rune {
+	if shade == 1 {
+		return monoWhite
+	}
+	return monoBlack
+}
+
+// monoName returns "black" or "white", for 0 or 1
+func monoName(shade byte) string {
+	if shade == 1 {
+		return "white"
+	}
+	return "black"
+}
+
+// monoLegend returns the legend that is shown below the image in monochrome mode,
+// with only the two runes that can be used
+func monoLegend(hasTransparentPixels bool) string {
+	legend := fmt.Sprintf("\n 0 = %c\n 1 = %c\n", monoBlack, monoWhite)
+	if hasTransparentPixels {
+		legend += " T = transparent\n"
+	}
+	return legend
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xyproto/vt100"
+)
+
+const (
+	// Report button presses, releases and drags, using the SGR (1006) format
+	enableMouseTracking  = "\x1b[?1000h\x1b[?1002h\x1b[?1006h"
+	disableMouseTracking = "\x1b[?1006l\x1b[?1002l\x1b[?1000l"
+
+	mouseLeft      = 0
+	mouseWheelUp   = 64
+	mouseWheelDown = 65
+)
+
+// MouseEvent is a mouse button press, drag, release or scroll wheel event
+type MouseEvent struct {
+	button  int  // 0 for the left button, 1 for the middle button, 2 for the right button, 64 and 65 for the wheel
+	x, y    int  // the screen coordinates, counted from 0
+	drag    bool // is the mouse being moved while the button is held down?
+	release bool // was the button released?
+}
+
+// KeyReader reads keypresses and mouse events from the TTY.
+// Unlike tty.String, it reads more than 3 bytes at a time, which is needed for mouse events,
+// and keeps the bytes that are not used yet for the next call to Read.
+type KeyReader struct {
+	tty     *vt100.TTY
+	pending []byte
+	queued  []string // keys that are returned by Read before reading from the TTY again
+}
+
+// NewKeyReader creates a new KeyReader for the given TTY
+func NewKeyReader(tty *vt100.TTY) *KeyReader {
+	return &KeyReader{tty, nil, nil}
+}
+
+// EnableMouse makes the terminal report mouse events
+func EnableMouse() {
+	fmt.Print(enableMouseTracking)
+}
+
+// DisableMouse makes the terminal stop reporting mouse events
+func DisableMouse() {
+	fmt.Print(disableMouseTracking)
+}
+
+// Read will block and then return either a key or a mouse event.
+// The keys are returned in the same way as by tty.String, for instance "a", "c:17" or "←".
+// Arrow keys that are pressed together with shift are returned as "⇧←", "⇧→", "⇧↑" or "⇧↓", and shift-tab as "⇧⇥".
+// An empty string and nil are returned if the input could not be interpreted.
+func (kr *KeyReader) Read() (string, *MouseEvent) {
+	if len(kr.queued) > 0 {
+		key := kr.queued[0]
+		kr.queued = kr.queued[1:]
+		return key, nil
+	}
+	if len(kr.pending) == 0 {
+		buf := make([]byte, 256)
+		kr.tty.RawMode()
+		kr.tty.SetTimeout(0)
+		n, err := kr.tty.Term().Read(buf)
+		kr.tty.Restore()
+		if err != nil {
+			return "", nil
+		}
+		kr.pending = buf[:n]
+	}
+	b := kr.pending
+	switch {
+	case len(b) == 0:
+		return "", nil
+	case bytes.HasPrefix(b, []byte("\x1b[<")):
+		// A mouse event, on the form ESC [ < button ; x ; y M, or ending with m for a release
+		end := bytes.IndexAny(b, "Mm")
+		if end < 0 {
+			kr.pending = nil
+			return "", nil
+		}
+		kr.pending = b[end+1:]
+		return "", parseMouseEvent(string(b[3:end]), b[end] == 'm')
+	case len(b) >= 6 && bytes.HasPrefix(b, []byte("\x1b[1;2")):
+		// An arrow key with shift, on the form ESC [ 1 ; 2 A
+		kr.pending = b[6:]
+		switch b[5] {
+		case 'A':
+			return "⇧↑", nil
+		case 'B':
+			return "⇧↓", nil
+		case 'C':
+			return "⇧→", nil
+		case 'D':
+			return "⇧←", nil
+		}
+		return "", nil
+	case len(b) >= 3 && b[0] == 27 && b[1] == '[':
+		kr.pending = b[3:]
+		switch b[2] {
+		case 'A':
+			return "↑", nil
+		case 'B':
+			return "↓", nil
+		case 'C':
+			return "→", nil
+		case 'D':
+			return "←", nil
+		case 'Z':
+			return "⇧⇥", nil
+		}
+		// Skip the rest of other escape sequences
+		kr.pending = nil
+		return "", nil
+	}
+	r, size := utf8.DecodeRune(b)
+	kr.pending = b[size:]
+	if r == utf8.RuneError {
+		return "", nil
+	}
+	if size == 1 && !unicode.IsPrint(r) {
+		return "c:" + strconv.Itoa(int(r)), nil
+	}
+	return string(r), nil
+}
+
+// Queue makes the next call to Read return the given key, as if it was pressed,
+// for running the commands that are chosen from the command palette
+func (kr *KeyReader) Queue(key string) {
+	kr.queued = append(kr.queued, key)
+}
+
+// parseMouseEvent parses the "button;x;y" part of an SGR mouse event
+func parseMouseEvent(s string, release bool) *MouseEvent {
+	var button, x, y int
+	if _, err := fmt.Sscanf(s, "%d;%d;%d", &button, &x, &y); err != nil {
+		return nil
+	}
+	m := &MouseEvent{release: release}
+	// Bit 5 is set while the mouse is moved with the button held down
+	m.drag = button&32 != 0
+	m.button = button &^ 32
+	// The coordinates start at 1
+	m.x, m.y = x-1, y-1
+	return m
+}
+
+// MoveToScreenPosition moves the cursor to the text that is drawn at the given screen coordinates,
+// taking the zoom factor and gutter into account. Returns false if the coordinates are outside of the text area.
+func (e *Editor) MoveToScreenPosition(c *vt100.Canvas, x, y int) bool {
+	// The last line is used by the status bar
+	if x >= int(c.W()) || y >= int(c.H())-1 {
+		return false
+	}
+	x -= e.pos.left
+	y -= e.pos.top
+	if x < 0 || y < 0 {
+		return false
+	}
+	e.pos.sx = x/e.pos.Zoom() + e.pos.panX
+	e.pos.sy = y/e.pos.Zoom() + e.pos.panY
+	e.KeepCursorInImage()
+	e.redrawCursor = true
+	return true
+}
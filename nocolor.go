@@ -13,6 +13,7 @@ func (e *Editor) respectNoColorEnvironmentVariable() {
 		e.bg = vt100.BackgroundDefault
 		e.searchFg = vt100.Default
 		e.gitColor = vt100.Default
+		e.noColor = true
 		syntax.DefaultTextConfig.String = ""
 		syntax.DefaultTextConfig.Keyword = ""
 		syntax.DefaultTextConfig.Comment = ""
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// opsVersion is the version of the format of operations scripts, for -record and -apply.
+// New operations can be added without changing it, but scripts with a newer version are refused.
+const opsVersion = 1
+
+// maxRecordedPixels is how many changed pixels are recorded as set operations, when the image has been
+// changed without going through Apply, like when typing. If more have changed, the whole image is recorded.
+const maxRecordedPixels = 16
+
+// Op is an operation that changes the image, as it is recorded with -record and replayed with -apply.
+// Pixels are given as [x, y] and colors as #rrggbbaa. Only the fields that the operation uses are set.
+type Op struct {
+	Op     string     `json:"op"`               // the name of the operation, see Apply
+	At     []int      `json:"at,omitempty"`     // a pixel, or the first corner or end point
+	To     []int      `json:"to,omitempty"`     // the other corner or end point
+	By     []int      `json:"by,omitempty"`     // how many pixels to shift the image by
+	Color  string     `json:"color,omitempty"`  // the brush, or the color that is replaced
+	With   string     `json:"with,omitempty"`   // the color that replaces Color
+	Name   string     `json:"name,omitempty"`   // the pattern, filter or stamp
+	N      int        `json:"n,omitempty"`      // the steps, period, threshold level or number of levels
+	Wrap   bool       `json:"wrap,omitempty"`   // wrap around when shifting the image?
+	Letter string     `json:"letter,omitempty"` // the letter to draw
+	Bold   bool       `json:"bold,omitempty"`   // draw the letter in bold?
+	Pixels [][]string `json:"pixels,omitempty"` // rows of colors, for pasting a block
+	Mode   string     `json:"mode,omitempty"`   // the mode of the whole image
+	Size   []int      `json:"size,omitempty"`   // the width and height of the whole image
+	Lines  []string   `json:"lines,omitempty"`  // the text of the whole image, including any legend
+	File   string     `json:"file,omitempty"`   // the file that was saved
+}
+
+// OpsScript is the contents of an operations script
+type OpsScript struct {
+	Version int  `json:"version"`
+	Ops     []Op `json:"ops"`
+}
+
+// opsEnd is the end of an operations script, after the last operation
+const opsEnd = "\n  ]\n}\n"
+
+// Recorder writes the operations that change the image to an operations script, as they happen
+type Recorder struct {
+	filename string
+	offset   int64    // where the next operation is written, over the end of the script
+	count    int      // how many operations have been recorded
+	lines    [][]rune // the image as it was after the last recorded operation, for finding other changes
+	mode     Mode
+	width    int
+	height   int
+}
+
+// NewRecorder creates a recorder that writes to the given file, and writes an empty script to it right away,
+// so that a file that can not be written is reported before any changes are made
+func NewRecorder(filename string) (*Recorder, error) {
+	start := fmt.Sprintf("{\n  \"version\": %d,\n  \"ops\": [", opsVersion)
+	r := &Recorder{filename: filename, offset: int64(len(start))}
+	return r, ioutil.WriteFile(filename, []byte(start+"]\n}\n"), 0644)
+}
+
+// Record adds an operation to the script. Only the operation and the end of the script are written, over the
+// old end, so that the file is a complete script after each operation, without writing all of it again.
+// The script looks like it was written with json.MarshalIndent.
+func (r *Recorder) Record(op Op) error {
+	data, err := json.MarshalIndent(op, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	separator := ",\n    "
+	if r.count == 0 {
+		separator = "\n    "
+	}
+	written := append([]byte(separator), data...)
+	f, err := os.OpenFile(r.filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(append(written, opsEnd...), r.offset); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	r.offset += int64(len(written))
+	r.count++
+	return nil
+}
+
+// remember stores a copy of the image, as it is after the last recorded operation
+func (r *Recorder) remember(e *Editor) {
+	r.lines = e.CopyLines()
+	r.mode, r.width, r.height = e.mode, e.width, e.height
+}
+
+// StartRecording records the operations that change the image to the given file, from now on
+func (e *Editor) StartRecording(filename string) error {
+	r, err := NewRecorder(filename)
+	if err != nil {
+		return err
+	}
+	r.remember(e)
+	e.recorder = r
+	return nil
+}
+
+// ReadOps reads an operations script, and checks that the version is one that can be replayed
+func ReadOps(filename string) (OpsScript, error) {
+	var script OpsScript
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return script, err
+	}
+	if err := json.Unmarshal(data, &script); err != nil {
+		return script, fmt.Errorf("%s is not an operations script: %s", filename, err)
+	}
+	if script.Version < 1 || script.Version > opsVersion {
+		return script, fmt.Errorf("%s has version %d, but only version %d and older can be replayed", filename, script.Version, opsVersion)
+	}
+	return script, nil
+}
+
+// formatOpColor returns the color as #rrggbbaa, for an operations script
+func formatOpColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// parseOpColor parses a color on the form #rrggbbaa, or #rrggbb for an opaque color
+func parseOpColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || !strings.HasPrefix(s, "#") || (len(hex) != 6 && len(hex) != 8) {
+		return color.NRGBA{}, fmt.Errorf("%q is not a color on the form #rrggbbaa", s)
+	}
+	if len(hex) == 6 {
+		return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// opPoint returns the pixel or size that is given as [x, y] in an operation
+func opPoint(name string, xy []int) (image.Point, error) {
+	if len(xy) != 2 {
+		return image.Point{}, fmt.Errorf("%s must be given as [x, y]", name)
+	}
+	return image.Pt(xy[0], xy[1]), nil
+}
+
+// opPixel returns the pixel that is given as [x, y] in an operation, and checks that it is within the image
+func (e *Editor) opPixel(name string, xy []int) (image.Point, error) {
+	p, err := opPoint(name, xy)
+	if err != nil {
+		return p, err
+	}
+	if p.X < 0 || p.Y < 0 || p.X >= e.width || p.Y >= e.height {
+		return p, fmt.Errorf("pixel %d,%d is outside of the %dx%d image", p.X, p.Y, e.width, e.height)
+	}
+	return p, nil
+}
+
+// modeFromName returns the mode with the given name, as returned by Mode.String
+func modeFromName(name string) (Mode, error) {
+	for _, mode := range []Mode{modeGray4, modeRGB, modeRGBA, modePalette, modeMono} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return modeBlank, fmt.Errorf("%q is not a mode, only gray4, rgb, rgba, palette or mono", name)
+}
+
+// pixelOp returns a set operation for the given pixel, with its current color
+func (e *Editor) pixelOp(x, y int) (Op, error) {
+	c, err := e.Pixel(x, y)
+	if err != nil {
+		return Op{}, err
+	}
+	return Op{Op: "set", At: []int{x, y}, Color: formatOpColor(c)}, nil
+}
+
+// imageOp returns an operation that replaces the whole image with the image as it is now
+func (e *Editor) imageOp() Op {
+	lines := make([]string, len(e.lines))
+	for i, runes := range e.lines {
+		lines[i] = string(runes)
+	}
+	return Op{Op: "image", Mode: e.mode.String(), Size: []int{e.width, e.height}, Lines: lines}
+}
+
+// Apply performs an operation on the image. This is how the drawing tools change the image,
+// both when editing and when replaying an operations script with -apply. The operations are:
+//
+//	set              paint the pixel At with Color
+//	line             draw a line from At to To with Color
+//	rectangle        draw a rectangle from the corner At to the corner To with Color
+//	filled-rectangle the same, but filled
+//	cut-block        make the pixels from the corner At to the corner To transparent
+//	paste-block      paste the rows of Pixels with the top left corner at At
+//	invert           invert the image
+//	brighten         make the image N steps brighter, or darker if N is negative
+//	contrast         increase the contrast by N steps, or decrease it if N is negative
+//	shade            make the pixel At N shades brighter, or darker if N is negative
+//	shift            shift the image By pixels, wrapping around if Wrap is true
+//	trim             trim the image to the artwork
+//	center           move the artwork to the middle of the image
+//	replace          replace the pixels of Color with the color With
+//	generate         fill the image with the pattern Name, with the period N
+//	letter           draw Letter from the built-in font with Color, in Bold or not
+//	stamp            stamp the shape Name at At with Color
+//	filter           apply the filter Name, with the level or levels N or the color Color
+//	image            replace the whole image with Lines, in Mode and with Size
+//	save             the image was saved to File, which is only recorded and does nothing
+//
+// Returns a message that describes what was done, which may be empty.
+func (e *Editor) Apply(op Op) (string, error) {
+	var (
+		c   = e.brush
+		err error
+	)
+	if op.Color != "" {
+		if c, err = parseOpColor(op.Color); err != nil {
+			return "", err
+		}
+	}
+	// The drawing tools paint with the brush
+	brush := e.brush
+	e.brush = c
+	defer func() {
+		e.brush = brush
+	}()
+	switch op.Op {
+	case "set":
+		p, err := e.opPixel("at", op.At)
+		if err != nil {
+			return "", err
+		}
+		e.SetPixel(p.X, p.Y, c)
+		return "", nil
+	case "line", "rectangle", "filled-rectangle", "cut-block":
+		from, err := e.opPixel("at", op.At)
+		if err != nil {
+			return "", err
+		}
+		to, err := e.opPixel("to", op.To)
+		if err != nil {
+			return "", err
+		}
+		switch op.Op {
+		case "line":
+			e.DrawLine(from, to)
+		case "cut-block":
+			e.CopyBlock(from, to, true)
+		default:
+			e.DrawRect(from, to, op.Op == "filled-rectangle")
+		}
+		return "", nil
+	case "paste-block":
+		at, err := e.opPixel("at", op.At)
+		if err != nil {
+			return "", err
+		}
+		block := make([][]color.NRGBA, len(op.Pixels))
+		for y, row := range op.Pixels {
+			block[y] = make([]color.NRGBA, len(row))
+			for x, s := range row {
+				if block[y][x], err = parseOpColor(s); err != nil {
+					return "", err
+				}
+			}
+		}
+		e.PasteBlock(block, at)
+		return "", nil
+	case "invert":
+		e.Invert()
+		return "Inverted", nil
+	case "brighten":
+		e.Brighten(op.N)
+		return fmt.Sprintf("brightness %+d", op.N), nil
+	case "contrast":
+		e.Contrast(op.N)
+		return fmt.Sprintf("contrast %+d", op.N), nil
+	case "shade":
+		p, err := e.opPixel("at", op.At)
+		if err != nil {
+			return "", err
+		}
+		if e.mode != modeGray4 {
+			return "", errors.New("shade is only for 16 color grayscale images")
+		}
+		// Transparent pixels are left as they are
+		e.StepShade(p, op.N)
+		return "", nil
+	case "shift":
+		by, err := opPoint("by", op.By)
+		if err != nil {
+			return "", err
+		}
+		e.ShiftImage(by.X, by.Y, op.Wrap)
+		return fmt.Sprintf("shifted %+d,%+d", by.X, by.Y), nil
+	case "trim":
+		return e.Trim()
+	case "center":
+		return e.CenterArtwork()
+	case "replace":
+		to, err := parseOpColor(op.With)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Replaced %d pixels", e.ReplaceColor(c, to)), nil
+	case "generate":
+		period := op.N
+		if period < 1 {
+			period = 1
+		}
+		if err := e.Generate(op.Name, period); err != nil {
+			return "", err
+		}
+		return "Generated " + op.Name, nil
+	case "letter":
+		letter, err := ParseLetter(op.Letter)
+		if err != nil {
+			return "", err
+		}
+		n, err := e.DrawLetter(letter, op.Bold)
+		if err != nil {
+			return "", err
+		}
+		style := "regular"
+		if op.Bold {
+			style = "bold"
+		}
+		return filterMessage(fmt.Sprintf("Drew the %s letter %q", style, letter), n), nil
+	case "stamp":
+		at, err := opPoint("at", op.At)
+		if err != nil {
+			return "", err
+		}
+		s, ok := findStamp(op.Name)
+		if !ok {
+			return "", fmt.Errorf("%q is not a stamp, only %s", op.Name, strings.Join(stampNames(), ", "))
+		}
+		return filterMessage(fmt.Sprintf("Stamped the %s at %d,%d", op.Name, at.X, at.Y), e.PasteStamp(s, at)), nil
+	case "filter":
+		return e.applyFilter(op.Name, op.N, c)
+	case "image":
+		return "", e.applyImage(op)
+	case "save":
+		return "", nil
+	}
+	return "", fmt.Errorf("%q is not an operation", op.Op)
+}
+
+// applyFilter applies the filter with the given name, for Apply. level is the threshold level or the
+// number of levels to posterize to, and c is the color of the outline or drop shadow.
+func (e *Editor) applyFilter(name string, level int, c color.NRGBA) (string, error) {
+	if isGrayFilter(name) && e.mode != modeGray4 {
+		return "", fmt.Errorf("the %s filter is only for 16 color grayscale images", name)
+	}
+	switch name {
+	case "threshold":
+		if level < 0 || level > 15 {
+			return "", fmt.Errorf("the threshold must be from 0 to 15, not %d", level)
+		}
+		return filterMessage(fmt.Sprintf("Shades below %d are now 0 and the rest 15", level), e.Threshold(byte(level))), nil
+	case "posterize":
+		if level < 2 || level > 16 {
+			return "", fmt.Errorf("the number of levels must be from 2 to 16, not %d", level)
+		}
+		return filterMessage(fmt.Sprintf("Posterized to %d levels", level), e.Posterize(level)), nil
+	case "auto-contrast":
+		lo, hi, n, ok := e.AutoContrast()
+		if !ok {
+			return "Nothing to stretch, the image has less than two shades", nil
+		} else if lo == 0 && hi == 15 {
+			return "The shades already go from 0 to 15", nil
+		}
+		return filterMessage(fmt.Sprintf("Stretched the shades from %d-%d to 0-15", lo, hi), n), nil
+	case "outline":
+		return filterMessage("Added an outline", e.Outline(c)), nil
+	case "shadow":
+		return filterMessage("Added a drop shadow", e.DropShadow(c)), nil
+	}
+	return "", fmt.Errorf("%q is not a filter, only %s", name, strings.Join(filterNames, ", "))
+}
+
+// applyImage replaces the whole image with the image in the operation, for Apply.
+// If the mode or size differs, the image is converted to the current mode and scaled to the current size.
+func (e *Editor) applyImage(op Op) error {
+	mode, err := modeFromName(op.Mode)
+	if err != nil {
+		return err
+	}
+	size, err := opPoint("size", op.Size)
+	if err != nil {
+		return err
+	}
+	if mode != e.mode || size.X != e.width || size.Y != e.height {
+		m, err := textToImage(mode, size, strings.Join(op.Lines, "\n"))
+		if err != nil {
+			return err
+		}
+		return e.ReplaceImage(m, "the recorded image")
+	}
+	e.Clear()
+	for y, line := range op.Lines {
+		for x, r := range []rune(line) {
+			e.Set(x, y, r)
+		}
+	}
+	return nil
+}
+
+// Do performs an operation with Apply, and records it if -record is given
+func (e *Editor) Do(op Op) (string, error) {
+	message, err := e.Apply(op)
+	if err != nil || e.recorder == nil {
+		return message, err
+	}
+	if op.Op == "generate" && op.Name == "noise" {
+		// The noise is random, so the result is recorded instead
+		op = e.imageOp()
+	}
+	e.recorder.remember(e)
+	if err := e.recorder.Record(op); err != nil {
+		return message, errors.New("could not record the operation: " + err.Error())
+	}
+	return message, nil
+}
+
+// RecordSave records that the image was saved, if -record is given
+func (e *Editor) RecordSave(filename string) error {
+	if e.recorder == nil {
+		return nil
+	}
+	if err := e.RecordChanges(); err != nil {
+		return err
+	}
+	return e.recorder.Record(Op{Op: "save", File: filename})
+}
+
+// RecordChanges records the changes to the image that have been made without going through Do, like typing,
+// painting with the pen, undoing or reloading, if -record is given. A few changed pixels are recorded as set
+// operations, and anything else as the whole image.
+func (e *Editor) RecordChanges() error {
+	r := e.recorder
+	if r == nil || !e.drawMode {
+		return nil
+	}
+	ops, ok := e.changedPixelOps(r)
+	if ok && len(ops) == 0 {
+		return nil
+	}
+	if !ok || len(ops) > maxRecordedPixels {
+		ops = []Op{e.imageOp()}
+	}
+	r.remember(e)
+	for _, op := range ops {
+		if err := r.Record(op); err != nil {
+			return errors.New("could not record the changes: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// changedPixelOps returns set operations for the pixels that differ from what the recorder remembers.
+// Returns false if something else than valid pixels has changed, like the size or the legend.
+func (e *Editor) changedPixelOps(r *Recorder) ([]Op, bool) {
+	if r.mode != e.mode || r.width != e.width || r.height != e.height || len(r.lines) != len(e.lines) {
+		return nil, false
+	}
+	var (
+		ops []Op
+		cw  = cellWidth(e.mode)
+	)
+	for y, runes := range e.lines {
+		old := r.lines[y]
+		if string(runes) == string(old) {
+			continue
+		}
+		if y >= e.height || len(runes) != len(old) {
+			return nil, false
+		}
+		for x := 0; x*cw < len(runes); x++ {
+			end := (x + 1) * cw
+			if end > len(runes) {
+				end = len(runes)
+			}
+			if string(runes[x*cw:end]) == string(old[x*cw:end]) {
+				continue
+			}
+			if x >= e.width {
+				return nil, false
+			}
+			op, err := e.pixelOp(x, y)
+			if err != nil {
+				return nil, false
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops, true
+}
+
+// ApplyOps replays the operations script in opsFilename on the image in inFilename, and writes the result to
+// outFilename, in the format that its extension says, without using the terminal. If inFilename does not exist,
+// the operations are replayed on a new blank image. size chooses the image in .ico files with several images.
+func ApplyOps(opsFilename, inFilename, outFilename string, mode Mode, size int) error {
+	script, err := ReadOps(opsFilename)
+	if err != nil {
+		return err
+	}
+	e := NewEditor(vt100.Default, vt100.BackgroundDefault, true, 10, vt100.Default, mode)
+	if _, err := os.Stat(inFilename); err == nil {
+		if e.FileFormat(inFilename).HasEntries() {
+			if err := e.ChooseEntry(nil, nil, nil, inFilename, size); err != nil {
+				return withCode(err, exitDecode)
+			}
+		}
+		if _, err := e.Load(nil, nil, inFilename); err != nil {
+			return withCode(err, exitDecode)
+		}
+	} else {
+		newMode, err := e.PrepareEmpty(nil, nil, inFilename)
+		if err != nil {
+			return withCode(err, exitUsage)
+		}
+		e.mode = newMode
+	}
+	if !e.drawMode {
+		return withCode(errors.New(inFilename+" is not an image"), exitDecode)
+	}
+	for i, op := range script.Ops {
+		if _, err := e.Apply(op); err != nil {
+			return fmt.Errorf("%s, operation %d (%s): %s", opsFilename, i+1, op.Op, err)
+		}
+		// Use the colors that the legend says, in indexed 16 color mode
+		if err := e.UpdatePalette(); err != nil {
+			return fmt.Errorf("%s, operation %d (%s): %s", opsFilename, i+1, op.Op, err)
+		}
+	}
+	if isICNS(outFilename) {
+		return withCode(e.ExportICNS(outFilename), exitEncode)
+	}
+	if err := checkOutputFilename(outFilename); err != nil {
+		return withCode(err, exitUsage)
+	}
+	e.format = formatFromExtension(outFilename)
+	e.icoEntries, e.icoIndex = nil, 0
+	return withCode(e.Save(&outFilename, false, false), exitEncode)
+}
+
+// opXY returns the pixel as [x, y], for an operation
+func opXY(p image.Point) []int {
+	return []int{p.X, p.Y}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xyproto/vt100"
+)
+
+func TestRecordApply(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		in  = filepath.Join(dir, "in.png")
+		ops = filepath.Join(dir, "ops.json")
+		out = filepath.Join(dir, "out.png")
+		red = color.NRGBA{0xff, 0, 0, 0xff}
+	)
+	if err := WriteFavicon(modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), in, true); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEditor(vt100.Default, vt100.BackgroundDefault, true, 10, vt100.Default, modeRGBA)
+	if _, err := e.Load(nil, nil, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.StartRecording(ops); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []Op{
+		{Op: "set", At: []int{0, 0}, Color: formatOpColor(red)},
+		{Op: "line", At: []int{0, 15}, To: []int{15, 0}, Color: "#00ff00"},
+		{Op: "invert"},
+		{Op: "shift", By: []int{1, 2}, Wrap: true},
+		{Op: "generate", Name: "noise", N: 1},
+	} {
+		if _, err := e.Do(op); err != nil {
+			t.Fatalf("%s: %v", op.Op, err)
+		}
+	}
+	// Pixels that are typed are recorded one by one, unless there are too many of them
+	for x := 0; x < 16; x++ {
+		e.SetPixel(x, 4, red)
+		e.SetPixel(x, 5, red)
+	}
+	if err := e.RecordChanges(); err != nil {
+		t.Fatal(err)
+	}
+	e.SetPixel(3, 3, color.NRGBA{})
+	if err := e.RecordSave(out); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := ReadOps(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, op := range script.Ops {
+		names = append(names, op.Op)
+	}
+	if got, want := strings.Join(names, " "), "set line invert shift image image set save"; got != want {
+		t.Errorf("recorded the operations %s, but wanted %s", got, want)
+	}
+
+	// Replaying the script on the same image gives the same image
+	if err := ApplyOps(ops, in, out, modeRGBA, 0); err != nil {
+		t.Fatal(err)
+	}
+	replayed := NewEditor(vt100.Default, vt100.BackgroundDefault, true, 10, vt100.Default, modeRGBA)
+	if _, err := replayed.Load(nil, nil, out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := replayed.String(), e.String(); got != want {
+		t.Errorf("replaying the script gave\n%s\nbut wanted\n%s", got, want)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ops.json")
+	script := OpsScript{Version: opsVersion, Ops: []Op{}}
+	// The file is a complete script after each operation, that looks like the whole script was written at once
+	check := func() {
+		t.Helper()
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := json.MarshalIndent(script, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(data), string(want)+"\n"; got != want {
+			t.Errorf("after %d operations, the script is\n%s\nbut wanted\n%s", len(script.Ops), got, want)
+		}
+		if read, err := ReadOps(filename); err != nil {
+			t.Error(err)
+		} else if len(read.Ops) != len(script.Ops) {
+			t.Errorf("read %d operations, but wanted %d", len(read.Ops), len(script.Ops))
+		}
+	}
+	r, err := NewRecorder(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+	for _, op := range []Op{
+		{Op: "invert"},
+		{Op: "paste", At: []int{1, 2}, Pixels: [][]string{{"#ff0000ff", "#00000000"}}},
+		{Op: "save", File: "favicon.ico"},
+	} {
+		if err := r.Record(op); err != nil {
+			t.Fatal(err)
+		}
+		script.Ops = append(script.Ops, op)
+		check()
+	}
+}
+
+func TestReadOpsVersion(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ops.json")
+	tests := []struct {
+		script string
+		err    string
+	}{
+		{`{"version": 1, "ops": [{"op": "invert"}]}`, ""},
+		{fmt.Sprintf(`{"version": %d, "ops": []}`, opsVersion), ""},
+		{fmt.Sprintf(`{"version": %d, "ops": []}`, opsVersion+1), fmt.Sprintf("has version %d, but only version %d and older", opsVersion+1, opsVersion)},
+		{`{"version": 0, "ops": []}`, "has version 0"},
+		{`{"ops": []}`, "has version 0"},
+		{`{"version": 1, "ops": [`, "is not an operations script"},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(filename, []byte(test.script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ReadOps(filename)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.script, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: read a script that should be refused", test.script)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: got the error %q, but wanted one with %q", test.script, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// paletteHexColumn is the column of the rrggbb hex digits in the lines of the palette legend, like "_ = #rrggbb"
+const paletteHexColumn = 5
+
+// paletteColors are the colors in indexed 16 color mode. Entry i is drawn with the same rune as shade i in
+// grayscale mode. The colors are read from the legend below the image, which can be edited.
+var paletteColors []color.NRGBA
+
+// paletteLegend returns the legend that is shown below the image in indexed 16 color mode,
+// with one line per palette entry, like "_ = #rrggbb"
+func paletteLegend(hasTransparentPixels bool) string {
+	var sb strings.Builder
+	letters := lookupLetters()
+	sb.WriteString("\n")
+	for i, c := range paletteColors {
+		sb.WriteString(fmt.Sprintf("%c = #%02x%02x%02x\n", letters[byte(i)], c.R, c.G, c.B))
+	}
+	if hasTransparentPixels {
+		sb.WriteString("T = transparent\n")
+	}
+	return sb.String()
+}
+
+// parsePaletteLegend reads the palette from the lines of the legend, see paletteLegend
+func parsePaletteLegend(lines []string) ([]color.NRGBA, error) {
+	var (
+		palette []color.NRGBA
+		letters = lookupLetters()
+	)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "T = transparent" {
+			continue
+		}
+		if len(palette) == img.MaxPaletteColors {
+			return nil, fmt.Errorf("the palette can have at most %d colors", img.MaxPaletteColors)
+		}
+		prefix := string(letters[byte(len(palette))]) + " = #"
+		if !strings.HasPrefix(line, prefix) {
+			return nil, fmt.Errorf("palette entry %d must start with %q, not %q", len(palette), prefix, line)
+		}
+		hex := strings.TrimPrefix(line, prefix)
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return nil, fmt.Errorf("palette entry %d is %q, which is not a color on the form #rrggbb", len(palette), "#"+hex)
+		}
+		palette = append(palette, color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff})
+	}
+	return palette, nil
+}
+
+// UpdatePalette reads the palette from the legend below the image, in indexed 16 color mode,
+// so that the pixels are drawn with the colors that have been typed into the legend
+func (e *Editor) UpdatePalette() error {
+	if !e.drawMode || e.mode != modePalette {
+		return nil
+	}
+	var lines []string
+	for y := e.height; y < e.Len(); y++ {
+		lines = append(lines, e.Line(y))
+	}
+	palette, err := parsePaletteLegend(lines)
+	if err != nil {
+		return err
+	}
+	paletteColors = palette
+	return nil
+}
+
+// paletteEditable checks if the given data position is one of the hex digits in the palette legend,
+// which can be edited for changing the color of all the pixels that use that palette entry
+func (e *Editor) paletteEditable(x, y int) bool {
+	return e.drawMode && e.mode == modePalette && y > e.height && y <= e.height+len(paletteColors) &&
+		x >= paletteHexColumn && x < paletteHexColumn+6
+}
+
+// MapPalette replaces every color in the palette legend with the result of f, which changes the colors
+// of all the pixels in indexed 16 color mode. Returns the number of colors that were changed.
+func (e *Editor) MapPalette(f func(color.NRGBA) color.NRGBA) int {
+	if err := e.UpdatePalette(); err != nil {
+		return 0
+	}
+	changed := 0
+	for i, c := range paletteColors {
+		nc := f(c)
+		nc.A = 0xff
+		if nc == c {
+			continue
+		}
+		paletteColors[i] = nc
+		hex := []rune(fmt.Sprintf("%02x%02x%02x", nc.R, nc.G, nc.B))
+		for j, r := range hex {
+			e.Set(paletteHexColumn+j, e.height+1+i, r)
+		}
+		changed++
+	}
+	return changed
+}
+
+// ShadeColor returns the color of the shade with the given number, from 0 to 15.
+// In indexed 16 color mode, this is the color of that palette entry, and false is returned if there is no such entry.
+// In monochrome mode, only 0 (black) and 1 (white) are valid.
+func (e *Editor) ShadeColor(shade byte) (color.NRGBA, bool) {
+	if e.mode == modeMono {
+		// 0 is black and 1 is white
+		if int(shade) >= len(img.MonoColors) {
+			return color.NRGBA{}, false
+		}
+		return img.MonoColors[shade], true
+	}
+	if e.mode != modePalette {
+		return img.ShadeColor(shade), shade < 16
+	}
+	if int(shade) >= len(paletteColors) {
+		return color.NRGBA{}, false
+	}
+	return paletteColors[shade], true
+}
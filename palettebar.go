@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/xyproto/favicon/img"
+	"github.com/xyproto/vt100"
+)
+
+// paletteMinRows is the smallest terminal height, in rows, where the palette bar is shown
+const paletteMinRows = 20
+
+// PaletteBar represents the strip just above the status bar that shows the 16 shades, or the palette entries, and the brush
+type PaletteBar struct {
+	fg        vt100.AttributeColor // foreground color
+	bg        vt100.AttributeColor // background color
+	highlight vt100.AttributeColor // foreground color of the shade that is used by the brush
+	editor    *Editor              // an editor struct (for getting the brush)
+}
+
+// NewPaletteBar takes a foreground color, background color and foreground color for highlighting the brush
+func NewPaletteBar(fg, bg, highlight vt100.AttributeColor, editor *Editor) *PaletteBar {
+	return &PaletteBar{fg, bg, highlight, editor}
+}
+
+// Visible checks if the palette bar should be drawn, which is in draw mode, if the terminal is tall enough
+func (pb *PaletteBar) Visible(c *vt100.Canvas) bool {
+	return pb.editor.drawMode && c.H() >= paletteMinRows
+}
+
+// Draw will draw the palette bar to the canvas, in the line above the status bar.
+// The shade of the brush is highlighted and surrounded by brackets.
+func (pb *PaletteBar) Draw(c *vt100.Canvas) {
+	if !pb.Visible(c) {
+		return
+	}
+	var (
+		y              = c.H() - 2
+		letters        = lookupLetters()
+		shade, isShade = pb.editor.BrushShade()
+	)
+	for x := uint(0); x < c.W(); x++ {
+		pb.editor.writeRune(c, x, y, pb.fg, pb.bg, ' ')
+	}
+	count := byte(16)
+	switch pb.editor.mode {
+	case modePalette:
+		// Only show the entries that are in the palette legend
+		count = byte(len(paletteColors))
+	case modeMono:
+		// Only black and white
+		count = byte(len(img.MonoColors))
+		letters = map[byte]rune{0: monoBlack, 1: monoWhite}
+	}
+	x := uint(0)
+	for i := byte(0); i < count; i++ {
+		text := fmt.Sprintf(" %d%c ", i, letters[i])
+		fg := pb.fg
+		if isShade && i == shade {
+			text = fmt.Sprintf("[%d%c]", i, letters[i])
+			fg = pb.highlight
+		}
+		pb.editor.writeString(c, x, y, fg, pb.bg, text)
+		x += uint(len([]rune(text)))
+	}
+}
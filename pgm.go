@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// pgmMaxShade is the largest value in the .pgm images that are written, one per grayscale shade
+const pgmMaxShade = 15
+
+// pgmReader reads the whitespace separated fields of a netpbm header or ASCII raster, skipping comments
+type pgmReader struct {
+	data []byte
+	pos  int
+}
+
+// skipSpace skips whitespace and comments, which start with # and last to the end of the line
+func (pr *pgmReader) skipSpace() {
+	for pr.pos < len(pr.data) {
+		switch b := pr.data[pr.pos]; {
+		case b == '#':
+			for pr.pos < len(pr.data) && pr.data[pr.pos] != '\n' && pr.data[pr.pos] != '\r' {
+				pr.pos++
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f':
+			pr.pos++
+		default:
+			return
+		}
+	}
+}
+
+// field returns the next field, or an empty string if there are no more fields
+func (pr *pgmReader) field() string {
+	pr.skipSpace()
+	start := pr.pos
+	for pr.pos < len(pr.data) {
+		b := pr.data[pr.pos]
+		if b == '#' || b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f' {
+			break
+		}
+		pr.pos++
+	}
+	return string(pr.data[start:pr.pos])
+}
+
+// number returns the next field as a number from 0 to max. what is used in error messages.
+func (pr *pgmReader) number(what string, max int) (int, error) {
+	s := pr.field()
+	if s == "" {
+		return 0, errors.New("the .pgm image is truncated, the " + what + " is missing")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("the %s in the .pgm image is %q, but must be a number from 0 to %d", what, s, max)
+	}
+	return n, nil
+}
+
+// DecodePGM reads a netpbm grayscale image, with either an ASCII (P2) or a binary (P5) raster
+func DecodePGM(r io.Reader) (*image.Gray, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	pr := &pgmReader{data: data}
+	magic := pr.field()
+	if magic != "P2" && magic != "P5" {
+		return nil, errors.New("not a .pgm image, the first bytes must be P2 or P5")
+	}
+	width, err := pr.number("width", img.MaxDecodeSize())
+	if err != nil {
+		return nil, err
+	}
+	height, err := pr.number("height", img.MaxDecodeSize())
+	if err != nil {
+		return nil, err
+	}
+	maxval, err := pr.number("maximum value", 65535)
+	if err != nil {
+		return nil, err
+	}
+	if width < 1 || height < 1 || maxval < 1 {
+		return nil, fmt.Errorf("the width, height and maximum value in the .pgm image must be at least 1, not %d, %d and %d", width, height, maxval)
+	}
+
+	m := image.NewGray(image.Rect(0, 0, width, height))
+	// Scale the values from 0..maxval to 0..255
+	set := func(i, v int) error {
+		if v > maxval {
+			return fmt.Errorf("pixel %d,%d in the .pgm image is %d, which is larger than the maximum value %d", i%width, i/width, v, maxval)
+		}
+		m.Pix[i] = uint8((v*255 + maxval/2) / maxval)
+		return nil
+	}
+
+	if magic == "P2" {
+		for i := 0; i < width*height; i++ {
+			s := pr.field()
+			if s == "" {
+				return nil, fmt.Errorf("the .pgm image is truncated, it has %d of %d pixels", i, width*height)
+			}
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("pixel %d,%d in the .pgm image is %q, which is not a number", i%width, i/width, s)
+			}
+			if err := set(i, v); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	// The binary raster starts after a single whitespace character
+	pr.pos++
+	bytesPerPixel := 1
+	if maxval > 255 {
+		bytesPerPixel = 2
+	}
+	if pr.pos > len(data) {
+		pr.pos = len(data)
+	}
+	raster := data[pr.pos:]
+	if len(raster) < width*height*bytesPerPixel {
+		return nil, fmt.Errorf("the .pgm image is truncated, it has %d of %d pixels", len(raster)/bytesPerPixel, width*height)
+	}
+	for i := 0; i < width*height; i++ {
+		v := int(raster[i*bytesPerPixel])
+		if bytesPerPixel == 2 {
+			v = v<<8 | int(raster[i*2+1])
+		}
+		if err := set(i, v); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// EncodePGM converts the textual representation to an image and writes it to the given io.Writer
+// as an ASCII (P2) .pgm image, with one value from 0 to 15 per grayscale shade.
+// Color images are converted to grayscale, and transparent pixels are written as black.
+func EncodePGM(w io.Writer, mode Mode, size image.Point, text string) error {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "P2\n%d %d\n%d\n", size.X, size.Y, pgmMaxShade)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if x > 0 {
+				buf.WriteByte(' ')
+			}
+			shade, opaque := img.GrayShade(m.At(x, y))
+			if !opaque {
+				shade = 0
+			}
+			fmt.Fprintf(&buf, "%2d", shade)
+		}
+		buf.WriteByte('\n')
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// WritePGM converts the textual representation to an image and saves it as a .pgm image, see EncodePGM
+func WritePGM(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodePGM(w, mode, size, text)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestDecodePGM(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []uint8
+	}{
+		{"P2", "P2\n2 2\n15\n0 15\n7 8\n", []uint8{0, 255, 119, 136}},
+		{"P2 with comments", "P2 # made by hand\n# 2x2\n2 2 # width and height\n15\n0 15 # first row\n# second row\n7\n8", []uint8{0, 255, 119, 136}},
+		{"P5", "P5\n2 2\n255\n\x00\x80\xff\x01", []uint8{0, 128, 255, 1}},
+		{"P5 with comments", "P5 # made by hand\n2 # width\n2 # height\n255\n\x00\x80\xff\x01", []uint8{0, 128, 255, 1}},
+		{"16-bit P5", "P5\n2 2\n1023\n\x00\x00\x03\xff\x02\x00\x00\x01", []uint8{0, 255, 128, 0}},
+	}
+	for _, test := range tests {
+		m, err := DecodePGM(strings.NewReader(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if size := m.Bounds().Size(); size != image.Pt(2, 2) {
+			t.Errorf("%s: the image is %v, but wanted 2x2", test.name, size)
+			continue
+		}
+		if !bytes.Equal(m.Pix, test.want) {
+			t.Errorf("%s: got the pixels %v, but wanted %v", test.name, m.Pix, test.want)
+		}
+	}
+}
+
+func TestDecodePGMBroken(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"empty", "", "not a .pgm image"},
+		{"P6", "P6\n1 1\n255\n\x00\x00\x00", "not a .pgm image"},
+		{"no height", "P2\n2 # width\n", "truncated, the height is missing"},
+		{"no maximum value", "P5 2 2", "truncated, the maximum value is missing"},
+		{"width", "P2\nx 2\n15\n", `the width in the .pgm image is "x"`},
+		{"zero width", "P2\n0 2\n15\n", "must be at least 1, not 0, 2 and 15"},
+		{"truncated P2", "P2\n2 2\n15\n0 15\n7", "truncated, it has 3 of 4 pixels"},
+		{"truncated P5", "P5\n2 2\n255\n\x00\x80\xff", "truncated, it has 3 of 4 pixels"},
+		{"truncated 16-bit P5", "P5\n2 1\n1023\n\x00\x00\x03", "truncated, it has 1 of 2 pixels"},
+		{"not a number", "P2\n2 2\n15\n0 15\n7 x", `pixel 1,1 in the .pgm image is "x", which is not a number`},
+		{"above the maximum value", "P2\n2 2\n15\n0 16\n7 8", "pixel 1,0 in the .pgm image is 16, which is larger than the maximum value 15"},
+		{"16-bit above the maximum value", "P5\n2 1\n1000\n\x00\x00\x03\xe9", "pixel 1,0 in the .pgm image is 1001, which is larger than the maximum value 1000"},
+	}
+	for _, test := range tests {
+		_, err := DecodePGM(strings.NewReader(test.data))
+		if err == nil {
+			t.Errorf("%s: decoded the broken .pgm image %q", test.name, test.data)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got the error %q, but wanted one with %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestEncodePGM(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 2, 2))
+	copy(m.Pix, []uint8{0, 255, 119, 136})
+	mode, size, text, _, err := imageToText(m, "shades.pgm", true, modeGray4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodePGM(&buf, mode, size, string(text)); err != nil {
+		t.Fatal(err)
+	}
+	want := "P2\n2 2\n15\n 0 15\n 7  8\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nbut wanted\n%s", got, want)
+	}
+	decoded, err := DecodePGM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.Pix, m.Pix) {
+		t.Errorf("got the pixels %v back, but wanted %v", decoded.Pix, m.Pix)
+	}
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// CursorPixel returns the image coordinates of the pixel under the cursor, clamped to the image area.
+// The bool is false if the cursor is outside of the image area.
+func (e *Editor) CursorPixel() (image.Point, bool) {
+	p := image.Pt(e.pos.sx/cellWidth(e.mode), e.DataY())
+	inside := p.X < e.width && p.Y < e.height
+	return e.clampPixel(p), inside
+}
+
+// CursorConfined checks if the cursor only moves between the pixels of the image, which is the default in draw mode.
+// Pressing j lets the cursor move freely, for editing the legend.
+func (e *Editor) CursorConfined() bool {
+	return e.drawMode && !e.freeCursor && e.width > 0 && e.height > 0
+}
+
+// StepPixel moves the cursor dx pixels to the right and dy pixels down, without leaving the image area.
+// RGB and RGBA pixels are written with several hex digits, so the cursor moves one column at a time within
+// the row instead, for editing the digits.
+func (e *Editor) StepPixel(dx, dy int) {
+	cw := cellWidth(e.mode)
+	step := cw
+	if e.mode == modeRGB || e.mode == modeRGBA {
+		step = 1
+	}
+	if x := e.pos.sx + dx*step; x >= 0 && x < e.width*cw {
+		e.pos.sx = x
+	}
+	if y := e.pos.sy + dy; y >= 0 && y < e.height {
+		e.pos.sy = y
+	}
+}
+
+// ParsePixelCoordinates parses pixel coordinates like "11,6", counted from 0, and checks that they are
+// within the image area
+func (e *Editor) ParsePixelCoordinates(text string) (image.Point, error) {
+	fields := strings.Split(text, ",")
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return image.Point{}, errors.New("pixel coordinates must be given as x,y, not " + strconv.Quote(text))
+	}
+	x, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return image.Point{}, err
+	}
+	y, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return image.Point{}, err
+	}
+	if x >= e.width || y >= e.height {
+		return image.Point{}, fmt.Errorf("pixel %d,%d is outside of the %dx%d image", x, y, e.width, e.height)
+	}
+	return image.Pt(x, y), nil
+}
+
+// GoToPixel moves the cursor to the given pixel, which must be within the image area
+func (e *Editor) GoToPixel(p image.Point) {
+	e.pos.sx = p.X * cellWidth(e.mode)
+	e.pos.sy = p.Y
+}
+
+// clampPixel moves the given image coordinates to the closest pixel within the image area
+func (e *Editor) clampPixel(p image.Point) image.Point {
+	if p.X < 0 {
+		p.X = 0
+	} else if p.X >= e.width {
+		p.X = e.width - 1
+	}
+	if p.Y < 0 {
+		p.Y = 0
+	} else if p.Y >= e.height {
+		p.Y = e.height - 1
+	}
+	return p
+}
+
+// Pixel returns the color of the pixel at the given image coordinates
+func (e *Editor) Pixel(x, y int) (color.NRGBA, error) {
+	cw := cellWidth(e.mode)
+	cell := make([]rune, cw)
+	for i := range cell {
+		cell[i] = e.Get(x*cw+i, y)
+	}
+	return parsePixel(e.mode, cell)
+}
+
+// SetPixel sets the pixel at the given image coordinates, if it is within the image area
+func (e *Editor) SetPixel(x, y int, c color.NRGBA) {
+	if x < 0 || y < 0 || x >= e.width || y >= e.height {
+		return
+	}
+	cw := cellWidth(e.mode)
+	for i, r := range []rune(pixelText(e.mode, c)) {
+		e.Set(x*cw+i, y, r)
+	}
+}
+
+// SetBrushFromCursor sets the brush to the color of the pixel under the cursor.
+// Returns false if the cursor is outside of the image area or if the pixel is not valid.
+func (e *Editor) SetBrushFromCursor() bool {
+	p, inside := e.CursorPixel()
+	if !inside {
+		return false
+	}
+	c, err := e.Pixel(p.X, p.Y)
+	if err != nil {
+		return false
+	}
+	e.brush = c
+	return true
+}
+
+// PenDown paints the pixel under the cursor with the brush, if the pen is active
+func (e *Editor) PenDown() {
+	if !e.pen {
+		return
+	}
+	if p, inside := e.CursorPixel(); inside {
+		e.SetPixel(p.X, p.Y, e.brush)
+	}
+}
+
+// Erase moves the cursor to the previous pixel, like backspace, and makes that pixel transparent.
+// If the cursor is at the first pixel of a row, that pixel is made transparent instead.
+// Returns false if the cursor is outside of the image area.
+func (e *Editor) Erase() bool {
+	p, inside := e.CursorPixel()
+	if !inside {
+		return false
+	}
+	if p.X > 0 && e.pos.sx > 0 {
+		p.X--
+	}
+	e.pos.sx = p.X * cellWidth(e.mode)
+	// Writes "T " in grayscale mode and a blank cell in RGB and RGBA mode, keeping the columns aligned
+	e.SetPixel(p.X, p.Y, color.NRGBA{0, 0, 0, 0})
+	return true
+}
+
+// BrushShade returns the shade of the brush, from 0 to 15, if the brush is one of the 16 grayscale shades,
+// or the palette entry of the brush, in indexed 16 color mode, or 0 for black and 1 for white, in monochrome mode
+func (e *Editor) BrushShade() (byte, bool) {
+	if e.brush.A == 0 {
+		return 0, false
+	}
+	if e.mode == modePalette || e.mode == modeMono {
+		colors := paletteColors
+		if e.mode == modeMono {
+			colors = img.MonoColors
+		}
+		for i, c := range colors {
+			if c == e.brush {
+				return byte(i), true
+			}
+		}
+		return 0, false
+	}
+	if e.mode == modeGray4 {
+		// Use the same conversion as when the brush is drawn
+		shade, _ := img.RuneShade([]rune(pixelText(e.mode, e.brush))[0])
+		return shade, true
+	}
+	shade := e.brush.R / 16
+	return shade, e.brush == img.ShadeColor(shade)
+}
+
+// BrushStatus returns a description of the brush, like "brush: @ (14)", "brush: |ff0000" or "brush: eraser"
+func (e *Editor) BrushStatus() string {
+	if e.brush.A == 0 {
+		return "brush: eraser"
+	}
+	if shade, ok := e.BrushShade(); ok && e.mode == modeGray4 {
+		return fmt.Sprintf("brush: %c (%d)", lookupLetters()[shade], shade)
+	} else if ok && e.mode == modeMono {
+		return fmt.Sprintf("brush: %c (%d)", monoRune(shade), shade)
+	} else if ok && e.mode == modePalette {
+		return fmt.Sprintf("brush: %c (%d, #%02x%02x%02x)", lookupLetters()[shade], shade, e.brush.R, e.brush.G, e.brush.B)
+	}
+	return "brush: " + pixelText(e.mode, e.brush)
+}
+
+// PixelStatusMessage returns the coordinates of the pixel under the cursor, counted from 0,
+// together with the shade and numeric value, like "x 11 y 6 shade @ (14)", or the color, like "x 11 y 6 color |ff0000",
+// followed by how many pixels in the image are not transparent, like "200/256 opaque".
+// Returns false if the cursor is outside of the image area.
+func (e *Editor) PixelStatusMessage() (string, bool) {
+	p, inside := e.CursorPixel()
+	if !inside {
+		return "", false
+	}
+	return e.pixelStatus(p) + fmt.Sprintf(", %d/%d opaque", e.Histogram().Opaque(), e.width*e.height), true
+}
+
+// pixelStatus returns the coordinates of the given pixel, together with the shade or color, see PixelStatusMessage
+func (e *Editor) pixelStatus(p image.Point) string {
+	pixel, err := e.Pixel(p.X, p.Y)
+	if err != nil {
+		return fmt.Sprintf("x %d y %d", p.X, p.Y)
+	}
+	if pixel.A == 0 {
+		return fmt.Sprintf("x %d y %d transparent", p.X, p.Y)
+	}
+	if e.mode == modeGray4 {
+		shade := pixel.R / 16
+		return fmt.Sprintf("x %d y %d shade %c (%d)", p.X, p.Y, lookupLetters()[shade], shade)
+	}
+	if e.mode == modeMono {
+		shade, _ := img.MonoShade(pixel)
+		return fmt.Sprintf("x %d y %d %c (%s)", p.X, p.Y, monoRune(shade), monoName(shade))
+	}
+	if e.mode == modePalette {
+		return fmt.Sprintf("x %d y %d color %c (#%02x%02x%02x)", p.X, p.Y, e.Get(p.X*cellWidth(e.mode), p.Y), pixel.R, pixel.G, pixel.B)
+	}
+	return fmt.Sprintf("x %d y %d color %s", p.X, p.Y, pixelText(e.mode, pixel))
+}
+
+// ValidatePixels checks that all pixels in the image area can be saved as they are.
+// In 16 color grayscale mode, unknown runes would otherwise be saved as black pixels,
+// and runes in the column after each pixel would be left out.
+// In indexed 16 color mode, the palette in the legend is checked as well.
+func (e *Editor) ValidatePixels() error {
+	if err := e.UpdatePalette(); err != nil {
+		return err
+	}
+	cw := cellWidth(e.mode)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			if e.mode == modeRGB || e.mode == modeRGBA {
+				if _, err := e.Pixel(x, y); err != nil {
+					return fmt.Errorf("pixel %d,%d: %s", x, y, err)
+				}
+				continue
+			}
+			if e.mode == modePalette || e.mode == modeMono {
+				if _, err := e.Pixel(x, y); err != nil {
+					return fmt.Errorf("pixel %d,%d: %s", x, y, err)
+				}
+			} else if r := e.Get(x*cw, y); !isShade(r) {
+				return fmt.Errorf("pixel %d,%d has the unknown shade %q (ctrl-f saves anyway)", x, y, r)
+			}
+			if r := e.Get(x*cw+1, y); r != ' ' {
+				return fmt.Errorf("pixel %d,%d is followed by %q instead of a space (ctrl-f saves anyway)", x, y, r)
+			}
+		}
+	}
+	return nil
+}
+
+// ReplaceImage replaces the pixels with the given image, scaled to the current image size.
+// The current mode is kept. The legend is recreated, in 16 color grayscale mode.
+func (e *Editor) ReplaceImage(m image.Image, name string) error {
+	if m.Bounds().Dx() != e.width || m.Bounds().Dy() != e.height {
+		m = img.ScaleNearest(m, e.width)
+	}
+	_, _, data, _, err := imageToText(m, name, true, e.mode)
+	if err != nil {
+		return err
+	}
+	datalines := bytes.Split(data, []byte{'\n'})
+	e.Clear()
+	for y, dataline := range datalines {
+		for x, letter := range []rune(string(dataline)) {
+			e.Set(x, y, letter)
+		}
+	}
+	e.changed = true
+	return nil
+}
+
+// MapPixels replaces every pixel in the image area that is not fully transparent with the result of f.
+// Pixels that can not be parsed are left as they are. Returns the number of pixels that were changed.
+// In indexed 16 color mode, the colors of the palette are replaced instead, see MapPalette.
+func (e *Editor) MapPixels(f func(color.NRGBA) color.NRGBA) int {
+	if e.mode == modePalette {
+		return e.MapPalette(f)
+	}
+	changed := 0
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			c, err := e.Pixel(x, y)
+			if err != nil || c.A == 0 {
+				continue
+			}
+			if nc := f(c); nc != c {
+				e.SetPixel(x, y, nc)
+				changed++
+			}
+		}
+	}
+	return changed
+}
+
+// Invert inverts the intensity of every pixel that is not transparent.
+// In grayscale mode, the shade v becomes 15-v. In RGB and RGBA mode, each color channel is inverted.
+func (e *Editor) Invert() {
+	e.MapPixels(func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{0xff - c.R, 0xff - c.G, 0xff - c.B, c.A}
+	})
+}
+
+// ReplaceColor replaces every pixel with the color from with the color to.
+// All fully transparent pixels count as the same color. Returns the number of pixels that were changed.
+func (e *Editor) ReplaceColor(from, to color.NRGBA) int {
+	changed := 0
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			c, err := e.Pixel(x, y)
+			if err != nil || !(c == from || (c.A == 0 && from.A == 0)) {
+				continue
+			}
+			e.SetPixel(x, y, to)
+			changed++
+		}
+	}
+	return changed
+}
+
+// intensityStep is how much each color channel is changed by Brighten and Contrast.
+// This is one step in 16 color grayscale mode.
+const intensityStep = 16
+
+// Brighten makes every pixel that is not transparent one step brighter, or one step darker if steps is negative
+func (e *Editor) Brighten(steps int) {
+	e.MapPixels(func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{clampChannel(int(c.R) + steps*intensityStep), clampChannel(int(c.G) + steps*intensityStep), clampChannel(int(c.B) + steps*intensityStep), c.A}
+	})
+}
+
+// Contrast moves every color channel of every pixel that is not transparent one step away from the midpoint,
+// or one step towards the midpoint if steps is negative
+func (e *Editor) Contrast(steps int) {
+	adjust := func(v uint8) uint8 {
+		if steps < 0 {
+			// Compress, but do not cross the midpoint
+			if v < 0x80 {
+				if nv := int(v) - steps*intensityStep; nv < 0x80 {
+					return uint8(nv)
+				}
+				return 0x7f
+			}
+			if nv := int(v) + steps*intensityStep; nv >= 0x80 {
+				return uint8(nv)
+			}
+			return 0x80
+		}
+		// Stretch
+		if v < 0x80 {
+			return clampChannel(int(v) - steps*intensityStep)
+		}
+		return clampChannel(int(v) + steps*intensityStep)
+	}
+	e.MapPixels(func(c color.NRGBA) color.NRGBA {
+		return color.NRGBA{adjust(c.R), adjust(c.G), adjust(c.B), c.A}
+	})
+}
+
+// StepShade makes the given pixel one shade brighter, or one shade darker if steps is negative, clamped to 0..15.
+// Only for 16 color grayscale mode. Returns false if the pixel is transparent, outside of the image or if
+// the image is not in 16 color grayscale mode.
+func (e *Editor) StepShade(p image.Point, steps int) bool {
+	if e.mode != modeGray4 || p.X < 0 || p.Y < 0 || p.X >= e.width || p.Y >= e.height {
+		return false
+	}
+	c, err := e.Pixel(p.X, p.Y)
+	if err != nil || c.A == 0 {
+		return false
+	}
+	v := clampChannel(int(c.R) + steps*intensityStep)
+	e.SetPixel(p.X, p.Y, color.NRGBA{v, v, v, c.A})
+	return true
+}
+
+// clampChannel returns the given value as a color channel value, clamped to 0..255
+func clampChannel(v int) uint8 {
+	if v < 0 {
+		return 0
+	} else if v > 0xff {
+		return 0xff
+	}
+	return uint8(v)
+}
+
+// DrawLine draws a line from one pixel to another with the brush color, by using Bresenham's line algorithm.
+// The end points are clamped to the image area.
+func (e *Editor) DrawLine(from, to image.Point) {
+	from, to = e.clampPixel(from), e.clampPixel(to)
+	dx, dy := abs(to.X-from.X), -abs(to.Y-from.Y)
+	sx, sy := 1, 1
+	if from.X > to.X {
+		sx = -1
+	}
+	if from.Y > to.Y {
+		sy = -1
+	}
+	x, y, err := from.X, from.Y, dx+dy
+	for {
+		e.SetPixel(x, y, e.brush)
+		if x == to.X && y == to.Y {
+			break
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y += sy
+		}
+	}
+}
+
+// DrawRect draws a rectangle between two corner pixels with the brush color, either filled or as an outline.
+// The corners are clamped to the image area.
+func (e *Editor) DrawRect(from, to image.Point, filled bool) {
+	r := image.Rectangle{e.clampPixel(from), e.clampPixel(to)}.Canon()
+	for y := r.Min.Y; y <= r.Max.Y; y++ {
+		for x := r.Min.X; x <= r.Max.X; x++ {
+			if filled || y == r.Min.Y || y == r.Max.Y || x == r.Min.X || x == r.Max.X {
+				e.SetPixel(x, y, e.brush)
+			}
+		}
+	}
+}
+
+// CopyBlock returns the pixels in the block between two corner pixels, as rows of colors.
+// If cut is true, the pixels in the block are made transparent.
+func (e *Editor) CopyBlock(from, to image.Point, cut bool) [][]color.NRGBA {
+	r := image.Rectangle{e.clampPixel(from), e.clampPixel(to)}.Canon()
+	block := make([][]color.NRGBA, 0, r.Dy()+1)
+	for y := r.Min.Y; y <= r.Max.Y; y++ {
+		row := make([]color.NRGBA, 0, r.Dx()+1)
+		for x := r.Min.X; x <= r.Max.X; x++ {
+			c, err := e.Pixel(x, y)
+			if err != nil {
+				c = color.NRGBA{0, 0, 0, 0}
+			}
+			row = append(row, c)
+			if cut {
+				e.SetPixel(x, y, color.NRGBA{0, 0, 0, 0})
+			}
+		}
+		block = append(block, row)
+	}
+	return block
+}
+
+// PasteBlock writes a block of pixels with the top left corner at the given pixel,
+// overwriting the pixels underneath. Pixels outside of the image area are left out.
+func (e *Editor) PasteBlock(block [][]color.NRGBA, at image.Point) {
+	for y, row := range block {
+		for x, c := range row {
+			e.SetPixel(at.X+x, at.Y+y, c)
+		}
+	}
+}
+
+// PixelRows returns the rows of pixels as text, one line per row, without the legend. Each line is padded with
+// spaces or cut, so that it is exactly as wide as a row of the image, like 32 columns for 16 grayscale pixels.
+func (e *Editor) PixelRows() string {
+	var (
+		rows  = make([]string, e.height)
+		width = lineWidth(e.mode, e.width)
+	)
+	for y := range rows {
+		runes := e.lineRunes(y)
+		if len(runes) > width {
+			runes = runes[:width]
+		}
+		rows[y] = string(runes) + strings.Repeat(" ", width-len(runes))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// PasteRows overwrites the rows of the image with the lines of the given text, starting with the row of the cursor,
+// like when pasting rows of pixels that were copied from another image. The lines are clipped to the image area,
+// and rows that are longer than a line keep the rest of their pixels. Returns the number of rows that were pasted.
+func (e *Editor) PasteRows(text string) int {
+	var (
+		lines = strings.Split(strings.TrimRight(strings.Replace(text, "\r\n", "\n", -1), "\n"), "\n")
+		width = lineWidth(e.mode, e.width)
+		n     = 0
+	)
+	for i, line := range lines {
+		y := e.DataY() + i
+		if y >= e.height {
+			break
+		}
+		// Replace nonbreaking spaces with regular spaces, like when pasting a single line
+		line = strings.Replace(line, "\u00a0", " ", -1)
+		for x, r := range []rune(line) {
+			if x >= width {
+				break
+			}
+			e.Set(x, y, r)
+		}
+		n++
+	}
+	return n
+}
+
+// ShiftImage moves all the pixels in the image area by dx, dy pixels. The runes of each pixel are moved as
+// they are. If wrap is true, the pixels that are moved out on one side come back in on the other side.
+// If not, transparent pixels are shifted in.
+func (e *Editor) ShiftImage(dx, dy int, wrap bool) {
+	cw := cellWidth(e.mode)
+	transparent := []rune(pixelText(e.mode, color.NRGBA{0, 0, 0, 0}))
+	cells := make([][][]rune, e.height)
+	for y := range cells {
+		cells[y] = make([][]rune, e.width)
+		for x := range cells[y] {
+			cell := make([]rune, cw)
+			for i := range cell {
+				cell[i] = e.Get(x*cw+i, y)
+			}
+			cells[y][x] = cell
+		}
+	}
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			// The pixel that ends up at x, y
+			fromX, fromY := x-dx, y-dy
+			cell := transparent
+			if wrap {
+				cell = cells[(fromY%e.height+e.height)%e.height][(fromX%e.width+e.width)%e.width]
+			} else if fromX >= 0 && fromY >= 0 && fromX < e.width && fromY < e.height {
+				cell = cells[fromY][fromX]
+			}
+			for i, r := range cell {
+				e.Set(x*cw+i, y, r)
+			}
+		}
+	}
+	e.changed = true
+}
+
+// ShiftStatus returns a description of what happens at the edges when the image is shifted, for the status bar
+func (e *Editor) ShiftStatus() string {
+	if e.wrapShift {
+		return " (wrapping around)"
+	}
+	return " (shifting in transparent pixels)"
+}
+
+// MarkStatus returns a status message for the drawing tool that has set a mark,
+// describing the area from the mark to the pixel under the cursor
+func (e *Editor) MarkStatus() string {
+	p, _ := e.CursorPixel()
+	switch e.markTool {
+	case 'l':
+		return fmt.Sprintf("Line from %d,%d to %d,%d", e.mark.X, e.mark.Y, p.X, p.Y)
+	case 'r', 'R':
+		r := image.Rectangle{e.mark, p}.Canon()
+		return fmt.Sprintf("Rectangle from %d,%d to %d,%d (%dx%d)", e.mark.X, e.mark.Y, p.X, p.Y, r.Dx()+1, r.Dy()+1)
+	case 'm':
+		r := image.Rectangle{e.mark, p}.Canon()
+		return fmt.Sprintf("Block from %d,%d to %d,%d (%dx%d)", e.mark.X, e.mark.Y, p.X, p.Y, r.Dx()+1, r.Dy()+1)
+	}
+	return ""
+}
+
+// abs returns the absolute value of an int
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// encoder is used for all .ico and .png images that are written, so that saving the same image twice gives
+// the same bytes. It only writes the chunks that are needed for the pixels, and no chunks with the time or other
+// metadata. The compression level can be set with -png-compression, the interlacing with -png-interlace and the
+// text chunks with -meta.
+var encoder img.Encoder
+
+// pngCompressionLevels are the names of the compression levels that can be given with -png-compression
+var pngCompressionLevels = []struct {
+	name  string
+	level png.CompressionLevel
+}{
+	{"none", png.NoCompression},
+	{"fast", png.BestSpeed},
+	{"default", png.DefaultCompression},
+	{"best", png.BestCompression},
+}
+
+// parsePNGCompression returns the compression level with the given name, from pngCompressionLevels
+func parsePNGCompression(name string) (png.CompressionLevel, error) {
+	for _, l := range pngCompressionLevels {
+		if l.name == name {
+			return l.level, nil
+		}
+	}
+	return png.DefaultCompression, fmt.Errorf("%q is not a compression level, only none, fast, default or best", name)
+}
+
+// metaList is the value of -meta, which can be given several times
+type metaList []string
+
+// String returns the values of -meta, separated by commas
+func (l *metaList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds another value of -meta
+func (l *metaList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// parsePNGMeta parses values of -meta, like "Author=Alex". The keyword must be from 1 to 79 printable ASCII
+// characters, without spaces at the start or the end, as .png images require.
+func parsePNGMeta(values []string) ([]img.Text, error) {
+	var entries []img.Text
+	for _, s := range values {
+		i := strings.IndexByte(s, '=')
+		if i == -1 {
+			return nil, fmt.Errorf("-meta must be like \"Author=Alex\", not %q", s)
+		}
+		key, value := s[:i], s[i+1:]
+		if key == "" || len(key) > 79 || strings.TrimSpace(key) != key {
+			return nil, fmt.Errorf("the keyword %q must be from 1 to 79 characters, without spaces at the start or the end", key)
+		}
+		for _, r := range key {
+			if r < ' ' || r > '~' {
+				return nil, fmt.Errorf("the keyword %q can only contain printable ASCII characters", key)
+			}
+		}
+		if strings.IndexByte(value, 0) != -1 {
+			return nil, fmt.Errorf("the text for %s can not contain NUL bytes", key)
+		}
+		entries = append(entries, img.Text{Key: key, Value: value})
+	}
+	return entries, nil
+}
+
+// ReadPNGText reads the keywords and texts of the text chunks in the given .png image, see img.ReadText
+func ReadPNGText(filename string) ([]img.Text, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return img.ReadText(f)
+}
+
+// pngTextSummary returns the keywords and texts on one line, like "Author: Alex, License: MIT"
+func pngTextSummary(entries []img.Text) string {
+	parts := make([]string, len(entries))
+	for i, entry := range entries {
+		parts[i] = entry.Key + ": " + strings.Join(strings.Fields(entry.Value), " ")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -13,11 +13,16 @@ type Position struct {
 	offset      int // how far one has scrolled
 	scrollSpeed int // how many lines to scroll, when scrolling
 	savedX      int // for smart down cursor movement
+	zoom        int // how many screen cells each cell of text is drawn as, horizontally and vertically
+	left        int // how many screen columns are used to the left of the contents, for the gutter
+	top         int // how many screen rows are used above the contents, for the gutter
+	panX        int // how many columns of the image are scrolled out of view to the left, in draw mode
+	panY        int // how many lines of the image are scrolled out of view above, in draw mode
 }
 
 // NewPosition returns a new Position struct
 func NewPosition(scrollSpeed int) *Position {
-	return &Position{0, 0, 0, scrollSpeed, 0}
+	return &Position{0, 0, 0, scrollSpeed, 0, 1, 0, 0, 0, 0}
 }
 
 // Copy will create a new Position struct that is a copy of this one
@@ -28,17 +33,35 @@ func (p *Position) Copy() Position {
 	p2.offset = p.offset
 	p2.scrollSpeed = p.scrollSpeed
 	p2.savedX = p.savedX
+	p2.zoom = p.zoom
+	p2.left = p.left
+	p2.top = p.top
+	p2.panX = p.panX
+	p2.panY = p.panY
 	return p2
 }
 
-// ScreenX returns the screen X position in the current view
+// ScreenX returns the screen X position in the current view, taking the zoom factor, gutter and panning into account
 func (p *Position) ScreenX() int {
-	return p.sx
+	return p.left + (p.sx-p.panX)*p.Zoom()
 }
 
-// ScreenY returns the screen Y position in the current view
+// ScreenY returns the screen Y position in the current view, taking the zoom factor, gutter and panning into account
 func (p *Position) ScreenY() int {
-	return p.sy
+	return p.top + (p.sy-p.panY)*p.Zoom()
+}
+
+// Zoom returns the zoom factor, which is at least 1
+func (p *Position) Zoom() int {
+	if p.zoom < 1 {
+		return 1
+	}
+	return p.zoom
+}
+
+// SetZoom will set the zoom factor
+func (p *Position) SetZoom(zoom int) {
+	p.zoom = zoom
 }
 
 // Offset returns the scroll offset for the current view
@@ -56,6 +79,12 @@ func (p *Position) SetY(y int) {
 	p.sy = y
 }
 
+// SetMargins will set how many screen columns and rows are used to the left of and above the contents
+func (p *Position) SetMargins(left, top int) {
+	p.left = left
+	p.top = top
+}
+
 // SetOffset will set the screen scolling offset
 func (p *Position) SetOffset(offset int) {
 	p.offset = offset
@@ -74,7 +103,7 @@ func (p *Position) Up() error {
 func (p *Position) Down(c *vt100.Canvas) error {
 	h := 25
 	if c != nil {
-		h = int(c.H())
+		h = (int(c.H()) - p.top) / p.Zoom()
 	}
 	if p.sy >= h-1 {
 		return errors.New("already at the bottom of the canvas")
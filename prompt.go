@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/vt100"
+)
+
+// PromptPixel asks for a pixel in the status bar, in the textual representation of the current mode.
+// In grayscale, indexed 16 color and monochrome mode, a single shade, palette or black and white rune is read. In RGB and RGBA mode, hex digits are read until return is pressed,
+// and no digits means a transparent pixel.
+// Returns false if the prompt was cancelled or if the pixel is not valid, in which case an error is shown.
+func (e *Editor) PromptPixel(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, prompt string) (color.NRGBA, bool) {
+	status.ClearAll(c)
+	status.SetMessage(prompt)
+	status.ShowNoTimeout(c, e)
+
+	if e.mode == modeGray4 || e.mode == modePalette || e.mode == modeMono {
+		for {
+			key := tty.String()
+			switch key {
+			case "":
+				continue
+			case "c:27", "c:17": // esc or ctrl-q
+				status.ClearAll(c)
+				return color.NRGBA{}, false
+			}
+			status.ClearAll(c)
+			r := []rune(key)[0]
+			pixel, err := parsePixel(e.mode, []rune{r})
+			if err != nil || (e.mode == modeGray4 && !isShade(r)) || len([]rune(key)) > 1 {
+				status.SetErrorMessage(fmt.Sprintf("%q is not a valid shade", key))
+				status.Show(c, e)
+				return color.NRGBA{}, false
+			}
+			return pixel, true
+		}
+	}
+
+	digits := ""
+	for {
+		key := tty.String()
+		switch key {
+		case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "a", "b", "c", "d", "e", "f", "A", "B", "C", "D", "E", "F":
+			digits += strings.ToLower(key)
+			status.SetMessage(prompt + " |" + digits)
+			status.ShowNoTimeout(c, e)
+		case "c:8", "c:127": // ctrl-h or backspace
+			if len(digits) > 0 {
+				digits = digits[:len(digits)-1]
+				status.ClearAll(c)
+				status.SetMessage(prompt + " |" + digits)
+				status.ShowNoTimeout(c, e)
+			}
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return color.NRGBA{}, false
+		case "c:13": // return
+			status.ClearAll(c)
+			pixel, err := parsePixel(e.mode, []rune("|"+digits))
+			if err != nil {
+				status.SetErrorMessage(err.Error())
+				status.Show(c, e)
+				return color.NRGBA{}, false
+			}
+			return pixel, true
+		}
+	}
+}
+
+// Confirm asks a yes/no question in the status bar. Returns true only if y is pressed.
+func (e *Editor) Confirm(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, question string) bool {
+	status.ClearAll(c)
+	status.SetMessage(question + " (y/n)")
+	status.ShowNoTimeout(c, e)
+	key := ""
+	for key == "" {
+		key = tty.String()
+	}
+	status.ClearAll(c)
+	return key == "y" || key == "Y"
+}
+
+// Ask asks a question in the status bar and waits until one of the given keys is pressed,
+// as returned by tty.String. Returns the key that was pressed.
+func (e *Editor) Ask(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, question string, keys ...string) string {
+	status.ClearAll(c)
+	status.SetMessage(question)
+	status.ShowNoTimeout(c, e)
+	for {
+		key := tty.String()
+		for _, k := range keys {
+			if key == k {
+				status.ClearAll(c)
+				return key
+			}
+		}
+	}
+}
+
+// PromptFilename asks for a filename in the status bar, starting with the given filename.
+// Tab completes the filename with the .ico, .cur and .png files and the directories that match.
+// Returns false if the prompt was cancelled.
+func (e *Editor) PromptFilename(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, prompt, filename string) (string, bool) {
+	for {
+		status.ClearAll(c)
+		status.SetMessage(prompt + " " + filename)
+		status.ShowNoTimeout(c, e)
+		key := tty.String()
+		switch key {
+		case "c:13": // return
+			status.ClearAll(c)
+			return filename, true
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return "", false
+		case "c:8", "c:127": // ctrl-h or backspace
+			if runes := []rune(filename); len(runes) > 0 {
+				filename = string(runes[:len(runes)-1])
+			}
+		case "c:9": // tab
+			filename = completeFilename(filename)
+		default:
+			if runes := []rune(key); len(runes) == 1 && unicode.IsPrint(runes[0]) {
+				filename += key
+			}
+		}
+	}
+}
+
+// PromptText asks for a line of text in the status bar, starting with the given text.
+// Returns false if the prompt was cancelled.
+func (e *Editor) PromptText(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, prompt, text string) (string, bool) {
+	for {
+		status.ClearAll(c)
+		status.SetMessage(prompt + " " + text)
+		status.ShowNoTimeout(c, e)
+		key := tty.String()
+		switch key {
+		case "c:13": // return
+			status.ClearAll(c)
+			return text, true
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return "", false
+		case "c:8", "c:127": // ctrl-h or backspace
+			if runes := []rune(text); len(runes) > 0 {
+				text = string(runes[:len(runes)-1])
+			}
+		default:
+			if runes := []rune(key); len(runes) == 1 && unicode.IsPrint(runes[0]) {
+				text += key
+			}
+		}
+	}
+}
+
+// ChooseFromMenu lets the user choose one of the names in the status bar, by using the arrow keys and return.
+// Returns false if esc or ctrl-q was pressed.
+func (e *Editor) ChooseFromMenu(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, title string, names []string) (string, bool) {
+	index := 0
+	for {
+		var sb strings.Builder
+		sb.WriteString(title)
+		for i, name := range names {
+			if i == index {
+				sb.WriteString(" [" + name + "]")
+			} else {
+				sb.WriteString(" " + name)
+			}
+		}
+		status.ClearAll(c)
+		status.SetMessage(sb.String())
+		status.ShowNoTimeout(c, e)
+		switch tty.String() {
+		case "←": // left arrow
+			if index > 0 {
+				index--
+			}
+		case "→": // right arrow
+			if index < len(names)-1 {
+				index++
+			}
+		case "c:27", "c:17": // esc or ctrl-q
+			status.ClearAll(c)
+			return "", false
+		case "c:13": // return
+			status.ClearAll(c)
+			return names[index], true
+		}
+	}
+}
+
+// PromptNumber asks for a whole number from min to max in the status bar, starting with the given number.
+// Returns false if the prompt was cancelled or if the number is not valid, in which case an error is shown.
+func (e *Editor) PromptNumber(c *vt100.Canvas, tty *vt100.TTY, status *StatusBar, prompt string, number, min, max int) (int, bool) {
+	text, ok := e.PromptText(c, tty, status, prompt, strconv.Itoa(number))
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || n < min || n > max {
+		status.SetErrorMessage(fmt.Sprintf("%q is not a number from %d to %d", text, min, max))
+		status.Show(c, e)
+		return 0, false
+	}
+	return n, true
+}
+
+// completeFilename returns the longest filename that starts with the given prefix and is shared by all
+// .ico, .cur and .png files and directories that match. A "/" is added if the only match is a directory.
+func completeFilename(prefix string) string {
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return prefix
+	}
+	var candidates []string
+	for _, match := range matches {
+		if fi, err := os.Stat(match); err == nil && fi.IsDir() {
+			candidates = append(candidates, match+string(filepath.Separator))
+		} else if strings.HasSuffix(match, ".ico") || strings.HasSuffix(match, ".cur") || strings.HasSuffix(match, ".png") {
+			candidates = append(candidates, match)
+		}
+	}
+	if len(candidates) == 0 {
+		return prefix
+	}
+	// Find the longest common prefix
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) < len(prefix) {
+		return prefix
+	}
+	return common
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// screenCell is a cell of the canvas, as it was written by the editor
+type screenCell struct {
+	fg, bg vt100.AttributeColor
+	r      rune
+}
+
+// markDirty marks the given line as changed, so that it is written to the terminal by DrawChanged
+func (e *Editor) markDirty(y int) {
+	if e.dirty == nil {
+		e.dirty = make(map[int]bool)
+	}
+	e.dirty[y] = true
+}
+
+// writeRune writes a rune to the canvas, like c.WriteRune, and remembers the colors it was written with,
+// so that a line can later be written to the terminal by itself, instead of drawing the whole canvas
+func (e *Editor) writeRune(c *vt100.Canvas, x, y uint, fg, bg vt100.AttributeColor, r rune) {
+	w, h := c.W(), c.H()
+	if x >= w || y >= h {
+		return
+	}
+	c.WriteRune(x, y, fg, bg, r)
+	if len(e.screen) != int(w*h) {
+		e.screen = make([]screenCell, w*h)
+	}
+	e.screen[y*w+x] = screenCell{fg, bg.Background(), r}
+}
+
+// writeString writes a string to the canvas with writeRune, stopping at the right edge
+func (e *Editor) writeString(c *vt100.Canvas, x, y uint, fg, bg vt100.AttributeColor, s string) {
+	for _, r := range s {
+		e.writeRune(c, x, y, fg, bg, r)
+		x++
+	}
+}
+
+// screenRow returns what the given row of the canvas looks like, as a string with the color codes
+// that the terminal needs for drawing it
+func (e *Editor) screenRow(w, y int) string {
+	var (
+		sb             strings.Builder
+		lastfg, lastbg vt100.AttributeColor
+	)
+	for x := 0; x < w; x++ {
+		cell := e.screen[y*w+x]
+		if x == 0 || !cell.fg.Equal(lastfg) || !cell.bg.Equal(lastbg) {
+			sb.WriteString(cell.fg.Combine(cell.bg).String())
+		}
+		if cell.r < 32 {
+			sb.WriteRune(' ')
+		} else {
+			sb.WriteRune(cell.r)
+		}
+		lastfg, lastbg = cell.fg, cell.bg
+	}
+	return sb.String()
+}
+
+// writeRow writes a row of the canvas to the terminal, for DrawChanged. It can be replaced, for counting
+// which rows are written.
+var writeRow = func(row int, s string) {
+	vt100.SetXY(0, uint(row))
+	os.Stdout.Write([]byte(s))
+}
+
+// DrawChanged writes the lines that have changed since they were last drawn to the terminal, one row at a time,
+// instead of drawing the whole canvas. If nothing has changed, nothing is written, so moving the cursor around
+// does not rewrite any cells. Falls back to DrawLines if the canvas has been resized or is too small.
+func (e *Editor) DrawChanged(c *vt100.Canvas) {
+	if len(e.dirty) == 0 {
+		return
+	}
+	w, h := int(c.W()), int(c.H())
+	if _, small := e.TooSmall(c); small || len(e.screen) != w*h {
+		e.DrawLines(c, true, false)
+		return
+	}
+	var (
+		offset  = e.pos.Offset()
+		first   = offset + e.pos.panY // the first line that is shown, when the image is panned
+		zoom    = e.pos.Zoom()
+		_, top  = e.gutterSize(zoom)
+		bottom  = h - 1 // the status bar
+		visible = e.palette != nil && e.palette.Visible(c)
+		rows    = make(map[int]bool)
+	)
+	if visible {
+		bottom--
+	}
+	// Update the canvas, then pick out the rows that belong to the changed lines
+	e.WriteLines(c, offset, h+offset, 0, 0)
+	for y := range e.dirty {
+		for dy := 0; dy < zoom; dy++ {
+			if row := top + (y-first)*zoom + dy; row >= top && row < bottom {
+				rows[row] = true
+			}
+		}
+	}
+	// The brush may have changed as well
+	if visible {
+		e.palette.Draw(c)
+		rows[h-2] = true
+	}
+	e.dirty = nil
+	for row := range rows {
+		writeRow(row, e.screenRow(w, row))
+	}
+	// The cursor has been moved by writing the rows
+	e.redrawCursor = true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+	"github.com/xyproto/vt100"
+)
+
+// countRows replaces writeRow with a function that counts how many times each row is written, until the test ends
+func countRows(t *testing.T) map[int]int {
+	written := make(map[int]int)
+	original := writeRow
+	writeRow = func(row int, s string) {
+		written[row]++
+	}
+	t.Cleanup(func() {
+		writeRow = original
+	})
+	return written
+}
+
+func TestDrawChangedCursor(t *testing.T) {
+	_, size, text, _, err := imageToText(testImage(16), "test", true, modeGray4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newTestEditor(strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")...)
+	e.drawMode, e.mode, e.width, e.height = true, modeGray4, size.X, size.Y
+	c := vt100.NewCanvas()
+	written := countRows(t)
+
+	// Write the whole canvas once, like DrawLines does when the file is loaded, but without drawing it
+	if err := e.WriteLines(c, 0, int(c.H()), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	e.dirty = nil
+
+	// Moving the cursor around does not write any rows
+	for i := 0; i < 5; i++ {
+		e.pos.Right(c)
+		e.pos.Down(c)
+		e.PanToCursor(c)
+		e.DrawChanged(c)
+	}
+	if len(written) != 0 {
+		t.Errorf("moving the cursor wrote the rows %v", written)
+	}
+
+	// Changing a pixel only writes the row with that pixel
+	e.Set(0, 3, img.ShadeRune(15))
+	e.DrawChanged(c)
+	_, top := e.gutterSize(e.pos.Zoom())
+	if len(written) != 1 || written[top+3] != 1 {
+		t.Errorf("changing a pixel on line 3 wrote the rows %v", written)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// RefView is how the reference image is shown
+type RefView int
+
+const (
+	refHidden RefView = iota // the reference image is not shown
+	refBeside                // the reference image is shown to the right of the image
+	refOnion                 // the reference image is shown in the transparent pixels of the image, as onion skin
+)
+
+// refColor is the escape sequence for the dim color that the reference image is drawn with
+const refColor = "\x1b[90m"
+
+// ReadReference reads an image of any of the supported formats, for showing it as a read-only reference
+// while editing. The image is scaled to the given size, so that each pixel lines up with a pixel in the image.
+func ReadReference(filename string, size image.Point) (*image.NRGBA, error) {
+	format := detectFormat(filename)
+	if format == formatUnknown {
+		return nil, errors.New(filename + " is not an .ico, .cur, .png, .pgm, .bmp or .jpg image")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := decodeImage(f, format)
+	if err != nil {
+		return nil, decodeError(filename, err)
+	}
+	if m.Bounds().Size() != size {
+		m = scaleInteger(m, size.X)
+	}
+	ref := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+	draw.Draw(ref, ref.Bounds(), m, m.Bounds().Min, draw.Src)
+	return ref, nil
+}
+
+// LoadReference reads the given image and shows it to the right of the image, see ReadReference
+func (e *Editor) LoadReference(filename string) error {
+	ref, err := ReadReference(filename, image.Pt(e.width, e.height))
+	if err != nil {
+		return err
+	}
+	e.reference, e.refFile, e.refView = ref, filename, refBeside
+	return nil
+}
+
+// RefStatus returns a description of how the reference image is shown, for the status bar
+func (e *Editor) RefStatus() string {
+	switch e.refView {
+	case refBeside:
+		return fmt.Sprintf("Showing %s to the right of the image (Q for onion skin)", e.refFile)
+	case refOnion:
+		return fmt.Sprintf("Showing %s in the transparent pixels (Q to hide it)", e.refFile)
+	}
+	return "Hiding " + e.refFile + " (Q to show it again)"
+}
+
+// DrawReference draws the reference image in a dim color, on top of what the canvas has already drawn.
+// It is drawn either to the right of the image, or in the pixels of the image that are transparent.
+// The reference image is never a part of the text that is edited and saved.
+func (e *Editor) DrawReference(c *vt100.Canvas) {
+	if !e.drawMode || e.reference == nil || e.refView == refHidden {
+		return
+	}
+	var (
+		sb        strings.Builder
+		cw        = cellWidth(e.mode)
+		zoom      = e.pos.Zoom()
+		left, top = e.gutterSize(zoom)
+		w, h      = int(c.W()), int(c.H()) - top
+		offset    = e.pos.Offset()
+	)
+	if e.refView == refBeside {
+		// Leave one column between the image and the reference image
+		left += e.width*cw*zoom + 1
+	}
+	if !e.noColor {
+		sb.WriteString(refColor)
+	}
+	for y := offset; y < e.height && (y-offset+1)*zoom <= h-1; y++ {
+		for x := 0; x < e.width && left+(x+1)*cw*zoom <= w; x++ {
+			ref := e.reference.NRGBAAt(x, y)
+			if e.refView == refOnion {
+				// Only the transparent pixels of the image show the reference image below
+				if pixel, err := e.Pixel(x, y); ref.A == 0 || err != nil || pixel.A != 0 {
+					continue
+				}
+			}
+			text := []rune(pixelText(e.mode, ref))
+			if ref.A == 0 && !e.literalT {
+				// Transparent pixels are displayed like in the image, see displayLine
+				for i, r := range text {
+					if r == 'T' || r == ' ' {
+						text[i] = checkerRune(x, y)
+					}
+				}
+			}
+			zoomed := make([]rune, cw*zoom)
+			for i := range zoomed {
+				zoomed[i] = text[i/zoom]
+			}
+			for dy := 0; dy < zoom; dy++ {
+				sb.WriteString(fmt.Sprintf("\x1b[%d;%dH", top+(y-offset)*zoom+dy+1, left+x*cw*zoom+1))
+				sb.WriteString(string(zoomed))
+			}
+		}
+	}
+	sb.WriteString(vt100.NoColor())
+	fmt.Print(sb.String())
+}
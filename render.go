@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+	"github.com/xyproto/vt100"
+)
+
+// checkerLight and checkerDark are the colors of the checkerboard pattern that is used for transparent pixels
+var (
+	checkerLight = color.NRGBA{0x99, 0x99, 0x99, 0xff}
+	checkerDark  = color.NRGBA{0x66, 0x66, 0x66, 0xff}
+)
+
+// hasTruecolor checks if the terminal supports 24-bit colors, by looking at $COLORTERM
+func hasTruecolor() bool {
+	colorterm := os.Getenv("COLORTERM")
+	return colorterm == "truecolor" || colorterm == "24bit"
+}
+
+// colorCode returns the escape sequence for using the given color as the background color,
+// together with a black or white foreground color, for the text on top of it.
+// If truecolor is false, the closest color in the 256 color palette is used instead.
+func colorCode(c color.NRGBA, truecolor bool) string {
+	fg := "30" // black
+	if (299*int(c.R)+587*int(c.G)+114*int(c.B))/1000 < 0x80 {
+		fg = "97" // white
+	}
+	if truecolor {
+		return fmt.Sprintf("\x1b[%s;48;2;%d;%d;%dm", fg, c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("\x1b[%s;48;5;%dm", fg, color256(c))
+}
+
+// color256 returns the closest color in the 256 color palette, by using the grayscale ramp for grays
+// and the 6x6x6 color cube for other colors
+func color256(c color.NRGBA) int {
+	if c.R == c.G && c.G == c.B {
+		switch {
+		case c.R < 4:
+			return 16 // black, from the color cube
+		case c.R > 246:
+			return 231 // white, from the color cube
+		}
+		// 24 grays from 8 to 238, in steps of 10
+		return 232 + (int(c.R)-3)/10
+	}
+	cube := func(v uint8) int {
+		return (int(v)*5 + 127) / 255
+	}
+	return 16 + 36*cube(c.R) + 6*cube(c.G) + cube(c.B)
+}
+
+// DrawColors draws the pixels that are visible on the canvas with their real colors as the background color,
+// on top of what the canvas has already drawn. Transparent pixels are drawn as a checkerboard.
+// The text of each pixel is kept, so that the image can still be edited.
+// The escape sequences are written directly, since the canvas only handles 16 color attributes.
+func (e *Editor) DrawColors(c *vt100.Canvas) {
+	if !e.drawMode || e.noColor {
+		return
+	}
+	var (
+		sb        strings.Builder
+		cw        = cellWidth(e.mode)
+		zoom      = e.pos.Zoom()
+		left, top = e.gutterSize(zoom)
+		w, h      = int(c.W()) - left, int(c.H()) - top
+		offset    = e.pos.Offset() + e.pos.panY
+		panX      = e.pos.panX
+		truecolor = hasTruecolor()
+	)
+	for y := offset; y < e.height && (y-offset+1)*zoom <= h-1; y++ {
+		for x := panX / cw; x < e.width && ((x+1)*cw-panX)*zoom <= w; x++ {
+			pixel, err := e.Pixel(x, y)
+			if err != nil || e.isHotspot(x, y) {
+				// Invalid pixels and the hotspot of a cursor are left as they are
+				continue
+			}
+			if pixel.A != 0 && e.mode == modeGray4 && e.theme.shadeColors {
+				// Draw the shades with colors that are easier to tell apart
+				shade, _ := img.GrayShade(pixel)
+				pixel = colorblindShade(shade)
+			} else if pixel.A == 0 {
+				if (x+y)%2 == 0 {
+					pixel = checkerLight
+				} else {
+					pixel = checkerDark
+				}
+			}
+			text := make([]rune, cw*zoom)
+			for i := range text {
+				text[i] = e.Get(x*cw+i/zoom, y)
+			}
+			// Move the cursor to the pixel, then draw the text with the color of the pixel, once per zoomed row
+			sb.WriteString(colorCode(pixel, truecolor))
+			for dy := 0; dy < zoom; dy++ {
+				sb.WriteString(fmt.Sprintf("\x1b[%d;%dH", top+(y-offset)*zoom+dy+1, left+(x*cw-panX)*zoom+1))
+				sb.WriteString(string(text))
+			}
+		}
+	}
+	sb.WriteString(vt100.NoColor())
+	fmt.Print(sb.String())
+}
+
+// DrawOverlays draws what is shown on top of the canvas, if enabled: the pixels with their real colors,
+// the half block preview, the reference image and the histogram. This is needed each time the canvas has been drawn,
+// since that covers the whole terminal. Returns true if anything was drawn, and the cursor must be placed again.
+func (e *Editor) DrawOverlays(c *vt100.Canvas) bool {
+	drawn := false
+	if _, small := e.TooSmall(c); small {
+		return drawn
+	}
+	if e.colors {
+		e.DrawColors(c)
+		drawn = true
+	}
+	if e.halfBlocks {
+		e.DrawHalfBlocks(c)
+		drawn = true
+	}
+	if e.reference != nil && e.refView != refHidden {
+		e.DrawReference(c)
+		drawn = true
+	}
+	if e.histogram {
+		e.DrawHistogram(c)
+		drawn = true
+	}
+	return drawn
+}
+
+// colorAttributes returns the SGR attributes for using the given color as the foreground color,
+// or as the background color if background is true
+func colorAttributes(c color.NRGBA, background, truecolor bool) string {
+	layer := 38
+	if background {
+		layer = 48
+	}
+	if truecolor {
+		return fmt.Sprintf("%d;2;%d;%d;%d", layer, c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("%d;5;%d", layer, color256(c))
+}
+
+// halfBlockLines renders an image with half block characters, where each text line shows two rows of pixels.
+// Transparent pixels use the default background color. Each line ends with a reset of the colors.
+func halfBlockLines(m image.Image, truecolor bool) []string {
+	var (
+		bounds = m.Bounds()
+		lines  []string
+	)
+	at := func(x, y int) color.NRGBA {
+		if y >= bounds.Max.Y {
+			return color.NRGBA{}
+		}
+		return color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		var sb strings.Builder
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			top, bottom := at(x, y), at(x, y+1)
+			switch {
+			case top.A == 0 && bottom.A == 0:
+				sb.WriteString("\x1b[39;49m ")
+			case top.A == 0:
+				sb.WriteString("\x1b[49;" + colorAttributes(bottom, false, truecolor) + "m▄")
+			case bottom.A == 0:
+				sb.WriteString("\x1b[49;" + colorAttributes(top, false, truecolor) + "m▀")
+			default:
+				sb.WriteString("\x1b[" + colorAttributes(top, false, truecolor) + ";" + colorAttributes(bottom, true, truecolor) + "m▀")
+			}
+		}
+		sb.WriteString(vt100.NoColor())
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+// DrawHalfBlocks draws the image with half block characters in the top right corner of the terminal,
+// on top of what the canvas has already drawn
+func (e *Editor) DrawHalfBlocks(c *vt100.Canvas) {
+	if !e.drawMode || e.noColor {
+		return
+	}
+	m, err := textToImage(e.mode, image.Pt(e.width, e.height), e.String())
+	if err != nil {
+		return
+	}
+	var (
+		sb   strings.Builder
+		left = int(c.W()) - e.width + 1
+	)
+	if left < 1 {
+		return
+	}
+	for y, line := range halfBlockLines(m, hasTruecolor()) {
+		if y >= int(c.H())-1 {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("\x1b[%d;%dH", y+1, left))
+		sb.WriteString(line)
+	}
+	fmt.Print(sb.String())
+}
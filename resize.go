@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
@@ -20,8 +21,144 @@ func SetUpResizeHandler(c *vt100.Canvas, e *Editor, status *StatusBar, tty *vt10
 		for range sigChan {
 			newCanvas := e.FullResetRedraw(c, status)
 			*c = *newCanvas
+			e.KeepCursorOnCanvas(c)
 			e.DrawLines(c, true, false)
+			e.DrawOverlays(c)
+			status.DrawIndicator(c)
+			vt100.SetXY(uint(e.pos.ScreenX()), uint(e.pos.ScreenY()))
 		}
 	}()
 	resizeMutex.Unlock()
 }
+
+// TooSmall checks if the terminal is too small for showing at least one pixel of the image, with room for the
+// gutter and the status bar. If it is, a message about how large the terminal needs to be is returned.
+// Images that are larger than the terminal are panned with PanToCursor.
+func (e *Editor) TooSmall(c *vt100.Canvas) (string, bool) {
+	if !e.drawMode || e.width == 0 || e.height == 0 {
+		return "", false
+	}
+	zoom := e.pos.Zoom()
+	left, top := e.gutterSize(zoom)
+	if need := left + cellWidth(e.mode)*zoom; need > int(c.W()) {
+		return fmt.Sprintf("terminal too small (need %d columns)", need), true
+	}
+	if need := top + zoom + 1; need > int(c.H()) {
+		return fmt.Sprintf("terminal too small (need %d rows)", need), true
+	}
+	return "", false
+}
+
+// ViewSize returns how many columns and lines of the image can be shown on the canvas at the same time,
+// in draw mode, with room for the gutter, the palette bar and the status bar
+func (e *Editor) ViewSize(c *vt100.Canvas) (int, int) {
+	var (
+		zoom      = e.pos.Zoom()
+		left, top = e.gutterSize(zoom)
+		bottom    = 1 // the status bar
+	)
+	if e.palette != nil && e.palette.Visible(c) {
+		bottom++
+	}
+	return (int(c.W()) - left) / zoom, (int(c.H()) - top - bottom) / zoom
+}
+
+// Clipped returns which sides of the image are outside of the view, in draw mode,
+// when the image is larger than the canvas
+func (e *Editor) Clipped(c *vt100.Canvas) (left, right, above, below bool) {
+	if !e.drawMode || e.width == 0 || e.height == 0 {
+		return
+	}
+	cols, rows := e.ViewSize(c)
+	left = e.pos.panX > 0
+	right = e.pos.panX+cols < lineWidth(e.mode, e.width)
+	above = e.pos.panY > 0
+	below = e.pos.panY+rows < e.height
+	return
+}
+
+// PanToCursor scrolls the image horizontally and vertically, in draw mode, so that the cursor is within the view.
+// The view is panned one whole pixel at a time, and not further than needed for showing the edges of the image.
+// Returns true if the view was panned, and the canvas needs to be redrawn.
+func (e *Editor) PanToCursor(c *vt100.Canvas) bool {
+	if !e.drawMode {
+		return false
+	}
+	var (
+		cols, rows = e.ViewSize(c)
+		cw         = cellWidth(e.mode)
+		panX, panY = e.pos.panX, e.pos.panY
+	)
+	if cols < cw || rows < 1 {
+		return false
+	}
+	// Keep the whole pixel under the cursor in view
+	sx := e.pos.sx - e.pos.sx%cw
+	if sx < panX {
+		panX = sx
+	} else if sx+cw > panX+cols {
+		panX = sx + cw - cols
+	}
+	if e.pos.sy < panY {
+		panY = e.pos.sy
+	} else if e.pos.sy >= panY+rows {
+		panY = e.pos.sy - rows + 1
+	}
+	// Do not scroll further than to the right and bottom edges of the image, or the cursor, if it is outside of it
+	width, height := lineWidth(e.mode, e.width), e.height
+	if sx+cw > width {
+		width = sx + cw
+	}
+	if e.pos.sy >= height {
+		height = e.pos.sy + 1
+	}
+	if panX > width-cols {
+		panX = width - cols
+	}
+	if panY > height-rows {
+		panY = height - rows
+	}
+	if panX < 0 {
+		panX = 0
+	}
+	if panY < 0 {
+		panY = 0
+	}
+	// Pan one whole pixel at a time
+	panX += (cw - panX%cw) % cw
+	if panX == e.pos.panX && panY == e.pos.panY {
+		return false
+	}
+	e.pos.panX, e.pos.panY = panX, panY
+	e.redraw = true
+	e.redrawCursor = true
+	return true
+}
+
+// KeepCursorOnCanvas moves the cursor to the closest position on the canvas, after the terminal has been resized.
+// When editing text, the view is scrolled so that the line of the cursor is in the middle, if it is below the canvas.
+// When drawing, the image is panned so that the cursor is in view.
+func (e *Editor) KeepCursorOnCanvas(c *vt100.Canvas) {
+	if e.drawMode {
+		e.KeepCursorInImage()
+		e.PanToCursor(c)
+		e.redrawCursor = true
+		return
+	}
+	zoom := e.pos.Zoom()
+	// The last row is used by the status bar
+	w, h := (int(c.W())-e.pos.left)/zoom, (int(c.H())-e.pos.top)/zoom-1
+	if w < 1 || h < 1 {
+		return
+	}
+	if e.pos.sx >= w {
+		e.pos.sx = w - 1
+	}
+	if e.pos.sy >= h {
+		dataY := e.DataY()
+		e.pos.offset = dataY - h/2
+		e.pos.sy = dataY - e.pos.offset
+	}
+	e.KeepCursorInImage()
+	e.redrawCursor = true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// SetSearch starts highlighting the pixels that have the shade or color of the given rune, as it is written in the
+// current mode, or the transparent pixels for T. RGB and RGBA pixels take more than one rune, so only T can be
+// searched for in those modes.
+func (e *Editor) SetSearch(r rune) error {
+	if r == 'T' {
+		e.searchShade = r
+		return nil
+	}
+	if e.mode == modeRGB || e.mode == modeRGBA {
+		return fmt.Errorf("only T can be searched for in %s mode", e.mode)
+	}
+	if e.mode == modeGray4 && !isShade(r) {
+		// Unknown runes would be read as black pixels
+		return fmt.Errorf("%q is not one of the 16 shades", string(r))
+	}
+	if _, err := parsePixel(e.mode, []rune{r, ' '}); err != nil {
+		return err
+	}
+	e.searchShade = r
+	return nil
+}
+
+// ClearSearch stops highlighting the pixels that were searched for
+func (e *Editor) ClearSearch() {
+	e.searchShade = 0
+}
+
+// searchColor returns the color of the pixels that are searched for, and false if there is no search
+func (e *Editor) searchColor() (color.NRGBA, bool) {
+	if e.searchShade == 0 {
+		return color.NRGBA{}, false
+	}
+	if e.searchShade == 'T' {
+		return color.NRGBA{}, true
+	}
+	c, err := parsePixel(e.mode, []rune{e.searchShade, ' '})
+	return c, err == nil
+}
+
+// isMatch checks if the pixel at the given image coordinates has the color that is searched for
+func (e *Editor) isMatch(x, y int, searched color.NRGBA) bool {
+	pixel, err := e.Pixel(x, y)
+	if err != nil {
+		return false
+	}
+	if searched.A == 0 {
+		// All transparent pixels match, regardless of how they are written
+		return pixel.A == 0
+	}
+	return pixel == searched
+}
+
+// SearchMatches returns the pixels that match the search, or nil if there is no search
+func (e *Editor) SearchMatches() map[image.Point]bool {
+	searched, ok := e.searchColor()
+	if !ok {
+		return nil
+	}
+	matches := make(map[image.Point]bool)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			if e.isMatch(x, y, searched) {
+				matches[image.Pt(x, y)] = true
+			}
+		}
+	}
+	return matches
+}
+
+// NextMatch moves the cursor to the next pixel that matches the search, row by row from the pixel after the
+// cursor. Returns true if the search wrapped around from the last pixel to the first one, and false if nothing matches.
+func (e *Editor) NextMatch() (bool, bool) {
+	searched, ok := e.searchColor()
+	if !ok || e.width == 0 || e.height == 0 {
+		return false, false
+	}
+	p, _ := e.CursorPixel()
+	start := p.Y*e.width + p.X
+	n := e.width * e.height
+	for i := 1; i <= n; i++ {
+		index := (start + i) % n
+		x, y := index%e.width, index/e.width
+		if e.isMatch(x, y, searched) {
+			e.GoToPixel(image.Pt(x, y))
+			return start+i >= n, true
+		}
+	}
+	return false, false
+}
+
+// searchMessage returns the status message after searching, like "12 pixels match @"
+func (e *Editor) searchMessage() string {
+	shade := string(e.searchShade)
+	if e.searchShade == 'T' {
+		shade = "T (transparent)"
+	}
+	switch n := len(e.SearchMatches()); n {
+	case 0:
+		return "No pixels match " + shade
+	case 1:
+		return "1 pixel matches " + shade + " (ctrl-n to go to it)"
+	default:
+		return fmt.Sprintf("%d pixels match %s (ctrl-n for the next one)", n, shade)
+	}
+}
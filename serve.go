@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"image"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// previewSizes are the sizes that the image is shown in on the preview page, in CSS pixels
+var previewSizes = []int{16, 32, 64, 128, 256}
+
+// PreviewServer serves a web page that uses the image that is being edited as its favicon, and shows it
+// enlarged, for -serve. The images are encoded from the editor contents on each request, so that unsaved
+// changes are shown, and the page is told to reload the images when they change.
+type PreviewServer struct {
+	mut       sync.Mutex
+	title     string                 // the filename, for the title of the page
+	text      string                 // the editor contents that the images were made from
+	mode      Mode                   // the mode of the image
+	saved     image.Image            // the image as it is saved in .png and .ico files
+	bits      uint16                 // the number of bits per pixel in .ico files
+	version   int                    // increased each time the image changes
+	listeners map[chan struct{}]bool // the pages that are waiting to hear about changes
+	done      chan struct{}          // closed when the server shuts down
+	server    *http.Server
+	listener  net.Listener
+}
+
+// NewPreviewServer starts serving the preview page at the given address, like ":8080", in the background.
+// Update must be called to give it an image to serve.
+func NewPreviewServer(addr string) (*PreviewServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ps := &PreviewServer{
+		listeners: make(map[chan struct{}]bool),
+		done:      make(chan struct{}),
+		listener:  listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ps.serveIndex)
+	mux.HandleFunc("/favicon.ico", ps.serveImage)
+	mux.HandleFunc("/favicon.png", ps.serveImage)
+	mux.HandleFunc("/events", ps.serveEvents)
+	ps.server = &http.Server{Handler: mux}
+	go ps.server.Serve(listener)
+	return ps, nil
+}
+
+// URL returns the address of the preview page, with localhost if the server listens on all interfaces
+func (ps *PreviewServer) URL() string {
+	host, port, err := net.SplitHostPort(ps.listener.Addr().String())
+	if err != nil {
+		return "http://" + ps.listener.Addr().String() + "/"
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
+
+// Update gives the preview server the image that is in the editor now, and tells the pages that show it
+// to reload it, if it has changed. Images with pixels that can not be read are skipped, so that the last
+// valid image is shown while a color is being typed in.
+func (ps *PreviewServer) Update(e *Editor, filename string) {
+	if !e.drawMode {
+		return
+	}
+	text := e.String()
+	ps.mut.Lock()
+	defer ps.mut.Unlock()
+	ps.title = filename
+	if text == ps.text && e.mode == ps.mode {
+		return
+	}
+	m, err := textToImage(e.mode, image.Pt(e.width, e.height), text)
+	if err != nil {
+		return
+	}
+	// The images are converted here, since the palette is shared with the editor
+	ps.text, ps.mode = text, e.mode
+	ps.saved, ps.bits = savedImage(e.mode, m).Saved(), e.mode.Bits()
+	ps.version++
+	for listener := range ps.listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+			// The page has not caught up with the last change yet
+		}
+	}
+}
+
+// Close stops the server, and the pages that are waiting for changes
+func (ps *PreviewServer) Close() {
+	close(ps.done)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ps.server.Shutdown(ctx)
+}
+
+// serveIndex serves the preview page
+func (ps *PreviewServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	ps.mut.Lock()
+	title, version := ps.title, ps.version
+	ps.mut.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<link id="icon" rel="icon" href="/favicon.ico?v=%d">
+<style>
+body { font-family: sans-serif; background: repeating-conic-gradient(#ccc 0%% 25%%, #fff 0%% 50%%) 0 0 / 16px 16px; }
+img { image-rendering: pixelated; margin: 1em; vertical-align: bottom; }
+</style>
+</head>
+<body>
+<p>
+`, html.EscapeString(title), version)
+	for _, size := range previewSizes {
+		fmt.Fprintf(w, "<img class=\"preview\" src=\"/favicon.png?v=%d\" width=\"%d\" height=\"%d\" title=\"%dx%d\">\n", version, size, size, size, size)
+	}
+	fmt.Fprint(w, `</p>
+<script>
+// Reload the images when the server says that they have changed
+new EventSource("/events").onmessage = function(event) {
+  document.getElementById("icon").href = "/favicon.ico?v=" + event.data;
+  for (const img of document.querySelectorAll("img.preview")) {
+    img.src = "/favicon.png?v=" + event.data;
+  }
+};
+</script>
+</body>
+</html>
+`)
+}
+
+// serveImage encodes the image as an .ico or .png image, depending on the path
+func (ps *PreviewServer) serveImage(w http.ResponseWriter, r *http.Request) {
+	ps.mut.Lock()
+	m, bits := ps.saved, ps.bits
+	ps.mut.Unlock()
+	if m == nil {
+		http.Error(w, "there is no image to show yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	var err error
+	if r.URL.Path == "/favicon.png" {
+		w.Header().Set("Content-Type", "image/png")
+		err = encoder.EncodePNG(w, m)
+	} else {
+		w.Header().Set("Content-Type", "image/x-icon")
+		err = encoder.EncodeICOAll(w, []image.Image{m}, bits)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveEvents sends the version of the image to the page each time the image changes, as server-sent events
+func (ps *PreviewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	changed := make(chan struct{}, 1)
+	ps.mut.Lock()
+	ps.listeners[changed] = true
+	ps.mut.Unlock()
+	defer func() {
+		ps.mut.Lock()
+		delete(ps.listeners, changed)
+		ps.mut.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	flusher.Flush()
+	for {
+		select {
+		case <-changed:
+			ps.mut.Lock()
+			version := ps.version
+			ps.mut.Unlock()
+			fmt.Fprint(w, "data: "+strconv.Itoa(version)+"\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-ps.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xyproto/vt100"
+)
+
+// SetUpSignalHandler sets up a signal handler that restores the terminal and quits, if the editor is interrupted,
+// terminated or the terminal is closed. The exit code is 128 plus the signal number, like for shells.
+func SetUpSignalHandler(tty *vt100.TTY) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigChan
+		restoreTerminal(tty)
+		fmt.Fprintln(os.Stderr, "favicon: "+sig.String())
+		if s, ok := sig.(syscall.Signal); ok {
+			os.Exit(128 + int(s))
+		}
+		os.Exit(1)
+	}()
+}
+
+// Suspend restores the terminal and stops the editor, for ctrl-z, since the terminal is in raw mode and does not
+// send SIGTSTP by itself. Returns when the shell continues the editor, after setting up the terminal again.
+// This is called from the main loop, which then redraws the editor, so that the canvas is only drawn from there.
+func Suspend(tty *vt100.TTY) {
+	title := currentTitle
+	DisableMouse()
+	RestoreTitle()
+	vt100.Clear()
+	vt100.Close()
+	tty.Restore()
+	syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+	tty.RawMode()
+	EnableMouse()
+	SetTitle(title)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// slotCount is how many snapshot slots there are, numbered from 0
+const slotCount = 10
+
+// Slot is a copy of the image that is stored with q and restored with ;, for trying out ideas
+type Slot struct {
+	lines   [][]rune
+	mode    Mode
+	width   int
+	height  int
+	hotspot image.Point
+}
+
+// Slots are the snapshot slots of an editor. They only live for the session, and are not saved to disk.
+type Slots [slotCount]*Slot
+
+// SaveSlot stores a copy of the image in the snapshot slot with the given number
+func (e *Editor) SaveSlot(n int) {
+	if e.slots == nil {
+		e.slots = &Slots{}
+	}
+	e.slots[n] = &Slot{e.CopyLines(), e.mode, e.width, e.height, e.hotspot}
+}
+
+// SlotUsed checks if something has been stored in the snapshot slot with the given number
+func (e *Editor) SlotUsed(n int) bool {
+	return e.slots != nil && e.slots[n] != nil
+}
+
+// RestoreSlot replaces the image with the copy in the snapshot slot with the given number.
+// Returns false if nothing has been stored in that slot.
+func (e *Editor) RestoreSlot(n int) bool {
+	if !e.SlotUsed(n) {
+		return false
+	}
+	slot := e.slots[n]
+	// The slot can be restored more than once, so the editor gets a copy of the lines
+	lines := make([][]rune, len(slot.lines))
+	for i, runes := range slot.lines {
+		lines[i] = make([]rune, len(runes))
+		copy(lines[i], runes)
+	}
+	e.lines = lines
+	e.mode, e.width, e.height, e.hotspot = slot.mode, slot.width, slot.height, slot.hotspot
+	e.changed = true
+	e.redraw = true
+	e.KeepCursorInImage()
+	return true
+}
+
+// SlotsInUse returns the numbers of the snapshot slots that have something stored in them,
+// like "1, 3", or "none"
+func (e *Editor) SlotsInUse() string {
+	var used []string
+	if e.slots != nil {
+		for i, slot := range e.slots {
+			if slot != nil {
+				used = append(used, strconv.Itoa(i))
+			}
+		}
+	}
+	if len(used) == 0 {
+		return "none"
+	}
+	return strings.Join(used, ", ")
+}
+
+// PromptSlot asks for the number of a snapshot slot in the status bar, and reads a digit from 0 to 9.
+// The slots that are in use are listed. Returns false if esc or ctrl-q is pressed.
+func (e *Editor) PromptSlot(c *vt100.Canvas, status *StatusBar, keys *KeyReader, prompt string) (int, bool) {
+	status.ClearAll(c)
+	status.SetMessage(prompt + " (0-9, in use: " + e.SlotsInUse() + ")")
+	status.ShowNoTimeout(c, e)
+	defer status.ClearAll(c)
+	for {
+		key, _ := keys.Read()
+		switch {
+		case len(key) == 1 && key[0] >= '0' && key[0] <= '9':
+			return int(key[0] - '0'), true
+		case key == "c:27" || key == "c:17": // esc or ctrl-q
+			return 0, false
+		}
+	}
+}
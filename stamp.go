@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// Stamp is a small pre-drawn shape that can be pasted with the brush.
+// Each row is a line of runes, where '.' and ' ' are transparent and all other runes are painted.
+type Stamp struct {
+	name string
+	rows []string
+}
+
+// stamps are the stamps that can be chosen from the stamp menu, in the order they are shown.
+// Stamps that are loaded with LoadStamps are added after the built-in ones.
+var stamps = []Stamp{
+	{"heart", []string{
+		".##.##.",
+		"#######",
+		"#######",
+		".#####.",
+		"..###..",
+		"...#...",
+	}},
+	{"star", []string{
+		"...#...",
+		"...#...",
+		"#######",
+		".#####.",
+		"..###..",
+		".##.##.",
+		"##...##",
+	}},
+	{"arrow", []string{
+		"...#...",
+		"...##..",
+		"######.",
+		"#######",
+		"######.",
+		"...##..",
+		"...#...",
+	}},
+	{"check", []string{
+		"......#",
+		".....##",
+		"#...##.",
+		"##.##..",
+		".###...",
+		"..#....",
+	}},
+	{"cross", []string{
+		"#...#",
+		".#.#.",
+		"..#..",
+		".#.#.",
+		"#...#",
+	}},
+	{"rss", []string{
+		"###.....",
+		"...##...",
+		".....#..",
+		"###...#.",
+		"...#...#",
+		"#...#..#",
+		"##..#..#",
+		"##..#..#",
+	}},
+}
+
+// stampNames returns the names of all the stamps, in the order they are shown in the menu
+func stampNames() []string {
+	names := make([]string, len(stamps))
+	for i, s := range stamps {
+		names[i] = s.name
+	}
+	return names
+}
+
+// findStamp returns the stamp with the given name, and false if there is no such stamp
+func findStamp(name string) (Stamp, bool) {
+	for _, s := range stamps {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return Stamp{}, false
+}
+
+// isStampPixel checks if the given rune in a stamp is painted, and not transparent
+func isStampPixel(r rune) bool {
+	return r != '.' && r != ' '
+}
+
+// ParseStamp reads a stamp from the given text, with one row of runes per line.
+// Empty lines at the end are ignored, and the stamp must have at least one pixel that is painted.
+func ParseStamp(name, text string) (Stamp, error) {
+	rows := strings.Split(strings.TrimRight(strings.Replace(text, "\r\n", "\n", -1), "\n"), "\n")
+	if len(rows) > img.MaxSize {
+		return Stamp{}, fmt.Errorf("the %s stamp has %d rows, the maximum is %d", name, len(rows), img.MaxSize)
+	}
+	painted := false
+	for i, row := range rows {
+		runes := []rune(row)
+		if len(runes) > img.MaxSize {
+			return Stamp{}, fmt.Errorf("row %d of the %s stamp is %d pixels wide, the maximum is %d", i+1, name, len(runes), img.MaxSize)
+		}
+		for _, r := range runes {
+			if isStampPixel(r) {
+				painted = true
+			}
+		}
+	}
+	if !painted {
+		return Stamp{}, fmt.Errorf("the %s stamp is empty, only '.' and ' ' are transparent, all other runes are painted", name)
+	}
+	return Stamp{name, rows}, nil
+}
+
+// LoadStamps adds the stamps in the .txt files in the given directory to the stamp menu, sorted by filename.
+// The name of each stamp is the filename without .txt, and a loaded stamp replaces a built-in stamp with the same name.
+func LoadStamps(dir string) error {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(filenames) == 0 {
+		return errors.New("found no .txt stamps in " + dir)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		s, err := ParseStamp(strings.TrimSuffix(filepath.Base(filename), ".txt"), string(data))
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i := range stamps {
+			if stamps[i].name == s.name {
+				stamps[i], replaced = s, true
+				break
+			}
+		}
+		if !replaced {
+			stamps = append(stamps, s)
+		}
+	}
+	return nil
+}
+
+// PasteStamp paints the painted pixels of the stamp with the brush, with the top left corner at the given pixel.
+// Transparent pixels in the stamp leave the image as it is. Returns the number of pixels that were painted.
+func (e *Editor) PasteStamp(s Stamp, at image.Point) int {
+	painted := 0
+	for y, row := range s.rows {
+		for x, r := range []rune(row) {
+			px, py := at.X+x, at.Y+y
+			if !isStampPixel(r) || px < 0 || py < 0 || px >= e.width || py >= e.height {
+				continue
+			}
+			e.SetPixel(px, py, e.brush)
+			painted++
+		}
+	}
+	return painted
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+	"github.com/xyproto/vt100"
+)
+
+// histogramBar is the rune that the bars of the histogram are made of
+const histogramBar = '▇'
+
+// Histogram is the number of pixels at each of the 16 grayscale levels, and the number of transparent pixels.
+// For color images, the level of each pixel is its intensity, the same as when converting it to grayscale.
+type Histogram struct {
+	counts      [16]int
+	transparent int
+	invalid     int // pixels that can not be parsed, which are not counted
+}
+
+// Histogram counts the pixels in the image area at each grayscale level
+func (e *Editor) Histogram() Histogram {
+	var h Histogram
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			c, err := e.Pixel(x, y)
+			if err != nil {
+				h.invalid++
+				continue
+			}
+			level, opaque := img.GrayShade(c)
+			if !opaque {
+				h.transparent++
+				continue
+			}
+			h.counts[level]++
+		}
+	}
+	return h
+}
+
+// Opaque returns the number of pixels that are not transparent
+func (h Histogram) Opaque() int {
+	n := 0
+	for _, count := range h.counts {
+		n += count
+	}
+	return n
+}
+
+// Stats returns the darkest, the brightest and the mean level of the pixels that are not transparent,
+// and false if all pixels are transparent
+func (h Histogram) Stats() (byte, byte, float64, bool) {
+	var (
+		lo, hi     byte
+		n, sum     int
+		foundLevel bool
+	)
+	for level, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		if !foundLevel {
+			lo, foundLevel = byte(level), true
+		}
+		hi = byte(level)
+		n += count
+		sum += level * count
+	}
+	if n == 0 {
+		return 0, 0, 0, false
+	}
+	return lo, hi, float64(sum) / float64(n), true
+}
+
+// Summary returns the statistics for the status bar, like "min 2, max 15, mean 9.4, 12 transparent"
+func (h Histogram) Summary() string {
+	lo, hi, mean, ok := h.Stats()
+	if !ok {
+		return "All pixels are transparent"
+	}
+	summary := fmt.Sprintf("min %d, max %d, mean %.1f", lo, hi, mean)
+	// Icons that are mostly dark or mostly bright are hard to see on dark or light browser themes
+	switch {
+	case mean < 4:
+		summary += " (dark, for light themes)"
+	case mean > 11:
+		summary += " (bright, for dark themes)"
+	}
+	if h.transparent > 0 {
+		summary += fmt.Sprintf(", %d transparent", h.transparent)
+	}
+	if h.invalid > 0 {
+		summary += fmt.Sprintf(", %d invalid", h.invalid)
+	}
+	return summary
+}
+
+// Lines returns the histogram as a bar chart, with one line per level from 15 down to 0 and a line for the
+// transparent pixels. The longest bar is barWidth runes wide, and all levels that are used have a bar.
+func (h Histogram) Lines(barWidth int) []string {
+	longest := h.transparent
+	for _, count := range h.counts {
+		if count > longest {
+			longest = count
+		}
+	}
+	bar := func(count int) string {
+		if count == 0 || longest == 0 {
+			return ""
+		}
+		n := count * barWidth / longest
+		if n < 1 {
+			n = 1
+		}
+		return strings.Repeat(string(histogramBar), n) + " "
+	}
+	var (
+		letters = lookupLetters()
+		lines   []string
+	)
+	for level := 15; level >= 0; level-- {
+		count := h.counts[level]
+		lines = append(lines, fmt.Sprintf("%2d %c %s%d", level, letters[byte(level)], bar(count), count))
+	}
+	return append(lines, fmt.Sprintf(" T   %s%d", bar(h.transparent), h.transparent))
+}
+
+// histogramLeft returns the column where the histogram is drawn, to the right of the image,
+// and how wide the longest bar can be. The bar width is below 1 if there is no room for the histogram.
+func (e *Editor) histogramLeft(c *vt100.Canvas) (int, int) {
+	zoom := e.pos.Zoom()
+	left, _ := e.gutterSize(zoom)
+	// Leave one column between the image and the histogram, and make room for the level, the rune and the count
+	left += lineWidth(e.mode, e.width)*zoom + 1
+	return left, int(c.W()) - left - len(" T   ") - len(fmt.Sprint(e.width*e.height)) - 1
+}
+
+// HistogramFits checks if there is room for the histogram to the right of the image
+func (e *Editor) HistogramFits(c *vt100.Canvas) bool {
+	_, barWidth := e.histogramLeft(c)
+	return barWidth >= 1 && len(Histogram{}.Lines(1)) <= int(c.H())-1
+}
+
+// DrawHistogram draws the histogram of the image as a bar chart to the right of the image, on top of what the
+// canvas has already drawn, if there is room for it. It is never a part of the text that is edited and saved.
+func (e *Editor) DrawHistogram(c *vt100.Canvas) {
+	if !e.drawMode || !e.HistogramFits(c) {
+		return
+	}
+	var (
+		sb             strings.Builder
+		left, barWidth = e.histogramLeft(c)
+	)
+	for y, line := range e.Histogram().Lines(barWidth) {
+		sb.WriteString(fmt.Sprintf("\x1b[%d;%dH", y+1, left+1))
+		sb.WriteString(line)
+	}
+	fmt.Print(sb.String())
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
@@ -18,6 +19,9 @@ type StatusBar struct {
 	show    time.Duration        // show the message for how long before clearing
 	offset  int                  // scroll offset
 	isError bool                 // is this an error message that should be shown after redraw?
+	// indicator is a short text that is shown in the lower right corner for as long as it is set,
+	// for modes that should not be forgotten, like "PEN"
+	indicator string
 }
 
 // Used for keeping track of how many status messages are lined up to be cleared
@@ -26,16 +30,20 @@ var statusBeingShown int
 // NewStatusBar takes a foreground color, background color, foreground color for clearing,
 // background color for clearing and a duration for how long to display status messages.
 func NewStatusBar(fg, bg, errfg, errbg vt100.AttributeColor, editor *Editor, show time.Duration) *StatusBar {
-	return &StatusBar{"", fg, bg, errfg, errbg, editor, show, 0, false}
+	return &StatusBar{"", fg, bg, errfg, errbg, editor, show, 0, false, ""}
 }
 
 // Draw will draw the status bar to the canvas
 func (sb *StatusBar) Draw(c *vt100.Canvas, offset int) {
-	w := int(c.W())
+	// Center the message, or start at the left edge if it is too long to fit
+	x := (int(c.W()) - len([]rune(sb.msg))) / 2
+	if x < 0 {
+		x = 0
+	}
 	if sb.isError {
-		c.Write(uint((w-len(sb.msg))/2), c.H()-1, sb.errfg, sb.errbg, sb.msg)
+		c.Write(uint(x), c.H()-1, sb.errfg, sb.errbg, sb.msg)
 	} else {
-		c.Write(uint((w-len(sb.msg))/2), c.H()-1, sb.fg, sb.bg, sb.msg)
+		c.Write(uint(x), c.H()-1, sb.fg, sb.bg, sb.msg)
 	}
 	sb.offset = offset
 }
@@ -62,6 +70,10 @@ func (sb *StatusBar) Clear(c *vt100.Canvas) {
 	e := sb.editor
 	// Write all lines to the buffer
 	e.WriteLines(c, e.pos.Offset(), h+e.pos.Offset(), 0, 0)
+	if e.palette != nil {
+		e.palette.Draw(c)
+	}
+	sb.DrawIndicator(c)
 	c.Draw()
 	// Not an error message
 	sb.isError = false
@@ -85,6 +97,10 @@ func (sb *StatusBar) Show(c *vt100.Canvas, e *Editor) {
 		statusBeingShown--
 		if statusBeingShown == 0 {
 			sb.Clear(c)
+			// Drawing the canvas covers what was drawn on top of it
+			if sb.editor.DrawOverlays(c) {
+				vt100.SetXY(uint(sb.editor.pos.ScreenX()), uint(sb.editor.pos.ScreenY()))
+			}
 		}
 	}()
 	c.Draw()
@@ -100,6 +116,26 @@ func (sb *StatusBar) ShowNoTimeout(c *vt100.Canvas, e *Editor) {
 	c.Draw()
 }
 
+// SetIndicator sets the text that is shown in the lower right corner, or removes it if the text is empty
+func (sb *StatusBar) SetIndicator(text string) {
+	sb.indicator = text
+}
+
+// DrawIndicator draws the indicator text in the lower right corner, if it is set.
+// The text is written to the terminal by itself, instead of drawing the whole canvas.
+// Returns true if the cursor was moved by drawing it.
+func (sb *StatusBar) DrawIndicator(c *vt100.Canvas) bool {
+	if sb.indicator == "" {
+		return false
+	}
+	text := " " + sb.indicator + " "
+	x, y := c.W()-uint(len(text)), c.H()-1
+	c.Write(x, y, sb.errfg, sb.bg, text)
+	vt100.SetXY(x, y)
+	fmt.Print(sb.errfg.Combine(sb.bg.Background()).String() + text)
+	return true
+}
+
 // SetColors can be used for setting a color theme for the status bar field
 // bg should be a background attribute, like vt100.BackgroundBlue.
 func (sb *StatusBar) SetColors(fg, bg vt100.AttributeColor) {
@@ -120,3 +156,15 @@ func (sb *StatusBar) ShowLineColWordCount(c *vt100.Canvas, e *Editor, filename s
 	sb.SetMessage(statusString)
 	sb.ShowNoTimeout(c, e)
 }
+
+// ShowPixelPosition shows a status message with the current filename, pixel coordinates and shade or color.
+// Outside of the image area, the line, column and word count is shown instead.
+func (sb *StatusBar) ShowPixelPosition(c *vt100.Canvas, e *Editor, filename string) {
+	pixelStatus, ok := e.PixelStatusMessage()
+	if !ok {
+		sb.ShowLineColWordCount(c, e, filename)
+		return
+	}
+	sb.SetMessage(filename + ": " + pixelStatus)
+	sb.ShowNoTimeout(c, e)
+}
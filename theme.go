@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/vt100"
+)
+
+// Theme is a display profile, with the colors of the editor, the status bar and the palette bar,
+// and how the cursor and the shades are drawn
+type Theme struct {
+	name        string
+	fg          vt100.AttributeColor // the text of the image
+	bg          vt100.AttributeColor // the background of the image
+	searchFg    vt100.AttributeColor // the pixels that are highlighted by a search or a diff
+	gitColor    vt100.AttributeColor // git commit messages
+	statusFg    vt100.AttributeColor // status messages
+	statusBg    vt100.AttributeColor // the background of status messages and the palette bar
+	statusErrFg vt100.AttributeColor // error messages and the indicator
+	statusErrBg vt100.AttributeColor // the background of error messages
+	paletteFg   vt100.AttributeColor // the shades in the palette bar
+	brushFg     vt100.AttributeColor // the shade of the brush, in the palette bar
+	boldCursor  bool                 // draw the pixel under the cursor in bold?
+	shadeColors bool                 // draw the shades with colors from dark blue to yellow, instead of gray, with ctrl-r?
+}
+
+// themes are the display profiles that can be chosen with -theme, and cycled through with I
+var themes = []Theme{
+	{
+		// The default, for dark backgrounds
+		name:        "dark",
+		fg:          vt100.LightGreen,
+		bg:          vt100.BackgroundDefault,
+		searchFg:    vt100.LightMagenta,
+		gitColor:    vt100.Default,
+		statusFg:    vt100.White,
+		statusBg:    vt100.BackgroundBlack,
+		statusErrFg: vt100.LightRed,
+		statusErrBg: vt100.BackgroundDefault,
+		paletteFg:   vt100.White,
+		brushFg:     vt100.LightMagenta,
+	},
+	{
+		// For white backgrounds
+		name:        "light",
+		fg:          vt100.Black,
+		bg:          vt100.Gray,
+		searchFg:    vt100.Red,
+		gitColor:    vt100.Blue,
+		statusFg:    vt100.White,
+		statusBg:    vt100.BackgroundBlue,
+		statusErrFg: vt100.Red,
+		statusErrBg: vt100.BackgroundDefault,
+		paletteFg:   vt100.White,
+		brushFg:     vt100.Yellow,
+	},
+	{
+		// Pure white on black, with the pixel under the cursor in bold
+		name:        "high-contrast",
+		fg:          vt100.White,
+		bg:          vt100.BackgroundBlack,
+		searchFg:    vt100.LightYellow,
+		gitColor:    vt100.White,
+		statusFg:    vt100.Black,
+		statusBg:    vt100.BackgroundWhite,
+		statusErrFg: vt100.LightYellow,
+		statusErrBg: vt100.BackgroundBlack,
+		paletteFg:   vt100.Black,
+		brushFg:     vt100.Blue,
+		boldCursor:  true,
+	},
+	{
+		// Without red and green, which are hard to tell apart with deuteranopia,
+		// and with the shades drawn from dark blue to yellow with ctrl-r
+		name:        "colorblind",
+		fg:          vt100.White,
+		bg:          vt100.BackgroundDefault,
+		searchFg:    vt100.LightYellow,
+		gitColor:    vt100.LightBlue,
+		statusFg:    vt100.White,
+		statusBg:    vt100.BackgroundBlue,
+		statusErrFg: vt100.LightYellow,
+		statusErrBg: vt100.BackgroundDefault,
+		paletteFg:   vt100.White,
+		brushFg:     vt100.LightYellow,
+		shadeColors: true,
+	},
+}
+
+// themeNames returns the names of the display profiles
+func themeNames() []string {
+	names := make([]string, len(themes))
+	for i, theme := range themes {
+		names[i] = theme.name
+	}
+	return names
+}
+
+// findTheme returns the display profile with the given name
+func findTheme(name string) (Theme, bool) {
+	for _, theme := range themes {
+		if theme.name == name {
+			return theme, true
+		}
+	}
+	return Theme{}, false
+}
+
+// nextTheme returns the display profile after the given one, wrapping around at the end
+func nextTheme(current Theme) Theme {
+	for i, theme := range themes {
+		if theme.name == current.name {
+			return themes[(i+1)%len(themes)]
+		}
+	}
+	return themes[0]
+}
+
+// parseTheme returns the display profile with the given name, as given with -theme.
+// For "auto", the light or dark profile is chosen by detecting the background color with lightBackground.
+func parseTheme(name string) (Theme, error) {
+	if name == "auto" || name == "" {
+		if lightBackground(os.Getenv("COLORFGBG")) {
+			name = "light"
+		} else {
+			name = "dark"
+		}
+	}
+	theme, ok := findTheme(name)
+	if !ok {
+		return Theme{}, fmt.Errorf("%q is not a theme, only auto, %s", name, strings.Join(themeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// lightBackground checks if the COLORFGBG environment variable, which is set by some terminals, says that
+// the background is light. The value is on the form "foreground;background", like "0;15", or with a field
+// in between, like "0;default;15". The background is light if it is one of the 16 ANSI colors, from 7 and up.
+// If the value is missing or can not be parsed, the background is assumed to be dark.
+func lightBackground(colorfgbg string) bool {
+	fields := strings.Split(colorfgbg, ";")
+	if len(fields) < 2 {
+		return false
+	}
+	bg, err := strconv.Atoi(strings.TrimSpace(fields[len(fields)-1]))
+	if err != nil || bg < 0 || bg > 15 {
+		return false
+	}
+	return bg >= 7
+}
+
+// SetTheme uses the colors of the given display profile for the editor, unless NO_COLOR is set.
+// The canvas must be redrawn afterwards.
+func (e *Editor) SetTheme(theme Theme) {
+	e.theme = theme
+	e.fg = theme.fg
+	e.bg = theme.bg
+	e.searchFg = theme.searchFg
+	e.gitColor = theme.gitColor
+	e.respectNoColorEnvironmentVariable()
+}
+
+// SetTheme uses the colors of the given display profile for the status bar, unless NO_COLOR is set
+func (sb *StatusBar) SetTheme(theme Theme) {
+	sb.fg = theme.statusFg
+	sb.bg = theme.statusBg
+	sb.errfg = theme.statusErrFg
+	sb.errbg = theme.statusErrBg
+	sb.respectNoColorEnvironmentVariable()
+}
+
+// SetTheme uses the colors of the given display profile for the palette bar
+func (pb *PaletteBar) SetTheme(theme Theme) {
+	pb.fg = theme.paletteFg
+	pb.bg = theme.statusBg
+	pb.highlight = theme.brushFg
+}
+
+// cividis are colors from dark blue to yellow, with evenly increasing lightness, which can be told apart
+// with the most common kinds of color blindness, from the cividis color map by Nuñez, Anderton and Renslow
+var cividis = []color.NRGBA{
+	{0x00, 0x22, 0x4e, 0xff},
+	{0x12, 0x35, 0x70, 0xff},
+	{0x3b, 0x49, 0x6c, 0xff},
+	{0x57, 0x5d, 0x6d, 0xff},
+	{0x70, 0x71, 0x73, 0xff},
+	{0x8a, 0x87, 0x79, 0xff},
+	{0xa5, 0x9c, 0x74, 0xff},
+	{0xc3, 0xb3, 0x69, 0xff},
+	{0xe1, 0xcc, 0x55, 0xff},
+	{0xfe, 0xe8, 0x38, 0xff},
+}
+
+// colorblindShade returns the color that the given grayscale shade, from 0 to 15, is drawn with when the
+// display profile has shadeColors, by interpolating between the cividis colors
+func colorblindShade(shade byte) color.NRGBA {
+	if shade > 15 {
+		shade = 15
+	}
+	var (
+		pos  = int(shade) * (len(cividis) - 1) // in fifteenths of the steps between the colors
+		i    = pos / 15
+		frac = pos % 15
+	)
+	if i >= len(cividis)-1 {
+		return cividis[len(cividis)-1]
+	}
+	a, b := cividis[i], cividis[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8((int(x)*(15-frac) + int(y)*frac) / 15)
+	}
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+// drawCursorCell draws the pixel under the cursor in bold, when the display profile has boldCursor,
+// on top of the numlines lines that WriteLines has written, starting with the given line
+func (e *Editor) drawCursorCell(c *vt100.Canvas, offset, numlines, cx, cy, zoom int) {
+	if !e.theme.boldCursor || e.noColor || !e.drawMode {
+		return
+	}
+	p, inside := e.CursorPixel()
+	if !inside || p.Y < offset || (p.Y-offset+1)*zoom > numlines {
+		return
+	}
+	e.boldCell = p
+	var (
+		cw   = cellWidth(e.mode)
+		bold = e.fg.Combine(vt100.Bright)
+	)
+	for dy := 0; dy < zoom; dy++ {
+		for i := 0; i < cw*zoom; i++ {
+			sx := cx + p.X*cw*zoom + i
+			if sx >= int(c.W()) {
+				break
+			}
+			if sx < e.pos.left {
+				// Panned out of view
+				continue
+			}
+			e.writeRune(c, uint(sx), uint(cy+(p.Y-offset)*zoom+dy), bold, e.bg, e.Get(p.X*cw+i/zoom, p.Y))
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// titleTerminals are the prefixes of $TERM for terminals that support setting the title
+var titleTerminals = []string{"xterm", "rxvt", "screen", "tmux", "alacritty", "foot", "kitty", "st-", "wezterm", "konsole", "gnome", "vte", "mlterm"}
+
+// currentTitle is the terminal title that was set last, or "" if the title has not been set
+var currentTitle string
+
+// titleSupported checks if the terminal title can be set, by looking at $TERM.
+// Setting $NO_TITLE leaves the title as it is.
+func titleSupported() bool {
+	if os.Getenv("NO_TITLE") != "" {
+		return false
+	}
+	term := os.Getenv("TERM")
+	for _, prefix := range titleTerminals {
+		if strings.HasPrefix(term, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// titleText returns the terminal title for the given filename, like "favicon: foo.ico *" if there are unsaved changes
+func titleText(filename string, changed bool) string {
+	// Control characters in the filename would end the escape sequence early
+	title := "favicon: " + strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+	if changed {
+		title += " *"
+	}
+	return title
+}
+
+// SetTitle sets the terminal title, if the terminal supports it and the title has changed.
+// The title from before is saved the first time, for RestoreTitle.
+func SetTitle(title string) {
+	if title == currentTitle || !titleSupported() {
+		return
+	}
+	if currentTitle == "" {
+		fmt.Print("\x1b[22;0t")
+	}
+	fmt.Print("\x1b]0;" + title + "\a")
+	currentTitle = title
+}
+
+// RestoreTitle clears the title that was set with SetTitle, and restores the title from before,
+// in terminals that can do that
+func RestoreTitle() {
+	if currentTitle == "" {
+		return
+	}
+	fmt.Print("\x1b]0;\a\x1b[23;0t")
+	currentTitle = ""
+}
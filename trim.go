@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// isBackground checks if the given pixel is transparent or black, which is what is around the artwork
+// that is found by ArtworkBounds. All color channels below one grayscale step count as black.
+func isBackground(c color.NRGBA) bool {
+	return c.A == 0 || (c.R < intensityStep && c.G < intensityStep && c.B < intensityStep)
+}
+
+// ArtworkBounds returns the bounding box of the pixels that are neither transparent nor black,
+// and false if there are no such pixels. Pixels that can not be parsed are counted as artwork.
+func (e *Editor) ArtworkBounds() (image.Rectangle, bool) {
+	var bounds image.Rectangle
+	found := false
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			if c, err := e.Pixel(x, y); err == nil && isBackground(c) {
+				continue
+			}
+			pixel := image.Rect(x, y, x+1, y+1)
+			if !found {
+				bounds, found = pixel, true
+			} else {
+				bounds = bounds.Union(pixel)
+			}
+		}
+	}
+	return bounds, found
+}
+
+// errEmptyImage is returned by Trim and CenterArtwork when all pixels are transparent or black
+var errEmptyImage = errors.New("the image is empty, all pixels are transparent or black")
+
+// Trim crops the image to the bounding box of the artwork and scales it up by the largest whole number that
+// fits, so that the pixels stay sharp. The result is centered, with transparent pixels around it.
+// Returns a message about what was done, or an error if the image is empty or can not be trimmed.
+func (e *Editor) Trim() (string, error) {
+	bounds, found := e.ArtworkBounds()
+	if !found {
+		return "", errEmptyImage
+	}
+	if bounds.Dx() == e.width || bounds.Dy() == e.height {
+		return "", errors.New("nothing to trim, the artwork already fills the image")
+	}
+	if err := e.ValidatePixels(); err != nil {
+		return "", err
+	}
+	cropped := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c, _ := e.Pixel(x, y)
+			cropped.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, c)
+		}
+	}
+	if err := e.ReplaceImage(scaleInteger(cropped, e.width), "the trimmed image"); err != nil {
+		return "", err
+	}
+	factor := e.width / bounds.Dx()
+	if f := e.height / bounds.Dy(); f < factor {
+		factor = f
+	}
+	return fmt.Sprintf("Trimmed to the %dx%d artwork at %d,%d, scaled up %dx and centered", bounds.Dx(), bounds.Dy(), bounds.Min.X, bounds.Min.Y, factor), nil
+}
+
+// CenterArtwork moves the bounding box of the artwork to the middle of the image, shifting in transparent pixels.
+// Returns a message about what was done, or an error if the image is empty or already centered.
+func (e *Editor) CenterArtwork() (string, error) {
+	bounds, found := e.ArtworkBounds()
+	if !found {
+		return "", errEmptyImage
+	}
+	dx := (e.width-bounds.Dx())/2 - bounds.Min.X
+	dy := (e.height-bounds.Dy())/2 - bounds.Min.Y
+	if dx == 0 && dy == 0 {
+		return "", errors.New("the artwork is already centered")
+	}
+	e.ShiftImage(dx, dy, false)
+	return fmt.Sprintf("Centered the %dx%d artwork, by shifting it %+d,%+d", bounds.Dx(), bounds.Dy(), dx, dy), nil
+}
@@ -3,23 +3,29 @@ package main
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
+// undoCoalesceTime is how soon after a single pixel edit the next one must come, for both to be undone in one step
+const undoCoalesceTime = 2 * time.Second
+
 // Undo is a struct that can store several states of the editor and position
 type Undo struct {
 	index                int
 	size                 int
 	editorCopies         []Editor
-	editorLineCopies     []map[int][]rune
+	editorLineCopies     [][][]rune
 	editorPositionCopies []Position
 	hasSomething         []bool
 	mut                  *sync.RWMutex
+	coalescing           bool      // is the last snapshot for a series of single pixel edits?
+	lastPixelEdit        time.Time // when the last single pixel edit was made
 }
 
 // NewUndo takes arguments that are only for initializing the undo buffers.
 // The *Position and *vt100.Canvas is used only as a default values for the elements in the undo buffers.
 func NewUndo(size int) *Undo {
-	return &Undo{0, size, make([]Editor, size), make([]map[int][]rune, size), make([]Position, size), make([]bool, size), &sync.RWMutex{}}
+	return &Undo{0, size, make([]Editor, size), make([][][]rune, size), make([]Position, size), make([]bool, size), &sync.RWMutex{}, false, time.Time{}}
 }
 
 // Snapshot will store a snapshot, and move to the next position in the circular buffer
@@ -27,9 +33,45 @@ func (u *Undo) Snapshot(e *Editor) {
 	u.mut.Lock()
 	defer u.mut.Unlock()
 
+	u.coalescing = false
+	u.snapshot(e)
+}
+
+// SnapshotPixel will store a snapshot before a single pixel edit, like typing a shade or plotting the brush.
+// Single pixel edits that come in quick succession are undone in one step, so no snapshot is stored if the
+// last one was also for a single pixel edit, made less than undoCoalesceTime ago.
+func (u *Undo) SnapshotPixel(e *Editor) {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	now := time.Now()
+	if !(u.coalescing && now.Sub(u.lastPixelEdit) < undoCoalesceTime) {
+		u.snapshot(e)
+		u.coalescing = true
+	}
+	u.lastPixelEdit = now
+}
+
+// snapshot stores a snapshot, without locking. The lines that are the same as in the previous snapshot are
+// shared with it, instead of being copied, since the stored lines are never modified.
+func (u *Undo) snapshot(e *Editor) {
+	var previous [][]rune
+	if prevIndex := (u.index - 1 + u.size) % u.size; u.hasSomething[prevIndex] {
+		previous = u.editorLineCopies[prevIndex]
+	}
+	lines := make([][]rune, len(e.lines))
+	for i, runes := range e.lines {
+		if i < len(previous) && string(previous[i]) == string(runes) {
+			lines[i] = previous[i]
+			continue
+		}
+		lines[i] = make([]rune, len(runes))
+		copy(lines[i], runes)
+	}
+
 	u.hasSomething[u.index] = true
 	u.editorCopies[u.index] = *e
-	u.editorLineCopies[u.index] = e.CopyLines()
+	u.editorLineCopies[u.index] = lines
 	u.editorPositionCopies[u.index] = e.pos
 
 	// Go forward 1 step in the circular buffer
@@ -45,6 +87,9 @@ func (u *Undo) Restore(e *Editor) error {
 	u.mut.Lock()
 	defer u.mut.Unlock()
 
+	// The next single pixel edit is undone by itself
+	u.coalescing = false
+
 	// Go back 1 step in the circular buffer
 	u.index--
 	// Circular buffer wrap
@@ -54,8 +99,20 @@ func (u *Undo) Restore(e *Editor) error {
 
 	// Restore the state from this index, if there is something there
 	if u.hasSomething[u.index] {
+		// The display profile, what is on the screen and the snapshot slots are not a part of the undo history
+		theme, fg, bg, searchFg, screen, slots := e.theme, e.fg, e.bg, e.searchFg, e.screen, e.slots
+		// Neither is the file on disk, which is not changed by undoing
+		modTime, fileSize, backupName := e.modTime, e.fileSize, e.backupName
 		*e = u.editorCopies[u.index]
-		e.lines = u.editorLineCopies[u.index]
+		e.theme, e.fg, e.bg, e.searchFg, e.screen, e.slots = theme, fg, bg, searchFg, screen, slots
+		e.modTime, e.fileSize, e.backupName = modTime, fileSize, backupName
+		// The stored lines may be shared with other snapshots, so the editor gets a copy
+		lines := make([][]rune, len(u.editorLineCopies[u.index]))
+		for i, runes := range u.editorLineCopies[u.index] {
+			lines[i] = make([]rune, len(runes))
+			copy(lines[i], runes)
+		}
+		e.lines = lines
 		e.pos = u.editorPositionCopies[u.index]
 		return nil
 	}
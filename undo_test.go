@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+// setRune changes the rune at the given position, like typing a shade does
+func setRune(e *Editor, x, y int, r rune) {
+	e.lines[y][x] = r
+}
+
+func TestSnapshotPixel(t *testing.T) {
+	e := newTestEditor("aaaa", "bbbb", "cccc")
+	u := NewUndo(10)
+
+	// A burst of pixel edits is undone in one step
+	for x := 0; x < 4; x++ {
+		u.SnapshotPixel(e)
+		setRune(e, x, 0, 'x')
+	}
+	// A fill is its own step, and so is the pixel edit after it
+	u.Snapshot(e)
+	copy(e.lines[1], []rune("yyyy"))
+	u.SnapshotPixel(e)
+	setRune(e, 0, 2, 'z')
+	checkLines(t, "after the edits", e, "xxxx", "yyyy", "zccc")
+
+	for _, want := range [][]string{
+		{"xxxx", "yyyy", "cccc"},
+		{"xxxx", "bbbb", "cccc"},
+		{"aaaa", "bbbb", "cccc"},
+	} {
+		if err := u.Restore(e); err != nil {
+			t.Fatal(err)
+		}
+		checkLines(t, "undo", e, want...)
+	}
+	if err := u.Restore(e); err == nil {
+		t.Errorf("restored more snapshots than were stored: %q", e.lines)
+	}
+}
+
+func TestSnapshotPixelAfterUndo(t *testing.T) {
+	e := newTestEditor("aa")
+	u := NewUndo(10)
+	u.SnapshotPixel(e)
+	setRune(e, 0, 0, 'x')
+	if err := u.Restore(e); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first pixel edit after undoing starts a new step, and so does one that comes too late
+	u.SnapshotPixel(e)
+	setRune(e, 0, 0, 'y')
+	u.lastPixelEdit = u.lastPixelEdit.Add(-undoCoalesceTime)
+	u.SnapshotPixel(e)
+	setRune(e, 1, 0, 'y')
+	for _, want := range []string{"ya", "aa"} {
+		if err := u.Restore(e); err != nil {
+			t.Fatal(err)
+		}
+		checkLines(t, "undo", e, want)
+	}
+}
+
+func TestSnapshotSharesLines(t *testing.T) {
+	e := newTestEditor("aaaa", "bbbb", "cccc")
+	u := NewUndo(10)
+	u.Snapshot(e)
+	setRune(e, 0, 1, 'x')
+	u.Snapshot(e)
+
+	first, second := u.editorLineCopies[0], u.editorLineCopies[1]
+	for y, shared := range []bool{true, false, true} {
+		if same := &first[y][0] == &second[y][0]; same != shared {
+			t.Errorf("line %d is shared between the snapshots: %v, but wanted %v", y, same, shared)
+		}
+		if &second[y][0] == &e.lines[y][0] {
+			t.Errorf("line %d is shared between the snapshot and the editor", y)
+		}
+	}
+
+	// The restored lines are copies, so that editing them does not change the snapshots
+	if err := u.Restore(e); err != nil {
+		t.Fatal(err)
+	}
+	setRune(e, 0, 0, 'y')
+	if string(first[0]) != "aaaa" || string(second[0]) != "aaaa" {
+		t.Errorf("editing the restored lines changed the snapshots to %q and %q", first[0], second[0])
+	}
+}
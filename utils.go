@@ -13,14 +13,24 @@ func exists(path string) bool {
 	return err == nil
 }
 
-func quitError(tty *vt100.TTY, err error) {
+// restoreTerminal closes the TTY and restores the terminal, so that it is not left in raw mode,
+// with a hidden cursor, mouse reporting or the title that was set while editing
+func restoreTerminal(tty *vt100.TTY) {
 	if tty != nil {
 		tty.Close()
 	}
+	DisableMouse()
+	RestoreTitle()
 	vt100.Reset()
 	vt100.Clear()
 	vt100.Close()
+}
+
+// quitError restores the terminal, then prints the error and quits with the exit code for it,
+// which is the given code, like exitDecode, unless the file does not exist or the error already has a code
+func quitError(tty *vt100.TTY, err error, code int) {
+	restoreTerminal(tty)
 	fmt.Fprintln(os.Stderr, "error: "+err.Error())
 	vt100.SetXY(uint(0), uint(1))
-	os.Exit(1)
+	os.Exit(exitCode(withCode(err, code)))
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// faviconSizes are the widths and heights that favicons usually have, from the sizes in .ico files
+// to the sizes of the Apple touch icon and the images in the web app manifest
+var faviconSizes = []int{16, 24, 32, 48, 64, 96, 128, 152, 167, 180, 192, 256, 512}
+
+// finding is a problem with a file that is found by -validate
+type finding struct {
+	warning bool // is this only a warning, and not an error?
+	message string
+}
+
+// errorFinding returns a finding that is an error
+func errorFinding(format string, a ...interface{}) finding {
+	return finding{false, fmt.Sprintf(format, a...)}
+}
+
+// warningFinding returns a finding that is a warning
+func warningFinding(format string, a ...interface{}) finding {
+	return finding{true, fmt.Sprintf(format, a...)}
+}
+
+// validateSize checks that the image is square and has one of the sizes in faviconSizes.
+// The name is what the image is called in the findings, like "the image" or "entry 2".
+func validateSize(name string, size image.Point) []finding {
+	if size.X != size.Y {
+		return []finding{warningFinding("%s is %dx%d, but favicons should be square", name, size.X, size.Y)}
+	}
+	for _, s := range faviconSizes {
+		if size.X == s {
+			return nil
+		}
+	}
+	sizes := make([]string, len(faviconSizes))
+	for i, s := range faviconSizes {
+		sizes[i] = strconv.Itoa(s)
+	}
+	return []finding{warningFinding("%s is %dx%d, which is not one of the usual favicon sizes (%s)", name, size.X, size.Y, strings.Join(sizes, ", "))}
+}
+
+// validateICO reads an .ico or .cur file with img.ReadEntries, which checks the header and that the entries are
+// within the file, and then checks that the entries do not overlap, that each entry can be decoded and has the
+// size and the number of bits per pixel that the directory says, and that there are no unused bytes at the end.
+// Returns the findings and the images that could be decoded.
+func validateICO(data []byte) ([]finding, []image.Image) {
+	entries, err := img.ReadEntries(bytes.NewReader(data))
+	if err != nil {
+		return []finding{errorFinding("%s", err)}, nil
+	}
+	var (
+		findings []finding
+		images   []image.Image
+		end      = 6 + 16*len(entries) // where the last entry ends
+	)
+	for i, entry := range entries {
+		var (
+			n     = i + 1
+			start = int(entry.Dir.Offset)
+			stop  = start + len(entry.Data)
+		)
+		for j := 0; j < i; j++ {
+			otherStart := int(entries[j].Dir.Offset)
+			otherStop := otherStart + len(entries[j].Data)
+			if start < otherStop && otherStart < stop {
+				findings = append(findings, errorFinding("entry %d overlaps entry %d", n, j+1))
+			}
+		}
+		if stop > end {
+			end = stop
+		}
+		m, err := entry.Decode()
+		if err != nil {
+			findings = append(findings, errorFinding("entry %d can not be decoded: %s", n, err))
+			continue
+		}
+		images = append(images, m)
+		size := image.Pt(m.Bounds().Dx(), m.Bounds().Dy())
+		if dirSize := entry.Size(); size != dirSize {
+			findings = append(findings, warningFinding("entry %d is %dx%d, but the directory says %dx%d", n, size.X, size.Y, dirSize.X, dirSize.Y))
+		}
+		// The bits per pixel of PNG entries are not stored in the data in a way that EntryBits can compare
+		if bits := img.EntryBits(entry.Data); img.DetectFormat(entry.Data) != img.FormatPNG && entry.Dir.Bits != 0 && entry.Dir.Bits != bits {
+			findings = append(findings, warningFinding("entry %d has %d bits per pixel, but the directory says %d", n, bits, entry.Dir.Bits))
+		}
+		findings = append(findings, validateSize(fmt.Sprintf("entry %d", n), size)...)
+	}
+	if end < len(data) {
+		findings = append(findings, warningFinding("there are %d bytes after the last entry that are not used", len(data)-end))
+	}
+	return findings, images
+}
+
+// anyTransparency checks if any of the given images has pixels that are transparent or partially transparent
+func anyTransparency(images []image.Image) bool {
+	for _, m := range images {
+		if img.HasTransparency(m) || img.HasPartialAlpha(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFile checks that the given file is a well-formed .ico, .cur or .png favicon, and that the images
+// have the usual favicon sizes. If requireAlpha is true, it is an error if no pixel is transparent.
+func ValidateFile(filename string, requireAlpha bool) []finding {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return []finding{errorFinding("the file does not exist")}
+	} else if err != nil {
+		return []finding{errorFinding("%s", err)}
+	}
+	var (
+		findings []finding
+		images   []image.Image
+	)
+	switch format := sniffFormat(filename); format {
+	case formatICO, formatCUR:
+		findings, images = validateICO(data)
+	case formatPNG:
+		m, err := img.DecodePNG(bytes.NewReader(data))
+		if err != nil {
+			return []finding{errorFinding("the .png image can not be decoded: %s", err)}
+		}
+		images = []image.Image{m}
+		findings = validateSize("the image", image.Pt(m.Bounds().Dx(), m.Bounds().Dy()))
+	case formatUnknown:
+		return []finding{errorFinding("this is not an .ico or .png image")}
+	default:
+		return []finding{errorFinding("%s images are not favicons, only .ico and .png images are", format)}
+	}
+	if requireAlpha && len(images) > 0 && !anyTransparency(images) {
+		findings = append(findings, errorFinding("no pixels are transparent, but -require-alpha is given"))
+	}
+	return findings
+}
+
+// Validate checks the given files with ValidateFile, where glob patterns like "static/*.ico" are expanded,
+// and writes one line per finding to errOut, like "favicon.ico: warning: ...", or "favicon.ico: ok" to out if
+// there are none. Returns exitOK, exitValidateWarnings or exitValidateErrors.
+func Validate(out, errOut io.Writer, patterns []string, requireAlpha bool) int {
+	code := exitOK
+	for _, pattern := range patterns {
+		filenames := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil || len(matches) == 0 {
+				fmt.Fprintf(errOut, "%s: error: no files match\n", pattern)
+				code = exitValidateErrors
+				continue
+			}
+			filenames = matches
+		}
+		for _, filename := range filenames {
+			findings := ValidateFile(filename, requireAlpha)
+			if len(findings) == 0 {
+				fmt.Fprintf(out, "%s: ok\n", filename)
+			}
+			for _, f := range findings {
+				if f.warning {
+					fmt.Fprintf(errOut, "%s: warning: %s\n", filename, f.message)
+					if code == exitOK {
+						code = exitValidateWarnings
+					}
+				} else {
+					fmt.Fprintf(errOut, "%s: error: %s\n", filename, f.message)
+					code = exitValidateErrors
+				}
+			}
+		}
+	}
+	return code
+}
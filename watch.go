@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The timing of -watch. These are variables, so that tests can make them shorter.
+var (
+	// watchInterval is how often the watched file is checked for changes
+	watchInterval = 250 * time.Millisecond
+
+	// watchSettle is how long the watched file must stay the same before it is converted, so that
+	// several writes in a row, like from an image editor that saves in steps, only give one conversion
+	watchSettle = 500 * time.Millisecond
+)
+
+// watchRetries is how many times a file that can not be converted is tried again, since an image editor
+// may still be writing it, before waiting for the next change
+const watchRetries = 5
+
+// watchOutput returns the file that -watch writes to. "to" can be a filename, like favicon.ico,
+// or a format, like ico, for writing next to the source file.
+func watchOutput(source, to string) string {
+	if filepath.Ext(to) != "" {
+		return to
+	}
+	return siblingFilename(source, "."+to)
+}
+
+// fileStamp is the modification time and size of a file, for noticing when it changes
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stampFile returns the modification time and size of the file
+func stampFile(filename string) (fileStamp, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}, nil
+}
+
+// Watch converts the source image to the file or format given by "to", see watchOutput, like Convert, and then
+// again each time the source changes, until something is received on stop. The file is checked every watchInterval,
+// and converted once it has stayed the same for watchSettle. One line with the time is written per conversion, to
+// errOut if it failed and to out if not.
+// If the file can not be converted, as when it is only partly written, it is tried again, up to watchRetries times.
+func Watch(out, errOut io.Writer, source, to string, mode Mode, size, scale int, bundle bool, stop <-chan os.Signal) error {
+	if to == "" {
+		return usageError("-watch needs a file or a format to write to, like -to favicon.ico")
+	}
+	outFilename := watchOutput(source, to)
+	if err := checkOutputFilename(outFilename); err != nil {
+		return withCode(err, exitUsage)
+	}
+	if _, err := stampFile(source); err != nil {
+		return err
+	}
+	var (
+		built   fileStamp // the file as it was when it was last converted, or tried
+		seen    fileStamp // the file as it was when it was last checked
+		changed time.Time // when the file was last seen to change
+		retries int       // how many times the current version has been tried again
+		pending = true    // is there a change that has not been converted yet?
+		ticker  = time.NewTicker(watchInterval)
+		logf    = func(w io.Writer, format string, a ...interface{}) {
+			fmt.Fprintf(w, time.Now().Format("15:04:05")+" "+format+"\n", a...)
+		}
+	)
+	defer ticker.Stop()
+	logf(out, "watching %s, writing %s", source, outFilename)
+	for {
+		select {
+		case <-stop:
+			logf(out, "stopped watching %s", source)
+			return nil
+		case now := <-ticker.C:
+			stamp, err := stampFile(source)
+			if err != nil {
+				// The file may be replaced by an editor that saves to a new file and renames it
+				continue
+			}
+			if stamp != seen {
+				seen, changed = stamp, now
+				if stamp != built {
+					pending, retries = true, 0
+				}
+				continue
+			}
+			if !pending || now.Sub(changed) < watchSettle {
+				continue
+			}
+			built = stamp
+			if err := Convert(source, outFilename, mode, size, scale, bundle); err != nil {
+				if retries < watchRetries {
+					retries++
+					changed = now
+					continue
+				}
+				logf(errOut, "error: %s, waiting for %s to change", err, source)
+				pending = false
+				continue
+			}
+			logf(out, "saved %s", outFilename)
+			pending = false
+		}
+	}
+}
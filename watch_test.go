@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// lockedBuffer is a bytes.Buffer that can be written by Watch while the test reads it
+type lockedBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mut.Lock()
+	defer lb.mut.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mut.Lock()
+	defer lb.mut.Unlock()
+	return lb.buf.String()
+}
+
+// fastWatch makes Watch check the file more often and wait less for it to settle, until the test ends
+func fastWatch(t *testing.T) {
+	interval, settle := watchInterval, watchSettle
+	watchInterval, watchSettle = 2*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() {
+		watchInterval, watchSettle = interval, settle
+	})
+}
+
+// waitFor waits until the output of Watch has the given text
+func waitFor(t *testing.T, w *lockedBuffer, text string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(w.String(), text); {
+		if time.Now().After(deadline) {
+			t.Fatalf("waited for %q, but got\n%s", text, w)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fastWatch(t)
+	var (
+		dir    = t.TempDir()
+		source = filepath.Join(dir, "icon.png")
+		out    = filepath.Join(dir, "icon.ico")
+		full   bytes.Buffer
+		w      lockedBuffer
+		stop   = make(chan os.Signal)
+		done   = make(chan error)
+	)
+	if err := EncodeFavicon(&full, modeRGBA, image.Pt(16, 16), testText(t, modeRGBA, 16), true); err != nil {
+		t.Fatal(err)
+	}
+	// The image is only half written when the watching starts
+	if err := ioutil.WriteFile(source, full.Bytes()[:full.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	go func() {
+		done <- Watch(&w, &w, source, "ico", modeBlank, 0, 0, false, stop)
+	}()
+
+	// The half written image is tried again, once per watchSettle, before the error is shown
+	waitFor(t, &w, "error: ")
+	if elapsed := time.Since(start); elapsed < watchRetries*watchSettle {
+		t.Errorf("gave up after %v, without trying again %d times", elapsed, watchRetries)
+	}
+	if exists(out) {
+		t.Error("wrote the half written image")
+	}
+
+	// Once the image has been written, it is converted
+	if err := ioutil.WriteFile(source, full.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, &w, "saved "+out)
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, img.ICOMagic) {
+		t.Errorf("%s is not an .ico image", out)
+	}
+
+	stop <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got %v after stopping, but wanted nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not stop")
+	}
+	if n := strings.Count(w.String(), "error: "); n != 1 {
+		t.Errorf("showed %d errors, but wanted 1:\n%s", n, w.String())
+	}
+	if !strings.Contains(w.String(), "stopped watching "+source) {
+		t.Errorf("did not say that the watching stopped:\n%s", w.String())
+	}
+}
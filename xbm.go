@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// xbmBytesPerLine is how many bytes are written per line in the bits array of .xbm images
+const xbmBytesPerLine = 12
+
+// xbmMagic is the start of .xbm images, which are C source code
+var xbmMagic = []byte("#define ")
+
+// DecodeXBM reads an X bitmap, which is C source code with #define lines for the width and the height, followed
+// by an array with the bits. The bits of each row start at a new byte, with the leftmost pixel in the least
+// significant bit. Set bits are black and the rest are white. Both X11 bitmaps, with an array of char, and X10
+// bitmaps, with an array of short, can be read.
+func DecodeXBM(r io.Reader) (*image.Gray, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		width, height int
+		text          = string(data)
+	)
+	// Read the #define lines, up to the array
+	start := strings.IndexByte(text, '{')
+	if start == -1 {
+		return nil, errors.New("not an .xbm image, there is no array with bits")
+	}
+	for _, line := range strings.Split(text[:start], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "#define" {
+			continue
+		}
+		n, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("the .xbm image defines %s as %q, which is not a number", fields[1], fields[2])
+		}
+		switch {
+		case strings.HasSuffix(fields[1], "width"):
+			width = n
+		case strings.HasSuffix(fields[1], "height"):
+			height = n
+		}
+	}
+	if width < 1 || height < 1 || width > img.MaxDecodeSize() || height > img.MaxDecodeSize() {
+		return nil, fmt.Errorf("the .xbm image must define a width and a height from 1 to %d, not %d and %d", img.MaxDecodeSize(), width, height)
+	}
+	// X10 bitmaps have 16 bits per array element, but the bits are in the same order
+	bitsPerElement := 8
+	if strings.Contains(text[strings.LastIndex(text[:start], "\n")+1:start], "short") {
+		bitsPerElement = 16
+	}
+	end := strings.IndexByte(text[start:], '}')
+	if end == -1 {
+		return nil, errors.New("the .xbm image is truncated, the array with bits is not closed with }")
+	}
+	var bits []byte
+	for i, s := range strings.Split(text[start+1:start+end], ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			// A trailing comma
+			continue
+		}
+		v, err := strconv.ParseUint(s, 0, bitsPerElement)
+		if err != nil {
+			return nil, fmt.Errorf("element %d in the .xbm image is %q, which is not a number from 0 to %d", i, s, 1<<uint(bitsPerElement)-1)
+		}
+		bits = append(bits, byte(v))
+		if bitsPerElement == 16 {
+			bits = append(bits, byte(v>>8))
+		}
+	}
+	rowBytes := (width + 7) / 8
+	if bitsPerElement == 16 {
+		rowBytes = (width + 15) / 16 * 2
+	}
+	if len(bits) < rowBytes*height {
+		return nil, fmt.Errorf("the .xbm image is truncated, it has %d of %d bytes", len(bits), rowBytes*height)
+	}
+	m := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if bits[y*rowBytes+x/8]&(1<<uint(x%8)) != 0 {
+				m.SetGray(x, y, color.Gray{0})
+			} else {
+				m.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+	return m, nil
+}
+
+// EncodeXBM converts the textual representation to an image and writes it to the given io.Writer as an X11
+// bitmap. Pixels that are black in monochrome mode are set bits, and the rest, including transparent pixels,
+// are not. The name is used for the C identifiers, like favicon_width for favicon.ico, see cName.
+func EncodeXBM(w io.Writer, mode Mode, size image.Point, text, name string) error {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	var (
+		buf      bytes.Buffer
+		id       = cName(name)
+		rowBytes = (size.X + 7) / 8
+		bits     = make([]byte, rowBytes*size.Y)
+	)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if shade, opaque := img.MonoShade(m.At(x, y)); opaque && shade == 0 {
+				bits[y*rowBytes+x/8] |= 1 << uint(x%8)
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "#define %s_width %d\n", id, size.X)
+	fmt.Fprintf(&buf, "#define %s_height %d\n", id, size.Y)
+	fmt.Fprintf(&buf, "static unsigned char %s_bits[] = {\n", id)
+	for i, b := range bits {
+		if i%xbmBytesPerLine == 0 {
+			buf.WriteString("   ")
+		}
+		fmt.Fprintf(&buf, "0x%02x", b)
+		switch {
+		case i == len(bits)-1:
+			buf.WriteString(" };\n")
+		case i%xbmBytesPerLine == xbmBytesPerLine-1:
+			buf.WriteString(",\n")
+		default:
+			buf.WriteString(", ")
+		}
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// WriteXBM converts the textual representation to an image and saves it as an .xbm image, see EncodeXBM
+func WriteXBM(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeXBM(w, mode, size, text, filename)
+	})
+}
+
+// PrintXBM reads an .ico or .png image and writes it to w as an .xbm image, see EncodeXBM.
+// If size is not 0, that image is read from .ico files with several images.
+func PrintXBM(filename string, w io.Writer, size int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	im, err := img.DecodeSize(f, size)
+	if err != nil {
+		return decodeError(filename, err)
+	}
+	mode, imageSize, data, _, err := imageToText(im.Image, filename, true, modeRGBA)
+	if err != nil {
+		return err
+	}
+	return EncodeXBM(w, mode, imageSize, string(data), filename)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// smiley are the pixels of testdata/smiley.xbm, where X is black
+var smiley = []string{
+	"..XXXXXXXX..",
+	".X........X.",
+	"X..........X",
+	"X..XX..XX..X",
+	"X..XX..XX..X",
+	"X..........X",
+	"X..........X",
+	"X.X......X.X",
+	"X..X....X..X",
+	"X...XXXX...X",
+	".X........X.",
+	"..XXXXXXXX..",
+}
+
+// checkSmiley checks that the image has the pixels in smiley
+func checkSmiley(t *testing.T, name string, m *image.Gray) {
+	t.Helper()
+	if size := m.Bounds().Size(); size != image.Pt(len(smiley[0]), len(smiley)) {
+		t.Fatalf("%s: the image is %v, but wanted 12x12", name, size)
+	}
+	for y, row := range smiley {
+		for x, r := range row {
+			if black := m.GrayAt(x, y).Y == 0; black != (r == 'X') {
+				t.Errorf("%s: the pixel at (%d,%d) is black: %v, but wanted %c", name, x, y, black, r)
+			}
+		}
+	}
+}
+
+func TestDecodeXBM(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/smiley.xbm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeXBM(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSmiley(t, "smiley.xbm", m)
+
+	// X10 bitmaps have the same bits, two bytes per array element, with the first byte as the low byte
+	x10 := `#define smiley_width 12
+#define smiley_height 12
+static short smiley_bits[] = {
+   0x03fc, 0x0402, 0x0801, 0x0999, 0x0999, 0x0801, 0x0801, 0x0a05, 0x0909, 0x08f1, 0x0402, 0x03fc};
+`
+	if m, err = DecodeXBM(strings.NewReader(x10)); err != nil {
+		t.Fatal(err)
+	}
+	checkSmiley(t, "X10 bitmap", m)
+
+	for _, broken := range []string{
+		"",
+		"#define a_width 12\n#define a_height 8\nstatic char a_bits[] = { 0xff };\n",
+		"#define a_width 12\n#define a_height 1\nstatic char a_bits[] = { 0xff, 0x100 };\n",
+		"#define a_width 12\nstatic char a_bits[] = { 0xff, 0x0f };\n",
+		"#define a_width 12\n#define a_height 1\nstatic char a_bits[] = { 0xff, 0x0f,\n",
+	} {
+		if _, err := DecodeXBM(strings.NewReader(broken)); err == nil {
+			t.Errorf("decoded the broken .xbm image %q", broken)
+		}
+	}
+}
+
+func TestEncodeXBM(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/smiley.xbm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeXBM(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, size, text, _, err := imageToText(m, "smiley.xbm", true, modeMono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeXBM(&buf, mode, size, string(text), "testdata/smiley.xbm"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("got\n%s\nbut wanted\n%s", got, want)
+	}
+}
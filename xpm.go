@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/xyproto/favicon/img"
+)
+
+const (
+	// xpmChars are the characters that are used for the 16 grayscale shades in .xpm images, from dark to bright
+	xpmChars = "0123456789abcdef"
+
+	// xpmTransparent is the character that is used for transparent pixels in .xpm images
+	xpmTransparent = ' '
+)
+
+// xpmName returns a C identifier for the image, based on the filename, like favicon_xpm for favicon.ico
+func xpmName(filename string) string {
+	return cName(filename) + "_xpm"
+}
+
+// cName returns the filename without the directory and the extension, as a C identifier, like favicon for favicon.ico
+func cName(filename string) string {
+	base := filepath.Base(filename)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+// EncodeXPM converts the textual representation to an image and writes it to the given io.Writer as an
+// XPM3 image, with one character per pixel. The palette has the 16 grayscale shades and None for transparent
+// pixels, so color images are converted to grayscale. The name is used for the C variable, see xpmName.
+func EncodeXPM(w io.Writer, mode Mode, size image.Point, text, name string) error {
+	m, err := textToImage(mode, size, text)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("/* XPM */\n")
+	fmt.Fprintf(&buf, "static char *%s[] = {\n", xpmName(name))
+	buf.WriteString("/* columns rows colors chars-per-pixel */\n")
+	fmt.Fprintf(&buf, "\"%d %d %d 1 \",\n", size.X, size.Y, len(xpmChars)+1)
+	fmt.Fprintf(&buf, "\"%c c None\",\n", xpmTransparent)
+	for i := 0; i < len(xpmChars); i++ {
+		c := img.ShadeColor(byte(i))
+		fmt.Fprintf(&buf, "\"%c c #%02x%02x%02x\",\n", xpmChars[i], c.R, c.G, c.B)
+	}
+	buf.WriteString("/* pixels */\n")
+	for y := 0; y < size.Y; y++ {
+		buf.WriteByte('"')
+		for x := 0; x < size.X; x++ {
+			if shade, opaque := img.GrayShade(m.At(x, y)); opaque {
+				buf.WriteByte(xpmChars[shade])
+			} else {
+				buf.WriteByte(xpmTransparent)
+			}
+		}
+		buf.WriteByte('"')
+		if y < size.Y-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("};\n")
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// WriteXPM converts the textual representation to an image and saves it as an .xpm image, see EncodeXPM
+func WriteXPM(mode Mode, size image.Point, text, filename string) error {
+	return writeEncoded(filename, func(w io.Writer) error {
+		return EncodeXPM(w, mode, size, text, filename)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/xyproto/favicon/img"
+)
+
+// xpmString matches the C strings of an .xpm image
+var xpmString = regexp.MustCompile(`"([^"]*)"`)
+
+// parseXPM reads an XPM3 image with one character per pixel, where the colors are None or #rrggbb
+func parseXPM(t *testing.T, data []byte) *image.NRGBA {
+	t.Helper()
+	var strs []string
+	for _, match := range xpmString.FindAllStringSubmatch(string(data), -1) {
+		strs = append(strs, match[1])
+	}
+	var width, height, ncolors, cpp int
+	if len(strs) == 0 {
+		t.Fatal("there are no strings in the .xpm image")
+	}
+	if _, err := fmt.Sscanf(strs[0], "%d %d %d %d", &width, &height, &ncolors, &cpp); err != nil || cpp != 1 {
+		t.Fatalf("the values %q can not be read", strs[0])
+	}
+	if len(strs) != 1+ncolors+height {
+		t.Fatalf("there are %d strings, but wanted %d", len(strs), 1+ncolors+height)
+	}
+	colors := make(map[byte]color.NRGBA)
+	for _, s := range strs[1 : 1+ncolors] {
+		fields := strings.Fields(s[1:])
+		if len(fields) != 2 || fields[0] != "c" {
+			t.Fatalf("the color %q can not be read", s)
+		}
+		var c color.NRGBA
+		if fields[1] != "None" {
+			c.A = 0xff
+			if _, err := fmt.Sscanf(fields[1], "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+				t.Fatalf("the color %q can not be read", s)
+			}
+		}
+		colors[s[0]] = c
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y, row := range strs[1+ncolors:] {
+		if len(row) != width {
+			t.Fatalf("row %d has %d pixels, but wanted %d", y, len(row), width)
+		}
+		for x := 0; x < width; x++ {
+			c, ok := colors[row[x]]
+			if !ok {
+				t.Fatalf("the pixel at (%d,%d) has the color %q, which is not in the color table", x, y, row[x])
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+func TestEncodeXPM(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/gradient.xpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, size, text, _, err := imageToText(parseXPM(t, want), "gradient.xpm", true, modeGray4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeXPM(&buf, mode, size, string(text), "testdata/gradient.xpm"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("got\n%s\nbut wanted\n%s", got, want)
+	}
+
+	// Color images are converted to grayscale, with the closest shades
+	mode, size, text, _, err = imageToText(testImage(16), "colors.png", true, modeRGBA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := EncodeXPM(&buf, mode, size, string(text), "colors.png"); err != nil {
+		t.Fatal(err)
+	}
+	m := parseXPM(t, buf.Bytes())
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			shade, opaque := img.GrayShade(testImage(16).At(x, y))
+			want := color.NRGBA{}
+			if opaque {
+				want = img.ShadeColor(shade)
+			}
+			if got := m.NRGBAAt(x, y); got != want {
+				t.Fatalf("the pixel at (%d,%d) is %v, but wanted %v", x, y, got, want)
+			}
+		}
+	}
+	if !strings.Contains(buf.String(), "static char *colors_xpm[] = {") {
+		t.Error("the variable is not named after the file")
+	}
+}